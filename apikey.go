@@ -0,0 +1,231 @@
+package gate
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// APIKeyPrefix is the visible prefix which distinguishes API keys from JWTs
+const APIKeyPrefix = "gate_"
+
+var (
+	// ErrInvalidAPIKey is thrown when an API key is malformed or unknown
+	ErrInvalidAPIKey error = KindError{"invalid API key", ErrInvalidCredentials}
+
+	// ErrAPIKeyExpired is thrown when an API key is expired
+	ErrAPIKeyExpired error = KindError{"the API key is expired", ErrTokenExpired}
+
+	// ErrAPIKeyRevoked is thrown when an API key is revoked
+	ErrAPIKeyRevoked error = KindError{"the API key is revoked", ErrTokenRevoked}
+)
+
+// APIKey is the long-lived key of a machine client. Only the hash of its secret is kept
+type APIKey struct {
+	ID         string
+	Name       string
+	Hash       string
+	UserID     string
+	Scopes     []Ability
+	CreatedAt  time.Time
+	ExpiredAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+}
+
+// Expired checks if the key is expired at the given time. Keys without expiration never expire
+func (key APIKey) Expired(now time.Time) bool {
+	return !key.ExpiredAt.IsZero() && now.After(key.ExpiredAt)
+}
+
+// Revoked checks if the key is revoked
+func (key APIKey) Revoked() bool {
+	return !key.RevokedAt.IsZero()
+}
+
+// APIKeyUser is the user authenticated by an API key. Its abilities are limited to the key scopes, by Authorize and GetUserAbilities
+type APIKeyUser struct {
+	User
+	Key APIKey
+}
+
+// GetScopes returns the abilities the key is restricted to
+func (user APIKeyUser) GetScopes() []UserAbility {
+	if len(user.Key.Scopes) == 0 {
+		return nil
+	}
+
+	scopes := make([]UserAbility, len(user.Key.Scopes))
+	for i, scope := range user.Key.Scopes {
+		scopes[i] = scope
+	}
+
+	return scopes
+}
+
+// IsAPIKey determines whether the given string is an API key or not
+func IsAPIKey(str string) bool {
+	return strings.HasPrefix(str, APIKeyPrefix)
+}
+
+// IssueAPIKey generates and stores an API key for a specific user. The returned plain key is shown once and never stored.
+// Empty scopes give the key every ability of its owner and a zero expiration makes it never expire
+func IssueAPIKey(auth Auth, user User, name string, scopes []Ability, expiration time.Duration) (key APIKey, plain string, err error) {
+	service, err := getAPIKeyService(auth)
+	if err != nil {
+		return
+	}
+
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	if err != nil {
+		err = errors.Wrap(err, "could not generate API key")
+		return
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		err = errors.Wrap(err, "could not generate API key")
+		return
+	}
+
+	key = APIKey{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		UserID:    user.GetID(),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	if expiration > 0 {
+		key.ExpiredAt = key.CreatedAt.Add(expiration)
+	}
+
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+	key.Hash = hashAPIKeySecret(encodedSecret)
+	plain = APIKeyPrefix + key.ID + "_" + encodedSecret
+
+	err = service.Store(key)
+	if err != nil {
+		err = errors.Wrap(err, "could not store API key")
+		return
+	}
+
+	Audit(auth, AuditEvent{Type: EventTokenIssued, UserID: key.UserID, TokenID: key.ID, TokenKind: TokenKindAPIKey})
+	return
+}
+
+// AuthenticateAPIKey performs the authentication using an API key
+func AuthenticateAPIKey(auth Auth, plain string) (user User, err error) {
+	id, secret, err := parseAPIKey(plain)
+	if err != nil {
+		return
+	}
+
+	service, err := getAPIKeyService(auth)
+	if err != nil {
+		return
+	}
+
+	key, err := service.FindOneByID(id)
+	if err != nil {
+		if service.IsErrNotFound(err) {
+			err = ErrInvalidAPIKey
+			return
+		}
+
+		err = errors.Wrap(err, "could not find the API key")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashAPIKeySecret(secret))) != 1 {
+		err = ErrInvalidAPIKey
+		return
+	}
+
+	if key.Revoked() {
+		err = ErrAPIKeyRevoked
+		return
+	}
+
+	now := time.Now()
+	if key.Expired(now) {
+		err = ErrAPIKeyExpired
+		return
+	}
+
+	err = service.Touch(key.ID, now)
+	if err != nil {
+		err = errors.Wrap(err, "could not track API key usage")
+		return
+	}
+	key.LastUsedAt = now
+
+	userService, err := auth.UserService()
+	if err != nil {
+		return
+	}
+
+	owner, err := userService.FindOneByID(key.UserID)
+	if err != nil {
+		err = errors.Wrap(err, "could not find the user with the given id")
+		return
+	}
+
+	user = APIKeyUser{owner, key}
+	return
+}
+
+// RevokeAPIKey revokes the API key with the given ID
+func RevokeAPIKey(auth Auth, id string) (err error) {
+	service, err := getAPIKeyService(auth)
+	if err != nil {
+		return
+	}
+
+	err = service.Revoke(id, time.Now())
+	if err != nil {
+		err = errors.Wrap(err, "could not revoke API key")
+		return
+	}
+
+	Audit(auth, AuditEvent{Type: EventTokenRevoked, TokenID: id, TokenKind: TokenKindAPIKey})
+	return
+}
+
+// getAPIKeyService returns the API key service of the Auth if it is an APIKeyAuth
+func getAPIKeyService(auth Auth) (APIKeyService, error) {
+	keyed, ok := auth.(APIKeyAuth)
+	if !ok {
+		return nil, MissingServiceError{Service: "API key service"}
+	}
+
+	return keyed.APIKeyService()
+}
+
+func parseAPIKey(plain string) (id, secret string, err error) {
+	if !IsAPIKey(plain) {
+		err = ErrInvalidAPIKey
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(plain, APIKeyPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		err = ErrInvalidAPIKey
+		return
+	}
+
+	id, secret = parts[0], parts[1]
+	return
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,93 @@
+package gate
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Types of audit events
+const (
+	EventLoginSucceeded       = "login.succeeded"
+	EventLoginFailed          = "login.failed"
+	EventUserProvisioned      = "user.provisioned"
+	EventTokenIssued          = "token.issued"
+	EventTokenRevoked         = "token.revoked"
+	EventAuthenticationFailed = "authentication.failed"
+	EventAuthorizationAllowed = "authorization.allowed"
+	EventAuthorizationDenied  = "authorization.denied"
+)
+
+// Kinds of tokens in audit events
+const (
+	TokenKindJWT     = "jwt"
+	TokenKindAPIKey  = "api_key"
+	TokenKindSession = "session"
+)
+
+// AuditEvent is the structured record of an authentication or authorization event
+type AuditEvent struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Provider  string    `json:"provider,omitempty"`
+	Login     string    `json:"login,omitempty"`
+	UserID    string    `json:"user_id,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	TokenID   string    `json:"token_id,omitempty"`
+	TokenKind string    `json:"token_kind,omitempty"`
+	Action    string    `json:"action,omitempty"`
+	Object    string    `json:"object,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// AuditSink is the contract which receives audit events, e.g. to write them to a file
+type AuditSink interface {
+	Emit(AuditEvent) error
+}
+
+// AuditingAuth is the contract for Auth implementations providing an audit sink, e.g. through dependency.Container
+type AuditingAuth interface {
+	Auth
+	AuditSink() (AuditSink, error)
+}
+
+// Audit emits the event to the audit sink, if any. The time defaults to now.
+// Sink failures do not fail the audited operation, sinks should report them on their own
+func Audit(auth Auth, event AuditEvent) {
+	auditing, ok := auth.(AuditingAuth)
+	if !ok {
+		return
+	}
+
+	sink, err := auditing.AuditSink()
+	if err != nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	sink.Emit(event)
+}
+
+// AuditLogin emits the outcome of a login. The login is the identifier given by the client, e.g. the email or the client ID
+func AuditLogin(auth Auth, provider, login string, user User, err error) {
+	if err != nil || user == nil {
+		event := AuditEvent{Type: EventLoginFailed, Provider: provider, Login: login}
+		if err != nil {
+			event.Reason = auditReason(err)
+		}
+
+		Audit(auth, event)
+		return
+	}
+
+	Audit(auth, AuditEvent{Type: EventLoginSucceeded, Provider: provider, Login: login, UserID: user.GetID(), Email: user.GetEmail()})
+}
+
+// auditReason is the root cause of the error, e.g. "invalid credentials", rather than its whole context
+func auditReason(err error) string {
+	return errors.Cause(err).Error()
+}
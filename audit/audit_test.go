@@ -0,0 +1,134 @@
+package audit_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/audit"
+	"github.com/hiendv/gate/internal/test"
+	"github.com/pkg/errors"
+)
+
+var events = []gate.AuditEvent{
+	{Type: gate.EventLoginSucceeded, Time: time.Unix(1605049200, 0).UTC(), Provider: "password", Login: "email@local", UserID: "id", Email: "email@local"},
+	{Type: gate.EventAuthorizationDenied, Time: time.Unix(1605049201, 0).UTC(), UserID: "id", Action: "GET", Object: "/admin", Reason: "forbidden"},
+}
+
+func TestMemorySink(t *testing.T) {
+	sink := audit.NewMemorySink()
+	for _, event := range events {
+		err := sink.Emit(event)
+		test.AssertOK(t, err, "valid event")
+	}
+
+	if len(sink.Events()) != 2 {
+		t.Fatalf("unexpected events: %v", sink.Events())
+	}
+
+	denied := sink.Events(gate.EventAuthorizationDenied)
+	if len(denied) != 1 || denied[0].Object != "/admin" {
+		t.Fatalf("unexpected events: %v", denied)
+	}
+
+	sink.Reset()
+	if len(sink.Events()) != 0 {
+		t.Fatalf("unexpected events: %v", sink.Events())
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	_, err := audit.NewFileSink("")
+	test.AssertErr(t, err, "missing path")
+
+	dir, err := ioutil.TempDir("", "gate-audit")
+	test.AssertOK(t, err, "valid directory")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	sink, err := audit.NewFileSink(path)
+	test.AssertOK(t, err, "valid path")
+
+	err = sink.Emit(events[0])
+	test.AssertOK(t, err, "valid event")
+
+	err = sink.Close()
+	test.AssertOK(t, err, "open file")
+
+	// Events are appended to the existing file
+	sink, err = audit.NewFileSink(path)
+	test.AssertOK(t, err, "existing file")
+
+	err = sink.Emit(events[1])
+	test.AssertOK(t, err, "valid event")
+
+	err = sink.Close()
+	test.AssertOK(t, err, "open file")
+
+	err = sink.Emit(events[0])
+	test.AssertErr(t, err, "closed file")
+
+	info, err := os.Stat(path)
+	test.AssertOK(t, err, "existing file")
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected permissions: %v", info.Mode())
+	}
+
+	file, err := os.Open(path)
+	test.AssertOK(t, err, "existing file")
+	defer file.Close()
+
+	var lines []gate.AuditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event gate.AuditEvent
+		err = json.Unmarshal(scanner.Bytes(), &event)
+		test.AssertOK(t, err, "JSON line")
+		lines = append(lines, event)
+	}
+
+	if len(lines) != 2 || lines[0].Type != events[0].Type || !lines[0].Time.Equal(events[0].Time) || lines[1].Reason != "forbidden" {
+		t.Fatalf("unexpected lines: %v", lines)
+	}
+}
+
+func TestMultiSink(t *testing.T) {
+	first, second := audit.NewMemorySink(), audit.NewMemorySink()
+	failing := audit.FuncSink(func(gate.AuditEvent) error {
+		return errors.New("unavailable")
+	})
+
+	sink := audit.NewMultiSink(first, failing, second)
+	err := sink.Emit(events[0])
+	test.AssertErr(t, err, "failing sink")
+
+	if len(first.Events()) != 1 || len(second.Events()) != 1 {
+		t.Fatal("unexpected skipped sink")
+	}
+
+	err = audit.NewMultiSink(first, second).Emit(events[1])
+	test.AssertOK(t, err, "valid sinks")
+}
+
+func TestFilterSink(t *testing.T) {
+	memory := audit.NewMemorySink()
+	sink := audit.NewFilterSink(memory, gate.EventAuthorizationAllowed)
+
+	err := sink.Emit(gate.AuditEvent{Type: gate.EventAuthorizationAllowed, UserID: "id", Action: "GET", Object: "/posts"})
+	test.AssertOK(t, err, "excluded event")
+
+	for _, event := range events {
+		err = sink.Emit(event)
+		test.AssertOK(t, err, "valid event")
+	}
+
+	recorded := memory.Events()
+	if len(recorded) != len(events) || recorded[1].Type != gate.EventAuthorizationDenied {
+		t.Fatalf("unexpected events: %v", recorded)
+	}
+}
@@ -0,0 +1,2 @@
+// Package audit contains the audit sinks receiving the events of gate, see gate.AuditSink
+package audit
@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
+)
+
+// FileSink is the audit sink appending the events to a file as JSON lines
+type FileSink struct {
+	file *os.File
+	*sync.Mutex
+}
+
+// NewFileSink is the constructor for FileSink. The file is created if it does not exist and is only readable by its owner
+func NewFileSink(path string) (sink FileSink, err error) {
+	if path == "" {
+		err = errors.New("missing path")
+		return
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		err = errors.Wrap(err, "could not open the file")
+		return
+	}
+
+	sink = FileSink{file, &sync.Mutex{}}
+	return
+}
+
+// Emit appends the event as a line. Each line is written at once so concurrent events are not interleaved
+func (sink FileSink) Emit(event gate.AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "could not encode the event")
+	}
+
+	sink.Lock()
+	defer sink.Unlock()
+
+	_, err = sink.file.Write(append(data, '\n'))
+	if err != nil {
+		return errors.Wrap(err, "could not write the event")
+	}
+
+	return nil
+}
+
+// Close closes the file
+func (sink FileSink) Close() error {
+	sink.Lock()
+	defer sink.Unlock()
+
+	return sink.file.Close()
+}
@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"sync"
+
+	"github.com/hiendv/gate"
+)
+
+// MemorySink is the in-memory audit sink, e.g. for tests
+type MemorySink struct {
+	events *[]gate.AuditEvent
+	*sync.Mutex
+}
+
+// NewMemorySink is the constructor for MemorySink
+func NewMemorySink() MemorySink {
+	return MemorySink{
+		events: &[]gate.AuditEvent{},
+		Mutex:  &sync.Mutex{},
+	}
+}
+
+// Emit keeps the event
+func (sink MemorySink) Emit(event gate.AuditEvent) error {
+	sink.Lock()
+	defer sink.Unlock()
+
+	*sink.events = append(*sink.events, event)
+	return nil
+}
+
+// Events returns the kept events in order, optionally only those of the given types
+func (sink MemorySink) Events(types ...string) (events []gate.AuditEvent) {
+	sink.Lock()
+	defer sink.Unlock()
+
+	for _, event := range *sink.events {
+		if len(types) == 0 || contains(types, event.Type) {
+			events = append(events, event)
+		}
+	}
+	return
+}
+
+// Reset drops the kept events
+func (sink MemorySink) Reset() {
+	sink.Lock()
+	defer sink.Unlock()
+
+	*sink.events = nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
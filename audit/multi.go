@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"strings"
+
+	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
+)
+
+// MultiSink is the audit sink forwarding the events to several sinks
+type MultiSink []gate.AuditSink
+
+// NewMultiSink is the constructor for MultiSink
+func NewMultiSink(sinks ...gate.AuditSink) MultiSink {
+	return MultiSink(sinks)
+}
+
+// Emit forwards the event to every sink, even when some of them fail
+func (sinks MultiSink) Emit(event gate.AuditEvent) error {
+	var messages []string
+	for _, sink := range sinks {
+		err := sink.Emit(event)
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+
+	if len(messages) != 0 {
+		return errors.Errorf("could not emit the event: %s", strings.Join(messages, "; "))
+	}
+
+	return nil
+}
+
+// FuncSink adapts a function to an audit sink, e.g. to forward the events to a logger
+type FuncSink func(gate.AuditEvent) error
+
+// Emit calls the function
+func (sink FuncSink) Emit(event gate.AuditEvent) error {
+	return sink(event)
+}
+
+// FilterSink is the audit sink forwarding the events to another sink unless their type is excluded,
+// e.g. gate.EventAuthorizationAllowed to only audit the denied authorizations
+type FilterSink struct {
+	sink     gate.AuditSink
+	excluded map[string]bool
+}
+
+// NewFilterSink is the constructor for FilterSink
+func NewFilterSink(sink gate.AuditSink, excluded ...string) FilterSink {
+	filter := FilterSink{sink, map[string]bool{}}
+	for _, event := range excluded {
+		filter.excluded[event] = true
+	}
+
+	return filter
+}
+
+// Emit forwards the event unless its type is excluded
+func (sink FilterSink) Emit(event gate.AuditEvent) error {
+	if sink.excluded[event.Type] {
+		return nil
+	}
+
+	return sink.sink.Emit(event)
+}
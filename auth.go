@@ -1,6 +1,8 @@
 package gate
 
 import (
+	"time"
+
 	"github.com/hiendv/gate/internal"
 )
 
@@ -25,6 +27,13 @@ type Auth interface {
 	GetUserAbilities(User) ([]UserAbility, error)
 }
 
+// DenyingAuth is the contract for Auth implementations providing the denied abilities of a user, e.g. along with their own GetUserAbilities.
+// Authorize uses GetUserDeniedAbilities when the Auth does not implement it
+type DenyingAuth interface {
+	Auth
+	GetUserDeniedAbilities(User) ([]UserAbility, error)
+}
+
 // UserService is the contract which offers queries on the user entity
 type UserService interface {
 	FindOneByID(string) (User, error)
@@ -44,6 +53,72 @@ type TokenService interface {
 	Store(JWT) error
 }
 
+// APIKeyService is the contract which offers queries on the API key entity
+type APIKeyService interface {
+	FindOneByID(string) (APIKey, error)
+	Store(APIKey) error
+	Touch(string, time.Time) error
+	Revoke(string, time.Time) error
+	IsErrNotFound(error) bool
+}
+
+// APIKeyAuth is the contract for Auth implementations providing an API key service, e.g. through dependency.Container
+type APIKeyAuth interface {
+	Auth
+	APIKeyService() (APIKeyService, error)
+}
+
+// IdentityService is the contract which offers queries on the identity entity
+type IdentityService interface {
+	FindOneByProviderSubject(string, string) (Identity, error)
+	FindByUserID(string) ([]Identity, error)
+	Store(Identity) error
+	Delete(string, string) error
+	IsErrNotFound(error) bool
+}
+
+// IdentityAuth is the contract for Auth implementations providing an identity service, e.g. through dependency.Container
+type IdentityAuth interface {
+	Auth
+	IdentityService() (IdentityService, error)
+}
+
+// SessionStore is the contract which keeps sessions. Store must replace the session with the same ID.
+// Touch must only update the last use of an existing session, so a deleted session is never stored again
+type SessionStore interface {
+	FindOneByID(string) (Session, error)
+	Store(Session) error
+	Touch(string, time.Time) error
+	Delete(string) error
+	IsErrNotFound(error) bool
+}
+
+// SessionAuth is the contract for Auth implementations providing a session store, e.g. through dependency.Container
+type SessionAuth interface {
+	Auth
+	SessionStore() (SessionStore, error)
+}
+
+// ClockAuth is the contract for Auth implementations providing the current time, e.g. through dependency.Container.
+// Sessions use time.Now when the Auth does not implement it
+type ClockAuth interface {
+	Auth
+	Clock() time.Time
+}
+
+// RelationService is the contract for relationship-based authorization, e.g. the relation package.
+// Authorize delegates the objects the service handles, e.g. by their type, to the relation the action requires
+type RelationService interface {
+	RelationFor(action, object string) (relation string, ok bool)
+	Check(object, relation, subject string) (bool, error)
+}
+
+// RelationAuth is the contract for Auth implementations providing a relation service, e.g. through dependency.Container
+type RelationAuth interface {
+	Auth
+	RelationService() (RelationService, error)
+}
+
 // Account is the contract for account
 type Account interface {
 	GetName() string
@@ -1,11 +1,42 @@
 package gate
 
 import (
+	"time"
+
 	"github.com/pkg/errors"
 )
 
-// Authenticate performs the authentication using JWT
+// Authenticate performs the authentication using JWT. API keys are authenticated with AuthenticateAPIKey
+// and session IDs with AuthenticateSession. Failures are audited
 func Authenticate(auth Auth, tokenString string) (user User, err error) {
+	start := time.Now()
+	user, err = authenticate(auth, tokenString)
+	measure(auth, MetricAuthentications, MetricAuthenticationDuration, start, err)
+	if err != nil {
+		Audit(auth, AuditEvent{Type: EventAuthenticationFailed, Reason: auditReason(err)})
+	}
+	return
+}
+
+func authenticate(auth Auth, tokenString string) (user User, err error) {
+	if IsSessionID(tokenString) {
+		user, err = AuthenticateSession(auth, tokenString)
+		if err != nil {
+			err = errors.Wrap(err, "could not authenticate the session")
+			return
+		}
+		return
+	}
+
+	if IsAPIKey(tokenString) {
+		user, err = AuthenticateAPIKey(auth, tokenString)
+		if err != nil {
+			err = errors.Wrap(err, "could not authenticate the API key")
+			return
+		}
+		return
+	}
+
 	token, err := auth.ParseJWT(tokenString)
 	if err != nil {
 		err = errors.Wrap(err, "could not parse the token")
@@ -20,7 +51,7 @@ func Authenticate(auth Auth, tokenString string) (user User, err error) {
 	return
 }
 
-// GetUserFromJWT returns a user from a given JWT
+// GetUserFromJWT returns a user from a given JWT. Users of tenant tokens are bound to the tenant, see TenantMember
 func GetUserFromJWT(auth Auth, token JWT) (user User, err error) {
 	service, err := auth.UserService()
 	if err != nil {
@@ -32,5 +63,15 @@ func GetUserFromJWT(auth Auth, token JWT) (user User, err error) {
 		err = errors.Wrap(err, "could not find the user with the given id")
 		return
 	}
+
+	if token.Tenant == "" {
+		return
+	}
+
+	user, err = NewTenantMember(user, token.Tenant)
+	if err != nil {
+		user = nil
+		return
+	}
 	return
 }
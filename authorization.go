@@ -1,6 +1,8 @@
 package gate
 
 import (
+	"time"
+
 	"github.com/hiendv/gate/internal"
 	"github.com/pkg/errors"
 )
@@ -13,8 +15,55 @@ var (
 	ErrNoAbilities = errors.New("there is no abilities")
 )
 
-// Authorize performs the authorization when a given user takes an action on an object using RBAC
+// Authorize performs the authorization when a given user takes an action on an object using RBAC,
+// or the relations of the user to the object when the relation service handles it. Either way, the denied abilities apply,
+// tenant members must be members of their tenant and scoped users must also have a matching scope
 func Authorize(auth Auth, user User, action, object string) (err error) {
+	start := time.Now()
+	err = authorize(auth, user, action, object)
+	measure(auth, MetricAuthorizations, MetricAuthorizationDuration, start, err)
+
+	event := AuditEvent{Type: EventAuthorizationAllowed, Action: action, Object: object}
+	if user != nil {
+		event.UserID = user.GetID()
+	}
+
+	if member, ok := user.(TenantMember); ok {
+		event.Tenant = member.Tenant
+	}
+
+	if err != nil {
+		event.Type = EventAuthorizationDenied
+		event.Reason = auditReason(err)
+	}
+
+	Audit(auth, event)
+	return
+}
+
+func authorize(auth Auth, user User, action, object string) (err error) {
+	relations, e := getRelationService(auth)
+	if e == nil {
+		if relation, ok := relations.RelationFor(action, object); ok {
+			err = authorizeRelation(relations, user, relation, object)
+			if err != nil {
+				return
+			}
+
+			err = authorizeTenant(user)
+			if err != nil {
+				return
+			}
+
+			err = authorizeDenials(auth, user, action, object)
+			if err != nil {
+				return
+			}
+
+			return authorizeScopes(auth, user, action, object)
+		}
+	}
+
 	abilities, err := auth.GetUserAbilities(user)
 	if err != nil {
 		err = errors.Wrap(err, "could not get the abilities")
@@ -26,14 +75,161 @@ func Authorize(auth Auth, user User, action, object string) (err error) {
 		return
 	}
 
-	if !authorizationCheck(auth, action, object, abilities) {
+	denied, err := getUserDeniedAbilities(auth, user)
+	if err != nil {
+		err = errors.Wrap(err, "could not get the denied abilities")
+		return
+	}
+
+	if !authorizationCheck(auth, action, object, abilities, denied) {
+		err = ErrForbidden
+		return
+	}
+
+	return authorizeScopes(auth, user, action, object)
+}
+
+// getRelationService returns the relation service of the Auth if it is a RelationAuth
+func getRelationService(auth Auth) (RelationService, error) {
+	relational, ok := auth.(RelationAuth)
+	if !ok {
+		return nil, MissingServiceError{Service: "relation service"}
+	}
+
+	return relational.RelationService()
+}
+
+// authorizeRelation checks the user is related to the object. Actions the object type does not map to a relation are forbidden
+func authorizeRelation(relations RelationService, user User, relation, object string) (err error) {
+	if user == nil {
+		err = errors.New("missing user")
+		return
+	}
+
+	if relation == "" {
+		err = ErrForbidden
+		return
+	}
+
+	related, err := relations.Check(object, relation, user.GetID())
+	if err != nil {
+		err = errors.Wrap(err, "could not check the relation")
+		return
+	}
+
+	if !related {
 		err = ErrForbidden
 	}
 	return
 }
 
-// GetUserAbilities returns a user's abilities
+// authorizeTenant checks a tenant member still has roles in its tenant, as the relations are not bound to tenants
+func authorizeTenant(user User) (err error) {
+	member, ok := user.(TenantMember)
+	if ok && len(member.GetRoles()) == 0 {
+		err = ErrNotTenantMember
+	}
+	return
+}
+
+// authorizeDenials forbids the actions denied to the user, see GetUserDeniedAbilities
+func authorizeDenials(auth Auth, user User, action, object string) (err error) {
+	denied, err := getUserDeniedAbilities(auth, user)
+	if err != nil {
+		err = errors.Wrap(err, "could not get the denied abilities")
+		return
+	}
+
+	matcher, err := auth.Matcher()
+	if err != nil {
+		return
+	}
+
+	if denialCheck(matcher, action, object, denied) {
+		err = ErrForbidden
+	}
+	return
+}
+
+func authorizeScopes(auth Auth, user User, action, object string) (err error) {
+	scoped, ok := user.(ScopedUser)
+	if !ok {
+		return
+	}
+
+	scopes := scoped.GetScopes()
+	if scopes != nil && !authorizationCheck(auth, action, object, scopes, nil) {
+		err = ErrForbidden
+	}
+	return
+}
+
+// GetUserAbilities returns a user's abilities. The abilities denied by DenyingRoles are not included, see GetUserDeniedAbilities.
+// The abilities of scoped users, e.g. API key users, are narrowed to their scopes
 func GetUserAbilities(auth Auth, user User) (abilities []UserAbility, err error) {
+	abilities, _, err = getUserAbilities(auth, user)
+	if err != nil {
+		return
+	}
+
+	if scoped, ok := user.(ScopedUser); ok {
+		abilities = scopeAbilities(auth, abilities, scoped.GetScopes())
+	}
+	return
+}
+
+// scopeAbilities keeps the abilities within a scope and the scopes within an ability.
+// An ability and a scope only overlapping partially are left out, so the result never grants more than Authorize
+func scopeAbilities(auth Auth, abilities, scopes []UserAbility) (scoped []UserAbility) {
+	if scopes == nil {
+		return abilities
+	}
+
+	matcher, err := auth.Matcher()
+	if err != nil {
+		return
+	}
+
+	within := func(ability UserAbility, others []UserAbility) bool {
+		for _, other := range others {
+			if internal.AuthorizationCheck(matcher, ability.GetAction(), ability.GetObject(), other) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, ability := range abilities {
+		if within(ability, scopes) {
+			scoped = append(scoped, ability)
+		}
+	}
+
+	for _, scope := range scopes {
+		if within(scope, abilities) && !within(scope, scoped) {
+			scoped = append(scoped, scope)
+		}
+	}
+	return
+}
+
+// GetUserDeniedAbilities returns the abilities denied to a user by DenyingRoles. They override the abilities of the user
+func GetUserDeniedAbilities(auth Auth, user User) (denied []UserAbility, err error) {
+	_, denied, err = getUserAbilities(auth, user)
+	return
+}
+
+// getUserDeniedAbilities returns the denied abilities from the Auth if it is a DenyingAuth, so it may override them like GetUserAbilities
+func getUserDeniedAbilities(auth Auth, user User) ([]UserAbility, error) {
+	if denying, ok := auth.(DenyingAuth); ok {
+		return denying.GetUserDeniedAbilities(user)
+	}
+
+	return GetUserDeniedAbilities(auth, user)
+}
+
+func getUserAbilities(auth Auth, user User) (abilities, denied []UserAbility, err error) {
 	roleIDs := user.GetRoles()
 	if len(roleIDs) == 0 {
 		return
@@ -52,19 +248,36 @@ func GetUserAbilities(auth Auth, user User) (abilities []UserAbility, err error)
 
 	for _, role := range roles {
 		abilities = append(abilities, role.GetAbilities()...)
+
+		if denying, ok := role.(DenyingRole); ok {
+			denied = append(denied, denying.GetDeniedAbilities()...)
+		}
 	}
 	return
 }
 
-func authorizationCheck(auth Auth, action, object string, abilities []UserAbility) bool {
+func authorizationCheck(auth Auth, action, object string, abilities, denied []UserAbility) bool {
 	matcher, err := auth.Matcher()
 	if err != nil {
 		return false
 	}
 
+	if denialCheck(matcher, action, object, denied) {
+		return false
+	}
+
 	for _, ability := range abilities {
-		matched := internal.AuthorizationCheck(matcher, action, object, ability)
-		if matched {
+		if internal.AuthorizationCheck(matcher, action, object, ability) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func denialCheck(matcher internal.Matcher, action, object string, denied []UserAbility) bool {
+	for _, ability := range denied {
+		if internal.DenialCheck(matcher, action, object, ability) {
 			return true
 		}
 	}
@@ -0,0 +1,108 @@
+package clientcredentials
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// assertionCache remembers the used assertion IDs until they expire so assertions cannot be replayed
+type assertionCache struct {
+	records map[string]time.Time
+	*sync.Mutex
+}
+
+// use marks the assertion ID as used. It returns false if the ID has already been used
+func (cache assertionCache) use(id string, expiredAt, now time.Time) bool {
+	cache.Lock()
+	defer cache.Unlock()
+
+	for key, exp := range cache.records {
+		if now.After(exp) {
+			delete(cache.records, key)
+		}
+	}
+
+	if _, ok := cache.records[id]; ok {
+		return false
+	}
+
+	cache.records[id] = expiredAt
+	return true
+}
+
+// verifyAssertion authenticates a client with a JWT signed by its private key (RFC 7523)
+func (auth Driver) verifyAssertion(assertion, id string) (client Client, err error) {
+	if auth.config.TokenURL == "" {
+		err = errors.New("the token URL is required for client assertions")
+		return
+	}
+
+	var lookupErr error
+	claims := jwt.MapClaims{}
+	_, err = new(jwt.Parser).ParseWithClaims(assertion, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, errors.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		subject, _ := claims["sub"].(string)
+		if subject == "" || (id != "" && subject != id) {
+			return nil, errors.New("invalid subject")
+		}
+
+		client, lookupErr = auth.findClient(subject)
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+
+		key := client.GetPublicKey()
+		if key == nil {
+			return nil, errors.New("the client does not support private_key_jwt")
+		}
+
+		return key, nil
+	})
+	if lookupErr != nil {
+		err = lookupErr
+		return
+	}
+
+	if err != nil {
+		err = ErrInvalidClient
+		return
+	}
+
+	now := auth.Now()
+	issuer, _ := claims["iss"].(string)
+	if issuer != client.GetID() || !claims.VerifyExpiresAt(now.Unix(), true) || !hasAudience(claims, auth.config.TokenURL) {
+		err = ErrInvalidClient
+		return
+	}
+
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if jti == "" || !auth.assertions.use(client.GetID()+":"+jti, time.Unix(int64(exp), 0), now) {
+		err = ErrInvalidClient
+		return
+	}
+	return
+}
+
+func hasAudience(claims jwt.MapClaims, audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, value := range aud {
+			if str, ok := value.(string); ok && str == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
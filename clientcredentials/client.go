@@ -0,0 +1,32 @@
+package clientcredentials
+
+import (
+	"github.com/hiendv/gate"
+)
+
+// Client is the contract for the client entity. Clients should keep only a hash of their secrets
+type Client interface {
+	gate.User
+	GetAllowedScopes() []string
+	VerifySecret(string) bool
+	// GetPublicKey returns the *rsa.PublicKey or *ecdsa.PublicKey verifying the client assertions, or nil if private_key_jwt is not allowed
+	GetPublicKey() interface{}
+}
+
+// ClientService is the contract which offers queries on the client entity
+type ClientService interface {
+	FindOneByID(string) (Client, error)
+	IsErrNotFound(error) bool
+}
+
+// ClientUser is the user authenticated by client credentials. Its abilities are limited to the granted scopes
+type ClientUser struct {
+	Client
+	Scopes    []string
+	abilities []gate.UserAbility
+}
+
+// GetScopes returns the abilities granted by the scopes
+func (user ClientUser) GetScopes() []gate.UserAbility {
+	return user.abilities
+}
@@ -0,0 +1,253 @@
+package clientcredentials
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal"
+	"github.com/pkg/errors"
+)
+
+// GrantType is the grant type handled by the driver
+const GrantType = "client_credentials"
+
+// Provider is the provider of audit events of client authentication
+const Provider = GrantType
+
+// AssertionType is the client assertion type of private_key_jwt authentication
+const AssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+var (
+	// ErrInvalidRequest is thrown when a token request is malformed
+	ErrInvalidRequest = errors.New("invalid request")
+
+	// ErrInvalidClient is thrown when the client authentication fails
+	ErrInvalidClient error = gate.KindError{Message: "invalid client", Kind: gate.ErrInvalidCredentials}
+
+	// ErrInvalidScope is thrown when the requested scopes are not allowed for the client
+	ErrInvalidScope = errors.New("invalid scope")
+
+	// ErrUnsupportedGrantType is thrown when a token request uses another grant type
+	ErrUnsupportedGrantType = errors.New("unsupported grant type")
+)
+
+// Driver is OAuth2 client credentials authentication
+type Driver struct {
+	dependency.Container
+	config     Config
+	clients    ClientService
+	assertions assertionCache
+	Now        func() time.Time
+}
+
+// New is the constructor for Driver
+func New(config Config, clients ClientService, container dependency.Container) *Driver {
+	var driver = &Driver{}
+
+	driver.config = config
+
+	if clients == nil {
+		return nil
+	}
+	driver.clients = clients
+
+	jwtConfig, err := gate.NewHMACJWTConfig("HS256", config.JWTSigningKey(), config.JWTExpiration(), config.JWTSkipClaimsValidation())
+	if err != nil {
+		return nil
+	}
+	container.SetJWTService(gate.NewJWTService(jwtConfig))
+	container.SetMatcher(internal.NewMatcher())
+	driver.Container = container
+
+	driver.assertions = assertionCache{map[string]time.Time{}, &sync.Mutex{}}
+	driver.Now = time.Now
+
+	return driver
+}
+
+// LoginURL returns the URL to the consent page
+func (auth Driver) LoginURL(state string) (string, error) {
+	return "", errors.New("the driver does not support login URL")
+}
+
+// Login authenticates a client with either client_id and client_secret or client_assertion_type and client_assertion.
+// The optional scope is a space-delimited subset of the client allowed scopes, which are all granted when it is omitted
+func (auth Driver) Login(credentials map[string]string) (user gate.User, err error) {
+	defer func(start time.Time) {
+		gate.AuditLogin(auth, Provider, credentials["client_id"], user, err)
+		gate.MeasureLogin(auth, Provider, start, err)
+	}(time.Now())
+
+	var client Client
+	if assertion := credentials["client_assertion"]; assertion != "" {
+		if credentials["client_assertion_type"] != AssertionType {
+			err = ErrInvalidRequest
+			return
+		}
+
+		client, err = auth.verifyAssertion(assertion, credentials["client_id"])
+	} else {
+		client, err = auth.verifySecret(credentials["client_id"], credentials["client_secret"])
+	}
+	if err != nil {
+		return
+	}
+
+	scopes, err := grantScopes(client, strings.Fields(credentials["scope"]))
+	if err != nil {
+		return
+	}
+
+	user = auth.newClientUser(client, scopes)
+	return
+}
+
+// IssueJWT issues and stores a JWT for a specific user. Tokens of clients carry the granted scopes
+func (auth Driver) IssueJWT(user gate.User) (token gate.JWT, err error) {
+	client, ok := user.(ClientUser)
+	if !ok {
+		return gate.IssueJWT(auth, user)
+	}
+
+	service, err := auth.JWTService()
+	if err != nil {
+		return
+	}
+
+	claims := service.NewClaims(client)
+	claims.Scope = strings.Join(client.Scopes, " ")
+	token, err = service.Issue(claims)
+	if err != nil {
+		err = errors.Wrap(err, "could not issue JWT")
+		return
+	}
+
+	err = gate.StoreJWT(auth, token)
+	if err != nil {
+		err = errors.Wrap(err, "could not store JWT")
+		return
+	}
+
+	gate.Audit(auth, gate.AuditEvent{Type: gate.EventTokenIssued, UserID: token.UserID, TokenID: token.ID, TokenKind: gate.TokenKindJWT})
+	return
+}
+
+// ParseJWT parses a JWT string to a JWT
+func (auth Driver) ParseJWT(tokenString string) (gate.JWT, error) {
+	return gate.ParseJWT(auth, tokenString)
+}
+
+// Authenticate performs the authentication using JWT
+func (auth Driver) Authenticate(tokenString string) (gate.User, error) {
+	return gate.Authenticate(auth, tokenString)
+}
+
+// GetUserFromJWT returns the client from a given JWT. Scopes the client is no longer allowed are dropped
+func (auth Driver) GetUserFromJWT(token gate.JWT) (user gate.User, err error) {
+	client, err := auth.findClient(token.UserID)
+	if err != nil {
+		err = errors.Wrap(err, "could not find the client with the given id")
+		return
+	}
+
+	var scopes []string
+	allowed := client.GetAllowedScopes()
+	for _, scope := range token.Scopes {
+		if contains(allowed, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	user = auth.newClientUser(client, scopes)
+	return
+}
+
+// Authorize performs the authorization when a given user takes an action on an object using RBAC
+func (auth Driver) Authorize(user gate.User, action, object string) (err error) {
+	return gate.Authorize(auth, user, action, object)
+}
+
+// GetUserAbilities returns a user's abilities
+func (auth Driver) GetUserAbilities(user gate.User) (abilities []gate.UserAbility, err error) {
+	return gate.GetUserAbilities(auth, user)
+}
+
+func (auth Driver) verifySecret(id, secret string) (client Client, err error) {
+	if id == "" || secret == "" {
+		err = ErrInvalidClient
+		return
+	}
+
+	client, err = auth.findClient(id)
+	if err != nil {
+		return
+	}
+
+	if !client.VerifySecret(secret) {
+		err = ErrInvalidClient
+		return
+	}
+	return
+}
+
+func (auth Driver) findClient(id string) (client Client, err error) {
+	client, err = auth.clients.FindOneByID(id)
+	if err != nil {
+		if auth.clients.IsErrNotFound(err) {
+			err = ErrInvalidClient
+			return
+		}
+
+		err = errors.Wrap(err, "could not find the client")
+		return
+	}
+	return
+}
+
+func (auth Driver) newClientUser(client Client, scopes []string) ClientUser {
+	user := ClientUser{Client: client, Scopes: scopes}
+	if auth.config.Scopes == nil {
+		return user
+	}
+
+	user.abilities = []gate.UserAbility{}
+	for _, scope := range scopes {
+		for _, ability := range auth.config.Scopes[scope] {
+			user.abilities = append(user.abilities, ability)
+		}
+	}
+	return user
+}
+
+func grantScopes(client Client, requested []string) (scopes []string, err error) {
+	allowed := client.GetAllowedScopes()
+	if len(requested) == 0 {
+		scopes = allowed
+		return
+	}
+
+	for _, scope := range requested {
+		if !contains(allowed, scope) {
+			err = ErrInvalidScope
+			return
+		}
+
+		if !contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,87 @@
+package clientcredentials_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/clientcredentials"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal/test/fixtures"
+)
+
+func Example() {
+	var auth gate.Auth
+
+	clientService := fixtures.NewMyClientService(
+		[]fixtures.Client{
+			{
+				ID:     "client-id",
+				Secret: "client-secret",
+				Roles:  []string{"role-id"},
+				Scopes: []string{"users:read"},
+			},
+		},
+	)
+	tokenService := fixtures.NewMyTokenService(nil)
+	roleService := fixtures.NewMyRoleService([]fixtures.Role{
+		{
+			ID: "role-id",
+			Abilities: []fixtures.Ability{
+				{Action: "GET", Object: "/api/v1/*"},
+			},
+		},
+	})
+
+	driver := clientcredentials.New(
+		clientcredentials.Config{
+			Config:   gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+			TokenURL: "http://localhost:8080/oauth/token",
+			Scopes: map[string][]gate.Ability{
+				"users:read": {{Action: "GET", Object: "/api/v1/users*"}},
+			},
+		},
+		clientService,
+		dependency.NewContainer(nil, tokenService, roleService),
+	)
+	if driver == nil {
+		fmt.Println("driver should not be nil")
+		return
+	}
+
+	auth = driver
+
+	// Mount the token endpoint, e.g. http.Handle("/oauth/token", driver.TokenHandler())
+	request := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth("client-id", "client-secret")
+
+	recorder := httptest.NewRecorder()
+	driver.TokenHandler().ServeHTTP(recorder, request)
+	fmt.Println(recorder.Code == http.StatusOK)
+
+	// Service accounts may also log in directly
+	user, err := auth.Login(map[string]string{"client_id": "client-id", "client_secret": "client-secret"})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("%s - %v\n", user.GetID(), err)
+
+	err = auth.Authorize(user, "GET", "/api/v1/users")
+	fmt.Printf("%v\n", err)
+
+	err = auth.Authorize(user, "GET", "/api/v1/posts")
+	fmt.Printf("%v\n", err)
+
+	// Output:
+	// true
+	// client-id - <nil>
+	// <nil>
+	// forbidden
+}
@@ -0,0 +1,392 @@
+package clientcredentials_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/clientcredentials"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal/test"
+	"github.com/hiendv/gate/internal/test/fixtures"
+	"github.com/pkg/errors"
+)
+
+var (
+	auth          gate.Auth
+	driver        *clientcredentials.Driver
+	clients       []fixtures.Client
+	rsaKey        *rsa.PrivateKey
+	ecdsaKey      *ecdsa.PrivateKey
+	tokenService  *fixtures.MyTokenService
+	clientService *fixtures.MyClientService
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	ecdsaKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	roles := []fixtures.Role{
+		{
+			ID: fixtures.RandomString(8),
+			Abilities: []fixtures.Ability{
+				{Action: "GET", Object: "/api/v1/*"},
+				{Action: "POST", Object: "/api/v1/users*"},
+			},
+		},
+	}
+
+	clients = []fixtures.Client{
+		{
+			ID:     fixtures.RandomString(8),
+			Name:   "billing",
+			Secret: "billing-secret",
+			Roles:  []string{roles[0].ID},
+			Scopes: []string{"users:read", "users:write"},
+		},
+		{
+			ID:        fixtures.RandomString(8),
+			Name:      "reporting",
+			Roles:     []string{roles[0].ID},
+			Scopes:    []string{"users:read"},
+			PublicKey: &rsaKey.PublicKey,
+		},
+		{
+			ID:        fixtures.RandomString(8),
+			Name:      "monitoring",
+			Roles:     []string{roles[0].ID},
+			Scopes:    []string{"users:read"},
+			PublicKey: &ecdsaKey.PublicKey,
+		},
+	}
+
+	tokenService = fixtures.NewMyTokenService(nil)
+	clientService = fixtures.NewMyClientService(clients)
+
+	driver = clientcredentials.New(
+		newConfig(),
+		clientService,
+		dependency.NewContainer(nil, tokenService, fixtures.NewMyRoleService(roles)),
+	)
+
+	auth = driver
+	if auth == nil {
+		os.Exit(1)
+	}
+
+	os.Exit(m.Run())
+}
+
+func newAssertion(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	assertion, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	test.AssertOK(t, err, "valid assertion")
+	return assertion
+}
+
+func newAssertionClaims(client fixtures.Client) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss": client.ID,
+		"sub": client.ID,
+		"aud": tokenURL,
+		"jti": fixtures.RandomString(16),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func TestClientCredentialsSecret(t *testing.T) {
+	t.Run("valid secret", func(t *testing.T) {
+		user, err := auth.Login(map[string]string{"client_id": clients[0].ID, "client_secret": "billing-secret"})
+		test.AssertOK(t, err, "valid secret")
+
+		client, ok := user.(clientcredentials.ClientUser)
+		if !ok || client.GetID() != clients[0].ID {
+			t.Fatalf("unexpected user: %v", user)
+		}
+
+		if strings.Join(client.Scopes, " ") != "users:read users:write" {
+			t.Fatalf("unexpected scopes: %v", client.Scopes)
+		}
+	})
+
+	t.Run("requested scopes", func(t *testing.T) {
+		user, err := auth.Login(map[string]string{"client_id": clients[0].ID, "client_secret": "billing-secret", "scope": "users:read users:read"})
+		test.AssertOK(t, err, "allowed scope")
+
+		if scopes := user.(clientcredentials.ClientUser).Scopes; len(scopes) != 1 || scopes[0] != "users:read" {
+			t.Fatalf("unexpected scopes: %v", scopes)
+		}
+
+		_, err = auth.Login(map[string]string{"client_id": clients[1].ID, "client_secret": "", "scope": "users:write"})
+		test.AssertErr(t, err, "missing secret")
+
+		_, err = auth.Login(map[string]string{"client_id": clients[0].ID, "client_secret": "billing-secret", "scope": "users:delete"})
+		if err != clientcredentials.ErrInvalidScope {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("invalid client", func(t *testing.T) {
+		_, err := auth.Login(map[string]string{})
+		test.AssertErr(t, err, "missing credentials")
+
+		_, err = auth.Login(map[string]string{"client_id": clients[0].ID, "client_secret": "wrong"})
+		if err != clientcredentials.ErrInvalidClient {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = auth.Login(map[string]string{"client_id": "unknown", "client_secret": "billing-secret"})
+		if err != clientcredentials.ErrInvalidClient || !errors.Is(err, gate.ErrInvalidCredentials) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = auth.Login(map[string]string{"client_id": clients[1].ID, "client_secret": "anything"})
+		test.AssertErr(t, err, "client without secret")
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		_, err := auth.Login(map[string]string{"client_id": fixtures.ClientIDTriggeringDatabaseError, "client_secret": "secret"})
+		test.AssertErr(t, err, "database error")
+
+		if err == clientcredentials.ErrInvalidClient {
+			t.Fatal("database errors should not be reported as invalid clients")
+		}
+	})
+}
+
+func TestClientCredentialsAssertion(t *testing.T) {
+	login := func(assertion string) (gate.User, error) {
+		return auth.Login(map[string]string{
+			"client_assertion_type": clientcredentials.AssertionType,
+			"client_assertion":      assertion,
+		})
+	}
+
+	t.Run("valid assertion", func(t *testing.T) {
+		user, err := login(newAssertion(t, jwt.SigningMethodRS256, rsaKey, newAssertionClaims(clients[1])))
+		test.AssertOK(t, err, "valid RSA assertion")
+
+		if user.GetID() != clients[1].ID {
+			t.Fatalf("unexpected user: %s", user.GetID())
+		}
+
+		claims := newAssertionClaims(clients[2])
+		claims["aud"] = []string{"https://other.local", tokenURL}
+		user, err = login(newAssertion(t, jwt.SigningMethodES256, ecdsaKey, claims))
+		test.AssertOK(t, err, "valid ECDSA assertion")
+
+		if user.GetID() != clients[2].ID {
+			t.Fatalf("unexpected user: %s", user.GetID())
+		}
+	})
+
+	t.Run("replayed assertion", func(t *testing.T) {
+		assertion := newAssertion(t, jwt.SigningMethodRS256, rsaKey, newAssertionClaims(clients[1]))
+		_, err := login(assertion)
+		test.AssertOK(t, err, "valid assertion")
+
+		_, err = login(assertion)
+		test.AssertErr(t, err, "replayed assertion")
+	})
+
+	t.Run("invalid assertion", func(t *testing.T) {
+		_, err := auth.Login(map[string]string{
+			"client_assertion_type": "urn:unknown",
+			"client_assertion":      newAssertion(t, jwt.SigningMethodRS256, rsaKey, newAssertionClaims(clients[1])),
+		})
+		test.AssertErr(t, err, "unknown assertion type")
+
+		_, err = auth.Login(map[string]string{
+			"client_id":             clients[2].ID,
+			"client_assertion_type": clientcredentials.AssertionType,
+			"client_assertion":      newAssertion(t, jwt.SigningMethodRS256, rsaKey, newAssertionClaims(clients[1])),
+		})
+		test.AssertErr(t, err, "mismatched client id")
+
+		_, err = login("malformed")
+		test.AssertErr(t, err, "malformed assertion")
+
+		_, err = login(newAssertion(t, jwt.SigningMethodHS256, []byte("billing-secret"), newAssertionClaims(clients[0])))
+		test.AssertErr(t, err, "symmetric assertion")
+
+		_, err = login(newAssertion(t, jwt.SigningMethodES256, ecdsaKey, newAssertionClaims(clients[1])))
+		test.AssertErr(t, err, "wrong key")
+
+		claims := newAssertionClaims(clients[1])
+		claims["aud"] = "https://other.local"
+		_, err = login(newAssertion(t, jwt.SigningMethodRS256, rsaKey, claims))
+		test.AssertErr(t, err, "wrong audience")
+
+		claims = newAssertionClaims(clients[1])
+		claims["iss"] = clients[2].ID
+		_, err = login(newAssertion(t, jwt.SigningMethodRS256, rsaKey, claims))
+		test.AssertErr(t, err, "wrong issuer")
+
+		claims = newAssertionClaims(clients[1])
+		claims["exp"] = time.Now().Add(-time.Minute).Unix()
+		_, err = login(newAssertion(t, jwt.SigningMethodRS256, rsaKey, claims))
+		test.AssertErr(t, err, "expired assertion")
+
+		claims = newAssertionClaims(clients[1])
+		delete(claims, "exp")
+		_, err = login(newAssertion(t, jwt.SigningMethodRS256, rsaKey, claims))
+		test.AssertErr(t, err, "missing expiration")
+
+		claims = newAssertionClaims(clients[1])
+		delete(claims, "jti")
+		_, err = login(newAssertion(t, jwt.SigningMethodRS256, rsaKey, claims))
+		test.AssertErr(t, err, "missing assertion id")
+
+		claims = newAssertionClaims(fixtures.Client{ID: fixtures.ClientIDTriggeringDatabaseError})
+		_, err = login(newAssertion(t, jwt.SigningMethodRS256, rsaKey, claims))
+		test.AssertErr(t, err, "database error")
+	})
+}
+
+func TestClientCredentialsHandler(t *testing.T) {
+	server := httptest.NewServer(driver.TokenHandler())
+	defer server.Close()
+
+	request := func(form url.Values, id, secret string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if id != "" {
+			req.SetBasicAuth(url.QueryEscape(id), url.QueryEscape(secret))
+		}
+
+		return http.DefaultClient.Do(req)
+	}
+
+	t.Run("basic authentication", func(t *testing.T) {
+		res, err := request(url.Values{"grant_type": {"client_credentials"}, "scope": {"users:read"}}, clients[0].ID, "billing-secret")
+		test.AssertOK(t, err, "valid request")
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK || res.Header.Get("Cache-Control") != "no-store" {
+			t.Fatalf("unexpected response: %d %v", res.StatusCode, res.Header)
+		}
+
+		var body clientcredentials.TokenResponse
+		err = json.NewDecoder(res.Body).Decode(&body)
+		test.AssertOK(t, err, "valid body")
+
+		if body.TokenType != "Bearer" || body.Scope != "users:read" || body.ExpiresIn != 3600 {
+			t.Fatalf("unexpected body: %v", body)
+		}
+
+		user, err := auth.Authenticate(body.AccessToken)
+		test.AssertOK(t, err, "valid token")
+
+		if user.GetID() != clients[0].ID {
+			t.Fatalf("unexpected user: %s", user.GetID())
+		}
+	})
+
+	t.Run("form authentication", func(t *testing.T) {
+		res, err := request(url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_assertion_type": {clientcredentials.AssertionType},
+			"client_assertion":      {newAssertion(t, jwt.SigningMethodRS256, rsaKey, newAssertionClaims(clients[1]))},
+		}, "", "")
+		test.AssertOK(t, err, "valid request")
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status: %d", res.StatusCode)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		cases := []struct {
+			form   url.Values
+			id     string
+			secret string
+			status int
+			code   string
+		}{
+			{url.Values{"grant_type": {"client_credentials"}}, clients[0].ID, "wrong", http.StatusUnauthorized, "invalid_client"},
+			{url.Values{"grant_type": {"client_credentials"}, "client_id": {clients[0].ID}, "client_secret": {"wrong"}}, "", "", http.StatusUnauthorized, "invalid_client"},
+			{url.Values{"grant_type": {"client_credentials"}, "scope": {"users:delete"}}, clients[0].ID, "billing-secret", http.StatusBadRequest, "invalid_scope"},
+			{url.Values{"grant_type": {"client_credentials"}}, fixtures.ClientIDTriggeringDatabaseError, "secret", http.StatusInternalServerError, "server_error"},
+		}
+
+		for _, c := range cases {
+			res, err := request(c.form, c.id, c.secret)
+			test.AssertOK(t, err, "valid request")
+
+			var body clientcredentials.ErrorResponse
+			err = json.NewDecoder(res.Body).Decode(&body)
+			res.Body.Close()
+			test.AssertOK(t, err, "valid body")
+
+			if res.StatusCode != c.status || body.Error != c.code {
+				t.Fatalf("unexpected response: %d %v", res.StatusCode, body)
+			}
+
+			if c.id != "" && c.code == "invalid_client" && res.Header.Get("WWW-Authenticate") == "" {
+				t.Fatal("missing WWW-Authenticate header")
+			}
+		}
+	})
+}
+
+func TestClientCredentialsJWT(t *testing.T) {
+	user, err := auth.Login(map[string]string{"client_id": clients[0].ID, "client_secret": "billing-secret", "scope": "users:read"})
+	test.AssertOK(t, err, "valid secret")
+
+	token, err := auth.IssueJWT(user)
+	test.AssertOK(t, err, "valid user")
+
+	if len(token.Scopes) != 1 || token.Scopes[0] != "users:read" {
+		t.Fatalf("unexpected scopes: %v", token.Scopes)
+	}
+
+	_, err = tokenService.FindOneByID(token.ID)
+	test.AssertOK(t, err, "existing token")
+
+	parsedToken, err := auth.ParseJWT(token.Value)
+	test.AssertOK(t, err, "valid token")
+
+	_, err = auth.GetUserFromJWT(parsedToken)
+	test.AssertOK(t, err, "existing client")
+
+	user, err = auth.Authenticate(token.Value)
+	test.AssertOK(t, err, "valid token")
+
+	err = auth.Authorize(user, "GET", "/api/v1/users")
+	test.AssertOK(t, err, "granted scope")
+
+	err = auth.Authorize(user, "POST", "/api/v1/users")
+	test.AssertErr(t, err, "scope not granted")
+
+	err = auth.Authorize(user, "GET", "/api/v1/posts")
+	test.AssertErr(t, err, "ability outside of the scopes")
+
+	_, err = auth.GetUserAbilities(user)
+	test.AssertOK(t, err, "valid abilities")
+
+	_, err = auth.GetUserFromJWT(gate.JWT{UserID: "unknown"})
+	test.AssertErr(t, err, "unknown client")
+}
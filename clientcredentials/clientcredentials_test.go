@@ -0,0 +1,130 @@
+package clientcredentials_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/clientcredentials"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal/test"
+	"github.com/hiendv/gate/internal/test/fixtures"
+)
+
+const tokenURL = "https://gate.local/oauth/token"
+
+func newConfig() clientcredentials.Config {
+	return clientcredentials.Config{
+		Config:   gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+		TokenURL: tokenURL,
+		Scopes: map[string][]gate.Ability{
+			"users:read":  {{Action: "GET", Object: "/api/v1/users*"}},
+			"users:write": {{Action: "POST", Object: "/api/v1/users*"}},
+		},
+	}
+}
+
+func TestClientCredentialsInvalidConfig(t *testing.T) {
+	instance := clientcredentials.New(
+		clientcredentials.Config{},
+		fixtures.NewMyClientService(nil),
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+
+	if instance != nil {
+		t.Fatal("unexpected non-nil driver")
+	}
+}
+
+func TestClientCredentialsInvalidDependencies(t *testing.T) {
+	instance := clientcredentials.New(
+		newConfig(),
+		nil,
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+
+	if instance != nil {
+		t.Fatal("unexpected non-nil driver")
+	}
+}
+
+func TestClientCredentialsLoginURL(t *testing.T) {
+	driver := clientcredentials.New(
+		newConfig(),
+		fixtures.NewMyClientService(nil),
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	_, err := driver.LoginURL("state")
+	test.AssertErr(t, err, "unsupported login URL")
+}
+
+func TestClientCredentialsMissingTokenURL(t *testing.T) {
+	config := newConfig()
+	config.TokenURL = ""
+	driver := clientcredentials.New(
+		config,
+		fixtures.NewMyClientService(nil),
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	_, err := driver.Login(map[string]string{
+		"client_assertion_type": clientcredentials.AssertionType,
+		"client_assertion":      "assertion",
+	})
+	test.AssertErr(t, err, "missing token URL")
+}
+
+func TestClientCredentialsHandlerRequests(t *testing.T) {
+	driver := clientcredentials.New(
+		newConfig(),
+		fixtures.NewMyClientService(nil),
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	handler := driver.TokenHandler()
+
+	request := httptest.NewRequest(http.MethodGet, tokenURL, nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status: %d", recorder.Code)
+	}
+
+	form := url.Values{"grant_type": {"password"}}
+	request = httptest.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "unsupported_grant_type") {
+		t.Fatalf("unexpected response: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	form = url.Values{"grant_type": {"client_credentials"}, "client_secret": {"secret"}}
+	request = httptest.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth("id", "secret")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "invalid_request") {
+		t.Fatalf("unexpected response: %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,14 @@
+package clientcredentials
+
+import (
+	"github.com/hiendv/gate"
+)
+
+// Config is the configuration for client credentials authentication
+type Config struct {
+	gate.Config
+	// TokenURL is the public URL of the token endpoint. It is the expected audience of client assertions
+	TokenURL string
+	// Scopes maps scopes to the abilities they grant. Tokens are not restricted by their scopes when it is nil
+	Scopes map[string][]gate.Ability
+}
@@ -0,0 +1,2 @@
+// Package clientcredentials is the OAuth2 client credentials driver for github.com/hiendv/gate. It makes Gate the token issuer of service accounts which authenticate with a client secret or a private_key_jwt assertion.
+package clientcredentials
@@ -0,0 +1,123 @@
+package clientcredentials
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TokenResponse is the successful response of the token endpoint
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// ErrorResponse is the error response of the token endpoint
+type ErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+type tokenHandler struct {
+	auth Driver
+}
+
+// TokenHandler returns the token endpoint handling client credentials requests (RFC 6749 section 4.4)
+func (auth Driver) TokenHandler() http.Handler {
+	return tokenHandler{auth}
+}
+
+// ServeHTTP authenticates the client and responds with a JWT
+func (handler tokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{"invalid_request", "the token endpoint only accepts POST"})
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writeError(w, ErrInvalidRequest, false)
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != GrantType {
+		writeError(w, ErrUnsupportedGrantType, false)
+		return
+	}
+
+	credentials := map[string]string{}
+	for _, key := range []string{"client_id", "client_secret", "client_assertion_type", "client_assertion", "scope"} {
+		credentials[key] = r.PostForm.Get(key)
+	}
+
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		if credentials["client_secret"] != "" || credentials["client_assertion"] != "" {
+			writeError(w, ErrInvalidRequest, false)
+			return
+		}
+
+		credentials["client_id"], err = url.QueryUnescape(id)
+		if err != nil {
+			writeError(w, ErrInvalidClient, true)
+			return
+		}
+
+		credentials["client_secret"], err = url.QueryUnescape(secret)
+		if err != nil {
+			writeError(w, ErrInvalidClient, true)
+			return
+		}
+	}
+
+	user, err := handler.auth.Login(credentials)
+	if err != nil {
+		writeError(w, err, basic)
+		return
+	}
+
+	token, err := handler.auth.IssueJWT(user)
+	if err != nil {
+		writeError(w, err, basic)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TokenResponse{
+		AccessToken: token.Value,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(token.ExpiredAt.Sub(token.IssuedAt).Seconds()),
+		Scope:       strings.Join(token.Scopes, " "),
+	})
+}
+
+func writeError(w http.ResponseWriter, err error, basic bool) {
+	switch errors.Cause(err) {
+	case ErrInvalidRequest:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{"invalid_request", err.Error()})
+	case ErrInvalidClient:
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{"invalid_client", err.Error()})
+	case ErrInvalidScope:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{"invalid_scope", err.Error()})
+	case ErrUnsupportedGrantType:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{"unsupported_grant_type", err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{"server_error", ""})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
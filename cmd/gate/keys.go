@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+func keygen(args []string, stdin io.Reader, stdout, stderr io.Writer) (err error) {
+	flags := newFlagSet("keygen", "", stderr)
+	kind := flags.String("type", "hmac", "the type of the key: hmac, rsa, ecdsa or ed25519")
+	bits := flags.Int("bits", 0, "the size of the HMAC secret, 256 by default, or of the RSA key, 2048 by default")
+	curve := flags.String("curve", "P-256", "the curve of the ECDSA key: P-256, P-384 or P-521")
+	out := flags.String("out", "", "write the key pair to <out>.pem and <out>.pub.pem instead of the standard output")
+	err = flags.Parse(args)
+	if err != nil {
+		return
+	}
+
+	var private crypto.Signer
+	switch *kind {
+	case "hmac":
+		size := *bits
+		if size == 0 {
+			size = 256
+		}
+
+		if size < 256 || size%8 != 0 {
+			return errors.New("the HMAC secret must be a multiple of 8 bits, at least 256")
+		}
+
+		secret := make([]byte, size/8)
+		_, err = rand.Read(secret)
+		if err != nil {
+			return errors.Wrap(err, "could not generate the secret")
+		}
+
+		_, err = fmt.Fprintln(stdout, base64.RawURLEncoding.EncodeToString(secret))
+		return
+	case "rsa":
+		size := *bits
+		if size == 0 {
+			size = 2048
+		}
+
+		if size < 2048 {
+			return errors.New("the RSA key must be at least 2048 bits")
+		}
+
+		private, err = rsa.GenerateKey(rand.Reader, size)
+	case "ecdsa":
+		c, ok := curves[*curve]
+		if !ok {
+			return errors.Errorf("unknown curve %q", *curve)
+		}
+
+		private, err = ecdsa.GenerateKey(c, rand.Reader)
+	case "ed25519":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return errors.Errorf("unknown key type %q", *kind)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "could not generate the key")
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return errors.Wrap(err, "could not encode the private key")
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return errors.Wrap(err, "could not encode the public key")
+	}
+
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	if *out == "" {
+		_, err = stdout.Write(append(privatePEM, publicPEM...))
+		return
+	}
+
+	err = writeNew(*out+".pem", privatePEM, 0600)
+	if err != nil {
+		return
+	}
+
+	err = writeNew(*out+".pub.pem", publicPEM, 0644)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(stdout, "wrote %s.pem and %s.pub.pem\n", *out, *out)
+	return
+}
+
+// writeNew writes a file which must not exist, so keys are never overwritten
+func writeNew(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return errors.Wrap(err, "could not create the file")
+	}
+
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "could not write the file")
+	}
+
+	return file.Close()
+}
+
+// readKey reads a PEM private or public key
+func readKey(path string) (key interface{}, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.Wrap(err, "could not read the key")
+		return
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		err = errors.Errorf("%s is not a PEM key", path)
+		return
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		err = errors.Errorf("unsupported PEM block %q", block.Type)
+		return
+	}
+
+	if err != nil {
+		err = errors.Wrap(err, "could not parse the key")
+		return
+	}
+	return
+}
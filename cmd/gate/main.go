@@ -0,0 +1,105 @@
+// Command gate offers the operations on keys, tokens and policies of gate, e.g.
+//
+//	gate keygen -type rsa -out jwt
+//	gate issue -key jwt.pem -user 42 -roles editor
+//	gate verify -key jwt.pub.pem eyJhbGciOi...
+//	gate can -policy policy.yaml -roles editor POST /posts
+//	gate simulate -before policy.yaml -after changed.yaml requests.jsonl
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+var commands = []command{
+	{"keygen", "generate an HMAC secret or an RSA, ECDSA or Ed25519 key pair", keygen},
+	{"issue", "issue a token for a user ID with roles", issue},
+	{"verify", "decode and verify a token, showing its claims and validation failures", verify},
+	{"can", "evaluate whether a user with roles may take an action on an object against a policy", can},
+	{"simulate", "report the decisions flipping between two policies for a corpus of requests", simulate},
+}
+
+// errFailed is returned by commands which already reported the failure, e.g. a denied authorization
+var errFailed = errors.New("failed")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		usage(stderr)
+		return 2
+	}
+
+	for _, command := range commands {
+		if command.name != args[0] {
+			continue
+		}
+
+		err := command.run(args[1:], stdin, stdout, stderr)
+		switch {
+		case err == nil:
+			return 0
+		case err == flag.ErrHelp:
+			return 2
+		case err == errFailed:
+			return 1
+		}
+
+		fmt.Fprintf(stderr, "gate %s: %v\n", command.name, err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "gate: unknown command %q\n", args[0])
+	usage(stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: gate <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, command := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", command.name, command.summary)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "gate <command> -h" for the flags of a command.`)
+}
+
+// newFlagSet returns the flag set of a command reporting its errors to stderr
+func newFlagSet(name, arguments string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet("gate "+name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: gate %s [flags] %s\n", name, arguments)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+// list splits a comma-separated flag value
+func list(value string) (items []string) {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return
+}
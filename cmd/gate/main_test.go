@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func execute(t *testing.T, stdin string, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(stdin), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "gate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	code, _, stderr := execute(t, "")
+	if code != 2 || !strings.Contains(stderr, "keygen") {
+		t.Fatalf("unexpected usage: %d %s", code, stderr)
+	}
+
+	code, _, stderr = execute(t, "", "unknown")
+	if code != 2 || !strings.Contains(stderr, `unknown command "unknown"`) {
+		t.Fatalf("unexpected usage: %d %s", code, stderr)
+	}
+
+	code, _, stderr = execute(t, "", "issue", "-h")
+	if code != 2 || !strings.Contains(stderr, "-roles") {
+		t.Fatalf("unexpected usage: %d %s", code, stderr)
+	}
+}
+
+func TestKeygen(t *testing.T) {
+	code, stdout, _ := execute(t, "", "keygen")
+	if code != 0 || len(strings.TrimSpace(stdout)) != 43 {
+		t.Fatalf("unexpected secret: %q", stdout)
+	}
+
+	code, _, stderr := execute(t, "", "keygen", "-bits", "128")
+	if code != 1 || !strings.Contains(stderr, "at least 256") {
+		t.Fatalf("unexpected error: %s", stderr)
+	}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	cases := []struct {
+		args  []string
+		check func(key interface{}) bool
+	}{
+		{[]string{"-type", "rsa"}, func(key interface{}) bool { _, ok := key.(*rsa.PrivateKey); return ok }},
+		{[]string{"-type", "ecdsa", "-curve", "P-384"}, func(key interface{}) bool {
+			k, ok := key.(*ecdsa.PrivateKey)
+			return ok && k.Curve.Params().Name == "P-384"
+		}},
+		{[]string{"-type", "ed25519"}, func(key interface{}) bool { _, ok := key.(ed25519.PrivateKey); return ok }},
+	}
+
+	for i, c := range cases {
+		out := filepath.Join(dir, string(rune('a'+i)))
+		code, _, stderr := execute(t, "", append([]string{"keygen", "-out", out}, c.args...)...)
+		if code != 0 {
+			t.Fatalf("unexpected error: %s", stderr)
+		}
+
+		key, err := readKey(out + ".pem")
+		if err != nil || !c.check(key) {
+			t.Fatalf("unexpected key %v: %v", c.args, err)
+		}
+
+		_, err = readKey(out + ".pub.pem")
+		if err != nil {
+			t.Fatalf("unexpected public key %v: %v", c.args, err)
+		}
+
+		info, err := os.Stat(out + ".pem")
+		if err != nil || info.Mode().Perm() != 0600 {
+			t.Fatalf("unexpected private key file: %v", info)
+		}
+
+		code, _, _ = execute(t, "", append([]string{"keygen", "-out", out}, c.args...)...)
+		if code != 1 {
+			t.Fatal("keys should not be overwritten")
+		}
+	}
+
+	code, stdout, _ = execute(t, "", "keygen", "-type", "ecdsa")
+	block, rest := pem.Decode([]byte(stdout))
+	if code != 0 || block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatalf("unexpected key: %s", stdout)
+	}
+
+	block, _ = pem.Decode(rest)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatalf("unexpected key: %s", stdout)
+	}
+}
+
+func TestIssueVerify(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	key := filepath.Join(dir, "jwt")
+	code, _, stderr := execute(t, "", "keygen", "-type", "rsa", "-out", key)
+	if code != 0 {
+		t.Fatalf("unexpected error: %s", stderr)
+	}
+
+	code, stdout, stderr := execute(t, "", "issue", "-key", key+".pem", "-user", "42", "-roles", "editor, viewer", "-tenant", "acme", "-expiration", "1h")
+	if code != 0 {
+		t.Fatalf("unexpected error: %s", stderr)
+	}
+
+	token := strings.TrimSpace(stdout)
+	code, stdout, stderr = execute(t, "", "verify", "-key", key+".pub.pem", token)
+	if code != 0 || !strings.Contains(stdout, `"alg": "RS256"`) || !strings.Contains(stdout, `"sub": "42"`) || !strings.Contains(stdout, `"tenant": "acme"`) || !strings.Contains(stdout, "  valid at") {
+		t.Fatalf("unexpected verification: %s %s", stdout, stderr)
+	}
+
+	code, stdout, _ = execute(t, token+"\n", "verify", "-key", key+".pub.pem", "-at", time.Now().Add(-time.Hour).Format(time.RFC3339))
+	if code != 1 || !strings.Contains(stdout, "FAILED iat: the token is not valid yet") {
+		t.Fatalf("unexpected verification: %s", stdout)
+	}
+
+	code, stdout, _ = execute(t, "", "verify", "-key", key+".pub.pem", "-at", time.Now().Add(time.Hour*2).Format(time.RFC3339), "-max-age", "1m", token)
+	if code != 1 || !strings.Contains(stdout, "FAILED exp: the token is expired") || !strings.Contains(stdout, "FAILED iat: the token exceeds its maximum age") {
+		t.Fatalf("every failure should be reported: %s", stdout)
+	}
+
+	code, stdout, _ = execute(t, "", "verify", "-secret", "secret", token)
+	if code != 1 || !strings.Contains(stdout, "FAILED signature") {
+		t.Fatalf("unexpected verification: %s", stdout)
+	}
+
+	code, _, stderr = execute(t, "", "issue", "-secret", "secret", "-key", key+".pem", "-user", "42")
+	if code != 1 || !strings.Contains(stderr, "not both") {
+		t.Fatalf("unexpected error: %s", stderr)
+	}
+
+	code, _, stderr = execute(t, "", "issue", "-secret", "secret")
+	if code != 1 || !strings.Contains(stderr, "missing -user") {
+		t.Fatalf("unexpected error: %s", stderr)
+	}
+
+	code, _, stderr = execute(t, "", "verify", "-secret", "secret", "not-a-token")
+	if code != 1 || !strings.Contains(stderr, "malformed token") {
+		t.Fatalf("unexpected error: %s", stderr)
+	}
+}
+
+func TestIssueVerifySecret(t *testing.T) {
+	os.Setenv("GATE_SECRET", "environment-secret")
+	defer os.Unsetenv("GATE_SECRET")
+
+	code, stdout, stderr := execute(t, "", "issue", "-user", "42")
+	if code != 0 {
+		t.Fatalf("unexpected error: %s", stderr)
+	}
+
+	token := strings.TrimSpace(stdout)
+	code, stdout, _ = execute(t, "", "verify", token)
+	if code != 0 || !strings.Contains(stdout, `"alg": "HS256"`) {
+		t.Fatalf("unexpected verification: %s", stdout)
+	}
+
+	code, stdout, _ = execute(t, "", "verify", "-secret", "other-secret", token)
+	if code != 1 || !strings.Contains(stdout, "FAILED signature") {
+		t.Fatalf("unexpected verification: %s", stdout)
+	}
+}
+
+func TestCan(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "policy.yaml")
+	err := ioutil.WriteFile(path, []byte(`roles:
+  viewer:
+    allow:
+      - {action: GET, object: /posts/*}
+  editor:
+    inherits: [viewer]
+    allow:
+      - {action: POST, object: /posts/*}
+    deny:
+      - {action: GET, object: /posts/drafts/*}
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		roles  string
+		action string
+		object string
+		code   int
+		output string
+	}{
+		{"viewer", "GET", "/posts/1", 0, "allow\n"},
+		{"viewer", "POST", "/posts/1", 1, "deny: forbidden\n"},
+		{"editor", "POST", "/posts/1", 0, "allow\n"},
+		{"editor", "GET", "/posts/drafts/1", 1, "deny: forbidden\n"},
+		{"", "GET", "/posts/1", 1, "deny: there is no abilities\n"},
+	}
+
+	for _, c := range cases {
+		code, stdout, stderr := execute(t, "", "can", "-policy", path, "-user", "42", "-roles", c.roles, c.action, c.object)
+		if code != c.code || stdout != c.output {
+			t.Fatalf("unexpected decision of %s %s for %s: %d %q %s", c.action, c.object, c.roles, code, stdout, stderr)
+		}
+	}
+
+	err = ioutil.WriteFile(path, []byte("roles:\n  viewer:\n    allow: [{action: GET, object: \"posts.(\"}]\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, stderr := execute(t, "", "can", "-policy", path, "-roles", "viewer", "GET", "/posts")
+	if code != 1 || !strings.Contains(stderr, `the object pattern "posts.(" of the role viewer is invalid`) {
+		t.Fatalf("unexpected error: %s", stderr)
+	}
+
+	code, _, _ = execute(t, "", "can", "-policy", path, "GET")
+	if code != 1 {
+		t.Fatal("an action and an object are required")
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	files := map[string]string{
+		"before.yaml": "roles:\n  viewer:\n    allow: [{action: GET, object: /posts/*}]\n",
+		"after.yaml":  "roles:\n  viewer:\n    allow: [{action: GET, object: /comments/*}]\n",
+		"requests.jsonl": `{"user": "1", "roles": ["viewer"], "action": "GET", "object": "/posts/1"}
+{"user": "1", "roles": ["viewer"], "action": "GET", "object": "/comments/1"}
+{"user": "2", "roles": ["guest"], "action": "GET", "object": "/comments/1"}
+`,
+	}
+
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, after, requests := filepath.Join(dir, "before.yaml"), filepath.Join(dir, "after.yaml"), filepath.Join(dir, "requests.jsonl")
+	code, stdout, stderr := execute(t, "", "simulate", "-before", before, "-after", after, requests)
+	expected := `3 requests, 1 revoked, 1 granted
+line 1: allow -> deny: 1 [viewer] GET /posts/1 (forbidden)
+line 2: deny -> allow: 1 [viewer] GET /comments/1 (forbidden)
+`
+	if code != 1 || stdout != expected {
+		t.Fatalf("unexpected simulation: %d %s %s", code, stdout, stderr)
+	}
+
+	code, stdout, stderr = execute(t, files["requests.jsonl"], "simulate", "-before", before, "-after", before, "-format", "json")
+	if code != 0 || !strings.Contains(stdout, `"requests": 3`) || !strings.Contains(stdout, `"revoked": []`) {
+		t.Fatalf("unexpected simulation: %d %s %s", code, stdout, stderr)
+	}
+
+	code, _, stderr = execute(t, `{"action": "GET"}`, "simulate", "-before", before, "-after", after)
+	if code != 1 || !strings.Contains(stderr, "line 1: the request requires an action and an object") {
+		t.Fatalf("unexpected error: %s", stderr)
+	}
+
+	code, _, stderr = execute(t, "", "simulate", "-before", before)
+	if code != 1 || !strings.Contains(stderr, "missing policy") {
+		t.Fatalf("unexpected error: %s", stderr)
+	}
+}
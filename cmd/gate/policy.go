@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hiendv/gate/policy"
+	"github.com/pkg/errors"
+)
+
+// loadPolicy loads a policy which is free of lint errors, as the role service requires
+func loadPolicy(path string) (p policy.Policy, err error) {
+	if path == "" {
+		err = errors.New("missing policy")
+		return
+	}
+
+	p, err = policy.Load(path)
+	if err != nil {
+		return
+	}
+
+	err = policy.Lint(p).Err()
+	if err != nil {
+		p = policy.Policy{}
+		err = errors.Wrap(err, path)
+		return
+	}
+	return
+}
+
+func can(args []string, stdin io.Reader, stdout, stderr io.Writer) (err error) {
+	flags := newFlagSet("can", "<action> <object>", stderr)
+	path := flags.String("policy", "", "the policy file, required")
+	id := flags.String("user", "", "the ID of the user")
+	roles := flags.String("roles", "", "the comma-separated roles of the user")
+	err = flags.Parse(args)
+	if err != nil {
+		return
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errors.New("expected an action and an object")
+	}
+
+	p, err := loadPolicy(*path)
+	if err != nil {
+		return
+	}
+
+	action, object := flags.Arg(0), flags.Arg(1)
+	err = policy.NewEvaluator(p).Authorize(policy.User{ID: *id, Roles: list(*roles)}, action, object)
+	if err != nil {
+		fmt.Fprintf(stdout, "deny: %s\n", errors.Cause(err))
+		return errFailed
+	}
+
+	fmt.Fprintln(stdout, "allow")
+	return
+}
+
+func simulate(args []string, stdin io.Reader, stdout, stderr io.Writer) (err error) {
+	flags := newFlagSet("simulate", "[requests]", stderr)
+	before := flags.String("before", "", "the current policy file, required")
+	after := flags.String("after", "", "the changed policy file, required")
+	format := flags.String("format", "text", "the output format, text or json")
+	err = flags.Parse(args)
+	if err != nil {
+		return
+	}
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return errors.New("expected at most one requests file")
+	}
+
+	if *format != "text" && *format != "json" {
+		return errors.Errorf("unsupported format %q", *format)
+	}
+
+	previous, err := loadPolicy(*before)
+	if err != nil {
+		return
+	}
+
+	next, err := loadPolicy(*after)
+	if err != nil {
+		return
+	}
+
+	input := stdin
+	if flags.NArg() == 1 {
+		file, e := os.Open(flags.Arg(0))
+		if e != nil {
+			return errors.Wrap(e, "could not open the requests")
+		}
+
+		defer file.Close()
+		input = file
+	}
+
+	requests, err := policy.ReadRequests(input)
+	if err != nil {
+		return
+	}
+
+	simulation := policy.Simulate(previous, next, requests)
+	if *format == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(simulation)
+		if err != nil {
+			return
+		}
+	} else {
+		fmt.Fprintf(stdout, "%d requests, %d revoked, %d granted\n", simulation.Requests, len(simulation.Revoked), len(simulation.Granted))
+		for _, change := range append(simulation.Revoked, simulation.Granted...) {
+			fmt.Fprintln(stdout, change)
+		}
+	}
+
+	if simulation.Changed() {
+		return errFailed
+	}
+	return
+}
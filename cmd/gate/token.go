@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
+)
+
+// user is the user a token is issued for
+type user struct {
+	id    string
+	name  string
+	email string
+	roles []string
+}
+
+// GetID returns user ID
+func (u user) GetID() string {
+	return u.id
+}
+
+// GetName returns user name
+func (u user) GetName() string {
+	return u.name
+}
+
+// GetEmail returns user email
+func (u user) GetEmail() string {
+	return u.email
+}
+
+// GetRoles returns user roles
+func (u user) GetRoles() []string {
+	return u.roles
+}
+
+// keyFlags are the flags selecting the key and the algorithm of tokens
+type keyFlags struct {
+	secret *string
+	key    *string
+	alg    *string
+}
+
+func newKeyFlags(flags *flag.FlagSet) keyFlags {
+	return keyFlags{
+		flags.String("secret", "", "the HMAC secret, $GATE_SECRET by default to keep it out of the shell history"),
+		flags.String("key", "", "the PEM file of the RSA or ECDSA key"),
+		flags.String("alg", "", "the signing algorithm, e.g. HS256, RS256 or ES256, inferred from the key by default"),
+	}
+}
+
+// resolve returns the algorithm and the key. Secrets are strings, as gate expects them
+func (k keyFlags) resolve() (method jwt.SigningMethod, key interface{}, err error) {
+	secret := *k.secret
+	if secret == "" && *k.key == "" {
+		secret = os.Getenv("GATE_SECRET")
+	}
+
+	alg := *k.alg
+	switch {
+	case secret != "" && *k.key != "":
+		err = errors.New("either -secret or -key must be given, not both")
+		return
+	case secret != "":
+		key = secret
+		if alg == "" {
+			alg = "HS256"
+		}
+	case *k.key != "":
+		key, err = readKey(*k.key)
+		if err != nil {
+			return
+		}
+
+		if alg == "" {
+			alg, err = defaultAlgorithm(key)
+			if err != nil {
+				return
+			}
+		}
+	default:
+		err = errors.New("missing -secret, $GATE_SECRET or -key")
+		return
+	}
+
+	method = jwt.GetSigningMethod(alg)
+	if method == nil {
+		err = errors.Errorf("unsupported algorithm %q", alg)
+		return
+	}
+	return
+}
+
+func defaultAlgorithm(key interface{}) (string, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PrivateKey:
+		return "ES" + curveBits(key.Curve.Params().BitSize), nil
+	case *ecdsa.PublicKey:
+		return "ES" + curveBits(key.Curve.Params().BitSize), nil
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return "", errors.New("Ed25519 keys are not supported for tokens")
+	}
+
+	return "", errors.New("unsupported key")
+}
+
+func curveBits(size int) string {
+	if size == 521 {
+		return "512"
+	}
+
+	return fmt.Sprint(size)
+}
+
+func issue(args []string, stdin io.Reader, stdout, stderr io.Writer) (err error) {
+	flags := newFlagSet("issue", "", stderr)
+	keys := newKeyFlags(flags)
+	id := flags.String("user", "", "the ID of the user, required")
+	roles := flags.String("roles", "", "the comma-separated roles of the user")
+	name := flags.String("name", "", "the name of the user")
+	email := flags.String("email", "", "the email of the user")
+	tenant := flags.String("tenant", "", "the tenant the roles are bound to")
+	scope := flags.String("scope", "", "the space-separated scopes of the token")
+	expiration := flags.Duration("expiration", time.Hour, "the lifetime of the token")
+	err = flags.Parse(args)
+	if err != nil {
+		return
+	}
+
+	if *id == "" {
+		return errors.New("missing -user")
+	}
+
+	method, key, err := keys.resolve()
+	if err != nil {
+		return
+	}
+
+	service := gate.NewJWTService(gate.NewJWTConfig(method, key, key, *expiration, false))
+	claims := service.NewClaims(user{*id, *name, *email, list(*roles)})
+	claims.Tenant = *tenant
+	claims.Scope = *scope
+
+	token, err := service.Issue(claims)
+	if err != nil {
+		return
+	}
+
+	_, err = fmt.Fprintln(stdout, token.Value)
+	return
+}
+
+func verify(args []string, stdin io.Reader, stdout, stderr io.Writer) (err error) {
+	flags := newFlagSet("verify", "[token]", stderr)
+	keys := newKeyFlags(flags)
+	leeway := flags.Duration("leeway", 0, "the tolerated clock skew")
+	maxAge := flags.Duration("max-age", 0, "the maximum age of the token")
+	at := flags.String("at", "", "verify the token at the given RFC 3339 time rather than now")
+	err = flags.Parse(args)
+	if err != nil {
+		return
+	}
+
+	tokenString, err := readToken(flags.Args(), stdin)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	if *at != "" {
+		now, err = time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return errors.Wrap(err, "invalid -at")
+		}
+	}
+
+	segments := strings.Split(tokenString, ".")
+	if len(segments) == 5 {
+		return errors.New("encrypted tokens are not supported")
+	}
+
+	if len(segments) != 3 {
+		return errors.Wrap(gate.ErrTokenMalformed, "a token has 3 segments")
+	}
+
+	header, err := decodeSegment(segments[0])
+	if err != nil {
+		return errors.Wrap(err, "invalid header")
+	}
+
+	payload, err := decodeSegment(segments[1])
+	if err != nil {
+		return errors.Wrap(err, "invalid claims")
+	}
+
+	fmt.Fprintf(stdout, "Header\n%s\nClaims\n%s\n", header, payload)
+
+	var claims gate.JWTClaims
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return errors.Wrap(gate.ErrTokenMalformed, "invalid claims")
+	}
+
+	printTime(stdout, "Issued at", claims.IssuedAt)
+	printTime(stdout, "Not before", claims.NotBefore)
+	printTime(stdout, "Expires at", claims.ExpiresAt)
+	if claims.AuthTime != 0 {
+		printTime(stdout, "Authenticated at", claims.AuthTime)
+	}
+
+	var failures []string
+	method, key, err := keys.resolve()
+	if err != nil {
+		return
+	}
+
+	service := gate.NewJWTService(gate.NewJWTConfig(method, key, key, 0, true).WithLifetime(gate.JWTLifetimePolicy{Leeway: *leeway, MaxAge: *maxAge}))
+	service.Now = func() time.Time {
+		return now
+	}
+
+	_, err = service.Parse(tokenString)
+	if err != nil {
+		failures = append(failures, "signature: "+err.Error())
+	}
+
+	for _, failure := range service.ValidateClaims(claims) {
+		failures = append(failures, failure.Error())
+	}
+
+	fmt.Fprintln(stdout, "Validation")
+	if len(failures) == 0 {
+		fmt.Fprintf(stdout, "  valid at %s\n", now.UTC().Format(time.RFC3339))
+		return nil
+	}
+
+	for _, failure := range failures {
+		fmt.Fprintf(stdout, "  FAILED %s\n", failure)
+	}
+
+	return errFailed
+}
+
+// readToken returns the token argument or reads it from the standard input
+func readToken(args []string, stdin io.Reader) (string, error) {
+	if len(args) > 1 {
+		return "", errors.New("too many arguments")
+	}
+
+	if len(args) == 1 && args[0] != "-" {
+		return strings.TrimSpace(args[0]), nil
+	}
+
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", errors.Wrap(err, "could not read the token")
+	}
+
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", errors.New("missing token")
+	}
+
+	return line, nil
+}
+
+// decodeSegment decodes a segment of a token as indented JSON
+func decodeSegment(segment string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	var indented bytes.Buffer
+	err = json.Indent(&indented, data, "  ", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte("  "), indented.Bytes()...), nil
+}
+
+func printTime(w io.Writer, label string, unix int64) {
+	if unix == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "%s: %s\n", label, time.Unix(unix, 0).UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,203 @@
+package cookie
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
+)
+
+// Default names used when the configuration omits them
+const (
+	DefaultName           = "gate_token"
+	DefaultCSRFCookieName = "gate_csrf"
+	DefaultCSRFHeaderName = "X-CSRF-Token"
+)
+
+// signatureSuffix is appended to the name of the cookie keeping the signature in split-token mode
+const signatureSuffix = "_sig"
+
+var (
+	// ErrTokenNotFound is thrown when the request does not carry the token cookie
+	ErrTokenNotFound = errors.New("token cookie not found")
+
+	// ErrInvalidCSRFToken is thrown when the CSRF header does not match the CSRF cookie
+	ErrInvalidCSRFToken = errors.New("invalid CSRF token")
+)
+
+// Config is the configuration for cookies
+type Config struct {
+	Name   string
+	Path   string
+	Domain string
+	// Insecure allows the cookies over plain HTTP, e.g. in development
+	Insecure bool
+	// SameSite defaults to http.SameSiteLaxMode
+	SameSite http.SameSite
+	// Split keeps the header and the payload of JWTs in a cookie readable by scripts, and the signature in an HttpOnly cookie
+	Split          bool
+	CSRFCookieName string
+	CSRFHeaderName string
+}
+
+// Transport writes and reads tokens as cookies
+type Transport struct {
+	config Config
+}
+
+// New is the constructor for Transport
+func New(config Config) Transport {
+	if config.Name == "" {
+		config.Name = DefaultName
+	}
+
+	if config.Path == "" {
+		config.Path = "/"
+	}
+
+	if config.SameSite == 0 {
+		config.SameSite = http.SameSiteLaxMode
+	}
+
+	if config.CSRFCookieName == "" {
+		config.CSRFCookieName = DefaultCSRFCookieName
+	}
+
+	if config.CSRFHeaderName == "" {
+		config.CSRFHeaderName = DefaultCSRFHeaderName
+	}
+
+	return Transport{config}
+}
+
+// WriteJWT writes the JWT cookie expiring along with the token
+func (transport Transport) WriteJWT(w http.ResponseWriter, token gate.JWT) error {
+	return transport.Write(w, token.Value, token.ExpiredAt)
+}
+
+// WriteSession writes the session cookie. It is a browser session cookie since the session expiration slides
+func (transport Transport) WriteSession(w http.ResponseWriter, session gate.Session) error {
+	return transport.Write(w, session.ID, time.Time{})
+}
+
+// Write writes the token cookie. A zero expiration makes it a browser session cookie
+func (transport Transport) Write(w http.ResponseWriter, token string, expiredAt time.Time) error {
+	if token == "" {
+		return errors.New("missing token")
+	}
+
+	if !transport.config.Split {
+		http.SetCookie(w, transport.cookie(transport.config.Name, token, expiredAt, true))
+		return nil
+	}
+
+	dot := strings.LastIndex(token, ".")
+	if dot == -1 || strings.Count(token, ".") != 2 {
+		return errors.New("only JWTs can be split")
+	}
+
+	http.SetCookie(w, transport.cookie(transport.config.Name, token[:dot], expiredAt, false))
+	http.SetCookie(w, transport.cookie(transport.config.Name+signatureSuffix, token[dot+1:], expiredAt, true))
+	return nil
+}
+
+// Read reads the token from the cookies of the request
+func (transport Transport) Read(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(transport.config.Name)
+	if err != nil || cookie.Value == "" {
+		return "", ErrTokenNotFound
+	}
+
+	if !transport.config.Split {
+		return cookie.Value, nil
+	}
+
+	signature, err := r.Cookie(transport.config.Name + signatureSuffix)
+	if err != nil || signature.Value == "" {
+		return "", ErrTokenNotFound
+	}
+
+	return cookie.Value + "." + signature.Value, nil
+}
+
+// Clear expires the token cookies, e.g. on logout
+func (transport Transport) Clear(w http.ResponseWriter) {
+	names := []string{transport.config.Name}
+	if transport.config.Split {
+		names = append(names, transport.config.Name+signatureSuffix)
+	}
+
+	for _, name := range names {
+		cookie := transport.cookie(name, "", time.Time{}, true)
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+// IssueCSRF writes a new CSRF cookie readable by scripts and returns its value.
+// Scripts must send the value back in the CSRF header of unsafe requests
+func (transport Transport) IssueCSRF(w http.ResponseWriter) (string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate CSRF token")
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(data)
+	http.SetCookie(w, transport.cookie(transport.config.CSRFCookieName, token, time.Time{}, false))
+	return token, nil
+}
+
+// VerifyCSRF verifies the CSRF header of unsafe requests against the CSRF cookie. Safe methods are always allowed
+func (transport Transport) VerifyCSRF(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return nil
+	}
+
+	cookie, err := r.Cookie(transport.config.CSRFCookieName)
+	if err != nil || cookie.Value == "" {
+		return ErrInvalidCSRFToken
+	}
+
+	header := r.Header.Get(transport.config.CSRFHeaderName)
+	if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+		return ErrInvalidCSRFToken
+	}
+
+	return nil
+}
+
+// Authenticate authenticates the request using the token cookie. Unsafe requests must pass the CSRF verification
+func (transport Transport) Authenticate(auth gate.Auth, r *http.Request) (user gate.User, err error) {
+	token, err := transport.Read(r)
+	if err != nil {
+		return
+	}
+
+	err = transport.VerifyCSRF(r)
+	if err != nil {
+		return
+	}
+
+	user, err = auth.Authenticate(token)
+	return
+}
+
+func (transport Transport) cookie(name, value string, expiredAt time.Time, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     transport.config.Path,
+		Domain:   transport.config.Domain,
+		Expires:  expiredAt,
+		Secure:   !transport.config.Insecure,
+		HttpOnly: httpOnly,
+		SameSite: transport.config.SameSite,
+	}
+}
@@ -0,0 +1,205 @@
+package cookie_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/cookie"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal/test"
+	"github.com/hiendv/gate/internal/test/fixtures"
+	"github.com/hiendv/gate/password"
+)
+
+// replay sends the cookies of the response back with a new request
+func replay(recorder *httptest.ResponseRecorder, method string) *http.Request {
+	request := httptest.NewRequest(method, "/", nil)
+	for _, c := range recorder.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			request.AddCookie(c)
+		}
+	}
+
+	return request
+}
+
+func findCookie(recorder *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range recorder.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func TestCookieDefaults(t *testing.T) {
+	transport := cookie.New(cookie.Config{})
+	recorder := httptest.NewRecorder()
+
+	expiredAt := time.Now().Add(time.Hour)
+	err := transport.WriteJWT(recorder, gate.JWT{Value: "header.payload.signature", ExpiredAt: expiredAt})
+	test.AssertOK(t, err, "valid token")
+
+	c := findCookie(recorder, cookie.DefaultName)
+	if c == nil || !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.Path != "/" || c.Expires.Unix() != expiredAt.Unix() {
+		t.Fatalf("unexpected cookie: %v", c)
+	}
+
+	token, err := transport.Read(replay(recorder, "GET"))
+	test.AssertOK(t, err, "valid cookie")
+
+	if token != "header.payload.signature" {
+		t.Fatalf("unexpected token: %s", token)
+	}
+
+	err = transport.Write(recorder, "", time.Time{})
+	test.AssertErr(t, err, "missing token")
+
+	_, err = transport.Read(httptest.NewRequest("GET", "/", nil))
+	if err != cookie.ErrTokenNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	recorder = httptest.NewRecorder()
+	err = cookie.New(cookie.Config{Insecure: true, SameSite: http.SameSiteStrictMode}).WriteSession(recorder, gate.Session{ID: gate.SessionPrefix + "id"})
+	test.AssertOK(t, err, "valid session")
+
+	c = findCookie(recorder, cookie.DefaultName)
+	if c == nil || c.Secure || c.SameSite != http.SameSiteStrictMode || !c.Expires.IsZero() {
+		t.Fatalf("unexpected cookie: %v", c)
+	}
+}
+
+func TestCookieSplit(t *testing.T) {
+	transport := cookie.New(cookie.Config{Name: "token", Split: true})
+	recorder := httptest.NewRecorder()
+
+	err := transport.Write(recorder, "header.payload.signature", time.Now().Add(time.Hour))
+	test.AssertOK(t, err, "valid token")
+
+	readable := findCookie(recorder, "token")
+	if readable == nil || readable.HttpOnly || readable.Value != "header.payload" {
+		t.Fatalf("unexpected readable cookie: %v", readable)
+	}
+
+	signature := findCookie(recorder, "token_sig")
+	if signature == nil || !signature.HttpOnly || signature.Value != "signature" {
+		t.Fatalf("unexpected signature cookie: %v", signature)
+	}
+
+	token, err := transport.Read(replay(recorder, "GET"))
+	test.AssertOK(t, err, "valid cookies")
+
+	if token != "header.payload.signature" {
+		t.Fatalf("unexpected token: %s", token)
+	}
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.AddCookie(readable)
+	_, err = transport.Read(request)
+	test.AssertErr(t, err, "missing signature")
+
+	err = transport.Write(httptest.NewRecorder(), gate.SessionPrefix+"id", time.Time{})
+	test.AssertErr(t, err, "session IDs can not be split")
+
+	recorder = httptest.NewRecorder()
+	transport.Clear(recorder)
+
+	cleared := recorder.Result().Cookies()
+	if len(cleared) != 2 || cleared[0].MaxAge != -1 || cleared[1].MaxAge != -1 {
+		t.Fatalf("unexpected cookies: %v", cleared)
+	}
+}
+
+func TestCookieCSRF(t *testing.T) {
+	transport := cookie.New(cookie.Config{})
+	recorder := httptest.NewRecorder()
+
+	token, err := transport.IssueCSRF(recorder)
+	test.AssertOK(t, err, "valid CSRF token")
+
+	c := findCookie(recorder, cookie.DefaultCSRFCookieName)
+	if c == nil || c.HttpOnly || c.Value != token {
+		t.Fatalf("unexpected CSRF cookie: %v", c)
+	}
+
+	err = transport.VerifyCSRF(replay(recorder, "GET"))
+	test.AssertOK(t, err, "safe method")
+
+	request := replay(recorder, "POST")
+	err = transport.VerifyCSRF(request)
+	if err != cookie.ErrInvalidCSRFToken {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request.Header.Set(cookie.DefaultCSRFHeaderName, "forged")
+	err = transport.VerifyCSRF(request)
+	if err != cookie.ErrInvalidCSRFToken {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request.Header.Set(cookie.DefaultCSRFHeaderName, token)
+	err = transport.VerifyCSRF(request)
+	test.AssertOK(t, err, "matching CSRF token")
+
+	request = httptest.NewRequest("DELETE", "/", nil)
+	request.Header.Set(cookie.DefaultCSRFHeaderName, token)
+	err = transport.VerifyCSRF(request)
+	test.AssertErr(t, err, "missing CSRF cookie")
+}
+
+func TestCookieAuthenticate(t *testing.T) {
+	userService := fixtures.NewMyUserService([]fixtures.User{{ID: "user-id", Email: "foo@local"}}, nil)
+	driver := password.New(
+		password.Config{Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false)},
+		password.LoginFuncStub,
+		dependency.NewContainer(userService, fixtures.NewMyTokenService(nil), fixtures.NewMyRoleService(nil)),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	user, err := userService.FindOneByID("user-id")
+	test.AssertOK(t, err, "existing user")
+
+	jwt, err := driver.IssueJWT(user)
+	test.AssertOK(t, err, "valid user")
+
+	transport := cookie.New(cookie.Config{Split: true})
+	recorder := httptest.NewRecorder()
+
+	err = transport.WriteJWT(recorder, jwt)
+	test.AssertOK(t, err, "valid token")
+
+	csrf, err := transport.IssueCSRF(recorder)
+	test.AssertOK(t, err, "valid CSRF token")
+
+	authenticated, err := transport.Authenticate(driver, replay(recorder, "GET"))
+	test.AssertOK(t, err, "valid cookies")
+
+	if authenticated.GetID() != user.GetID() {
+		t.Fatalf("ids should be equal: %v - %v", authenticated.GetID(), user.GetID())
+	}
+
+	request := replay(recorder, "POST")
+	_, err = transport.Authenticate(driver, request)
+	if err != cookie.ErrInvalidCSRFToken {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	request.Header.Set(cookie.DefaultCSRFHeaderName, csrf)
+	_, err = transport.Authenticate(driver, request)
+	test.AssertOK(t, err, "valid CSRF token")
+
+	_, err = transport.Authenticate(driver, httptest.NewRequest("GET", "/", nil))
+	test.AssertErr(t, err, "missing cookies")
+
+	if strings.Contains(findCookie(recorder, cookie.DefaultName).Value, findCookie(recorder, cookie.DefaultName+"_sig").Value) {
+		t.Fatal("the signature should not be readable")
+	}
+}
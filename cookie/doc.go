@@ -0,0 +1,2 @@
+// Package cookie transports gate tokens and session IDs to browsers as cookies, with double-submit CSRF protection
+package cookie
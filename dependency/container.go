@@ -1,9 +1,10 @@
 package dependency
 
 import (
+	"time"
+
 	"github.com/hiendv/gate"
 	"github.com/hiendv/gate/internal"
-	"github.com/pkg/errors"
 )
 
 // Container is the service container
@@ -14,11 +15,11 @@ type Container struct {
 // UserService returns user service from the services or throws an error if the service is invalid
 func (container Container) UserService() (gate.UserService, error) {
 	if container.services == nil {
-		return nil, errors.New("missing services")
+		return nil, gate.MissingServiceError{Service: "services"}
 	}
 
 	if container.services.UserService() == nil {
-		return nil, errors.New("missing user service")
+		return nil, gate.MissingServiceError{Service: "user service"}
 	}
 
 	return container.services.UserService(), nil
@@ -27,11 +28,11 @@ func (container Container) UserService() (gate.UserService, error) {
 // RoleService returns role service from the services or throws an error if the service is invalid
 func (container Container) RoleService() (gate.RoleService, error) {
 	if container.services == nil {
-		return nil, errors.New("missing services")
+		return nil, gate.MissingServiceError{Service: "services"}
 	}
 
 	if container.services.RoleService() == nil {
-		return nil, errors.New("missing role service")
+		return nil, gate.MissingServiceError{Service: "role service"}
 	}
 
 	return container.services.RoleService(), nil
@@ -40,24 +41,132 @@ func (container Container) RoleService() (gate.RoleService, error) {
 // TokenService returns token service from the services or throws an error if the service is invalid
 func (container Container) TokenService() (gate.TokenService, error) {
 	if container.services == nil {
-		return nil, errors.New("missing services")
+		return nil, gate.MissingServiceError{Service: "services"}
 	}
 
 	if container.services.TokenService() == nil {
-		return nil, errors.New("missing token service")
+		return nil, gate.MissingServiceError{Service: "token service"}
 	}
 
 	return container.services.TokenService(), nil
 }
 
+// APIKeyService returns API key service from the services or throws an error if the service is invalid
+func (container Container) APIKeyService() (gate.APIKeyService, error) {
+	if container.services == nil {
+		return nil, gate.MissingServiceError{Service: "services"}
+	}
+
+	if container.services.APIKeyService() == nil {
+		return nil, gate.MissingServiceError{Service: "API key service"}
+	}
+
+	return container.services.APIKeyService(), nil
+}
+
+// SetAPIKeyService is the setter for API key service
+func (container Container) SetAPIKeyService(service gate.APIKeyService) {
+	container.services.SetAPIKeyService(service)
+}
+
+// IdentityService returns identity service from the services or throws an error if the service is invalid
+func (container Container) IdentityService() (gate.IdentityService, error) {
+	if container.services == nil {
+		return nil, gate.MissingServiceError{Service: "services"}
+	}
+
+	if container.services.IdentityService() == nil {
+		return nil, gate.MissingServiceError{Service: "identity service"}
+	}
+
+	return container.services.IdentityService(), nil
+}
+
+// SetIdentityService is the setter for identity service
+func (container Container) SetIdentityService(service gate.IdentityService) {
+	container.services.SetIdentityService(service)
+}
+
+// SessionStore returns session store from the services or throws an error if the store is invalid
+func (container Container) SessionStore() (gate.SessionStore, error) {
+	if container.services == nil {
+		return nil, gate.MissingServiceError{Service: "services"}
+	}
+
+	if container.services.SessionStore() == nil {
+		return nil, gate.MissingServiceError{Service: "session store"}
+	}
+
+	return container.services.SessionStore(), nil
+}
+
+// SetSessionStore is the setter for session store
+func (container Container) SetSessionStore(store gate.SessionStore) {
+	container.services.SetSessionStore(store)
+}
+
+// AuditSink returns audit sink from the services or throws an error if the sink is invalid
+func (container Container) AuditSink() (gate.AuditSink, error) {
+	if container.services == nil {
+		return nil, gate.MissingServiceError{Service: "services"}
+	}
+
+	if container.services.AuditSink() == nil {
+		return nil, gate.MissingServiceError{Service: "audit sink"}
+	}
+
+	return container.services.AuditSink(), nil
+}
+
+// SetAuditSink is the setter for audit sink
+func (container Container) SetAuditSink(sink gate.AuditSink) {
+	container.services.SetAuditSink(sink)
+}
+
+// RelationService returns relation service from the services or throws an error if the service is invalid
+func (container Container) RelationService() (gate.RelationService, error) {
+	if container.services == nil {
+		return nil, gate.MissingServiceError{Service: "services"}
+	}
+
+	if container.services.RelationService() == nil {
+		return nil, gate.MissingServiceError{Service: "relation service"}
+	}
+
+	return container.services.RelationService(), nil
+}
+
+// SetRelationService is the setter for relation service
+func (container Container) SetRelationService(service gate.RelationService) {
+	container.services.SetRelationService(service)
+}
+
+// Instrumentation returns instrumentation from the services or throws an error if the instrumentation is invalid
+func (container Container) Instrumentation() (gate.Instrumentation, error) {
+	if container.services == nil {
+		return nil, gate.MissingServiceError{Service: "services"}
+	}
+
+	if container.services.Instrumentation() == nil {
+		return nil, gate.MissingServiceError{Service: "instrumentation"}
+	}
+
+	return container.services.Instrumentation(), nil
+}
+
+// SetInstrumentation is the setter for instrumentation
+func (container Container) SetInstrumentation(instrumentation gate.Instrumentation) {
+	container.services.SetInstrumentation(instrumentation)
+}
+
 // JWTService returns JWT service from the services or throws an error if the service is invalid
 func (container Container) JWTService() (*gate.JWTService, error) {
 	if container.services == nil {
-		return nil, errors.New("missing services")
+		return nil, gate.MissingServiceError{Service: "services"}
 	}
 
 	if container.services.JWTService() == nil {
-		return nil, errors.New("missing JWT service")
+		return nil, gate.MissingServiceError{Service: "JWT service"}
 	}
 
 	return container.services.JWTService(), nil
@@ -71,7 +180,7 @@ func (container Container) SetJWTService(service *gate.JWTService) {
 // Matcher returns Matcher instance from the services or throws an error if the instance is invalid
 func (container Container) Matcher() (internal.Matcher, error) {
 	if container.services == nil {
-		return internal.Matcher{}, errors.New("missing services")
+		return internal.Matcher{}, gate.MissingServiceError{Service: "services"}
 	}
 
 	return container.services.Matcher(), nil
@@ -82,6 +191,20 @@ func (container Container) SetMatcher(matcher internal.Matcher) {
 	container.services.SetMatcher(matcher)
 }
 
+// Clock returns the current time of the clock from the services, or of time.Now if the clock is not set
+func (container Container) Clock() time.Time {
+	if container.services == nil || container.services.Clock() == nil {
+		return time.Now()
+	}
+
+	return container.services.Clock()()
+}
+
+// SetClock is the setter for clock, e.g. to control the expiration of sessions
+func (container Container) SetClock(clock func() time.Time) {
+	container.services.SetClock(clock)
+}
+
 // NewContainer is the constructor for container
 func NewContainer(users gate.UserService, tokens gate.TokenService, roles gate.RoleService) Container {
 	return Container{
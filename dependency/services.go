@@ -1,17 +1,26 @@
 package dependency
 
 import (
+	"time"
+
 	"github.com/hiendv/gate"
 	"github.com/hiendv/gate/internal"
 )
 
 // Services is the servicer container for Auth
 type Services struct {
-	userService  gate.UserService
-	roleService  gate.RoleService
-	tokenService gate.TokenService
-	jwtService   *gate.JWTService
-	matcher      internal.Matcher
+	userService     gate.UserService
+	roleService     gate.RoleService
+	tokenService    gate.TokenService
+	apiKeyService   gate.APIKeyService
+	identityService gate.IdentityService
+	sessionStore    gate.SessionStore
+	auditSink       gate.AuditSink
+	instrumentation gate.Instrumentation
+	relationService gate.RelationService
+	jwtService      *gate.JWTService
+	matcher         internal.Matcher
+	clock           func() time.Time
 }
 
 // UserService is the getter for user service
@@ -29,6 +38,36 @@ func (services Services) TokenService() gate.TokenService {
 	return services.tokenService
 }
 
+// APIKeyService is the getter for API key service
+func (services Services) APIKeyService() gate.APIKeyService {
+	return services.apiKeyService
+}
+
+// IdentityService is the getter for identity service
+func (services Services) IdentityService() gate.IdentityService {
+	return services.identityService
+}
+
+// SessionStore is the getter for session store
+func (services Services) SessionStore() gate.SessionStore {
+	return services.sessionStore
+}
+
+// AuditSink is the getter for audit sink
+func (services Services) AuditSink() gate.AuditSink {
+	return services.auditSink
+}
+
+// Instrumentation is the getter for instrumentation
+func (services Services) Instrumentation() gate.Instrumentation {
+	return services.instrumentation
+}
+
+// RelationService is the getter for relation service
+func (services Services) RelationService() gate.RelationService {
+	return services.relationService
+}
+
 // JWTService is the getter for JWT service
 func (services Services) JWTService() *gate.JWTService {
 	return services.jwtService
@@ -39,12 +78,65 @@ func (services Services) Matcher() internal.Matcher {
 	return services.matcher
 }
 
-// SetJWTService is the setter for JWT service
+// Clock is the getter for clock
+func (services Services) Clock() func() time.Time {
+	return services.clock
+}
+
+// SetJWTService is the setter for JWT service. The service inherits the instrumentation it lacks
 func (services *Services) SetJWTService(service *gate.JWTService) {
+	if service != nil && service.Instrumentation == nil {
+		service.Instrumentation = services.instrumentation
+	}
+
 	services.jwtService = service
 }
 
-// SetMatcher is the setter for matcher
+// SetAPIKeyService is the setter for API key service
+func (services *Services) SetAPIKeyService(service gate.APIKeyService) {
+	services.apiKeyService = service
+}
+
+// SetIdentityService is the setter for identity service
+func (services *Services) SetIdentityService(service gate.IdentityService) {
+	services.identityService = service
+}
+
+// SetSessionStore is the setter for session store
+func (services *Services) SetSessionStore(store gate.SessionStore) {
+	services.sessionStore = store
+}
+
+// SetAuditSink is the setter for audit sink
+func (services *Services) SetAuditSink(sink gate.AuditSink) {
+	services.auditSink = sink
+}
+
+// SetInstrumentation is the setter for instrumentation. The JWT service and the matcher are instrumented too
+func (services *Services) SetInstrumentation(instrumentation gate.Instrumentation) {
+	services.instrumentation = instrumentation
+	if services.jwtService != nil {
+		services.jwtService.Instrumentation = instrumentation
+	}
+
+	services.matcher = services.matcher.WithCounter(instrumentation)
+}
+
+// SetRelationService is the setter for relation service
+func (services *Services) SetRelationService(service gate.RelationService) {
+	services.relationService = service
+}
+
+// SetMatcher is the setter for matcher. The matcher inherits the instrumentation
 func (services *Services) SetMatcher(matcher internal.Matcher) {
+	if services.instrumentation != nil {
+		matcher = matcher.WithCounter(services.instrumentation)
+	}
+
 	services.matcher = matcher
 }
+
+// SetClock is the setter for clock
+func (services *Services) SetClock(clock func() time.Time) {
+	services.clock = clock
+}
@@ -0,0 +1,83 @@
+package gate
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMissingService is thrown when a service is not provided, see MissingServiceError
+	ErrMissingService = errors.New("missing service")
+
+	// ErrInvalidKey is thrown when a key does not suit the signing or encryption algorithm
+	ErrInvalidKey = errors.New("invalid key")
+
+	// ErrTokenMalformed is thrown when a token could not be decoded
+	ErrTokenMalformed = errors.New("malformed token")
+
+	// ErrTokenSignature is thrown when a token is signed with another key or algorithm, or could not be decrypted
+	ErrTokenSignature = errors.New("invalid token signature")
+
+	// ErrTokenExpired is thrown when a token is expired, beyond the leeway
+	ErrTokenExpired = errors.New("the token is expired")
+
+	// ErrTokenNotValidYet is thrown when a token is issued in the future or used before its nbf, beyond the leeway
+	ErrTokenNotValidYet = errors.New("the token is not valid yet")
+
+	// ErrTokenTooOld is thrown when a token is issued longer ago than the maximum age
+	ErrTokenTooOld = errors.New("the token exceeds its maximum age")
+
+	// ErrTokenLifetimeExceeded is thrown when the authentication a token derives from exceeds its absolute lifetime
+	ErrTokenLifetimeExceeded = errors.New("the authentication exceeds its absolute lifetime")
+
+	// ErrTokenRevoked is thrown when a token is revoked, e.g. an API key
+	ErrTokenRevoked = errors.New("the token is revoked")
+
+	// ErrMissingCredentials is thrown when a login lacks a credential, see MissingCredentialsError
+	ErrMissingCredentials = errors.New("missing credentials")
+
+	// ErrInvalidCredentials is thrown when a login is rejected because of its credentials, e.g. a wrong password or code
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// KindError is a specific sentinel error which also matches a broader one, e.g. ErrInvalidAPIKey matches ErrInvalidCredentials
+type KindError struct {
+	Message string
+	Kind    error
+}
+
+func (err KindError) Error() string {
+	return err.Message
+}
+
+// Is reports whether the target is the broader error
+func (err KindError) Is(target error) bool {
+	return target == err.Kind
+}
+
+// MissingServiceError is thrown when a specific service is not provided. It matches ErrMissingService
+type MissingServiceError struct {
+	Service string
+}
+
+func (err MissingServiceError) Error() string {
+	return "missing " + err.Service
+}
+
+// Is reports whether the target is ErrMissingService
+func (err MissingServiceError) Is(target error) bool {
+	return target == ErrMissingService
+}
+
+// MissingCredentialsError is thrown when a login lacks a specific credential. It matches ErrMissingCredentials
+type MissingCredentialsError struct {
+	Credential string
+}
+
+func (err MissingCredentialsError) Error() string {
+	return "missing " + err.Credential
+}
+
+// Is reports whether the target is ErrMissingCredentials
+func (err MissingCredentialsError) Is(target error) bool {
+	return target == ErrMissingCredentials
+}
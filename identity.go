@@ -0,0 +1,262 @@
+package gate
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrIdentityNotFound is thrown when an identity is not linked to any user, or not to the given one
+	ErrIdentityNotFound = errors.New("identity not found")
+
+	// ErrIdentityLinked is thrown when an identity is already linked to another user
+	ErrIdentityLinked = errors.New("the identity is linked to another user")
+
+	// ErrIdentityNotLinked is thrown when the email of an identity matches an existing user who did not link it
+	ErrIdentityNotLinked = errors.New("the identity is not linked to the user of the email")
+)
+
+// Identity links a user to an account of an external provider, e.g. the subject of an OAuth provider
+type Identity struct {
+	Provider  string
+	Subject   string
+	UserID    string
+	Email     string
+	CreatedAt time.Time
+}
+
+// IdentifiedAccount is the contract for accounts carrying their stable identifier at the provider
+type IdentifiedAccount interface {
+	Account
+	GetSubject() string
+}
+
+// LinkIdentity links the account of the provider to a specific user
+func LinkIdentity(auth Auth, user User, provider string, account IdentifiedAccount) (identity Identity, err error) {
+	if user == nil {
+		err = errors.New("missing user")
+		return
+	}
+
+	if account == nil || account.GetSubject() == "" {
+		err = errors.New("missing account subject")
+		return
+	}
+
+	service, err := getIdentityService(auth)
+	if err != nil {
+		return
+	}
+
+	identity, err = service.FindOneByProviderSubject(provider, account.GetSubject())
+	if err == nil {
+		if identity.UserID != user.GetID() {
+			identity = Identity{}
+			err = ErrIdentityLinked
+		}
+		return
+	}
+
+	if !service.IsErrNotFound(err) {
+		err = errors.Wrap(err, "could not find the identity")
+		return
+	}
+
+	identity = Identity{
+		Provider:  provider,
+		Subject:   account.GetSubject(),
+		UserID:    user.GetID(),
+		Email:     account.GetEmail(),
+		CreatedAt: time.Now(),
+	}
+
+	err = service.Store(identity)
+	if err != nil {
+		identity = Identity{}
+		err = errors.Wrap(err, "could not store the identity")
+		return
+	}
+	return
+}
+
+// UnlinkIdentity removes the identity of the provider from a specific user
+func UnlinkIdentity(auth Auth, user User, provider, subject string) (err error) {
+	if user == nil {
+		err = errors.New("missing user")
+		return
+	}
+
+	service, err := getIdentityService(auth)
+	if err != nil {
+		return
+	}
+
+	identity, err := service.FindOneByProviderSubject(provider, subject)
+	if err != nil {
+		if service.IsErrNotFound(err) {
+			err = ErrIdentityNotFound
+			return
+		}
+
+		err = errors.Wrap(err, "could not find the identity")
+		return
+	}
+
+	if identity.UserID != user.GetID() {
+		err = ErrIdentityNotFound
+		return
+	}
+
+	err = service.Delete(provider, subject)
+	if err != nil {
+		err = errors.Wrap(err, "could not delete the identity")
+		return
+	}
+	return
+}
+
+// GetUserIdentities returns the identities linked to a specific user
+func GetUserIdentities(auth Auth, user User) (identities []Identity, err error) {
+	if user == nil {
+		err = errors.New("missing user")
+		return
+	}
+
+	service, err := getIdentityService(auth)
+	if err != nil {
+		return
+	}
+
+	identities, err = service.FindByUserID(user.GetID())
+	if err != nil {
+		err = errors.Wrap(err, "could not find the identities")
+		return
+	}
+	return
+}
+
+// LoginAccount resolves the user of an account authenticated by the provider.
+// With an identity service, identified accounts are resolved by their subject first.
+// Otherwise, the user is found by the email returned by verify, or created with the policy and this email.
+// Only the users created by the login get the identity linked, existing users must link it with LinkIdentity.
+// A nil verify trusts any email of the account
+func LoginAccount(auth Auth, provider string, account Account, verify func(Account) (string, error), policy ProvisioningPolicy) (user User, err error) {
+	if account == nil {
+		err = errors.New("missing account")
+		return
+	}
+
+	identities, e := getIdentityService(auth)
+	identified, ok := account.(IdentifiedAccount)
+	if e != nil || !ok || identified.GetSubject() == "" {
+		identified = nil
+	}
+
+	if identified != nil {
+		user, err = findUserByIdentity(auth, identities, provider, identified.GetSubject())
+		if err != ErrIdentityNotFound {
+			return
+		}
+	}
+
+	email := account.GetEmail()
+	if verify != nil {
+		email, err = verify(account)
+		if err != nil {
+			return
+		}
+	}
+
+	if email == "" {
+		err = errors.New("missing account email")
+		return
+	}
+
+	user, created, err := findOrCreateUser(auth, provider, email, account, policy)
+	if err != nil || identified == nil {
+		return
+	}
+
+	if !created {
+		user = nil
+		err = ErrIdentityNotLinked
+		return
+	}
+
+	_, err = LinkIdentity(auth, user, provider, identified)
+	if err != nil {
+		user = nil
+		err = errors.Wrap(err, "could not link the identity")
+		return
+	}
+	return
+}
+
+// getIdentityService returns the identity service of the Auth if it is an IdentityAuth
+func getIdentityService(auth Auth) (IdentityService, error) {
+	identified, ok := auth.(IdentityAuth)
+	if !ok {
+		return nil, MissingServiceError{Service: "identity service"}
+	}
+
+	return identified.IdentityService()
+}
+
+func findUserByIdentity(auth Auth, identities IdentityService, provider, subject string) (user User, err error) {
+	identity, err := identities.FindOneByProviderSubject(provider, subject)
+	if err != nil {
+		if identities.IsErrNotFound(err) {
+			err = ErrIdentityNotFound
+			return
+		}
+
+		err = errors.Wrap(err, "could not find the identity")
+		return
+	}
+
+	service, err := auth.UserService()
+	if err != nil {
+		err = errors.Wrap(err, "invalid user service")
+		return
+	}
+
+	user, err = service.FindOneByID(identity.UserID)
+	if err != nil {
+		err = errors.Wrap(err, "could not find the user with the given id")
+		return
+	}
+	return
+}
+
+func findOrCreateUser(auth Auth, provider, email string, account Account, policy ProvisioningPolicy) (user User, created bool, err error) {
+	service, err := auth.UserService()
+	if err != nil {
+		err = errors.Wrap(err, "invalid user service")
+		return
+	}
+
+	user, err = service.FindOneByEmail(email)
+	if err == nil {
+		return
+	}
+
+	if !service.IsErrNotFound(err) {
+		err = errors.Wrap(err, "could not find the user")
+		return
+	}
+
+	provisioning, err := policy.Provision(provider, email, account)
+	if err != nil {
+		return
+	}
+
+	user, err = createUser(service, account, provisioning)
+	if err != nil {
+		return
+	}
+
+	created = true
+	Audit(auth, AuditEvent{Type: EventUserProvisioned, Provider: provider, UserID: user.GetID(), Email: user.GetEmail()})
+	return
+}
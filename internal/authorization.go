@@ -28,3 +28,23 @@ func AuthorizationCheck(matcher Matcher, action, object string, ability Ability)
 
 	return true
 }
+
+// DenialCheck performs the check for an action on object with a given denied ability and a matcher.
+// Denials fail closed: an incomplete ability or a pattern which does not compile denies anything
+func DenialCheck(matcher Matcher, action, object string, ability Ability) bool {
+	if ability.GetAction() == "" || ability.GetObject() == "" {
+		return true
+	}
+
+	actionMatch, err := matcher.Match(action, ability.GetAction())
+	if err != nil {
+		return true
+	}
+
+	objectMatch, err := matcher.Match(object, ability.GetObject())
+	if err != nil {
+		return true
+	}
+
+	return actionMatch && objectMatch
+}
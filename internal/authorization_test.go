@@ -57,3 +57,22 @@ func TestAuthorizationCheck(t *testing.T) {
 		}
 	})
 }
+
+func TestDenialCheck(t *testing.T) {
+	matcher := NewMatcher()
+	if (!DenialCheck(matcher, "foo", "bar", myAbility{"foo", "bar*"})) {
+		t.Fatal("unexpected result")
+	}
+
+	if (DenialCheck(matcher, "foo", "bar", myAbility{"foo", "qux"})) {
+		t.Fatal("unexpected result")
+	}
+
+	if (!DenialCheck(matcher, "foo", "bar", myAbility{"", "qux"})) {
+		t.Fatal("incomplete denials should deny")
+	}
+
+	if (!DenialCheck(matcher, "foo", "bar", myAbility{"foo", "bar.("})) {
+		t.Fatal("invalid denials should deny")
+	}
+}
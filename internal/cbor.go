@@ -0,0 +1,351 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidCBOR is thrown when the given data is not well-formed CBOR
+var ErrInvalidCBOR = errors.New("invalid CBOR")
+
+const (
+	cborUnsigned byte = iota
+	cborNegative
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+// CBORDecode decodes the first CBOR data item (RFC 7049) of the given data and returns the remaining bytes.
+// Integers are decoded as int64, byte strings as []byte, text strings as string, arrays as []interface{}
+// and maps as map[interface{}]interface{}. Tags are skipped.
+func CBORDecode(data []byte) (value interface{}, rest []byte, err error) {
+	decoder := cborDecoder{data: data}
+	value, err = decoder.decode(0)
+	if err != nil {
+		return
+	}
+
+	rest = decoder.data[decoder.offset:]
+	return
+}
+
+type cborDecoder struct {
+	data   []byte
+	offset int
+}
+
+func (decoder *cborDecoder) read(n uint64) (result []byte, err error) {
+	if n > uint64(len(decoder.data)-decoder.offset) {
+		err = errors.Wrap(ErrInvalidCBOR, "unexpected end of data")
+		return
+	}
+
+	result = decoder.data[decoder.offset : decoder.offset+int(n)]
+	decoder.offset += int(n)
+	return
+}
+
+func (decoder *cborDecoder) head() (major, info byte, argument uint64, err error) {
+	initial, err := decoder.read(1)
+	if err != nil {
+		return
+	}
+
+	major = initial[0] >> 5
+	info = initial[0] & 0x1f
+
+	var size uint64
+	switch {
+	case info < 24:
+		argument = uint64(info)
+		return
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		err = errors.Wrap(ErrInvalidCBOR, "indefinite lengths are not supported")
+		return
+	}
+
+	raw, err := decoder.read(size)
+	if err != nil {
+		return
+	}
+
+	for _, b := range raw {
+		argument = argument<<8 | uint64(b)
+	}
+	return
+}
+
+func (decoder *cborDecoder) decode(depth int) (value interface{}, err error) {
+	if depth > 32 {
+		err = errors.Wrap(ErrInvalidCBOR, "nesting is too deep")
+		return
+	}
+
+	major, info, argument, err := decoder.head()
+	if err != nil {
+		return
+	}
+
+	switch major {
+	case cborUnsigned:
+		if argument > math.MaxInt64 {
+			err = errors.Wrap(ErrInvalidCBOR, "integer overflow")
+			return
+		}
+
+		value = int64(argument)
+	case cborNegative:
+		if argument > math.MaxInt64 {
+			err = errors.Wrap(ErrInvalidCBOR, "integer overflow")
+			return
+		}
+
+		value = -1 - int64(argument)
+	case cborBytes:
+		var raw []byte
+		raw, err = decoder.read(argument)
+		if err != nil {
+			return
+		}
+
+		value = append([]byte{}, raw...)
+	case cborText:
+		var raw []byte
+		raw, err = decoder.read(argument)
+		if err != nil {
+			return
+		}
+
+		value = string(raw)
+	case cborArray:
+		if argument > uint64(len(decoder.data)) {
+			err = errors.Wrap(ErrInvalidCBOR, "unexpected end of data")
+			return
+		}
+
+		items := make([]interface{}, argument)
+		for i := range items {
+			items[i], err = decoder.decode(depth + 1)
+			if err != nil {
+				return
+			}
+		}
+
+		value = items
+	case cborMap:
+		if argument > uint64(len(decoder.data)) {
+			err = errors.Wrap(ErrInvalidCBOR, "unexpected end of data")
+			return
+		}
+
+		items := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, item interface{}
+			key, err = decoder.decode(depth + 1)
+			if err != nil {
+				return
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				err = errors.Wrap(ErrInvalidCBOR, "unsupported map key")
+				return
+			}
+
+			item, err = decoder.decode(depth + 1)
+			if err != nil {
+				return
+			}
+
+			items[key] = item
+		}
+
+		value = items
+	case cborTag:
+		value, err = decoder.decode(depth + 1)
+	case cborSimple:
+		value, err = decoder.simple(info, argument)
+	}
+
+	return
+}
+
+func (decoder *cborDecoder) simple(info byte, argument uint64) (value interface{}, err error) {
+	switch info {
+	case 20:
+		value = false
+	case 21:
+		value = true
+	case 22, 23:
+		value = nil
+	case 25:
+		value = float64(halfToFloat(uint16(argument)))
+	case 26:
+		value = float64(math.Float32frombits(uint32(argument)))
+	case 27:
+		value = math.Float64frombits(argument)
+	default:
+		err = errors.Wrap(ErrInvalidCBOR, "unsupported simple value")
+	}
+
+	return
+}
+
+func halfToFloat(half uint16) float32 {
+	sign := uint32(half&0x8000) << 16
+	exponent := uint32(half>>10) & 0x1f
+	fraction := uint32(half & 0x3ff)
+
+	switch exponent {
+	case 0:
+		result := float32(math.Ldexp(float64(fraction), -24))
+		if sign != 0 {
+			return -result
+		}
+
+		return result
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | fraction<<13)
+	}
+
+	return math.Float32frombits(sign | (exponent+112)<<23 | fraction<<13)
+}
+
+// CBOREncode encodes the given value into CBOR using the canonical map key ordering of CTAP2.
+// It supports the types produced by CBORDecode along with int, string-keyed maps and nil.
+func CBOREncode(value interface{}) (data []byte, err error) {
+	buffer := &bytes.Buffer{}
+	err = cborEncode(buffer, value)
+	if err != nil {
+		return
+	}
+
+	data = buffer.Bytes()
+	return
+}
+
+func cborWriteHead(buffer *bytes.Buffer, major byte, argument uint64) {
+	major = major << 5
+	switch {
+	case argument < 24:
+		buffer.WriteByte(major | byte(argument))
+	case argument <= math.MaxUint8:
+		buffer.WriteByte(major | 24)
+		buffer.WriteByte(byte(argument))
+	case argument <= math.MaxUint16:
+		buffer.WriteByte(major | 25)
+		_ = binary.Write(buffer, binary.BigEndian, uint16(argument))
+	case argument <= math.MaxUint32:
+		buffer.WriteByte(major | 26)
+		_ = binary.Write(buffer, binary.BigEndian, uint32(argument))
+	default:
+		buffer.WriteByte(major | 27)
+		_ = binary.Write(buffer, binary.BigEndian, argument)
+	}
+}
+
+func cborEncode(buffer *bytes.Buffer, value interface{}) (err error) {
+	switch v := value.(type) {
+	case nil:
+		buffer.WriteByte(cborSimple<<5 | 22)
+	case bool:
+		if v {
+			buffer.WriteByte(cborSimple<<5 | 21)
+			return
+		}
+
+		buffer.WriteByte(cborSimple<<5 | 20)
+	case int:
+		return cborEncode(buffer, int64(v))
+	case int64:
+		if v < 0 {
+			cborWriteHead(buffer, cborNegative, uint64(-1-v))
+			return
+		}
+
+		cborWriteHead(buffer, cborUnsigned, uint64(v))
+	case uint64:
+		cborWriteHead(buffer, cborUnsigned, v)
+	case []byte:
+		cborWriteHead(buffer, cborBytes, uint64(len(v)))
+		buffer.Write(v)
+	case string:
+		cborWriteHead(buffer, cborText, uint64(len(v)))
+		buffer.WriteString(v)
+	case []interface{}:
+		cborWriteHead(buffer, cborArray, uint64(len(v)))
+		for _, item := range v {
+			err = cborEncode(buffer, item)
+			if err != nil {
+				return
+			}
+		}
+	case map[string]interface{}:
+		items := make(map[interface{}]interface{}, len(v))
+		for key, item := range v {
+			items[key] = item
+		}
+
+		return cborEncode(buffer, items)
+	case map[interface{}]interface{}:
+		return cborEncodeMap(buffer, v)
+	default:
+		err = errors.Errorf("unsupported CBOR type %T", value)
+	}
+
+	return
+}
+
+func cborEncodeMap(buffer *bytes.Buffer, items map[interface{}]interface{}) (err error) {
+	type entry struct {
+		key   []byte
+		value interface{}
+	}
+
+	entries := make([]entry, 0, len(items))
+	for key, value := range items {
+		var encoded []byte
+		encoded, err = CBOREncode(key)
+		if err != nil {
+			return
+		}
+
+		entries = append(entries, entry{encoded, value})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if len(entries[i].key) != len(entries[j].key) {
+			return len(entries[i].key) < len(entries[j].key)
+		}
+
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	cborWriteHead(buffer, cborMap, uint64(len(entries)))
+	for _, item := range entries {
+		buffer.Write(item.key)
+		err = cborEncode(buffer, item.value)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestCBOR(t *testing.T) {
+	t.Run("decode", func(t *testing.T) {
+		cases := map[string]interface{}{
+			"00":                 int64(0),
+			"17":                 int64(23),
+			"1818":               int64(24),
+			"1903e8":             int64(1000),
+			"20":                 int64(-1),
+			"3863":               int64(-100),
+			"4401020304":         []byte{1, 2, 3, 4},
+			"6449455446":         "IETF",
+			"83010203":           []interface{}{int64(1), int64(2), int64(3)},
+			"f4":                 false,
+			"f5":                 true,
+			"f6":                 nil,
+			"f93c00":             float64(1),
+			"fb3ff199999999999a": 1.1,
+			"a201020304":         map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)},
+			"a26161016162820203": map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}},
+			"c11a514b67b0":       int64(1363896240),
+		}
+
+		for input, expected := range cases {
+			data, err := hex.DecodeString(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			value, rest, err := CBORDecode(data)
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %s", input, err)
+			}
+
+			if len(rest) != 0 {
+				t.Fatalf("unexpected remaining bytes for %s", input)
+			}
+
+			if !reflect.DeepEqual(value, expected) {
+				t.Fatalf("unexpected value for %s: %#v", input, value)
+			}
+		}
+	})
+
+	t.Run("decode with remaining bytes", func(t *testing.T) {
+		_, rest, err := CBORDecode([]byte{0x01, 0x02})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(rest, []byte{0x02}) {
+			t.Fatalf("unexpected remaining bytes: %x", rest)
+		}
+	})
+
+	t.Run("decode malformed data", func(t *testing.T) {
+		cases := []string{
+			"",
+			"19",
+			"44010203",
+			"9f",
+			"a1f401",
+			"1bffffffffffffffff",
+			"f8",
+			"9a0fffffff",
+		}
+
+		for _, input := range cases {
+			data, err := hex.DecodeString(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = CBORDecode(data)
+			if err == nil {
+				t.Fatalf("unexpected success for %s", input)
+			}
+		}
+	})
+
+	t.Run("encode", func(t *testing.T) {
+		value := map[interface{}]interface{}{
+			"fmt":     "none",
+			int64(3):  int64(-7),
+			int64(-2): []byte{1, 2},
+			"list":    []interface{}{true, false, nil, 1000},
+		}
+
+		data, err := CBOREncode(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Canonical ordering puts shorter keys first
+		if data[1] != 0x03 {
+			t.Fatalf("unexpected key order: %x", data)
+		}
+
+		decoded, rest, err := CBORDecode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(rest) != 0 {
+			t.Fatal("unexpected remaining bytes")
+		}
+
+		expected := map[interface{}]interface{}{
+			"fmt":     "none",
+			int64(3):  int64(-7),
+			int64(-2): []byte{1, 2},
+			"list":    []interface{}{true, false, nil, int64(1000)},
+		}
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Fatalf("unexpected value: %#v", decoded)
+		}
+
+		_, err = CBOREncode(1.5)
+		if err == nil {
+			t.Fatal("unexpected success for unsupported type")
+		}
+	})
+}
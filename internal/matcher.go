@@ -10,6 +10,14 @@ import (
 // ErrInvalidExpression is thrown when the given expression is invalid
 var ErrInvalidExpression = errors.New("invalid expression")
 
+// MetricMatcherCacheLookups is the counter of expression cache lookups with a "result" label, either "hit" or "miss"
+const MetricMatcherCacheLookups = "gate_matcher_cache_lookups_total"
+
+// Counter is the contract which counts the cache lookups of Matcher
+type Counter interface {
+	IncCounter(name string, labels map[string]string)
+}
+
 // AsteriskParse translates asterisk "*" into "(.{0,})" for convenience
 func AsteriskParse(exp string) (result string) {
 	re := regexp.MustCompile(`\*($|\/)`)
@@ -17,16 +25,37 @@ func AsteriskParse(exp string) (result string) {
 	return
 }
 
+// Compile compiles a pattern of an ability the way Matcher does
+func Compile(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile(AsteriskParse(pattern))
+}
+
 // Matcher performs match operations for the given string and pattern with caching support
 type Matcher struct {
 	expressions map[string]*regexp.Regexp
+	counter     Counter
 	*sync.RWMutex
 }
 
+// WithCounter returns the matcher sharing the same cache which counts its cache lookups
+func (service Matcher) WithCounter(counter Counter) Matcher {
+	service.counter = counter
+	return service
+}
+
 func (service Matcher) getExpression(key string) (expression *regexp.Regexp, err error) {
 	expression, ok := service.expressions[key]
+	if service.counter != nil {
+		result := "hit"
+		if !ok {
+			result = "miss"
+		}
+
+		service.counter.IncCounter(MetricMatcherCacheLookups, map[string]string{"result": result})
+	}
+
 	if !ok {
-		expression, err = regexp.Compile(AsteriskParse(key))
+		expression, err = Compile(key)
 		if err != nil {
 			return
 		}
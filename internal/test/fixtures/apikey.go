@@ -0,0 +1,76 @@
+package fixtures
+
+import (
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
+)
+
+var errAPIKeyNotFound = errors.New("API key not found")
+
+// MyAPIKeyService is my API key service
+type MyAPIKeyService struct {
+	records []gate.APIKey
+}
+
+// NewMyAPIKeyService is the constructor for MyAPIKeyService
+func NewMyAPIKeyService(records []gate.APIKey) *MyAPIKeyService {
+	return &MyAPIKeyService{records}
+}
+
+// FindOneByID fetches the API key with the given ID
+func (service MyAPIKeyService) FindOneByID(id string) (key gate.APIKey, err error) {
+	for _, record := range service.records {
+		if record.ID == id {
+			key = record
+			return
+		}
+	}
+
+	err = errAPIKeyNotFound
+	return
+}
+
+// Store appends the API key
+func (service *MyAPIKeyService) Store(key gate.APIKey) error {
+	service.records = append(service.records, key)
+	return nil
+}
+
+// Touch tracks the last usage of the API key with the given ID
+func (service *MyAPIKeyService) Touch(id string, at time.Time) error {
+	return service.update(id, func(key *gate.APIKey) {
+		key.LastUsedAt = at
+	})
+}
+
+// Revoke revokes the API key with the given ID
+func (service *MyAPIKeyService) Revoke(id string, at time.Time) error {
+	return service.update(id, func(key *gate.APIKey) {
+		key.RevokedAt = at
+	})
+}
+
+// Expire makes the API key with the given ID expired
+func (service *MyAPIKeyService) Expire(id string) error {
+	return service.update(id, func(key *gate.APIKey) {
+		key.ExpiredAt = time.Now().Add(-time.Second)
+	})
+}
+
+// IsErrNotFound determines whether the error is not found error or not
+func (service MyAPIKeyService) IsErrNotFound(err error) bool {
+	return err == errAPIKeyNotFound
+}
+
+func (service *MyAPIKeyService) update(id string, apply func(*gate.APIKey)) error {
+	for i := range service.records {
+		if service.records[i].ID == id {
+			apply(&service.records[i])
+			return nil
+		}
+	}
+
+	return errAPIKeyNotFound
+}
@@ -0,0 +1,91 @@
+package fixtures
+
+import (
+	"crypto/subtle"
+
+	"github.com/hiendv/gate/clientcredentials"
+	"github.com/pkg/errors"
+)
+
+// ClientIDTriggeringDatabaseError should trigger database error
+const ClientIDTriggeringDatabaseError string = "error"
+
+var errClientNotFound = errors.New("client not found")
+
+// Client is my client
+type Client struct {
+	ID        string
+	Name      string
+	Secret    string
+	Roles     []string
+	Scopes    []string
+	PublicKey interface{}
+}
+
+// GetID returns client ID
+func (c Client) GetID() string {
+	return c.ID
+}
+
+// GetName returns client Name
+func (c Client) GetName() string {
+	return c.Name
+}
+
+// GetEmail returns an empty email since clients have none
+func (c Client) GetEmail() string {
+	return ""
+}
+
+// GetRoles returns client Roles
+func (c Client) GetRoles() []string {
+	return c.Roles
+}
+
+// GetAllowedScopes returns client Scopes
+func (c Client) GetAllowedScopes() []string {
+	return c.Scopes
+}
+
+// VerifySecret compares the given secret with client Secret
+func (c Client) VerifySecret(secret string) bool {
+	return c.Secret != "" && subtle.ConstantTimeCompare([]byte(c.Secret), []byte(secret)) == 1
+}
+
+// GetPublicKey returns client PublicKey
+func (c Client) GetPublicKey() interface{} {
+	return c.PublicKey
+}
+
+// MyClientService is my client service
+type MyClientService struct {
+	records []Client
+}
+
+// NewMyClientService is the constructor for MyClientService
+func NewMyClientService(records []Client) *MyClientService {
+	return &MyClientService{records}
+}
+
+// FindOneByID fetches the client with the given ID
+func (service MyClientService) FindOneByID(id string) (client clientcredentials.Client, err error) {
+	if id == ClientIDTriggeringDatabaseError {
+		err = errors.New("database error")
+		return
+	}
+
+	for _, record := range service.records {
+		if record.ID == id {
+			client = record
+			return
+		}
+	}
+
+	err = errClientNotFound
+	return
+}
+
+// IsErrNotFound determines whether the error is not found error or not
+func (service MyClientService) IsErrNotFound(err error) bool {
+	return err == errClientNotFound
+}
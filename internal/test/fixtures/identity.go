@@ -0,0 +1,72 @@
+package fixtures
+
+import (
+	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
+)
+
+var errIdentityNotFound = errors.New("identity not found")
+
+// ProviderTriggeringDatabaseError is the provider which triggers database errors of MyIdentityService
+const ProviderTriggeringDatabaseError = "error"
+
+// MyIdentityService is my identity service
+type MyIdentityService struct {
+	records []gate.Identity
+}
+
+// NewMyIdentityService is the constructor for MyIdentityService
+func NewMyIdentityService(records []gate.Identity) *MyIdentityService {
+	return &MyIdentityService{records}
+}
+
+// FindOneByProviderSubject fetches the identity with the given provider and subject
+func (service MyIdentityService) FindOneByProviderSubject(provider, subject string) (identity gate.Identity, err error) {
+	if provider == ProviderTriggeringDatabaseError {
+		err = errors.New("database error")
+		return
+	}
+
+	for _, record := range service.records {
+		if record.Provider == provider && record.Subject == subject {
+			identity = record
+			return
+		}
+	}
+
+	err = errIdentityNotFound
+	return
+}
+
+// FindByUserID fetches the identities of the user with the given ID
+func (service MyIdentityService) FindByUserID(id string) (identities []gate.Identity, err error) {
+	for _, record := range service.records {
+		if record.UserID == id {
+			identities = append(identities, record)
+		}
+	}
+	return
+}
+
+// Store appends the identity
+func (service *MyIdentityService) Store(identity gate.Identity) error {
+	service.records = append(service.records, identity)
+	return nil
+}
+
+// Delete removes the identity with the given provider and subject
+func (service *MyIdentityService) Delete(provider, subject string) error {
+	for i, record := range service.records {
+		if record.Provider == provider && record.Subject == subject {
+			service.records = append(service.records[:i], service.records[i+1:]...)
+			return nil
+		}
+	}
+
+	return errIdentityNotFound
+}
+
+// IsErrNotFound determines whether the error is not found error or not
+func (service MyIdentityService) IsErrNotFound(err error) bool {
+	return err == errIdentityNotFound
+}
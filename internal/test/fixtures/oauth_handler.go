@@ -3,7 +3,6 @@ package fixtures
 import (
 	"github.com/hiendv/gate"
 	"github.com/hiendv/gate/oauth"
-	"github.com/pkg/errors"
 )
 
 // CodeAndStateOAuthHandler is the stub for Handler
@@ -13,6 +12,6 @@ var CodeAndStateOAuthHandler oauth.Handler = func(user gate.Account) oauth.Login
 			return oauth.GoogleUser{Email: "email@gmail.com", EmailVerified: true}, nil
 		}
 
-		return nil, errors.New("invalid credentials")
+		return nil, gate.ErrInvalidCredentials
 	}
 }
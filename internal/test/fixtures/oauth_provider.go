@@ -66,7 +66,7 @@ func (config OAuthProvider) AuthCodeURL(state string, opts ...oauth2.AuthCodeOpt
 }
 
 // Exchange converts an authorization code into a token
-func (config OAuthProvider) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+func (config OAuthProvider) Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
 	if code == "" {
 		return nil, nil
 	}
@@ -0,0 +1,311 @@
+package fixtures
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+type oauthGrant struct {
+	challenge string
+	clientID  string
+	nonce     string
+	openID    bool
+	user      interface{}
+}
+
+// OAuthServer is the fake OAuth server with authorization, token and user info endpoints.
+// It is also an OpenID provider with discovery and key set endpoints
+type OAuthServer struct {
+	*httptest.Server
+	// Tamper modifies the ID token claims before they are signed
+	Tamper func(claims map[string]interface{})
+	// UserInfo overrides the user info response when it is not nil
+	UserInfo map[string]interface{}
+	// Emails is the response of the emails endpoint, e.g. GitHub /user/emails
+	Emails []map[string]interface{}
+	// ExpiresIn is the lifetime of access tokens in seconds
+	ExpiresIn int
+	key       *rsa.PrivateKey
+	keyID     string
+	keySets   int
+	grants    map[string]oauthGrant
+	tokens    map[string]interface{}
+	refreshes map[string]interface{}
+	*sync.Mutex
+}
+
+// NewOAuthServer is the constructor for OAuthServer
+func NewOAuthServer() *OAuthServer {
+	server := &OAuthServer{
+		ExpiresIn: 3600,
+		grants:    map[string]oauthGrant{},
+		tokens:    map[string]interface{}{},
+		refreshes: map[string]interface{}{},
+		Mutex:     &sync.Mutex{},
+	}
+
+	server.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", server.token)
+	mux.HandleFunc("/user", server.user)
+	mux.HandleFunc("/user/emails", server.emails)
+	mux.HandleFunc("/jwks", server.jwks)
+	mux.HandleFunc("/.well-known/openid-configuration", server.discovery)
+	server.Server = httptest.NewServer(mux)
+	return server
+}
+
+// Issuer returns the OpenID issuer of the server
+func (server *OAuthServer) Issuer() string {
+	return server.URL
+}
+
+// RotateKey replaces the key signing ID tokens
+func (server *OAuthServer) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	server.Lock()
+	defer server.Unlock()
+
+	server.key = key
+	server.keyID = RandomString(8)
+}
+
+// Endpoint returns the OAuth endpoint of the server
+func (server *OAuthServer) Endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:  server.URL + "/authorize",
+		TokenURL: server.URL + "/token",
+	}
+}
+
+// UserAPI returns the user info endpoint of the server
+func (server *OAuthServer) UserAPI() string {
+	return server.URL + "/user"
+}
+
+// Authorize simulates the user consent on the given auth URL. It returns the issued code and the state to send back
+func (server *OAuthServer) Authorize(authURL string, user interface{}) (code, state string, err error) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		return
+	}
+
+	query := parsed.Query()
+	challenge := query.Get("code_challenge")
+	if challenge != "" && query.Get("code_challenge_method") != "S256" {
+		err = errors.New("unsupported code challenge method")
+		return
+	}
+
+	server.Lock()
+	defer server.Unlock()
+
+	code = RandomString(16)
+	state = query.Get("state")
+	server.grants[code] = oauthGrant{
+		challenge: challenge,
+		clientID:  query.Get("client_id"),
+		nonce:     query.Get("nonce"),
+		openID:    strings.Contains(" "+query.Get("scope")+" ", " openid "),
+		user:      user,
+	}
+	return
+}
+
+func (server *OAuthServer) token(w http.ResponseWriter, r *http.Request) {
+	server.Lock()
+	defer server.Unlock()
+
+	if r.PostFormValue("grant_type") == "refresh_token" {
+		server.refresh(w, r)
+		return
+	}
+
+	code := r.PostFormValue("code")
+	grant, ok := server.grants[code]
+	if !ok {
+		writeOAuthError(w, "invalid_grant")
+		return
+	}
+	delete(server.grants, code)
+
+	if grant.challenge != "" {
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+	}
+
+	token := RandomString(16)
+	refreshToken := RandomString(16)
+	server.tokens[token] = grant.user
+	server.refreshes[refreshToken] = grant.user
+
+	response := map[string]interface{}{
+		"access_token":  token,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    server.ExpiresIn,
+	}
+
+	if grant.openID {
+		idToken, err := server.idToken(grant, token)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		response["id_token"] = idToken
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// refresh issues a new access token. The refresh token is kept so it is omitted from the response
+func (server *OAuthServer) refresh(w http.ResponseWriter, r *http.Request) {
+	user, ok := server.refreshes[r.PostFormValue("refresh_token")]
+	if !ok {
+		writeOAuthError(w, "invalid_grant")
+		return
+	}
+
+	token := RandomString(16)
+	server.tokens[token] = user
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   server.ExpiresIn,
+	})
+}
+
+func (server *OAuthServer) idToken(grant oauthGrant, accessToken string) (string, error) {
+	data, err := json.Marshal(grant.user)
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{}
+	err = json.Unmarshal(data, &claims)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(accessToken))
+	claims["iss"] = server.URL
+	claims["aud"] = grant.clientID
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	claims["at_hash"] = base64.RawURLEncoding.EncodeToString(sum[:16])
+	if grant.nonce != "" {
+		claims["nonce"] = grant.nonce
+	}
+
+	if server.Tamper != nil {
+		server.Tamper(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = server.keyID
+	return token.SignedString(server.key)
+}
+
+// KeySetRequests returns the number of key set requests
+func (server *OAuthServer) KeySetRequests() int {
+	server.Lock()
+	defer server.Unlock()
+
+	return server.keySets
+}
+
+func (server *OAuthServer) jwks(w http.ResponseWriter, r *http.Request) {
+	server.Lock()
+	defer server.Unlock()
+
+	server.keySets++
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": server.keyID,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(server.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(server.key.E)).Bytes()),
+			},
+		},
+	})
+}
+
+func (server *OAuthServer) discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 server.URL,
+		"authorization_endpoint": server.URL + "/authorize",
+		"token_endpoint":         server.URL + "/token",
+		"userinfo_endpoint":      server.URL + "/user",
+		"jwks_uri":               server.URL + "/jwks",
+	})
+}
+
+func (server *OAuthServer) user(w http.ResponseWriter, r *http.Request) {
+	server.Lock()
+	defer server.Unlock()
+
+	user, ok := server.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if server.UserInfo != nil {
+		user = server.UserInfo
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (server *OAuthServer) emails(w http.ResponseWriter, r *http.Request) {
+	server.Lock()
+	defer server.Unlock()
+
+	_, ok := server.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(server.Emails)
+}
+
+func writeOAuthError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
@@ -0,0 +1,22 @@
+package fixtures
+
+import (
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/password"
+)
+
+// NewPasswordDriver is the constructor for the password driver which only accepts the credentials of the account
+func NewPasswordDriver(config password.Config, account Account, container dependency.Container) *password.Driver {
+	return password.New(
+		config,
+		func(driver password.Driver, email, secret string) (gate.Account, error) {
+			if account.Valid(email, secret) {
+				return account, nil
+			}
+
+			return nil, gate.ErrInvalidCredentials
+		},
+		container,
+	)
+}
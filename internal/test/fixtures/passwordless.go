@@ -0,0 +1,123 @@
+package fixtures
+
+import (
+	"sync"
+
+	"github.com/hiendv/gate/passwordless"
+	"github.com/pkg/errors"
+)
+
+var errLoginTokenNotFound = errors.New("login token not found")
+
+// MyLoginTokenService is my login token service
+type MyLoginTokenService struct {
+	records map[string]passwordless.LoginToken
+	*sync.Mutex
+}
+
+// NewMyLoginTokenService is the constructor for MyLoginTokenService
+func NewMyLoginTokenService() *MyLoginTokenService {
+	return &MyLoginTokenService{map[string]passwordless.LoginToken{}, &sync.Mutex{}}
+}
+
+// FindOneByID fetches the login token with the given ID
+func (service MyLoginTokenService) FindOneByID(id string) (token passwordless.LoginToken, err error) {
+	service.Lock()
+	defer service.Unlock()
+
+	token, ok := service.records[id]
+	if !ok {
+		err = errLoginTokenNotFound
+	}
+
+	return
+}
+
+// Store replaces the login token
+func (service *MyLoginTokenService) Store(token passwordless.LoginToken) error {
+	service.Lock()
+	defer service.Unlock()
+
+	service.records[token.ID] = token
+	return nil
+}
+
+// IncrementAttempts increments the attempts of the login token with the given ID
+func (service *MyLoginTokenService) IncrementAttempts(id string) (int, error) {
+	service.Lock()
+	defer service.Unlock()
+
+	token, ok := service.records[id]
+	if !ok {
+		return 0, errLoginTokenNotFound
+	}
+
+	token.Attempts++
+	service.records[id] = token
+	return token.Attempts, nil
+}
+
+// Delete removes the login token with the given ID
+func (service *MyLoginTokenService) Delete(id string) error {
+	service.Lock()
+	defer service.Unlock()
+
+	if _, ok := service.records[id]; !ok {
+		return errLoginTokenNotFound
+	}
+
+	delete(service.records, id)
+	return nil
+}
+
+// IsErrNotFound determines whether the error is not found error or not
+func (service MyLoginTokenService) IsErrNotFound(err error) bool {
+	return err == errLoginTokenNotFound
+}
+
+// Count returns the number of records
+func (service MyLoginTokenService) Count() int {
+	service.Lock()
+	defer service.Unlock()
+
+	return len(service.records)
+}
+
+// FindByEmail fetches the login tokens of the given email
+func (service MyLoginTokenService) FindByEmail(email string) (tokens []passwordless.LoginToken) {
+	service.Lock()
+	defer service.Unlock()
+
+	for _, token := range service.records {
+		if token.Email == email {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return
+}
+
+// MyMailer is my mailer keeping the sent messages
+type MyMailer struct {
+	Messages []passwordless.Message
+	Failing  bool
+}
+
+// Send keeps the message
+func (mailer *MyMailer) Send(message passwordless.Message) error {
+	if mailer.Failing {
+		return errors.New("could not deliver")
+	}
+
+	mailer.Messages = append(mailer.Messages, message)
+	return nil
+}
+
+// Last returns the last sent message
+func (mailer MyMailer) Last() passwordless.Message {
+	if len(mailer.Messages) == 0 {
+		return passwordless.Message{}
+	}
+
+	return mailer.Messages[len(mailer.Messages)-1]
+}
@@ -0,0 +1,49 @@
+package fixtures
+
+import (
+	"github.com/hiendv/gate/oauth"
+	"github.com/pkg/errors"
+)
+
+var errProviderTokenNotFound = errors.New("provider token not found")
+
+// MyProviderTokenService is my provider token service
+type MyProviderTokenService struct {
+	records []oauth.ProviderToken
+}
+
+// NewMyProviderTokenService is the constructor for MyProviderTokenService
+func NewMyProviderTokenService(records []oauth.ProviderToken) *MyProviderTokenService {
+	return &MyProviderTokenService{records}
+}
+
+// FindOneByUserProvider fetches the token of the user at the provider
+func (service MyProviderTokenService) FindOneByUserProvider(userID, provider string) (token oauth.ProviderToken, err error) {
+	for _, record := range service.records {
+		if record.UserID == userID && record.Provider == provider {
+			token = record
+			return
+		}
+	}
+
+	err = errProviderTokenNotFound
+	return
+}
+
+// Store replaces the token of the user at the provider
+func (service *MyProviderTokenService) Store(token oauth.ProviderToken) error {
+	for i, record := range service.records {
+		if record.UserID == token.UserID && record.Provider == token.Provider {
+			service.records[i] = token
+			return nil
+		}
+	}
+
+	service.records = append(service.records, token)
+	return nil
+}
+
+// IsErrNotFound determines whether the error is not found error or not
+func (service MyProviderTokenService) IsErrNotFound(err error) bool {
+	return err == errProviderTokenNotFound
+}
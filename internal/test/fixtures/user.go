@@ -18,6 +18,8 @@ type User struct {
 	Name  string
 	Email string
 	Roles []string
+	// TenantRoles are the roles of the user per tenant
+	TenantRoles map[string][]string
 }
 
 // GetID returns user ID
@@ -40,11 +42,18 @@ func (u User) GetRoles() []string {
 	return u.Roles
 }
 
+// GetTenantRoles returns user Roles in the tenant
+func (u User) GetTenantRoles(tenant string) []string {
+	return u.TenantRoles[tenant]
+}
+
 // MyUserService is my user service
 type MyUserService struct {
 	records          []User
 	domains          []string
 	GenerateMyUserID func() string
+	// LastAccount is the account of the last created user
+	LastAccount gate.Account
 }
 
 // NewMyUserService is the constructor for MyUserService
@@ -55,6 +64,7 @@ func NewMyUserService(records []User, domains []string) *MyUserService {
 		func() string {
 			return RandomString(8)
 		},
+		nil,
 	}
 }
 
@@ -91,7 +101,14 @@ func (service MyUserService) FindOneByEmail(email string) (u gate.User, err erro
 
 // CreateOneByAccount creates the user with the given email
 func (service *MyUserService) CreateOneByAccount(account gate.Account) (u gate.User, err error) {
-	email := account.GetEmail()
+	return service.CreateOneByProvisioning(account, gate.Provisioning{Email: account.GetEmail()})
+}
+
+// CreateOneByProvisioning creates the user with the verified email and the provisioned roles
+func (service *MyUserService) CreateOneByProvisioning(account gate.Account, provisioning gate.Provisioning) (u gate.User, err error) {
+	service.LastAccount = account
+
+	email := provisioning.Email
 	parts := strings.Split(email, "@")
 	if len(parts) != 2 {
 		err = errors.New("invalid email")
@@ -107,6 +124,7 @@ func (service *MyUserService) CreateOneByAccount(account gate.Account) (u gate.U
 		ID:    service.GenerateMyUserID(),
 		Name:  account.GetName(),
 		Email: email,
+		Roles: provisioning.Roles,
 	}
 	service.records = append(service.records, record)
 	u = record
@@ -0,0 +1,241 @@
+package fixtures
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"sync"
+
+	"github.com/hiendv/gate/internal"
+	"github.com/hiendv/gate/webauthn"
+	"github.com/pkg/errors"
+)
+
+var errCredentialNotFound = errors.New("credential not found")
+
+// MyCredentialService is my WebAuthn credential service
+type MyCredentialService struct {
+	records []webauthn.Credential
+	*sync.Mutex
+}
+
+// NewMyCredentialService is the constructor for MyCredentialService
+func NewMyCredentialService(records []webauthn.Credential) *MyCredentialService {
+	return &MyCredentialService{records, &sync.Mutex{}}
+}
+
+// FindOneByID fetches the credential with the given ID
+func (service MyCredentialService) FindOneByID(id string) (credential webauthn.Credential, err error) {
+	service.Lock()
+	defer service.Unlock()
+
+	for _, record := range service.records {
+		if record.ID == id {
+			credential = record
+			return
+		}
+	}
+
+	err = errCredentialNotFound
+	return
+}
+
+// FindByUserID fetches the credentials of the given user
+func (service MyCredentialService) FindByUserID(userID string) (credentials []webauthn.Credential, err error) {
+	service.Lock()
+	defer service.Unlock()
+
+	for _, record := range service.records {
+		if record.UserID == userID {
+			credentials = append(credentials, record)
+		}
+	}
+
+	return
+}
+
+// Store appends the credential
+func (service *MyCredentialService) Store(credential webauthn.Credential) error {
+	service.Lock()
+	defer service.Unlock()
+
+	service.records = append(service.records, credential)
+	return nil
+}
+
+// UpdateSignCount updates the signature counter of the credential with the given ID if it is still the previous one
+func (service *MyCredentialService) UpdateSignCount(id string, previous, count uint32) (bool, error) {
+	service.Lock()
+	defer service.Unlock()
+
+	for i, record := range service.records {
+		if record.ID == id {
+			if record.SignCount != previous {
+				return false, nil
+			}
+
+			service.records[i].SignCount = count
+			return true, nil
+		}
+	}
+
+	return false, errCredentialNotFound
+}
+
+// IsErrNotFound determines whether the error is not found error or not
+func (service MyCredentialService) IsErrNotFound(err error) bool {
+	return err == errCredentialNotFound
+}
+
+// Authenticator is the software authenticator producing WebAuthn responses
+type Authenticator struct {
+	CredentialID []byte
+	Key          *ecdsa.PrivateKey
+	SignCount    uint32
+	Format       string
+	Flags        byte
+}
+
+// NewAuthenticator is the constructor for Authenticator
+func NewAuthenticator() (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authenticator{
+		CredentialID: []byte(RandomString(16)),
+		Key:          key,
+		Format:       "none",
+		Flags:        webauthn.FlagUserPresent | webauthn.FlagUserVerified,
+	}, nil
+}
+
+// ID returns the encoded credential ID
+func (authenticator Authenticator) ID() string {
+	return base64.RawURLEncoding.EncodeToString(authenticator.CredentialID)
+}
+
+// PublicKey returns the COSE-encoded public key
+func (authenticator Authenticator) PublicKey() []byte {
+	key, _ := internal.CBOREncode(map[interface{}]interface{}{
+		int64(1):  int64(2),
+		int64(3):  webauthn.AlgorithmES256,
+		int64(-1): int64(1),
+		int64(-2): pad(authenticator.Key.X.Bytes()),
+		int64(-3): pad(authenticator.Key.Y.Bytes()),
+	})
+	return key
+}
+
+// ClientData returns the client data JSON of a ceremony
+func ClientData(ceremony, challenge, origin string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    origin,
+	})
+	return data
+}
+
+// Create produces the registration response for the given challenge
+func (authenticator *Authenticator) Create(rpID, origin, challenge string) (map[string]string, error) {
+	clientData := ClientData("webauthn.create", challenge, origin)
+	authData := authenticator.authenticatorData(rpID, true)
+
+	statement := map[interface{}]interface{}{}
+	if authenticator.Format == "packed" {
+		signature, err := authenticator.sign(authData, clientData)
+		if err != nil {
+			return nil, err
+		}
+
+		statement["alg"] = webauthn.AlgorithmES256
+		statement["sig"] = signature
+	}
+
+	object, err := internal.CBOREncode(map[interface{}]interface{}{
+		"fmt":      authenticator.Format,
+		"attStmt":  statement,
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"client_data":        base64.RawURLEncoding.EncodeToString(clientData),
+		"attestation_object": base64.RawURLEncoding.EncodeToString(object),
+	}, nil
+}
+
+// Get produces the assertion response for the given challenge
+func (authenticator *Authenticator) Get(rpID, origin, challenge string) (map[string]string, error) {
+	authenticator.SignCount++
+
+	clientData := ClientData("webauthn.get", challenge, origin)
+	authData := authenticator.authenticatorData(rpID, false)
+
+	signature, err := authenticator.sign(authData, clientData)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"credential_id":      authenticator.ID(),
+		"client_data":        base64.RawURLEncoding.EncodeToString(clientData),
+		"authenticator_data": base64.RawURLEncoding.EncodeToString(authData),
+		"signature":          base64.RawURLEncoding.EncodeToString(signature),
+	}, nil
+}
+
+func (authenticator Authenticator) authenticatorData(rpID string, attested bool) []byte {
+	hash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, hash[:]...)
+
+	flags := authenticator.Flags
+	if attested {
+		flags |= webauthn.FlagAttestedCredentialData
+	}
+	data = append(data, flags)
+
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, authenticator.SignCount)
+	data = append(data, counter...)
+
+	if !attested {
+		return data
+	}
+
+	data = append(data, make([]byte, 16)...)
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(authenticator.CredentialID)))
+	data = append(data, length...)
+	data = append(data, authenticator.CredentialID...)
+	return append(data, authenticator.PublicKey()...)
+}
+
+func (authenticator Authenticator) sign(authData, clientData []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientData)
+	hash := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	r, s, err := ecdsa.Sign(rand.Reader, authenticator.Key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(struct{ R, S *big.Int }{r, s})
+}
+
+func pad(value []byte) []byte {
+	if len(value) >= 32 {
+		return value
+	}
+
+	return append(make([]byte, 32-len(value)), value...)
+}
@@ -0,0 +1,337 @@
+package gate
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Key management algorithms of encrypted tokens
+const (
+	JWEDirect       = "dir"
+	JWERSAOAEP      = "RSA-OAEP"
+	JWERSAOAEP256   = "RSA-OAEP-256"
+	JWEECDHES       = "ECDH-ES"
+	jweEncryption   = "A256GCM"
+	jweKeySize      = 32
+	jweNonceSize    = 12
+	jweContentType  = "JWT"
+	jweCompactParts = 5
+)
+
+// JWEConfig is the configuration for encrypted tokens. Signed tokens are nested in JWEs using A256GCM content encryption
+type JWEConfig struct {
+	alg        string
+	encryptKey interface{}
+	decryptKey interface{}
+}
+
+type jweHeader struct {
+	Alg string        `json:"alg"`
+	Enc string        `json:"enc"`
+	Cty string        `json:"cty,omitempty"`
+	Epk *jweEphemeral `json:"epk,omitempty"`
+}
+
+type jweEphemeral struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewJWEConfig is the constructor for JWEConfig. The keys depend on the algorithm:
+// a 32-byte []byte for dir, *rsa.PublicKey and *rsa.PrivateKey for RSA-OAEP(-256), *ecdsa.PublicKey and *ecdsa.PrivateKey for ECDH-ES.
+// Either key may be nil when the service only issues or only parses tokens
+func NewJWEConfig(alg string, encryptKey, decryptKey interface{}) (config JWEConfig, err error) {
+	if encryptKey == nil && decryptKey == nil {
+		err = errors.New("missing key")
+		return
+	}
+
+	valid := true
+	switch alg {
+	case JWEDirect:
+		for _, key := range []interface{}{encryptKey, decryptKey} {
+			if data, ok := key.([]byte); key != nil && (!ok || len(data) != jweKeySize) {
+				valid = false
+			}
+		}
+	case JWERSAOAEP, JWERSAOAEP256:
+		if _, ok := encryptKey.(*rsa.PublicKey); encryptKey != nil && !ok {
+			valid = false
+		}
+
+		if _, ok := decryptKey.(*rsa.PrivateKey); decryptKey != nil && !ok {
+			valid = false
+		}
+	case JWEECDHES:
+		if key, ok := encryptKey.(*ecdsa.PublicKey); encryptKey != nil && (!ok || curveName(key.Curve) == "") {
+			valid = false
+		}
+
+		if key, ok := decryptKey.(*ecdsa.PrivateKey); decryptKey != nil && (!ok || curveName(key.Curve) == "") {
+			valid = false
+		}
+	default:
+		err = errors.New("invalid JWE algorithm")
+		return
+	}
+
+	if !valid {
+		err = ErrInvalidKey
+		return
+	}
+
+	config = JWEConfig{alg, encryptKey, decryptKey}
+	return
+}
+
+// IsZero determines whether the configuration is omitted or not
+func (config JWEConfig) IsZero() bool {
+	return config.alg == ""
+}
+
+// Encrypt nests the signed token in a JWE using the compact serialization
+func (config JWEConfig) Encrypt(signed string) (string, error) {
+	if config.encryptKey == nil {
+		return "", errors.New("missing encryption key")
+	}
+
+	header := jweHeader{Alg: config.alg, Enc: jweEncryption, Cty: jweContentType}
+	var cek, encryptedKey []byte
+	var err error
+
+	switch config.alg {
+	case JWEDirect:
+		cek = config.encryptKey.([]byte)
+	case JWERSAOAEP, JWERSAOAEP256:
+		cek = make([]byte, jweKeySize)
+		_, err = rand.Read(cek)
+		if err != nil {
+			return "", errors.Wrap(err, "could not generate the content encryption key")
+		}
+
+		encryptedKey, err = rsa.EncryptOAEP(oaepHash(config.alg), rand.Reader, config.encryptKey.(*rsa.PublicKey), cek, nil)
+		if err != nil {
+			return "", errors.Wrap(err, "could not encrypt the content encryption key")
+		}
+	case JWEECDHES:
+		public := config.encryptKey.(*ecdsa.PublicKey)
+		ephemeral, e := ecdsa.GenerateKey(public.Curve, rand.Reader)
+		if e != nil {
+			return "", errors.Wrap(e, "could not generate the ephemeral key")
+		}
+
+		header.Epk = &jweEphemeral{
+			Kty: "EC",
+			Crv: curveName(public.Curve),
+			X:   encodeCoordinate(ephemeral.X, public.Curve),
+			Y:   encodeCoordinate(ephemeral.Y, public.Curve),
+		}
+		cek = deriveECDHKey(public.Curve, public.X, public.Y, ephemeral.D.Bytes())
+	default:
+		return "", errors.New("invalid JWE algorithm")
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return "", errors.Wrap(err, "could not encode the JWE header")
+	}
+	protected := base64.RawURLEncoding.EncodeToString(data)
+
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, jweNonceSize)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate the initialization vector")
+	}
+
+	sealed := gcm.Seal(nil, nonce, []byte(signed), []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return strings.Join([]string{
+		protected,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(nonce),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// Decrypt returns the signed token nested in a JWE using the compact serialization
+func (config JWEConfig) Decrypt(token string) (string, error) {
+	if config.decryptKey == nil {
+		return "", errors.New("missing decryption key")
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != jweCompactParts {
+		return "", ErrTokenMalformed
+	}
+
+	decoded := make([][]byte, jweCompactParts)
+	for i, part := range parts {
+		data, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return "", ErrTokenMalformed
+		}
+
+		decoded[i] = data
+	}
+
+	var header jweHeader
+	err := json.Unmarshal(decoded[0], &header)
+	if err != nil {
+		return "", errors.Wrap(ErrTokenMalformed, "malformed JWE header")
+	}
+
+	if header.Alg != config.alg || header.Enc != jweEncryption {
+		return "", errors.Wrapf(ErrTokenSignature, "unexpected JWE algorithm: %s %s", header.Alg, header.Enc)
+	}
+
+	var cek []byte
+	switch config.alg {
+	case JWEDirect:
+		if len(decoded[1]) != 0 {
+			return "", errors.Wrap(ErrTokenMalformed, "unexpected encrypted key")
+		}
+
+		cek = config.decryptKey.([]byte)
+	case JWERSAOAEP, JWERSAOAEP256:
+		cek, err = rsa.DecryptOAEP(oaepHash(config.alg), rand.Reader, config.decryptKey.(*rsa.PrivateKey), decoded[1], nil)
+		if err != nil || len(cek) != jweKeySize {
+			return "", errors.Wrap(ErrTokenSignature, "could not decrypt the content encryption key")
+		}
+	case JWEECDHES:
+		private := config.decryptKey.(*ecdsa.PrivateKey)
+		if len(decoded[1]) != 0 || header.Epk == nil || header.Epk.Kty != "EC" || header.Epk.Crv != curveName(private.Curve) {
+			return "", errors.Wrap(ErrTokenMalformed, "invalid ephemeral key")
+		}
+
+		x, y := decodeCoordinate(header.Epk.X), decodeCoordinate(header.Epk.Y)
+		if x == nil || y == nil || !private.Curve.IsOnCurve(x, y) {
+			return "", errors.Wrap(ErrTokenMalformed, "invalid ephemeral key")
+		}
+
+		cek = deriveECDHKey(private.Curve, x, y, private.D.Bytes())
+	default:
+		return "", errors.New("invalid JWE algorithm")
+	}
+
+	if len(decoded[2]) != jweNonceSize {
+		return "", errors.Wrap(ErrTokenMalformed, "invalid initialization vector")
+	}
+
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := gcm.Open(nil, decoded[2], append(decoded[3], decoded[4]...), []byte(parts[0]))
+	if err != nil {
+		return "", errors.Wrap(ErrTokenSignature, "could not decrypt JWE")
+	}
+
+	return string(signed), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid content encryption key")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid content encryption key")
+	}
+
+	return gcm, nil
+}
+
+func oaepHash(alg string) hash.Hash {
+	if alg == JWERSAOAEP {
+		return sha1.New()
+	}
+
+	return sha256.New()
+}
+
+// deriveECDHKey derives the content encryption key with the Concat KDF of NIST SP 800-56A as specified by RFC 7518
+func deriveECDHKey(curve elliptic.Curve, x, y *big.Int, d []byte) []byte {
+	z, _ := curve.ScalarMult(x, y, d)
+	secret := padBytes(z, curve)
+
+	var info bytes.Buffer
+	writeLengthPrefixed(&info, []byte(jweEncryption))
+	writeLengthPrefixed(&info, nil)
+	writeLengthPrefixed(&info, nil)
+	binary.Write(&info, binary.BigEndian, uint32(jweKeySize*8))
+
+	hasher := crypto.SHA256.New()
+	binary.Write(hasher, binary.BigEndian, uint32(1))
+	hasher.Write(secret)
+	hasher.Write(info.Bytes())
+	return hasher.Sum(nil)[:jweKeySize]
+}
+
+func writeLengthPrefixed(buffer *bytes.Buffer, data []byte) {
+	binary.Write(buffer, binary.BigEndian, uint32(len(data)))
+	buffer.Write(data)
+}
+
+func curveName(curve elliptic.Curve) string {
+	switch curve {
+	case elliptic.P256():
+		return "P-256"
+	case elliptic.P384():
+		return "P-384"
+	case elliptic.P521():
+		return "P-521"
+	}
+
+	return ""
+}
+
+func encodeCoordinate(value *big.Int, curve elliptic.Curve) string {
+	return base64.RawURLEncoding.EncodeToString(padBytes(value, curve))
+}
+
+func padBytes(value *big.Int, curve elliptic.Curve) []byte {
+	data := value.Bytes()
+	size := (curve.Params().BitSize + 7) / 8
+	if len(data) >= size {
+		return data
+	}
+
+	return append(make([]byte, size-len(data)), data...)
+}
+
+func decodeCoordinate(str string) *big.Int {
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+
+	return new(big.Int).SetBytes(data)
+}
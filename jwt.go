@@ -4,6 +4,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"encoding/hex"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -16,6 +17,7 @@ type JWTService struct {
 	config           JWTConfig
 	Now              func() time.Time
 	GenerateClaimsID func() string
+	Instrumentation  Instrumentation
 }
 
 // JWTConfig is the configuration for JWT service
@@ -25,6 +27,20 @@ type JWTConfig struct {
 	verifyKey            interface{}
 	expiration           time.Duration
 	skipClaimsValidation bool
+	encryption           JWEConfig
+	lifetime             JWTLifetimePolicy
+}
+
+// JWTLifetimePolicy bounds the lifetime of tokens. The zero value validates exp, iat and nbf strictly
+type JWTLifetimePolicy struct {
+	// Leeway tolerates the clock skew between hosts when validating the time claims
+	Leeway time.Duration
+	// MaxAge rejects tokens issued longer ago, regardless of their expiration
+	MaxAge time.Duration
+	// AbsoluteLifetime bounds the tokens refreshed from the same authentication, see RefreshJWT
+	AbsoluteLifetime time.Duration
+	// RoleExpirations overrides the expiration of tokens for users having the roles, e.g. shorter tokens for admins. The shortest one applies
+	RoleExpirations map[string]time.Duration
 }
 
 // JWTClaims are JWT claims with user's information
@@ -32,31 +48,54 @@ type JWTClaims struct {
 	Name  string   `json:"name"`
 	Email string   `json:"email"`
 	Roles []string `json:"roles"`
+	Scope string   `json:"scope,omitempty"`
+	// Tenant is the tenant the roles are bound to, see TenantMember
+	Tenant string `json:"tenant,omitempty"`
+	// AuthTime is the time of the authentication a refreshed token derives from. It is omitted for tokens issued right after authentication
+	AuthTime int64 `json:"auth_time,omitempty"`
 	jwt.StandardClaims
 }
 
 // JWT is the JSON Web Token
 type JWT struct {
-	ID        string
-	Value     string
-	UserID    string
-	ExpiredAt time.Time
-	IssuedAt  time.Time
+	ID              string
+	Value           string
+	UserID          string
+	Scopes          []string
+	Tenant          string
+	ExpiredAt       time.Time
+	IssuedAt        time.Time
+	AuthenticatedAt time.Time
 }
 
 // NewToken constructs a token from JWT claims
 func (service JWTService) NewToken(claims JWTClaims, value string) (token JWT) {
 	token.ID = claims.Id
 	token.UserID = claims.Subject
+	token.Scopes = strings.Fields(claims.Scope)
+	token.Tenant = claims.Tenant
 	token.ExpiredAt = time.Unix(claims.ExpiresAt, 0)
 	token.IssuedAt = time.Unix(claims.IssuedAt, 0)
+	token.AuthenticatedAt = time.Unix(claims.authTime(), 0)
 	token.Value = value
 	return
 }
 
 // NewJWTConfig is the constructor for JWTConfig
 func NewJWTConfig(method jwt.SigningMethod, signKey, verifyKey interface{}, expiration time.Duration, skipClaimsValidation bool) JWTConfig {
-	return JWTConfig{method, signKey, verifyKey, expiration, skipClaimsValidation}
+	return JWTConfig{method, signKey, verifyKey, expiration, skipClaimsValidation, JWEConfig{}, JWTLifetimePolicy{}}
+}
+
+// WithLifetime returns a copy of the configuration which enforces the lifetime policy
+func (config JWTConfig) WithLifetime(lifetime JWTLifetimePolicy) JWTConfig {
+	config.lifetime = lifetime
+	return config
+}
+
+// WithEncryption returns a copy of the configuration which nests the signed tokens in JWEs
+func (config JWTConfig) WithEncryption(encryption JWEConfig) JWTConfig {
+	config.encryption = encryption
+	return config
 }
 
 // NewHMACJWTConfig is the constructor for JWTConfig using HMAC signing method
@@ -68,7 +107,7 @@ func NewHMACJWTConfig(alg string, key interface{}, expiration time.Duration, ski
 	}
 
 	if key == nil {
-		err = errors.New("invalid key")
+		err = ErrInvalidKey
 		return
 	}
 
@@ -87,11 +126,16 @@ func NewJWTService(config JWTConfig) *JWTService {
 			id := xid.New()
 			return hex.EncodeToString(id[:])
 		},
+		nil,
 	}
 }
 
 // Issue generates a token from JWT claims with the service configuration
 func (service JWTService) Issue(claims JWTClaims) (token JWT, err error) {
+	defer func(start time.Time) {
+		service.measure("issue", start, err)
+	}(time.Now())
+
 	if service.config.method == nil {
 		err = errors.New("invalid JWT signing method")
 		return
@@ -115,40 +159,147 @@ func (service JWTService) Issue(claims JWTClaims) (token JWT, err error) {
 		return
 	}
 
+	if !service.config.encryption.IsZero() {
+		str, err = service.config.encryption.Encrypt(str)
+		if err != nil {
+			err = errors.Wrap(err, "could not encrypt JWT")
+			return
+		}
+	}
+
 	token = service.NewToken(claims, str)
 	return
 }
 
 // Parse resolves a token string to a JWT with the service configuration
 func (service JWTService) Parse(tokenString string) (token JWT, err error) {
+	defer func(start time.Time) {
+		service.measure("parse", start, err)
+	}(time.Now())
+
+	signed := tokenString
+	if !service.config.encryption.IsZero() {
+		signed, err = service.config.encryption.Decrypt(tokenString)
+		if err != nil {
+			err = errors.Wrap(err, "could not decrypt JWT")
+			return
+		}
+	}
+
+	// Time claims are validated with the leeway and the clock of the service
 	parser := new(jwt.Parser)
-	parser.SkipClaimsValidation = service.config.skipClaimsValidation
-	obj, err := parser.ParseWithClaims(tokenString, &JWTClaims{}, service.getVerifyingKey)
+	parser.SkipClaimsValidation = true
+	obj, err := parser.ParseWithClaims(signed, &JWTClaims{}, service.getVerifyingKey)
 	if err != nil {
-		err = errors.Wrap(err, "could not parse JWT")
+		err = errors.Wrap(parseError(err), "could not parse JWT")
 		return
 	}
 
 	if !obj.Valid {
-		err = errors.New("invalid JWT")
+		err = ErrTokenSignature
 		return
 	}
 
 	claims, ok := obj.Claims.(*JWTClaims)
 	if !ok {
-		err = errors.New("invalid claims")
+		err = errors.Wrap(ErrTokenMalformed, "invalid claims")
 		return
 	}
 
 	if claims == nil {
-		err = errors.New("invalid claims")
+		err = errors.Wrap(ErrTokenMalformed, "invalid claims")
 		return
 	}
 
+	if !service.config.skipClaimsValidation {
+		err = service.validateClaims(*claims)
+		if err != nil {
+			return
+		}
+	}
+
 	token = service.NewToken(*claims, tokenString)
 	return
 }
 
+func (service JWTService) measure(operation string, start time.Time, err error) {
+	Measure(service.Instrumentation, MetricJWTOperations, MetricJWTOperationDuration, map[string]string{"operation": operation}, start, err)
+}
+
+// parseError maps the validation errors of jwt-go to the token errors
+func parseError(err error) error {
+	validation, ok := err.(*jwt.ValidationError)
+	if !ok {
+		return err
+	}
+
+	if validation.Inner != nil && validation.Errors&jwt.ValidationErrorUnverifiable != 0 {
+		return validation.Inner
+	}
+
+	if validation.Errors&jwt.ValidationErrorMalformed != 0 {
+		return errors.Wrap(ErrTokenMalformed, validation.Error())
+	}
+
+	return errors.Wrap(ErrTokenSignature, validation.Error())
+}
+
+// ClaimError is the failure of the validation of a claim, e.g. exp
+type ClaimError struct {
+	Claim string
+	Err   error
+}
+
+func (err ClaimError) Error() string {
+	return err.Claim + ": " + err.Err.Error()
+}
+
+// Unwrap returns the token error, e.g. ErrTokenExpired
+func (err ClaimError) Unwrap() error {
+	return err.Err
+}
+
+// ValidateClaims returns every failure of the validation of the time claims, e.g. to diagnose a token
+func (service JWTService) ValidateClaims(claims JWTClaims) (failures []ClaimError) {
+	now := service.Now().Unix()
+	leeway := int64(service.config.lifetime.Leeway / time.Second)
+
+	if claims.ExpiresAt != 0 && now > claims.ExpiresAt+leeway {
+		failures = append(failures, ClaimError{"exp", ErrTokenExpired})
+	}
+
+	if claims.IssuedAt != 0 && now < claims.IssuedAt-leeway {
+		failures = append(failures, ClaimError{"iat", ErrTokenNotValidYet})
+	}
+
+	if claims.NotBefore != 0 && now < claims.NotBefore-leeway {
+		failures = append(failures, ClaimError{"nbf", ErrTokenNotValidYet})
+	}
+
+	if maxAge := int64(service.config.lifetime.MaxAge / time.Second); maxAge > 0 {
+		if claims.IssuedAt == 0 || now > claims.IssuedAt+maxAge+leeway {
+			failures = append(failures, ClaimError{"iat", ErrTokenTooOld})
+		}
+	}
+
+	if lifetime := int64(service.config.lifetime.AbsoluteLifetime / time.Second); lifetime > 0 {
+		if claims.authTime() == 0 || now > claims.authTime()+lifetime+leeway {
+			failures = append(failures, ClaimError{"auth_time", ErrTokenLifetimeExceeded})
+		}
+	}
+
+	return
+}
+
+func (service JWTService) validateClaims(claims JWTClaims) error {
+	failures := service.ValidateClaims(claims)
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return failures[0].Err
+}
+
 func (service JWTService) getSigningKey() (key interface{}, err error) {
 	if service.config.method == nil {
 		err = errors.New("invalid JWT signing method")
@@ -157,12 +308,12 @@ func (service JWTService) getSigningKey() (key interface{}, err error) {
 
 	switch service.config.method.(type) {
 	default:
-		err = errors.New("invalid key")
+		err = ErrInvalidKey
 		return
 	case *jwt.SigningMethodHMAC:
 		keyStr, ok := service.config.signKey.(string)
 		if !ok {
-			err = errors.New("invalid key")
+			err = ErrInvalidKey
 			return
 		}
 
@@ -170,7 +321,7 @@ func (service JWTService) getSigningKey() (key interface{}, err error) {
 	case *jwt.SigningMethodRSA:
 		keyRSA, ok := service.config.signKey.(*rsa.PrivateKey)
 		if !ok {
-			err = errors.New("invalid key")
+			err = ErrInvalidKey
 			return
 		}
 
@@ -178,7 +329,7 @@ func (service JWTService) getSigningKey() (key interface{}, err error) {
 	case *jwt.SigningMethodRSAPSS:
 		keyRSA, ok := service.config.signKey.(*rsa.PrivateKey)
 		if !ok {
-			err = errors.New("invalid key")
+			err = ErrInvalidKey
 			return
 		}
 
@@ -186,7 +337,7 @@ func (service JWTService) getSigningKey() (key interface{}, err error) {
 	case *jwt.SigningMethodECDSA:
 		keyECDSA, ok := service.config.signKey.(*ecdsa.PrivateKey)
 		if !ok {
-			err = errors.New("invalid key")
+			err = ErrInvalidKey
 			return
 		}
 
@@ -203,13 +354,13 @@ func (service JWTService) getVerifyingKey(token *jwt.Token) (key interface{}, er
 		return
 	case *jwt.SigningMethodHMAC:
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			err = errors.Errorf("unexpected signing method: %v", token.Header["alg"])
+			err = errors.Wrapf(ErrTokenSignature, "unexpected signing method: %v", token.Header["alg"])
 			return
 		}
 
 		keyStr, ok := service.config.verifyKey.(string)
 		if !ok {
-			err = errors.New("invalid key")
+			err = ErrInvalidKey
 			return
 		}
 
@@ -217,61 +368,131 @@ func (service JWTService) getVerifyingKey(token *jwt.Token) (key interface{}, er
 
 	case *jwt.SigningMethodRSA:
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			err = errors.Errorf("unexpected signing method: %v", token.Header["alg"])
+			err = errors.Wrapf(ErrTokenSignature, "unexpected signing method: %v", token.Header["alg"])
 			return
 		}
 
-		keyRSA, ok := service.config.verifyKey.(*rsa.PrivateKey)
-		if !ok {
-			err = errors.New("invalid key")
+		key, err = rsaPublicKey(service.config.verifyKey)
+		if err != nil {
 			return
 		}
 
-		key = keyRSA
-
 	case *jwt.SigningMethodRSAPSS:
 		if _, ok := token.Method.(*jwt.SigningMethodRSAPSS); !ok {
-			err = errors.Errorf("unexpected signing method: %v", token.Header["alg"])
+			err = errors.Wrapf(ErrTokenSignature, "unexpected signing method: %v", token.Header["alg"])
 			return
 		}
 
-		keyRSA, ok := service.config.verifyKey.(*rsa.PrivateKey)
-		if !ok {
-			err = errors.New("invalid key")
+		key, err = rsaPublicKey(service.config.verifyKey)
+		if err != nil {
 			return
 		}
 
-		key = keyRSA
-
 	case *jwt.SigningMethodECDSA:
 		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
-			err = errors.Errorf("unexpected signing method: %v", token.Header["alg"])
+			err = errors.Wrapf(ErrTokenSignature, "unexpected signing method: %v", token.Header["alg"])
 			return
 		}
 
-		keyECDSA, ok := service.config.verifyKey.(*ecdsa.PrivateKey)
-		if !ok {
-			err = errors.New("invalid key")
+		switch verifyKey := service.config.verifyKey.(type) {
+		default:
+			err = ErrInvalidKey
 			return
+		case *ecdsa.PublicKey:
+			key = verifyKey
+		case *ecdsa.PrivateKey:
+			key = &verifyKey.PublicKey
 		}
-
-		key = keyECDSA
 	}
 
 	return key, nil
 }
 
-// NewClaims generates JWTClaims for a specific user
-func (service JWTService) NewClaims(user User) JWTClaims {
-	return JWTClaims{
+// rsaPublicKey returns the RSA public key verifying the tokens. Private keys are accepted for convenience
+func rsaPublicKey(verifyKey interface{}) (key *rsa.PublicKey, err error) {
+	switch verifyKey := verifyKey.(type) {
+	default:
+		err = ErrInvalidKey
+	case *rsa.PublicKey:
+		key = verifyKey
+	case *rsa.PrivateKey:
+		key = &verifyKey.PublicKey
+	}
+	return
+}
+
+// NewClaims generates JWTClaims for a specific user. The expiration depends on the roles of the user, see JWTLifetimePolicy.
+// Tenant members are issued their tenant and the roles bound to it
+func (service JWTService) NewClaims(user User) (claims JWTClaims) {
+	now := service.Now()
+	claims = JWTClaims{
 		Name:  user.GetName(),
 		Email: user.GetEmail(),
 		Roles: user.GetRoles(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: service.Now().Add(service.config.expiration).Unix(),
-			IssuedAt:  service.Now().Unix(),
+			ExpiresAt: service.expiresAt(user, now, now).Unix(),
+			IssuedAt:  now.Unix(),
 			Id:        service.GenerateClaimsID(),
 			Subject:   user.GetID(),
 		},
 	}
+
+	if member, ok := user.(TenantMember); ok {
+		claims.Tenant = member.Tenant
+	}
+	return
+}
+
+// RefreshClaims generates JWTClaims for a specific user from a token of the same user.
+// The authentication time is carried over so the absolute lifetime is enforced across refreshes
+func (service JWTService) RefreshClaims(user User, token JWT) (claims JWTClaims, err error) {
+	if user == nil || user.GetID() != token.UserID {
+		err = errors.New("the token does not belong to the user")
+		return
+	}
+
+	claims = service.NewClaims(user)
+	authenticatedAt := token.AuthenticatedAt
+	if authenticatedAt.Unix() <= 0 {
+		authenticatedAt = token.IssuedAt
+	}
+
+	now := service.Now()
+	lifetime := service.config.lifetime.AbsoluteLifetime
+	if lifetime > 0 && !now.Before(authenticatedAt.Add(lifetime)) {
+		claims = JWTClaims{}
+		err = ErrTokenLifetimeExceeded
+		return
+	}
+
+	claims.ExpiresAt = service.expiresAt(user, now, authenticatedAt).Unix()
+	if authenticatedAt.Unix() != claims.IssuedAt {
+		claims.AuthTime = authenticatedAt.Unix()
+	}
+	return
+}
+
+func (service JWTService) expiresAt(user User, now, authenticatedAt time.Time) time.Time {
+	expiration := service.config.expiration
+	for _, role := range user.GetRoles() {
+		override, ok := service.config.lifetime.RoleExpirations[role]
+		if ok && override < expiration {
+			expiration = override
+		}
+	}
+
+	expiredAt := now.Add(expiration)
+	if lifetime := service.config.lifetime.AbsoluteLifetime; lifetime > 0 && expiredAt.After(authenticatedAt.Add(lifetime)) {
+		expiredAt = authenticatedAt.Add(lifetime)
+	}
+
+	return expiredAt
+}
+
+func (claims JWTClaims) authTime() int64 {
+	if claims.AuthTime != 0 {
+		return claims.AuthTime
+	}
+
+	return claims.IssuedAt
 }
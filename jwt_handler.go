@@ -23,6 +23,51 @@ func IssueJWT(auth Auth, user User) (token JWT, err error) {
 		err = errors.Wrap(err, "could not store JWT")
 		return
 	}
+
+	Audit(auth, AuditEvent{Type: EventTokenIssued, UserID: token.UserID, TokenID: token.ID, TokenKind: TokenKindJWT})
+	return
+}
+
+// RefreshJWT issues and stores a new JWT for the owner of a valid one.
+// The new token derives from the same authentication, so refreshes are bounded by the absolute lifetime of the JWT service
+func RefreshJWT(auth Auth, tokenString string) (token JWT, err error) {
+	service, err := auth.JWTService()
+	if err != nil {
+		return
+	}
+
+	current, err := auth.ParseJWT(tokenString)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse the token")
+		return
+	}
+
+	user, err := auth.GetUserFromJWT(current)
+	if err != nil {
+		err = errors.Wrap(err, "could not get the user")
+		return
+	}
+
+	claims, err := service.RefreshClaims(user, current)
+	if err != nil {
+		err = errors.Wrap(err, "could not refresh JWT")
+		return
+	}
+
+	token, err = service.Issue(claims)
+	if err != nil {
+		err = errors.Wrap(err, "could not issue JWT")
+		return
+	}
+
+	err = StoreJWT(auth, token)
+	if err != nil {
+		token = JWT{}
+		err = errors.Wrap(err, "could not store JWT")
+		return
+	}
+
+	Audit(auth, AuditEvent{Type: EventTokenIssued, UserID: token.UserID, TokenID: token.ID, TokenKind: TokenKindJWT, Reason: "refresh"})
 	return
 }
 
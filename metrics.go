@@ -0,0 +1,126 @@
+package gate
+
+import (
+	"time"
+
+	"github.com/hiendv/gate/internal"
+	"github.com/pkg/errors"
+)
+
+// Names of the metrics of gate operations. Counters have a "result" label, either "success" or an error label, see ErrorLabel
+const (
+	MetricLogins                 = "gate_logins_total"
+	MetricLoginDuration          = "gate_login_duration_seconds"
+	MetricJWTOperations          = "gate_jwt_operations_total"
+	MetricJWTOperationDuration   = "gate_jwt_operation_duration_seconds"
+	MetricAuthentications        = "gate_authentications_total"
+	MetricAuthenticationDuration = "gate_authentication_duration_seconds"
+	MetricAuthorizations         = "gate_authorizations_total"
+	MetricAuthorizationDuration  = "gate_authorization_duration_seconds"
+	MetricMatcherCacheLookups    = internal.MetricMatcherCacheLookups
+)
+
+// Instrumentation is the contract which receives the measurements of gate operations, e.g. to expose them to Prometheus
+type Instrumentation interface {
+	IncCounter(name string, labels map[string]string)
+	ObserveHistogram(name string, labels map[string]string, value float64)
+}
+
+// InstrumentedAuth is the contract for Auth implementations providing an instrumentation, e.g. through dependency.Container
+type InstrumentedAuth interface {
+	Auth
+	Instrumentation() (Instrumentation, error)
+}
+
+// errorLabels are the bounded values of the result label, in order of precedence. Specific errors precede the broader ones they match
+var errorLabels = []struct {
+	err   error
+	label string
+}{
+	{ErrMissingService, "missing_service"},
+	{ErrMissingCredentials, "missing_credentials"},
+	{ErrInvalidAPIKey, "invalid_api_key"},
+	{ErrAPIKeyExpired, "api_key_expired"},
+	{ErrAPIKeyRevoked, "api_key_revoked"},
+	{ErrInvalidSession, "invalid_session"},
+	{ErrSessionExpired, "session_expired"},
+	{ErrInvalidCredentials, "invalid_credentials"},
+	{ErrInvalidKey, "invalid_key"},
+	{ErrTokenMalformed, "token_malformed"},
+	{ErrTokenSignature, "token_signature"},
+	{ErrTokenExpired, "token_expired"},
+	{ErrTokenNotValidYet, "token_not_valid_yet"},
+	{ErrTokenTooOld, "token_too_old"},
+	{ErrTokenLifetimeExceeded, "token_lifetime_exceeded"},
+	{ErrTokenRevoked, "token_revoked"},
+	{ErrProvisioningDisabled, "provisioning_disabled"},
+	{ErrProvisioningDomainNotAllowed, "provisioning_domain_not_allowed"},
+	{ErrForbidden, "forbidden"},
+	{ErrNoAbilities, "no_abilities"},
+	{ErrNotTenantMember, "not_tenant_member"},
+}
+
+// ErrorLabel returns the result label of an operation. Unknown errors are labeled "error" to bound the cardinality
+func ErrorLabel(err error) string {
+	if err == nil {
+		return "success"
+	}
+
+	for _, known := range errorLabels {
+		if errors.Is(err, known.err) {
+			return known.label
+		}
+	}
+
+	return "error"
+}
+
+// Measure records the outcome and the duration of an operation started at the given time
+func Measure(instrumentation Instrumentation, counter, histogram string, labels map[string]string, start time.Time, err error) {
+	if instrumentation == nil {
+		return
+	}
+
+	observed := map[string]string{}
+	for key, value := range labels {
+		observed[key] = value
+	}
+
+	instrumentation.ObserveHistogram(histogram, observed, time.Since(start).Seconds())
+
+	counted := map[string]string{"result": ErrorLabel(err)}
+	for key, value := range labels {
+		counted[key] = value
+	}
+
+	instrumentation.IncCounter(counter, counted)
+}
+
+// MeasureLogin records the outcome and the duration of a login of the provider
+func MeasureLogin(auth Auth, provider string, start time.Time, err error) {
+	instrumentation, e := getInstrumentation(auth)
+	if e != nil {
+		return
+	}
+
+	Measure(instrumentation, MetricLogins, MetricLoginDuration, map[string]string{"provider": provider}, start, err)
+}
+
+// getInstrumentation returns the instrumentation of the Auth if it is an InstrumentedAuth
+func getInstrumentation(auth Auth) (Instrumentation, error) {
+	instrumented, ok := auth.(InstrumentedAuth)
+	if !ok {
+		return nil, MissingServiceError{Service: "instrumentation"}
+	}
+
+	return instrumented.Instrumentation()
+}
+
+func measure(auth Auth, counter, histogram string, start time.Time, err error) {
+	instrumentation, e := getInstrumentation(auth)
+	if e != nil {
+		return
+	}
+
+	Measure(instrumentation, counter, histogram, nil, start, err)
+}
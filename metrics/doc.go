@@ -0,0 +1,2 @@
+// Package metrics contains the instrumentation of gate exposing its metrics in the Prometheus text exposition format, see gate.Instrumentation
+package metrics
@@ -0,0 +1,82 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/internal/test"
+	"github.com/hiendv/gate/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := metrics.NewRegistry([]float64{0.5, 0.1})
+	registry.IncCounter(gate.MetricLogins, map[string]string{"provider": "password", "result": "success"})
+	registry.IncCounter(gate.MetricLogins, map[string]string{"result": "success", "provider": "password"})
+	registry.IncCounter(gate.MetricLogins, map[string]string{"provider": "password", "result": "invalid_credentials"})
+	registry.ObserveHistogram(gate.MetricLoginDuration, map[string]string{"provider": "password"}, 0.05)
+	registry.ObserveHistogram(gate.MetricLoginDuration, map[string]string{"provider": "password"}, 0.25)
+	registry.ObserveHistogram(gate.MetricLoginDuration, map[string]string{"provider": "password"}, 1)
+	registry.IncCounter("custom_total", map[string]string{"path": "a\"b\\c\nd"})
+
+	if value := registry.Counter(gate.MetricLogins, map[string]string{"provider": "password", "result": "success"}); value != 2 {
+		t.Fatalf("unexpected counter: %v", value)
+	}
+
+	var buffer bytes.Buffer
+	_, err := registry.WriteTo(&buffer)
+	test.AssertOK(t, err, "valid registry")
+
+	expected := `# TYPE custom_total counter
+custom_total{path="a\"b\\c\nd"} 1
+# HELP gate_logins_total Logins by provider and result.
+# TYPE gate_logins_total counter
+gate_logins_total{provider="password",result="invalid_credentials"} 1
+gate_logins_total{provider="password",result="success"} 2
+# HELP gate_login_duration_seconds Duration of logins in seconds by provider.
+# TYPE gate_login_duration_seconds histogram
+gate_login_duration_seconds_bucket{provider="password",le="0.1"} 1
+gate_login_duration_seconds_bucket{provider="password",le="0.5"} 2
+gate_login_duration_seconds_bucket{provider="password",le="+Inf"} 3
+gate_login_duration_seconds_sum{provider="password"} 1.3
+gate_login_duration_seconds_count{provider="password"} 3
+`
+	if buffer.String() != expected {
+		t.Fatalf("unexpected exposition:\n%s", buffer.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	registry := metrics.NewRegistry(nil)
+	registry.Describe("custom_total", "Custom counter.")
+	registry.IncCounter("custom_total", nil)
+	registry.ObserveHistogram("custom_seconds", nil, 0.001)
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if recorder.Code != 200 {
+		t.Fatalf("unexpected status: %d", recorder.Code)
+	}
+
+	if recorder.Header().Get("Content-Type") != metrics.ContentType {
+		t.Fatalf("unexpected content type: %s", recorder.Header().Get("Content-Type"))
+	}
+
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# HELP custom_total Custom counter.",
+		"custom_total 1",
+		"# TYPE custom_seconds histogram",
+		`custom_seconds_bucket{le="0.0005"} 0`,
+		`custom_seconds_bucket{le="0.001"} 1`,
+		`custom_seconds_bucket{le="+Inf"} 1`,
+		"custom_seconds_count 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hiendv/gate"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds of the histograms in seconds, used when the registry omits them
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+var descriptions = map[string]string{
+	gate.MetricLogins:                 "Logins by provider and result.",
+	gate.MetricLoginDuration:          "Duration of logins in seconds by provider.",
+	gate.MetricJWTOperations:          "JWT operations by operation and result.",
+	gate.MetricJWTOperationDuration:   "Duration of JWT operations in seconds by operation.",
+	gate.MetricAuthentications:        "Authentications by result.",
+	gate.MetricAuthenticationDuration: "Duration of authentications in seconds.",
+	gate.MetricAuthorizations:         "Authorizations by result.",
+	gate.MetricAuthorizationDuration:  "Duration of authorizations in seconds.",
+	gate.MetricMatcherCacheLookups:    "Expression cache lookups of the matcher by result.",
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Registry is the instrumentation keeping counters and histograms in memory
+type Registry struct {
+	buckets    []float64
+	help       map[string]string
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+	*sync.Mutex
+}
+
+// NewRegistry is the constructor for Registry. The metrics of gate are described
+func NewRegistry(buckets []float64) Registry {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+
+	help := map[string]string{}
+	for name, description := range descriptions {
+		help[name] = description
+	}
+
+	return Registry{
+		buckets:    sorted,
+		help:       help,
+		counters:   map[string]map[string]float64{},
+		histograms: map[string]map[string]*histogram{},
+		Mutex:      &sync.Mutex{},
+	}
+}
+
+// Describe sets the help text of a metric
+func (registry Registry) Describe(name, help string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.help[name] = help
+}
+
+// IncCounter increments the counter with the given labels
+func (registry Registry) IncCounter(name string, labels map[string]string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	series, ok := registry.counters[name]
+	if !ok {
+		series = map[string]float64{}
+		registry.counters[name] = series
+	}
+
+	series[formatLabels(labels)]++
+}
+
+// ObserveHistogram records the value in the histogram with the given labels
+func (registry Registry) ObserveHistogram(name string, labels map[string]string, value float64) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	series, ok := registry.histograms[name]
+	if !ok {
+		series = map[string]*histogram{}
+		registry.histograms[name] = series
+	}
+
+	key := formatLabels(labels)
+	record, ok := series[key]
+	if !ok {
+		record = &histogram{counts: make([]uint64, len(registry.buckets))}
+		series[key] = record
+	}
+
+	for i, bound := range registry.buckets {
+		if value <= bound {
+			record.counts[i]++
+		}
+	}
+
+	record.sum += value
+	record.count++
+}
+
+// Counter returns the value of the counter with the given labels
+func (registry Registry) Counter(name string, labels map[string]string) float64 {
+	registry.Lock()
+	defer registry.Unlock()
+
+	return registry.counters[name][formatLabels(labels)]
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (registry Registry) WriteTo(w io.Writer) (int64, error) {
+	registry.Lock()
+	var buffer bytes.Buffer
+
+	for _, name := range sortedKeys(registry.counters) {
+		registry.writeHeader(&buffer, name, "counter")
+		series := registry.counters[name]
+		for _, labels := range sortedKeys(series) {
+			fmt.Fprintf(&buffer, "%s%s %s\n", name, labels, formatValue(series[labels]))
+		}
+	}
+
+	for _, name := range sortedKeys(registry.histograms) {
+		registry.writeHeader(&buffer, name, "histogram")
+		series := registry.histograms[name]
+		for _, labels := range sortedKeys(series) {
+			record := series[labels]
+			for i, bound := range registry.buckets {
+				fmt.Fprintf(&buffer, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatValue(bound)), record.counts[i])
+			}
+
+			fmt.Fprintf(&buffer, "%s_bucket%s %d\n", name, withLabel(labels, "le", "+Inf"), record.count)
+			fmt.Fprintf(&buffer, "%s_sum%s %s\n", name, labels, formatValue(record.sum))
+			fmt.Fprintf(&buffer, "%s_count%s %d\n", name, labels, record.count)
+		}
+	}
+
+	registry.Unlock()
+	return buffer.WriteTo(w)
+}
+
+// Handler returns the HTTP handler exposing the metrics, e.g. on /metrics
+func (registry Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		registry.WriteTo(w)
+	})
+}
+
+func (registry Registry) writeHeader(buffer *bytes.Buffer, name, kind string) {
+	if help, ok := registry.help[name]; ok {
+		fmt.Fprintf(buffer, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	}
+
+	fmt.Fprintf(buffer, "# TYPE %s %s\n", name, kind)
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for _, key := range sortedKeys(labels) {
+		pairs = append(pairs, key+`="`+escapeLabel(labels[key])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func withLabel(labels, key, value string) string {
+	pair := key + `="` + escapeLabel(value) + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(m interface{}) (keys []string) {
+	switch values := m.(type) {
+	case map[string]string:
+		for key := range values {
+			keys = append(keys, key)
+		}
+	case map[string]float64:
+		for key := range values {
+			keys = append(keys, key)
+		}
+	case map[string]*histogram:
+		for key := range values {
+			keys = append(keys, key)
+		}
+	case map[string]map[string]float64:
+		for key := range values {
+			keys = append(keys, key)
+		}
+	case map[string]map[string]*histogram:
+		for key := range values {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return
+}
@@ -1,7 +1,10 @@
 package oauth
 
 import (
+	"time"
+
 	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/facebook"
 	"golang.org/x/oauth2/google"
@@ -10,6 +13,8 @@ import (
 // Config is the configuration for OAuth authentication
 type Config struct {
 	gate.Config
+	// Name is the provider of linked identities, e.g. google
+	Name         string
 	ClientID     string
 	ClientSecret string
 	Scopes       []string
@@ -17,38 +22,167 @@ type Config struct {
 	RedirectURI  string
 	UserAPI      string
 	Account      gate.Account
+	// PKCE enables Proof Key for Code Exchange (RFC 7636) for public and mobile clients
+	PKCE bool
+	// StateExpiration is the lifetime of states generated with a state store
+	StateExpiration time.Duration
+	// Issuer enables OpenID Connect. ID tokens must be issued by it
+	Issuer string
+	// JWKSURI is the key set verifying ID tokens
+	JWKSURI string
+	// UserInfoFallback fetches UserAPI when the ID token does not carry the email
+	UserInfoFallback bool
+	// AuthParams are the extra parameters of the consent page URL
+	AuthParams map[string]string
+	// EmailPolicy decides which account emails are trusted
+	EmailPolicy EmailPolicy
+	// Provisioning decides whether and how unknown users are created on login
+	Provisioning gate.ProvisioningPolicy
+}
+
+var (
+	// ErrUnverifiedEmail is thrown when the provider does not flag the account email as verified
+	ErrUnverifiedEmail = errors.New("unverified account email")
+
+	// ErrEmailDomainNotAllowed is thrown when the account email is not in the domain allow-list
+	ErrEmailDomainNotAllowed = errors.New("account email domain is not allowed")
+)
+
+// VerifiableAccount is the contract for accounts flagging whether their email is verified by the provider
+type VerifiableAccount interface {
+	gate.Account
+	IsEmailVerified() bool
+}
+
+// EmailPolicy is the trust policy of account emails. The zero value rejects the emails which accounts flag as unverified.
+// Accounts which are not VerifiableAccount are trusted as they used to be, unless RequireVerifiable is set
+type EmailPolicy struct {
+	// TrustProvider trusts emails without the verified flag, e.g. for providers which only expose verified emails
+	TrustProvider bool
+	// RequireVerifiable rejects the emails of accounts which cannot flag them as verified
+	RequireVerifiable bool
+	// Domains is the allow-list of email domains. Any domain is allowed when it is empty
+	Domains []string
+}
+
+// Verify returns the email of the account if it is trusted
+func (policy EmailPolicy) Verify(account gate.Account) (email string, err error) {
+	if account == nil || account.GetEmail() == "" {
+		err = errors.New("missing account email")
+		return
+	}
+
+	if !policy.TrustProvider {
+		verifiable, ok := account.(VerifiableAccount)
+		if ok && !verifiable.IsEmailVerified() || !ok && policy.RequireVerifiable {
+			err = ErrUnverifiedEmail
+			return
+		}
+	}
+
+	if !gate.EmailDomainAllowed(account.GetEmail(), policy.Domains) {
+		err = ErrEmailDomainNotAllowed
+		return
+	}
+
+	email = account.GetEmail()
+	return
 }
 
 // NewGoogleConfig is the constructor for OAuth configuration using Google API
 func NewGoogleConfig(base gate.Config, id, secret, redirectURI string) Config {
 	return Config{
-		base,
-		id,
-		secret,
-		[]string{"https://www.googleapis.com/auth/userinfo.email"},
-		google.Endpoint,
-		redirectURI,
-		"https://www.googleapis.com/oauth2/v3/userinfo",
-		&GoogleUser{},
+		Config:       base,
+		Name:         "google",
+		ClientID:     id,
+		ClientSecret: secret,
+		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email"},
+		Endpoint:     google.Endpoint,
+		RedirectURI:  redirectURI,
+		UserAPI:      "https://www.googleapis.com/oauth2/v3/userinfo",
+		Account:      &GoogleUser{},
 	}
 }
 
 // NewFacebookConfig is the constructor for OAuth configuration using Facebook API
 func NewFacebookConfig(base gate.Config, id, secret, redirectURI string) Config {
 	return Config{
-		base,
-		id,
-		secret,
-		[]string{"email"},
-		facebook.Endpoint,
-		redirectURI,
-		"https://graph.facebook.com/v2.11/me?fields=id,name,email",
-		&FacebookUser{},
+		Config:       base,
+		Name:         "facebook",
+		ClientID:     id,
+		ClientSecret: secret,
+		Scopes:       []string{"email"},
+		Endpoint:     facebook.Endpoint,
+		RedirectURI:  redirectURI,
+		UserAPI:      "https://graph.facebook.com/v2.11/me?fields=id,name,email",
+		Account:      &FacebookUser{},
+		// Facebook only exposes confirmed emails
+		EmailPolicy: EmailPolicy{TrustProvider: true},
+	}
+}
+
+// NewOIDCConfig is the constructor for OAuth configuration using an OpenID provider, see Discover
+func NewOIDCConfig(base gate.Config, discovery Discovery, id, secret, redirectURI string) Config {
+	return Config{
+		Config:       base,
+		ClientID:     id,
+		ClientSecret: secret,
+		Scopes:       []string{"openid", "email", "profile"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  discovery.AuthorizationEndpoint,
+			TokenURL: discovery.TokenEndpoint,
+		},
+		RedirectURI:      redirectURI,
+		UserAPI:          discovery.UserInfoEndpoint,
+		Account:          &OIDCUser{},
+		Issuer:           discovery.Issuer,
+		JWKSURI:          discovery.JWKSURI,
+		UserInfoFallback: discovery.UserInfoEndpoint != "",
+	}
+}
+
+// OIDCUser is the user from OpenID Connect standard claims
+type OIDCUser struct {
+	Subject           string   `json:"sub"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Groups            []string `json:"groups"`
+}
+
+// GetName returns user's name
+func (user OIDCUser) GetName() string {
+	if user.Name == "" {
+		return user.PreferredUsername
 	}
+
+	return user.Name
+}
+
+// GetSubject returns user's identifier at the provider
+func (user OIDCUser) GetSubject() string {
+	return user.Subject
+}
+
+// GetGroups returns user's groups at the provider
+func (user OIDCUser) GetGroups() []string {
+	return user.Groups
+}
+
+// GetEmail returns user's email
+func (user OIDCUser) GetEmail() string {
+	return user.Email
+}
+
+// IsEmailVerified returns whether the email is verified by the provider
+func (user OIDCUser) IsEmailVerified() bool {
+	return user.EmailVerified
 }
 
 // GoogleUser is the user from Google API
 type GoogleUser struct {
+	Subject       string `json:"sub"`
 	Name          string `json:"name"`
 	Email         string `json:"email"`
 	EmailVerified bool   `json:"email_verified"`
@@ -59,20 +193,26 @@ func (user GoogleUser) GetName() string {
 	return user.Name
 }
 
+// GetSubject returns user's identifier at the provider
+func (user GoogleUser) GetSubject() string {
+	return user.Subject
+}
+
 // GetEmail returns user's email
 func (user GoogleUser) GetEmail() string {
-	if !user.EmailVerified {
-		return ""
-	}
-
 	return user.Email
 }
 
-// FacebookUser is the user from Google API
+// IsEmailVerified returns whether the email is verified by the provider
+func (user GoogleUser) IsEmailVerified() bool {
+	return user.EmailVerified
+}
+
+// FacebookUser is the user from Facebook API. The API does not flag verified emails since it only exposes confirmed ones
 type FacebookUser struct {
-	Name          string `json:"name"`
-	Email         string `json:"email"`
-	EmailVerified bool   `json:"true"`
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
 }
 
 // GetName returns user's name
@@ -80,11 +220,12 @@ func (user FacebookUser) GetName() string {
 	return user.Name
 }
 
+// GetSubject returns user's identifier at the provider
+func (user FacebookUser) GetSubject() string {
+	return user.ID
+}
+
 // GetEmail returns user's email
 func (user FacebookUser) GetEmail() string {
-	if !user.EmailVerified {
-		return ""
-	}
-
 	return user.Email
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/hiendv/gate"
 	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
 )
 
 // Handler is the login handler
@@ -19,44 +20,177 @@ var HandlerStub Handler = func(user gate.Account) LoginFunc {
 	}
 }
 
-// StatelessHandler is the stateless handler
+// StatelessHandler is the stateless handler. Accounts implementing AccountFetcher fetch the rest of their information
 func StatelessHandler(user gate.Account) LoginFunc {
 	return func(driver Driver, code, state string) (account gate.Account, err error) {
-		// State is ignored
-		token, err := driver.provider.Exchange(context.TODO(), code)
+		account, _, err = fetchAccount(driver, user, code, state)
+		return
+	}
+}
+
+// TokenHandler is the StatelessHandler which keeps the upstream token for later API calls, see Driver.UserClient
+func TokenHandler(user gate.Account) LoginFunc {
+	return func(driver Driver, code, state string) (account gate.Account, err error) {
+		person, token, err := fetchAccount(driver, user, code, state)
 		if err != nil {
 			return
 		}
 
-		client := driver.provider.Client(context.TODO(), token)
-		if client == nil {
-			err = errors.New("invalid API client")
+		account = TokenAccount{person, token}
+		return
+	}
+}
+
+func fetchAccount(driver Driver, user gate.Account, code, state string) (account gate.Account, token *oauth2.Token, err error) {
+	// State is only used to find the PKCE code verifier
+	token, err = driver.Exchange(context.TODO(), code, state)
+	if err != nil {
+		err = exchangeError(err)
+		return
+	}
+
+	client := driver.provider.Client(context.TODO(), token)
+	if client == nil {
+		err = errors.New("invalid API client")
+		return
+	}
+
+	response, err := client.Get(driver.config.UserAPI)
+	if err != nil {
+		return
+	}
+	if response == nil {
+		err = errors.New("invalid API response")
+		return
+	}
+	defer func(response *http.Response) {
+		e := response.Body.Close()
+		if e == nil {
 			return
 		}
 
-		response, err := client.Get(driver.config.UserAPI)
+		// TODO
+	}(response)
+
+	person := newAccount(user)
+	err = json.NewDecoder(response.Body).Decode(person)
+	if err != nil {
+		return
+	}
+
+	if fetcher, ok := person.(AccountFetcher); ok {
+		err = fetcher.Fetch(client, driver.config.UserAPI)
 		if err != nil {
+			err = errors.Wrap(err, "could not fetch the account")
 			return
 		}
-		if response == nil {
-			err = errors.New("invalid API response")
-			return
-		}
-		defer func(response *http.Response) {
-			e := response.Body.Close()
-			if e == nil {
-				return
-			}
+	}
+
+	account = person
+	return
+}
 
-			// TODO
-		}(response)
+// OIDCHandler is the OpenID Connect handler. The account is decoded from the verified ID token claims.
+// With UserInfoFallback, the user info is fetched when the claims do not carry a trusted email
+func OIDCHandler(user gate.Account) LoginFunc {
+	return func(driver Driver, code, state string) (account gate.Account, err error) {
+		account, _, err = verifyAccount(driver, user, code, state)
+		return
+	}
+}
 
-		err = json.NewDecoder(response.Body).Decode(user)
+// OIDCTokenHandler is the OIDCHandler which keeps the upstream token for later API calls, see Driver.UserClient
+func OIDCTokenHandler(user gate.Account) LoginFunc {
+	return func(driver Driver, code, state string) (account gate.Account, err error) {
+		person, token, err := verifyAccount(driver, user, code, state)
 		if err != nil {
 			return
 		}
 
-		account = user
+		account = TokenAccount{person, token}
+		return
+	}
+}
+
+func verifyAccount(driver Driver, user gate.Account, code, state string) (account gate.Account, token *oauth2.Token, err error) {
+	ctx := context.TODO()
+	token, err = driver.Exchange(ctx, code, state)
+	if err != nil {
+		err = exchangeError(err)
 		return
 	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	if idToken == "" {
+		err = errors.New("missing ID token")
+		return
+	}
+
+	claims, err := driver.VerifyIDToken(ctx, idToken, token.AccessToken)
+	if err != nil {
+		return
+	}
+
+	person := newAccount(user)
+	err = decodeClaims(claims, person)
+	if err != nil {
+		err = errors.Wrap(err, "could not decode the claims")
+		return
+	}
+
+	_, trusted := driver.config.EmailPolicy.Verify(person)
+	if trusted == nil || !driver.config.UserInfoFallback || driver.config.UserAPI == "" {
+		account = person
+		return
+	}
+
+	client := driver.provider.Client(ctx, token)
+	if client == nil {
+		err = errors.New("invalid API client")
+		return
+	}
+
+	response, err := client.Get(driver.config.UserAPI)
+	if err != nil {
+		return
+	}
+	if response == nil {
+		err = errors.New("invalid API response")
+		return
+	}
+	defer response.Body.Close()
+
+	info := map[string]interface{}{}
+	err = json.NewDecoder(response.Body).Decode(&info)
+	if err != nil {
+		return
+	}
+
+	if info["sub"] != claims["sub"] {
+		err = errors.New("the user info does not belong to the ID token subject")
+		return
+	}
+
+	for key, value := range info {
+		claims[key] = value
+	}
+
+	err = decodeClaims(claims, person)
+	if err != nil {
+		err = errors.Wrap(err, "could not decode the user info")
+		return
+	}
+
+	account = person
+	return
+}
+
+// exchangeError reports the codes rejected by the provider as invalid credentials
+func exchangeError(err error) error {
+	retrieve, ok := err.(*oauth2.RetrieveError)
+	if !ok || retrieve.Response == nil || retrieve.Response.StatusCode >= http.StatusInternalServerError {
+		return errors.Wrap(err, "could not exchange the code")
+	}
+
+	return errors.Wrap(gate.ErrInvalidCredentials, retrieve.Error())
 }
@@ -2,6 +2,9 @@ package oauth
 
 import (
 	"context"
+	"crypto/subtle"
+	"sync"
+	"time"
 
 	"github.com/hiendv/gate"
 	"github.com/hiendv/gate/dependency"
@@ -16,15 +19,23 @@ type LoginFunc func(driver Driver, code, state string) (gate.Account, error)
 // Driver is OAuth authentication
 type Driver struct {
 	dependency.Container
-	config   Config
-	handler  LoginFunc
-	provider Provider
+	config           Config
+	handler          LoginFunc
+	provider         Provider
+	verifiers        VerifierStore
+	states           StateStore
+	tokens           ProviderTokenService
+	keys             keySet
+	flow             State
+	GenerateVerifier func() (string, error)
+	GenerateState    func() (string, error)
+	Now              func() time.Time
 }
 
 // Provider is the OAuth provider
 type Provider interface {
 	AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string
-	Exchange(ctx context.Context, code string) (*oauth2.Token, error)
+	Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error)
 	Client(ctx context.Context, t *oauth2.Token) internal.HTTPClient
 }
 
@@ -39,8 +50,8 @@ func (provider DefaultProvider) AuthCodeURL(state string, opts ...oauth2.AuthCod
 }
 
 // Exchange converts an authorization code into a token
-func (provider DefaultProvider) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
-	return provider.config.Exchange(ctx, code)
+func (provider DefaultProvider) Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return provider.config.Exchange(ctx, code, opts...)
 }
 
 // Client returns an HTTP client using the provided token
@@ -53,6 +64,12 @@ func New(config Config, handler Handler, container dependency.Container) *Driver
 	var driver = &Driver{}
 
 	driver.config = config
+	driver.GenerateVerifier = GenerateVerifier
+	driver.GenerateState = GenerateState
+	driver.Now = time.Now
+	driver.keys = keySet{config.JWKSURI, map[string]interface{}{}, &time.Time{}, &sync.Mutex{}}
+	driver.SetVerifierStore(NewMemoryVerifierStore())
+	driver.SetStateStore(NewMemoryStateStore())
 	driver.SetProvider(DefaultProvider{
 		&oauth2.Config{
 			ClientID:     config.ClientID,
@@ -93,55 +110,201 @@ func (auth *Driver) SetProvider(provider Provider) {
 	auth.provider = provider
 }
 
-// LoginURL returns the URL to the consent page
+// GetVerifierStore is the getter for PKCE code verifier store
+func (auth Driver) GetVerifierStore() VerifierStore {
+	return auth.verifiers
+}
+
+// SetVerifierStore is the setter for PKCE code verifier store
+func (auth *Driver) SetVerifierStore(store VerifierStore) {
+	auth.verifiers = store
+}
+
+// GetStateStore is the getter for state store
+func (auth Driver) GetStateStore() StateStore {
+	return auth.states
+}
+
+// SetStateStore is the setter for state store. States are kept by the store and verified on login
+func (auth *Driver) SetStateStore(store StateStore) {
+	auth.states = store
+}
+
+// WithStateStore returns a copy of the driver using the given state store, e.g. a CookieStateStore of the current request
+func (auth Driver) WithStateStore(store StateStore) Driver {
+	auth.states = store
+	return auth
+}
+
+// LoginURL returns the URL to the consent page for the given state. The state is kept by the state store and verified by Login.
+// It is not bound to a session, see LoginURLWithSession. With PKCE, a code verifier is generated and kept for the state
 func (auth Driver) LoginURL(state string) (string, error) {
+	if state == "" {
+		return "", errors.New("missing state")
+	}
+
+	return auth.loginURL(state, "")
+}
+
+// LoginURLWithSession returns the URL to the consent page for a state generated by the driver and bound to the given session.
+// The state and the session are then required by Login
+func (auth Driver) LoginURLWithSession(session string) (string, error) {
+	if session == "" {
+		return "", errors.New("missing session")
+	}
+
+	state, err := auth.GenerateState()
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate state")
+	}
+
+	return auth.loginURL(state, session)
+}
+
+func (auth Driver) loginURL(state, session string) (string, error) {
+	if auth.states == nil {
+		return "", gate.MissingServiceError{Service: "state store"}
+	}
+
 	if auth.provider == nil {
 		return "", errors.New("invalid oauth configuration")
 	}
 
-	return auth.provider.AuthCodeURL(state), nil
+	flow, err := auth.saveState(state, session)
+	if err != nil {
+		return "", err
+	}
+
+	return auth.authCodeURL(flow.Value, flow.Nonce)
 }
 
-// Login resolves OAuth authentication with the given handler and credentials
+func (auth Driver) authCodeURL(state, nonce string) (string, error) {
+	if auth.provider == nil {
+		return "", errors.New("invalid oauth configuration")
+	}
+
+	var opts []oauth2.AuthCodeOption
+	for key, value := range auth.config.AuthParams {
+		opts = append(opts, oauth2.SetAuthURLParam(key, value))
+	}
+
+	if nonce != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	}
+
+	if !auth.config.PKCE {
+		return auth.provider.AuthCodeURL(state, opts...), nil
+	}
+
+	if state == "" {
+		return "", errors.New("PKCE requires a state")
+	}
+
+	if auth.verifiers == nil {
+		return "", gate.MissingServiceError{Service: "code verifier store"}
+	}
+
+	verifier, err := auth.GenerateVerifier()
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate code verifier")
+	}
+
+	err = auth.verifiers.Store(state, verifier)
+	if err != nil {
+		return "", errors.Wrap(err, "could not store code verifier")
+	}
+
+	return auth.provider.AuthCodeURL(state, append(opts, challengeOptions(verifier)...)...), nil
+}
+
+// Exchange converts an authorization code into a token. With PKCE, the code verifier of the state is sent along
+func (auth Driver) Exchange(ctx context.Context, code, state string) (token *oauth2.Token, err error) {
+	if auth.provider == nil {
+		err = errors.New("invalid oauth configuration")
+		return
+	}
+
+	if !auth.config.PKCE {
+		return auth.provider.Exchange(ctx, code)
+	}
+
+	if auth.verifiers == nil {
+		err = gate.MissingServiceError{Service: "code verifier store"}
+		return
+	}
+
+	verifier, err := auth.verifiers.Take(state)
+	if err != nil {
+		err = errors.Wrap(err, "could not find the code verifier")
+		return
+	}
+
+	return auth.provider.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+}
+
+// Login resolves OAuth authentication with the given handler and credentials.
+// The state given to LoginURL, or the state and the session given to LoginURLWithSession, are required.
+// With an identity service, users are resolved by the account subject before its email, see gate.LoginAccount.
+// Upstream tokens returned along with the account, see TokenHandler, are stored for the user
 func (auth Driver) Login(credentials map[string]string) (user gate.User, err error) {
+	defer func(start time.Time) {
+		gate.AuditLogin(auth, auth.ProviderName(), "", user, err)
+		gate.MeasureLogin(auth, auth.ProviderName(), start, err)
+	}(time.Now())
+
 	code, ok := credentials["code"]
 	if !ok {
-		err = errors.New("missing code")
+		err = gate.MissingCredentialsError{Credential: "code"}
 		return
 	}
 
-	// state is optional because of stateless cases
-	person, err := auth.handler(auth, code, credentials["state"])
-	if err != nil {
-		err = errors.Wrap(err, "could not login")
+	if auth.states == nil {
+		err = gate.MissingServiceError{Service: "state store"}
 		return
 	}
 
-	service, err := auth.UserService()
+	auth.flow, err = auth.verifyState(credentials["state"], credentials["session"])
 	if err != nil {
-		err = errors.Wrap(err, "invalid user service")
 		return
 	}
 
-	if person.GetEmail() == "" {
-		err = errors.New("missing account email")
+	person, err := auth.handler(auth, code, credentials["state"])
+	if err != nil {
+		err = errors.Wrap(err, "could not login")
 		return
 	}
 
-	user, err = service.FindOneByEmail(person.GetEmail())
-	if err == nil {
-		return
+	var token *oauth2.Token
+	if account, ok := person.(TokenAccount); ok {
+		person, token = account.Account, account.Token
 	}
 
-	if !service.IsErrNotFound(err) {
-		err = errors.Wrap(err, "could not find the user")
+	user, err = gate.LoginAccount(auth, auth.ProviderName(), person, auth.config.EmailPolicy.Verify, auth.config.Provisioning)
+	if err != nil || token == nil {
 		return
 	}
 
-	user, err = service.CreateOneByAccount(person)
+	err = auth.storeProviderToken(user, token)
+	if err != nil {
+		user = nil
+		return
+	}
 	return
 }
 
+// ProviderName returns the provider of linked identities. It defaults to the issuer, then the authorization URL
+func (auth Driver) ProviderName() string {
+	if auth.config.Name != "" {
+		return auth.config.Name
+	}
+
+	if auth.config.Issuer != "" {
+		return auth.config.Issuer
+	}
+
+	return auth.config.Endpoint.AuthURL
+}
+
 // IssueJWT issues and stores a JWT for a specific user
 func (auth Driver) IssueJWT(user gate.User) (gate.JWT, error) {
 	return gate.IssueJWT(auth, user)
@@ -171,3 +334,52 @@ func (auth Driver) Authorize(user gate.User, action, object string) (err error)
 func (auth Driver) GetUserAbilities(user gate.User) (abilities []gate.UserAbility, err error) {
 	return gate.GetUserAbilities(auth, user)
 }
+
+func (auth Driver) saveState(value, session string) (state State, err error) {
+	state.Value = value
+	state.Session = session
+	if auth.config.Issuer != "" {
+		state.Nonce, err = auth.GenerateState()
+		if err != nil {
+			err = errors.Wrap(err, "could not generate nonce")
+			return
+		}
+	}
+
+	expiration := auth.config.StateExpiration
+	if expiration <= 0 {
+		expiration = DefaultStateExpiration
+	}
+	state.ExpiredAt = auth.Now().Add(expiration)
+
+	err = auth.states.Save(state)
+	if err != nil {
+		err = errors.Wrap(err, "could not store state")
+		return
+	}
+	return
+}
+
+func (auth Driver) verifyState(value, session string) (state State, err error) {
+	if value == "" {
+		err = ErrInvalidState
+		return
+	}
+
+	state, err = auth.states.Take(value)
+	if err == ErrStateNotFound {
+		err = ErrInvalidState
+		return
+	}
+
+	if err != nil {
+		err = errors.Wrap(err, "could not find the state")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(state.Session), []byte(session)) != 1 || auth.Now().After(state.ExpiredAt) {
+		err = ErrInvalidState
+		return
+	}
+	return
+}
@@ -87,7 +87,13 @@ func Example() {
 		return
 	}
 
-	user, err := auth.Login(map[string]string{"code": "code"})
+	_, err := auth.LoginURL("state")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	user, err := auth.Login(map[string]string{"code": "code", "state": "state"})
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -187,7 +193,13 @@ func ExampleDriver_Login() {
 		return
 	}
 
-	user, err := auth.Login(map[string]string{"code": "code"})
+	_, err := auth.LoginURL("state")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	user, err := auth.Login(map[string]string{"code": "code", "state": "state"})
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -195,7 +207,13 @@ func ExampleDriver_Login() {
 
 	fmt.Printf("%s:%s - %v\n", user.GetID(), user.GetEmail(), err)
 
-	secondUser, err := auth.Login(map[string]string{"code": "code2"})
+	_, err = auth.LoginURL("state")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	secondUser, err := auth.Login(map[string]string{"code": "code2", "state": "state"})
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -203,7 +221,13 @@ func ExampleDriver_Login() {
 
 	fmt.Printf("%s:%s - %v\n", secondUser.GetID(), secondUser.GetEmail(), err)
 
-	thirdUser, err := auth.Login(map[string]string{"code": "code3"})
+	_, err = auth.LoginURL("state")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	thirdUser, err := auth.Login(map[string]string{"code": "code3", "state": "state"})
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -277,7 +301,13 @@ func ExampleDriver_IssueJWT() {
 
 	driver.Container.SetJWTService(mockedJWTService)
 
-	user, err := auth.Login(map[string]string{"code": "code"})
+	_, err = auth.LoginURL("state")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	user, err := auth.Login(map[string]string{"code": "code", "state": "state"})
 	if err != nil {
 		fmt.Println(err)
 		return
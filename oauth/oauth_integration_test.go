@@ -1,7 +1,11 @@
 package oauth_test
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +14,7 @@ import (
 	"github.com/hiendv/gate/internal/test"
 	"github.com/hiendv/gate/internal/test/fixtures"
 	"github.com/hiendv/gate/oauth"
+	"github.com/pkg/errors"
 )
 
 var (
@@ -84,6 +89,16 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
+// login starts the flow of the given state before resolving the code
+func login(t *testing.T, auth gate.Auth, code string) (gate.User, error) {
+	t.Helper()
+
+	_, err := auth.LoginURL("state")
+	test.AssertOK(t, err, "valid login URL")
+
+	return auth.Login(map[string]string{"code": code, "state": "state"})
+}
+
 func TestOAuthWithBadProvider(t *testing.T) {
 	t.Run("login", func(t *testing.T) {
 		provider := driver.GetProvider()
@@ -91,19 +106,19 @@ func TestOAuthWithBadProvider(t *testing.T) {
 
 		t.Run("no client", func(t *testing.T) {
 			driver.SetProvider(fixtures.BadOAuthProvider{NoClient: true})
-			_, err := auth.Login(map[string]string{"code": "code", "state": "state"})
+			_, err := login(t, auth, "code")
 			test.AssertErr(t, err, "no client")
 		})
 
 		t.Run("no response", func(t *testing.T) {
 			driver.SetProvider(fixtures.BadOAuthProvider{NoResponse: true})
-			_, err := auth.Login(map[string]string{"code": "code", "state": "state"})
+			_, err := login(t, auth, "code")
 			test.AssertErr(t, err, "no response")
 		})
 
 		t.Run("malformed response", func(t *testing.T) {
 			driver.SetProvider(fixtures.BadOAuthProvider{MalformedResponse: true, OAuthProvider: fixtures.OAuthProvider{}})
-			_, err := auth.Login(map[string]string{"code": "code", "state": "state"})
+			_, err := login(t, auth, "code")
 			test.AssertErr(t, err, "malformed response")
 		})
 	})
@@ -127,7 +142,7 @@ func TestOAuthWithDefaultProvider(t *testing.T) {
 
 	t.Run("login", func(t *testing.T) {
 		t.Run("invalid credentials", func(t *testing.T) {
-			_, err := auth.Login(map[string]string{"code": "code", "state": "state"})
+			_, err := login(t, auth, "code")
 			test.AssertErr(t, err, "invalid credentials")
 		})
 	})
@@ -172,26 +187,26 @@ func TestOAuthWithMockedProvider(t *testing.T) {
 
 		t.Run("login", func(t *testing.T) {
 			t.Run("valid credentials", func(t *testing.T) {
-				firstUser, err := auth.Login(map[string]string{"code": "code", "state": "state"})
+				firstUser, err := login(t, auth, "code")
 				test.AssertOK(t, err, "valid credentials")
 
-				secondUser, err := auth.Login(map[string]string{"code": "code2", "state": "state"})
+				secondUser, err := login(t, auth, "code2")
 				test.AssertOK(t, err, "valid credentials")
 
 				if firstUser.GetID() != secondUser.GetID() {
 					t.Errorf("ids should be equal: %v - %v", firstUser.GetID(), secondUser.GetID())
 				}
 
-				_, err = auth.Login(map[string]string{"code": "code3"})
+				_, err = login(t, auth, "code3")
 				test.AssertErr(t, err, "unverified email")
 
-				_, err = auth.Login(map[string]string{"code": "code4"})
+				_, err = login(t, auth, "code4")
 				test.AssertErr(t, err, "forbidden email domain")
 
-				_, err = auth.Login(map[string]string{"code": "code5"})
+				_, err = login(t, auth, "code5")
 				test.AssertErr(t, err, "database error")
 
-				_, err = auth.Login(map[string]string{"code": "code6", "state": "state"})
+				_, err = login(t, auth, "code6")
 				test.AssertOK(t, err, "valid credentials")
 			})
 
@@ -223,18 +238,18 @@ func TestOAuthWithMockedProvider(t *testing.T) {
 		facebookDriver.SetProvider(fixtures.OAuthProvider{
 			map[string]gate.Account{
 				"code-token": oauth.FacebookUser{
-					Email:         "foo@facebook",
-					Name:          "foo",
-					EmailVerified: true,
+					ID:    "foo-id",
+					Email: "foo@facebook",
+					Name:  "foo",
 				},
 				"code2-token": oauth.FacebookUser{
-					Email:         "foo@facebook",
-					Name:          "foo",
-					EmailVerified: true,
+					ID:    "foo-id",
+					Email: "foo@facebook",
+					Name:  "foo",
 				},
 				"code3-token": oauth.FacebookUser{
-					Email:         "bar@facebook",
-					EmailVerified: false,
+					ID:   "bar-id",
+					Name: "bar",
 				},
 			},
 		})
@@ -246,18 +261,18 @@ func TestOAuthWithMockedProvider(t *testing.T) {
 
 		t.Run("login", func(t *testing.T) {
 			t.Run("valid credentials", func(t *testing.T) {
-				firstUser, err := auth.Login(map[string]string{"code": "code", "state": "state"})
+				firstUser, err := login(t, auth, "code")
 				test.AssertOK(t, err, "valid credentials")
 
-				secondUser, err := auth.Login(map[string]string{"code": "code2", "state": "state"})
+				secondUser, err := login(t, auth, "code2")
 				test.AssertOK(t, err, "valid credentials")
 
 				if firstUser.GetID() != secondUser.GetID() {
 					t.Errorf("ids should be equal: %v - %v", firstUser.GetID(), secondUser.GetID())
 				}
 
-				_, err = auth.Login(map[string]string{"code": "code3"})
-				test.AssertErr(t, err, "unverified email")
+				_, err = login(t, auth, "code3")
+				test.AssertErr(t, err, "missing email")
 			})
 
 			t.Run("invalid credentials", func(t *testing.T) {
@@ -269,6 +284,38 @@ func TestOAuthWithMockedProvider(t *testing.T) {
 			})
 		})
 	})
+
+	t.Run("email policy", func(t *testing.T) {
+		config := oauth.NewFacebookConfig(
+			gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+			"client-id",
+			"client-secret",
+			"http://localhost:8080",
+		)
+		config.EmailPolicy.Domains = []string{"local"}
+
+		policyDriver := oauth.New(config, oauth.StatelessHandler, dependency.NewContainer(userService, tokenService, roleService))
+		policyDriver.SetProvider(fixtures.OAuthProvider{
+			Responses: map[string]gate.Account{
+				"code-token": oauth.FacebookUser{
+					Email: "foo@local",
+					Name:  "foo",
+				},
+				"code2-token": oauth.FacebookUser{
+					Email: "foo@facebook",
+					Name:  "foo",
+				},
+			},
+		})
+
+		_, err := login(t, policyDriver, "code")
+		test.AssertOK(t, err, "allowed domain")
+
+		_, err = login(t, policyDriver, "code2")
+		if err != oauth.ErrEmailDomainNotAllowed {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
 }
 
 func TestOAuthJWT(t *testing.T) {
@@ -375,3 +422,783 @@ func TestOAuthJWT(t *testing.T) {
 		})
 	})
 }
+
+func TestOAuthPKCE(t *testing.T) {
+	server := fixtures.NewOAuthServer()
+	defer server.Close()
+
+	newDriver := func(pkce bool) *oauth.Driver {
+		return oauth.New(
+			oauth.Config{
+				Config:      gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+				ClientID:    "client-id",
+				Endpoint:    server.Endpoint(),
+				RedirectURI: "http://localhost:8080",
+				UserAPI:     server.UserAPI(),
+				Account:     &oauth.GoogleUser{},
+				PKCE:        pkce,
+			},
+			oauth.StatelessHandler,
+			dependency.NewContainer(userService, tokenService, roleService),
+		)
+	}
+
+	pkceDriver := newDriver(true)
+	if pkceDriver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	person := oauth.GoogleUser{Email: "foo@local", Name: "foo", EmailVerified: true}
+
+	t.Run("valid verifier", func(t *testing.T) {
+		url, err := pkceDriver.LoginURL("state")
+		test.AssertOK(t, err, "valid state")
+
+		code, state, err := server.Authorize(url, person)
+		test.AssertOK(t, err, "valid challenge")
+
+		user, err := pkceDriver.Login(map[string]string{"code": code, "state": state})
+		test.AssertOK(t, err, "valid verifier")
+
+		if user.GetEmail() != "foo@local" {
+			t.Fatalf("unexpected user: %s", user.GetEmail())
+		}
+	})
+
+	t.Run("unknown state", func(t *testing.T) {
+		url, err := pkceDriver.LoginURL("another-state")
+		test.AssertOK(t, err, "valid state")
+
+		code, _, err := server.Authorize(url, person)
+		test.AssertOK(t, err, "valid challenge")
+
+		_, err = pkceDriver.Login(map[string]string{"code": code, "state": "unknown"})
+		test.AssertErr(t, err, "unknown state")
+
+		_, err = pkceDriver.Login(map[string]string{"code": code})
+		test.AssertErr(t, err, "missing state")
+	})
+
+	t.Run("forged verifier", func(t *testing.T) {
+		url, err := pkceDriver.LoginURL("forged-state")
+		test.AssertOK(t, err, "valid state")
+
+		code, state, err := server.Authorize(url, person)
+		test.AssertOK(t, err, "valid challenge")
+
+		store := pkceDriver.GetVerifierStore()
+		_, err = store.Take(state)
+		test.AssertOK(t, err, "existing verifier")
+
+		err = store.Store(state, "forged-verifier-forged-verifier-forged-verifier")
+		test.AssertOK(t, err, "valid verifier")
+
+		_, err = pkceDriver.Login(map[string]string{"code": code, "state": state})
+		test.AssertErr(t, err, "forged verifier")
+		if !errors.Is(err, gate.ErrInvalidCredentials) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("without PKCE", func(t *testing.T) {
+		plainDriver := newDriver(false)
+		if plainDriver == nil {
+			t.Fatal("unexpected nil driver")
+		}
+
+		url, err := plainDriver.LoginURL("state")
+		test.AssertOK(t, err, "valid state")
+
+		if strings.Contains(url, "code_challenge") {
+			t.Fatalf("unexpected login URL: %s", url)
+		}
+
+		code, _, err := server.Authorize(url, person)
+		test.AssertOK(t, err, "no challenge")
+
+		_, err = plainDriver.Login(map[string]string{"code": code, "state": "state"})
+		test.AssertOK(t, err, "valid code")
+	})
+}
+
+func TestOAuthState(t *testing.T) {
+	server := fixtures.NewOAuthServer()
+	defer server.Close()
+
+	config := oauth.Config{
+		Config:      gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+		ClientID:    "client-id",
+		Endpoint:    server.Endpoint(),
+		RedirectURI: "http://localhost:8080",
+		UserAPI:     server.UserAPI(),
+		Account:     &oauth.GoogleUser{},
+		PKCE:        true,
+	}
+
+	stateDriver := oauth.New(
+		config,
+		oauth.StatelessHandler,
+		dependency.NewContainer(userService, tokenService, roleService),
+	)
+	if stateDriver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+	stateDriver.SetStateStore(oauth.NewMemoryStateStore())
+
+	person := oauth.GoogleUser{Email: "foo@local", Name: "foo", EmailVerified: true}
+	authorize := func(driver oauth.Driver, session string) (code, state string) {
+		url, err := driver.LoginURLWithSession(session)
+		test.AssertOK(t, err, "valid session")
+
+		code, state, err = server.Authorize(url, person)
+		test.AssertOK(t, err, "valid authorization")
+
+		if state == "" || state == session {
+			t.Fatalf("unexpected state: %s", state)
+		}
+		return
+	}
+
+	t.Run("valid state", func(t *testing.T) {
+		code, state := authorize(*stateDriver, "session")
+
+		user, err := stateDriver.Login(map[string]string{"code": code, "state": state, "session": "session"})
+		test.AssertOK(t, err, "valid state")
+
+		if user.GetEmail() != "foo@local" {
+			t.Fatalf("unexpected user: %s", user.GetEmail())
+		}
+
+		code, _ = authorize(*stateDriver, "session")
+		_, err = stateDriver.Login(map[string]string{"code": code, "state": state, "session": "session"})
+		test.AssertErr(t, err, "consumed state")
+	})
+
+	t.Run("invalid state", func(t *testing.T) {
+		code, state := authorize(*stateDriver, "session")
+
+		_, err := stateDriver.Login(map[string]string{"code": code, "session": "session"})
+		test.AssertErr(t, err, "missing state")
+
+		_, err = stateDriver.Login(map[string]string{"code": code, "state": "forged", "session": "session"})
+		test.AssertErr(t, err, "forged state")
+
+		_, err = stateDriver.Login(map[string]string{"code": code, "state": state, "session": "attacker-session"})
+		test.AssertErr(t, err, "another session")
+
+		_, err = stateDriver.Login(map[string]string{"code": code, "state": state, "session": "session"})
+		test.AssertErr(t, err, "state used by the rejected attempt")
+	})
+
+	t.Run("expired state", func(t *testing.T) {
+		now := stateDriver.Now
+		defer func() {
+			stateDriver.Now = now
+		}()
+
+		code, state := authorize(*stateDriver, "session")
+		stateDriver.Now = func() time.Time {
+			return now().Add(oauth.DefaultStateExpiration * 2)
+		}
+
+		_, err := stateDriver.Login(map[string]string{"code": code, "state": state, "session": "session"})
+		test.AssertErr(t, err, "expired state")
+	})
+
+	t.Run("cookie store", func(t *testing.T) {
+		key := []byte("cookie-secret")
+		recorder := httptest.NewRecorder()
+		code, state := authorize(stateDriver.WithStateStore(oauth.NewCookieStateStore(key, recorder, nil)), "session")
+
+		callback := httptest.NewRequest(http.MethodGet, "/callback", nil)
+		for _, cookie := range recorder.Result().Cookies() {
+			callback.AddCookie(cookie)
+		}
+
+		cookieDriver := stateDriver.WithStateStore(oauth.NewCookieStateStore(key, httptest.NewRecorder(), callback))
+		_, err := cookieDriver.Login(map[string]string{"code": code, "state": state, "session": "session"})
+		test.AssertOK(t, err, "valid cookie")
+
+		code, state = authorize(stateDriver.WithStateStore(oauth.NewCookieStateStore(key, httptest.NewRecorder(), nil)), "session")
+		cookieDriver = stateDriver.WithStateStore(oauth.NewCookieStateStore(key, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/callback", nil)))
+		_, err = cookieDriver.Login(map[string]string{"code": code, "state": state, "session": "session"})
+		test.AssertErr(t, err, "callback from another browser")
+	})
+}
+
+func TestOAuthOIDC(t *testing.T) {
+	server := fixtures.NewOAuthServer()
+	defer server.Close()
+
+	t.Run("discovery", func(t *testing.T) {
+		_, err := oauth.Discover(context.Background(), server.Issuer()+"/")
+		test.AssertErr(t, err, "mismatched issuer")
+
+		_, err = oauth.Discover(context.Background(), server.URL+"/unknown")
+		test.AssertErr(t, err, "missing discovery document")
+	})
+
+	discovery, err := oauth.Discover(context.Background(), server.Issuer())
+	test.AssertOK(t, err, "valid discovery document")
+
+	oidcDriver := oauth.New(
+		oauth.NewOIDCConfig(
+			gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+			discovery,
+			"client-id",
+			"client-secret",
+			"http://localhost:8080",
+		),
+		oauth.OIDCHandler,
+		dependency.NewContainer(userService, tokenService, roleService),
+	)
+	if oidcDriver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+	oidcDriver.SetStateStore(oauth.NewMemoryStateStore())
+
+	person := map[string]interface{}{"sub": "foo-subject", "name": "foo", "email": "foo@local", "email_verified": true}
+	login := func(driver *oauth.Driver, person map[string]interface{}) (gate.User, error) {
+		url, err := driver.LoginURLWithSession("session")
+		test.AssertOK(t, err, "valid session")
+
+		code, state, err := server.Authorize(url, person)
+		test.AssertOK(t, err, "valid authorization")
+
+		return driver.Login(map[string]string{"code": code, "state": state, "session": "session"})
+	}
+
+	t.Run("valid ID token", func(t *testing.T) {
+		url, err := oidcDriver.LoginURLWithSession("session")
+		test.AssertOK(t, err, "valid session")
+
+		if !strings.Contains(url, "nonce=") || !strings.Contains(url, "openid") {
+			t.Fatalf("unexpected login URL: %s", url)
+		}
+
+		user, err := login(oidcDriver, person)
+		test.AssertOK(t, err, "valid ID token")
+
+		if user.GetEmail() != "foo@local" {
+			t.Fatalf("unexpected user: %s", user.GetEmail())
+		}
+	})
+
+	t.Run("invalid ID token", func(t *testing.T) {
+		defer func() {
+			server.Tamper = nil
+		}()
+
+		cases := map[string]func(claims map[string]interface{}){
+			"wrong issuer": func(claims map[string]interface{}) {
+				claims["iss"] = "https://attacker.local"
+			},
+			"wrong audience": func(claims map[string]interface{}) {
+				claims["aud"] = "another-client"
+			},
+			"unauthorized party": func(claims map[string]interface{}) {
+				claims["aud"] = []string{"client-id", "another-client"}
+			},
+			"expired": func(claims map[string]interface{}) {
+				claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			},
+			"missing expiration": func(claims map[string]interface{}) {
+				delete(claims, "exp")
+			},
+			"wrong nonce": func(claims map[string]interface{}) {
+				claims["nonce"] = "replayed"
+			},
+			"missing nonce": func(claims map[string]interface{}) {
+				delete(claims, "nonce")
+			},
+			"wrong access token hash": func(claims map[string]interface{}) {
+				claims["at_hash"] = "forged"
+			},
+			"missing subject": func(claims map[string]interface{}) {
+				delete(claims, "sub")
+			},
+		}
+
+		for name, tamper := range cases {
+			server.Tamper = tamper
+			_, err := login(oidcDriver, person)
+			test.AssertErr(t, err, name)
+		}
+
+		server.Tamper = func(claims map[string]interface{}) {
+			claims["aud"] = []string{"client-id", "another-client"}
+			claims["azp"] = "client-id"
+		}
+		_, err := login(oidcDriver, person)
+		test.AssertOK(t, err, "authorized party")
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		requests := server.KeySetRequests()
+		server.RotateKey()
+
+		_, err := login(oidcDriver, person)
+		test.AssertErr(t, err, "the key set is not fetched again so soon")
+
+		if server.KeySetRequests() != requests {
+			t.Fatalf("unexpected key set requests: %d", server.KeySetRequests()-requests)
+		}
+
+		now := oidcDriver.Now
+		oidcDriver.Now = func() time.Time {
+			return now().Add(oauth.KeySetRefreshInterval)
+		}
+
+		_, err = login(oidcDriver, person)
+		test.AssertOK(t, err, "rotated key")
+
+		if server.KeySetRequests() != requests+1 {
+			t.Fatalf("unexpected key set requests: %d", server.KeySetRequests()-requests)
+		}
+	})
+
+	t.Run("user info fallback", func(t *testing.T) {
+		defer func() {
+			server.UserInfo = nil
+		}()
+
+		server.UserInfo = map[string]interface{}{"sub": "bar-subject", "email": "bar@local", "email_verified": true}
+		user, err := login(oidcDriver, map[string]interface{}{"sub": "bar-subject"})
+		test.AssertOK(t, err, "user info")
+
+		if user.GetEmail() != "bar@local" {
+			t.Fatalf("unexpected user: %s", user.GetEmail())
+		}
+
+		server.UserInfo = map[string]interface{}{"sub": "another-subject", "email": "bar@local", "email_verified": true}
+		_, err = login(oidcDriver, map[string]interface{}{"sub": "bar-subject"})
+		test.AssertErr(t, err, "user info of another subject")
+	})
+
+	t.Run("without ID token", func(t *testing.T) {
+		config := oauth.NewOIDCConfig(
+			gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+			discovery,
+			"client-id",
+			"client-secret",
+			"http://localhost:8080",
+		)
+		config.Scopes = []string{"email"}
+
+		plainDriver := oauth.New(config, oauth.OIDCHandler, dependency.NewContainer(userService, tokenService, roleService))
+		if plainDriver == nil {
+			t.Fatal("unexpected nil driver")
+		}
+		plainDriver.SetStateStore(oauth.NewMemoryStateStore())
+
+		_, err := login(plainDriver, person)
+		test.AssertErr(t, err, "missing ID token")
+	})
+}
+
+func TestOAuthProviders(t *testing.T) {
+	server := fixtures.NewOAuthServer()
+	defer server.Close()
+
+	base := gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false)
+	login := func(config oauth.Config, person interface{}) (gate.User, error) {
+		providerDriver := oauth.New(config, oauth.StatelessHandler, dependency.NewContainer(userService, tokenService, roleService))
+		if providerDriver == nil {
+			t.Fatal("unexpected nil driver")
+		}
+
+		url, err := providerDriver.LoginURL("state")
+		test.AssertOK(t, err, "valid login URL")
+
+		code, _, err := server.Authorize(url, person)
+		test.AssertOK(t, err, "valid authorization")
+
+		return providerDriver.Login(map[string]string{"code": code, "state": "state"})
+	}
+
+	t.Run("github", func(t *testing.T) {
+		defer func() {
+			server.Emails = nil
+		}()
+
+		config := oauth.NewGitHubConfig(base, "client-id", "client-secret", "http://localhost:8080")
+		config.Endpoint = server.Endpoint()
+		config.UserAPI = server.UserAPI()
+
+		person := map[string]interface{}{"id": 1, "login": "foo", "email": "public@external"}
+		server.Emails = []map[string]interface{}{
+			{"email": "public@external", "primary": false, "verified": true},
+			{"email": "foo@local", "primary": true, "verified": true},
+		}
+
+		user, err := login(config, person)
+		test.AssertOK(t, err, "verified primary email")
+
+		if user.GetEmail() != "foo@local" {
+			t.Fatalf("unexpected user: %s", user.GetEmail())
+		}
+
+		server.Emails = []map[string]interface{}{
+			{"email": "public@external", "primary": false, "verified": true},
+			{"email": "foo@local", "primary": true, "verified": false},
+		}
+
+		_, err = login(config, person)
+		test.AssertErr(t, err, "unverified primary email")
+
+		config.UserAPI = server.URL + "/unknown"
+		_, err = login(config, person)
+		test.AssertErr(t, err, "missing emails API")
+	})
+
+	t.Run("generic", func(t *testing.T) {
+		mapping := oauth.FieldMapping{
+			Subject:       "data.id",
+			Name:          "data.attributes.display_name",
+			Email:         "data.attributes.mail",
+			EmailVerified: "data.attributes.confirmed",
+		}
+		config := oauth.NewGenericConfig(base, server.Endpoint(), server.UserAPI(), []string{"profile"}, mapping, "client-id", "client-secret", "http://localhost:8080")
+
+		person := map[string]interface{}{
+			"data": map[string]interface{}{
+				"id": "bar-id",
+				"attributes": map[string]interface{}{
+					"display_name": "bar",
+					"mail":         "bar@local",
+					"confirmed":    true,
+				},
+			},
+		}
+
+		user, err := login(config, person)
+		test.AssertOK(t, err, "mapped user")
+
+		if user.GetEmail() != "bar@local" {
+			t.Fatalf("unexpected user: %s", user.GetEmail())
+		}
+
+		person["data"].(map[string]interface{})["attributes"].(map[string]interface{})["confirmed"] = false
+		_, err = login(config, person)
+		test.AssertErr(t, err, "unconfirmed email")
+
+		mapping.EmailVerified = ""
+		config = oauth.NewGenericConfig(base, server.Endpoint(), server.UserAPI(), []string{"profile"}, mapping, "client-id", "client-secret", "http://localhost:8080")
+
+		_, err = login(config, person)
+		if errors.Cause(err) != oauth.ErrUnverifiedEmail {
+			t.Fatalf("the provider should not be trusted by default: %v", err)
+		}
+
+		config.EmailPolicy.TrustProvider = true
+		_, err = login(config, person)
+		test.AssertOK(t, err, "trusted provider")
+	})
+}
+
+func TestOAuthIdentity(t *testing.T) {
+	foo, err := userService.FindOneByEmail("foo@local")
+	test.AssertOK(t, err, "existing user")
+
+	nobody, err := userService.FindOneByEmail("nobody@local")
+	test.AssertOK(t, err, "existing user")
+
+	container := dependency.NewContainer(userService, tokenService, roleService)
+	container.SetIdentityService(fixtures.NewMyIdentityService(nil))
+
+	identityDriver := oauth.New(
+		oauth.NewGoogleConfig(
+			gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+			"client-id",
+			"client-secret",
+			"http://localhost:8080",
+		),
+		oauth.StatelessHandler,
+		container,
+	)
+	identityDriver.SetProvider(fixtures.OAuthProvider{
+		Responses: map[string]gate.Account{
+			"code-token": oauth.GoogleUser{
+				Subject:       "foo-subject",
+				Email:         "foo@local",
+				EmailVerified: true,
+			},
+			"code2-token": oauth.GoogleUser{
+				Subject: "foo-subject",
+				Email:   "foo@elsewhere",
+			},
+			"code3-token": oauth.GoogleUser{
+				Subject: "attacker-subject",
+				Email:   "foo@local",
+			},
+			"code4-token": oauth.GoogleUser{
+				Subject:       "newcomer-subject",
+				Email:         "newcomer@local",
+				EmailVerified: true,
+			},
+		},
+	})
+
+	t.Run("login", func(t *testing.T) {
+		_, err := login(t, identityDriver, "code")
+		if err != gate.ErrIdentityNotLinked {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = gate.LinkIdentity(identityDriver, foo, "google", oauth.GoogleUser{Subject: "foo-subject"})
+		test.AssertOK(t, err, "linked identity")
+
+		user, err := login(t, identityDriver, "code")
+		test.AssertOK(t, err, "verified email")
+
+		if user.GetID() != foo.GetID() {
+			t.Fatalf("unexpected user: %s", user.GetID())
+		}
+
+		identities, err := gate.GetUserIdentities(identityDriver, foo)
+		test.AssertOK(t, err, "linked identities")
+
+		if len(identities) != 1 || identities[0].Provider != "google" || identities[0].Subject != "foo-subject" {
+			t.Fatalf("unexpected identities: %v", identities)
+		}
+
+		user, err = login(t, identityDriver, "code2")
+		test.AssertOK(t, err, "linked identity")
+
+		if user.GetID() != foo.GetID() {
+			t.Fatalf("unexpected user: %s", user.GetID())
+		}
+
+		_, err = login(t, identityDriver, "code3")
+		if errors.Cause(err) != oauth.ErrUnverifiedEmail {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		user, err = login(t, identityDriver, "code4")
+		test.AssertOK(t, err, "provisioned user")
+
+		identities, err = gate.GetUserIdentities(identityDriver, user)
+		test.AssertOK(t, err, "linked identities")
+
+		if len(identities) != 1 || identities[0].Subject != "newcomer-subject" {
+			t.Fatalf("unexpected identities: %v", identities)
+		}
+	})
+
+	t.Run("link", func(t *testing.T) {
+		_, err := gate.LinkIdentity(identityDriver, nobody, "google", oauth.GoogleUser{Subject: "foo-subject"})
+		if err != gate.ErrIdentityLinked {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		identity, err := gate.LinkIdentity(identityDriver, foo, "google", oauth.GoogleUser{Subject: "foo-subject"})
+		test.AssertOK(t, err, "already linked identity")
+
+		if identity.UserID != foo.GetID() {
+			t.Fatalf("unexpected identity: %v", identity)
+		}
+
+		_, err = gate.LinkIdentity(identityDriver, nobody, "google", oauth.GoogleUser{})
+		test.AssertErr(t, err, "missing subject")
+
+		_, err = gate.LinkIdentity(identityDriver, nobody, fixtures.ProviderTriggeringDatabaseError, oauth.GoogleUser{Subject: "subject"})
+		test.AssertErr(t, err, "database error")
+
+		_, err = gate.LinkIdentity(driver, nobody, "google", oauth.GoogleUser{Subject: "subject"})
+		test.AssertErr(t, err, "missing identity service")
+	})
+
+	t.Run("unlink", func(t *testing.T) {
+		err := gate.UnlinkIdentity(identityDriver, nobody, "google", "foo-subject")
+		if err != gate.ErrIdentityNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = gate.UnlinkIdentity(identityDriver, foo, "google", "foo-subject")
+		test.AssertOK(t, err, "linked identity")
+
+		err = gate.UnlinkIdentity(identityDriver, foo, "google", "foo-subject")
+		if err != gate.ErrIdentityNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = login(t, identityDriver, "code2")
+		test.AssertErr(t, err, "unlinked identity")
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		_, err := gate.LoginAccount(identityDriver, fixtures.ProviderTriggeringDatabaseError, oauth.GoogleUser{Subject: "subject"}, nil, gate.ProvisioningPolicy{})
+		test.AssertErr(t, err, "database error")
+	})
+}
+
+func TestOAuthProvisioning(t *testing.T) {
+	config := oauth.NewGoogleConfig(gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false), "client-id", "client-secret", "http://localhost:8080")
+	config.Account = &oauth.OIDCUser{}
+	config.Provisioning = gate.ProvisioningPolicy{
+		DefaultRoles: []string{"member"},
+		GroupRoles: map[string][]string{
+			"admins":     {"admin", "member"},
+			"developers": {"developer"},
+		},
+	}
+
+	provisioningDriver := oauth.New(config, oauth.StatelessHandler, dependency.NewContainer(userService, tokenService, roleService))
+	provisioningDriver.SetProvider(fixtures.OAuthProvider{
+		Responses: map[string]gate.Account{
+			"code-token": oauth.OIDCUser{
+				Subject:       "grouped-subject",
+				Email:         "grouped@local",
+				EmailVerified: true,
+				Groups:        []string{"admins", "developers", "unknown"},
+			},
+		},
+	})
+
+	user, err := login(t, provisioningDriver, "code")
+	test.AssertOK(t, err, "new user")
+
+	roles := user.GetRoles()
+	if strings.Join(roles, ",") != "member,admin,developer" {
+		t.Fatalf("unexpected roles: %v", roles)
+	}
+
+	t.Run("verified email", func(t *testing.T) {
+		policy := gate.ProvisioningPolicy{Domains: []string{"local"}}
+		verify := func(verified string) func(gate.Account) (string, error) {
+			return func(gate.Account) (string, error) {
+				return verified, nil
+			}
+		}
+
+		_, err := gate.LoginAccount(provisioningDriver, "fake", oauth.GoogleUser{Email: "public@local"}, verify("verified@external"), policy)
+		if errors.Cause(err) != gate.ErrProvisioningDomainNotAllowed {
+			t.Fatalf("the domain of the verified email should be checked: %v", err)
+		}
+
+		user, err := gate.LoginAccount(provisioningDriver, "fake", oauth.GoogleUser{}, verify("verified@local"), policy)
+		test.AssertOK(t, err, "allowed verified email")
+
+		if user.GetEmail() != "verified@local" {
+			t.Fatalf("the user should be created with the verified email: %s", user.GetEmail())
+		}
+
+		if _, ok := userService.LastAccount.(oauth.GoogleUser); !ok {
+			t.Fatalf("the user service should receive the account of the provider: %T", userService.LastAccount)
+		}
+	})
+}
+
+func TestOAuthProviderTokens(t *testing.T) {
+	server := fixtures.NewOAuthServer()
+	defer server.Close()
+
+	// Tokens expiring in a second are refreshed on the first use
+	server.ExpiresIn = 1
+
+	providerTokens := fixtures.NewMyProviderTokenService(nil)
+	tokenDriver := oauth.New(
+		oauth.Config{
+			Config:       gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+			Name:         "fake",
+			ClientID:     "client-id",
+			ClientSecret: "client-secret",
+			Endpoint:     server.Endpoint(),
+			RedirectURI:  "http://localhost:8080",
+			UserAPI:      server.UserAPI(),
+			Account:      &oauth.GoogleUser{},
+		},
+		oauth.TokenHandler,
+		dependency.NewContainer(userService, tokenService, roleService),
+	)
+	if tokenDriver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	person := map[string]interface{}{"sub": "foo-subject", "name": "foo", "email": "foo@local", "email_verified": true}
+	login := func(driver *oauth.Driver) (gate.User, error) {
+		url, err := driver.LoginURLWithSession("session")
+		test.AssertOK(t, err, "valid login URL")
+
+		code, state, err := server.Authorize(url, person)
+		test.AssertOK(t, err, "valid authorization")
+
+		return driver.Login(map[string]string{"code": code, "state": state, "session": "session"})
+	}
+
+	_, err := login(tokenDriver)
+	test.AssertErr(t, err, "missing provider token service")
+
+	tokenDriver.SetProviderTokenService(providerTokens)
+	user, err := login(tokenDriver)
+	test.AssertOK(t, err, "valid login")
+
+	stored, err := tokenDriver.GetProviderToken(user)
+	test.AssertOK(t, err, "stored token")
+
+	if stored.Provider != "fake" || stored.Token.AccessToken == "" || stored.Token.RefreshToken == "" {
+		t.Fatalf("unexpected token: %v", stored)
+	}
+
+	t.Run("refreshing client", func(t *testing.T) {
+		client, err := tokenDriver.UserClient(context.Background(), user)
+		test.AssertOK(t, err, "valid client")
+
+		response, err := client.Get(server.UserAPI())
+		test.AssertOK(t, err, "valid request")
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status: %d", response.StatusCode)
+		}
+
+		refreshed, err := tokenDriver.GetProviderToken(user)
+		test.AssertOK(t, err, "stored token")
+
+		if refreshed.Token.AccessToken == stored.Token.AccessToken || refreshed.Token.RefreshToken != stored.Token.RefreshToken {
+			t.Fatalf("unexpected refreshed token: %v", refreshed.Token)
+		}
+	})
+
+	t.Run("without token", func(t *testing.T) {
+		nobody, err := userService.FindOneByEmail("nobody@local")
+		test.AssertOK(t, err, "existing user")
+
+		_, err = tokenDriver.UserClient(context.Background(), nobody)
+		if err != oauth.ErrProviderTokenNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("without token refresh", func(t *testing.T) {
+		provider := tokenDriver.GetProvider()
+		defer tokenDriver.SetProvider(provider)
+
+		tokenDriver.SetProvider(fixtures.OAuthProvider{})
+		_, err := tokenDriver.UserClient(context.Background(), user)
+		test.AssertErr(t, err, "unsupported token refresh")
+	})
+
+	t.Run("OpenID Connect", func(t *testing.T) {
+		discovery, err := oauth.Discover(context.Background(), server.Issuer())
+		test.AssertOK(t, err, "valid discovery document")
+
+		oidcDriver := oauth.New(
+			oauth.NewOIDCConfig(gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false), discovery, "client-id", "client-secret", "http://localhost:8080"),
+			oauth.OIDCTokenHandler,
+			dependency.NewContainer(userService, tokenService, roleService),
+		)
+		oidcDriver.SetProviderTokenService(providerTokens)
+		oidcDriver.SetStateStore(oauth.NewMemoryStateStore())
+
+		user, err := login(oidcDriver)
+		test.AssertOK(t, err, "valid login")
+
+		stored, err := oidcDriver.GetProviderToken(user)
+		test.AssertOK(t, err, "stored token")
+
+		if stored.Provider != server.Issuer() {
+			t.Fatalf("unexpected token: %v", stored)
+		}
+	})
+}
@@ -1,14 +1,24 @@
 package oauth_test
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/hiendv/gate"
 	"github.com/hiendv/gate/dependency"
 	"github.com/hiendv/gate/internal/test"
 	"github.com/hiendv/gate/internal/test/fixtures"
 	"github.com/hiendv/gate/oauth"
+	"github.com/pkg/errors"
 )
 
 func TestOAuthInvalidConfig(t *testing.T) {
@@ -86,7 +96,7 @@ func TestOAuthLoginFunc(t *testing.T) {
 	}
 
 	t.Run("valid", func(t *testing.T) {
-		_, err := driver.Login(map[string]string{"code": "code", "state": "state"})
+		_, err := login(t, driver, "code")
 		test.AssertOK(t, err, "valid credentials")
 	})
 
@@ -132,7 +142,7 @@ func TestOAuthUserService(t *testing.T) {
 		})
 
 		t.Run("login", func(t *testing.T) {
-			_, err := driver.Login(map[string]string{"code": "code", "state": "state"})
+			_, err := login(t, driver, "code")
 			test.AssertErr(t, err, "missing user service")
 		})
 	})
@@ -283,3 +293,458 @@ func TestOAuthRoleService(t *testing.T) {
 		})
 	})
 }
+
+func TestOAuthPKCEChallenge(t *testing.T) {
+	challenge := oauth.S256Challenge("dBjftJeZ4CVP-mJ92IgRe2hH6ok5T3WJ--Zk6IpmA2s")
+	if challenge != "PzI7nDgwWmA1AaY8dde2YgLp-e0-WALJqL_tfz1Lo7M" {
+		t.Fatalf("unexpected challenge: %s", challenge)
+	}
+
+	verifier, err := oauth.GenerateVerifier()
+	test.AssertOK(t, err, "valid generator")
+
+	if len(verifier) != 43 {
+		t.Fatalf("unexpected verifier: %s", verifier)
+	}
+}
+
+func TestOAuthVerifierStore(t *testing.T) {
+	store := oauth.NewMemoryVerifierStore()
+
+	err := store.Store("state", "verifier")
+	test.AssertOK(t, err, "valid verifier")
+
+	verifier, err := store.Take("state")
+	test.AssertOK(t, err, "existing verifier")
+
+	if verifier != "verifier" {
+		t.Fatalf("unexpected verifier: %s", verifier)
+	}
+
+	_, err = store.Take("state")
+	test.AssertErr(t, err, "consumed verifier")
+}
+
+func TestOAuthPKCEConfig(t *testing.T) {
+	config := oauth.NewGoogleConfig(
+		gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+		"client-id",
+		"",
+		"http://localhost:8080",
+	)
+	config.PKCE = true
+
+	driver := oauth.New(
+		config,
+		oauth.StatelessHandler,
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	_, err := driver.LoginURL("")
+	test.AssertErr(t, err, "missing state")
+
+	url, err := driver.LoginURL("state")
+	test.AssertOK(t, err, "valid state")
+
+	if !strings.Contains(url, "code_challenge_method=S256") {
+		t.Fatalf("unexpected login URL: %s", url)
+	}
+
+	driver.GenerateVerifier = func() (string, error) {
+		return "", errors.New("no entropy")
+	}
+	_, err = driver.LoginURL("state")
+	test.AssertErr(t, err, "failing generator")
+
+	driver.SetVerifierStore(nil)
+	_, err = driver.LoginURL("state")
+	test.AssertErr(t, err, "missing verifier store")
+
+	_, err = driver.Login(map[string]string{"code": "code", "state": "state"})
+	test.AssertErr(t, err, "missing verifier store")
+}
+
+func TestOAuthStateStore(t *testing.T) {
+	store := oauth.NewMemoryStateStore()
+
+	err := store.Save(oauth.State{Value: "state", Session: "session", ExpiredAt: time.Now().Add(time.Minute)})
+	test.AssertOK(t, err, "valid state")
+
+	state, err := store.Take("state")
+	test.AssertOK(t, err, "existing state")
+
+	if state.Session != "session" {
+		t.Fatalf("unexpected state: %v", state)
+	}
+
+	_, err = store.Take("state")
+	test.AssertErr(t, err, "consumed state")
+}
+
+func TestOAuthCookieStateStore(t *testing.T) {
+	key := []byte("cookie-secret")
+	recorder := httptest.NewRecorder()
+
+	err := oauth.NewCookieStateStore(key, nil, nil).Save(oauth.State{Value: "state"})
+	test.AssertErr(t, err, "missing response writer")
+
+	err = oauth.NewCookieStateStore(nil, recorder, nil).Save(oauth.State{Value: "state"})
+	test.AssertErr(t, err, "missing key")
+
+	err = oauth.NewCookieStateStore(key, recorder, nil).Save(oauth.State{Value: "state", Session: "session", ExpiredAt: time.Now().Add(time.Minute)})
+	test.AssertOK(t, err, "valid state")
+
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || !cookies[0].Secure || cookies[0].Name != oauth.DefaultStateCookieName {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+
+	newRequest := func(cookie *http.Cookie) *http.Request {
+		request := httptest.NewRequest(http.MethodGet, "/callback", nil)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		return request
+	}
+
+	_, err = oauth.NewCookieStateStore(key, nil, nil).Take("state")
+	test.AssertErr(t, err, "missing request")
+
+	_, err = oauth.NewCookieStateStore(key, nil, newRequest(nil)).Take("state")
+	test.AssertErr(t, err, "missing cookie")
+
+	_, err = oauth.NewCookieStateStore(key, nil, newRequest(cookies[0])).Take("another-state")
+	test.AssertErr(t, err, "mismatched state")
+
+	_, err = oauth.NewCookieStateStore([]byte("another-secret"), nil, newRequest(cookies[0])).Take("state")
+	test.AssertErr(t, err, "another key")
+
+	tampered := *cookies[0]
+	tampered.Value = "e30." + strings.SplitN(tampered.Value, ".", 2)[1]
+	_, err = oauth.NewCookieStateStore(key, nil, newRequest(&tampered)).Take("state")
+	test.AssertErr(t, err, "tampered cookie")
+
+	recorder = httptest.NewRecorder()
+	state, err := oauth.NewCookieStateStore(key, recorder, newRequest(cookies[0])).Take("state")
+	test.AssertOK(t, err, "valid cookie")
+
+	if state.Session != "session" || state.ExpiredAt.Before(time.Now()) {
+		t.Fatalf("unexpected state: %v", state)
+	}
+
+	cleared := recorder.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].MaxAge >= 0 {
+		t.Fatalf("the cookie should be cleared: %v", cleared)
+	}
+}
+
+func TestOAuthStateConfig(t *testing.T) {
+	driver := oauth.New(
+		oauth.NewGoogleConfig(
+			gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+			"client-id",
+			"client-secret",
+			"http://localhost:8080",
+		),
+		oauth.HandlerStub,
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	if driver.GetStateStore() == nil {
+		t.Fatal("unexpected nil state store")
+	}
+
+	_, err := driver.LoginURLWithSession("")
+	test.AssertErr(t, err, "missing session")
+
+	_, err = driver.LoginURL("")
+	test.AssertErr(t, err, "missing state")
+
+	_, err = driver.Login(map[string]string{"code": "code", "state": "state"})
+	test.AssertErr(t, err, "unknown state")
+
+	driver.SetStateStore(nil)
+	_, err = driver.LoginURLWithSession("session")
+	test.AssertErr(t, err, "missing state store")
+
+	_, err = driver.LoginURL("state")
+	test.AssertErr(t, err, "missing state store")
+
+	driver.SetStateStore(oauth.NewMemoryStateStore())
+
+	driver.GenerateState = func() (string, error) {
+		return "", errors.New("no entropy")
+	}
+
+	_, err = driver.LoginURLWithSession("session")
+	test.AssertErr(t, err, "failing generator")
+
+	_, err = driver.WithStateStore(oauth.NewCookieStateStore([]byte("cookie-secret"), nil, nil)).LoginURLWithSession("session")
+	test.AssertErr(t, err, "failing generator")
+
+	driver.GenerateState = oauth.GenerateState
+	_, err = driver.WithStateStore(oauth.NewCookieStateStore([]byte("cookie-secret"), nil, nil)).LoginURLWithSession("session")
+	test.AssertErr(t, err, "failing store")
+}
+
+func TestOAuthVerifyIDToken(t *testing.T) {
+	driver := oauth.New(
+		oauth.NewGoogleConfig(
+			gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+			"client-id",
+			"client-secret",
+			"http://localhost:8080",
+		),
+		oauth.OIDCHandler,
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "subject"}).SignedString([]byte("client-secret"))
+	test.AssertOK(t, err, "valid token")
+
+	_, err = driver.VerifyIDToken(context.Background(), token, "")
+	test.AssertErr(t, err, "not an OpenID provider")
+
+	config := oauth.NewOIDCConfig(
+		gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+		oauth.Discovery{Issuer: "https://issuer.local"},
+		"client-id",
+		"client-secret",
+		"http://localhost:8080",
+	)
+	driver = oauth.New(config, oauth.OIDCHandler, dependency.NewContainer(nil, nil, nil))
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	driver.SetStateStore(nil)
+	_, err = driver.Login(map[string]string{"code": "code", "state": "state"})
+	test.AssertErr(t, err, "missing state store")
+
+	_, err = driver.VerifyIDToken(context.Background(), token, "")
+	test.AssertErr(t, err, "symmetric signature")
+
+	token, err = jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "subject"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	test.AssertOK(t, err, "valid token")
+
+	_, err = driver.VerifyIDToken(context.Background(), token, "")
+	test.AssertErr(t, err, "unsigned token")
+
+	user := oauth.OIDCUser{PreferredUsername: "foo", Email: "foo@local"}
+	if user.GetName() != "foo" || user.IsEmailVerified() {
+		t.Fatalf("unexpected user: %v", user)
+	}
+}
+
+func TestOAuthPresets(t *testing.T) {
+	base := gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false)
+
+	config := oauth.NewGitHubConfig(base, "client-id", "client-secret", "http://localhost:8080")
+	if config.Endpoint.AuthURL != "https://github.com/login/oauth/authorize" || config.UserAPI != "https://api.github.com/user" {
+		t.Fatalf("unexpected GitHub config: %v", config)
+	}
+
+	config = oauth.NewGitLabConfig(base, "", "client-id", "client-secret", "http://localhost:8080")
+	if config.Endpoint.TokenURL != "https://gitlab.com/oauth/token" || config.UserAPI != "https://gitlab.com/api/v4/user" {
+		t.Fatalf("unexpected GitLab config: %v", config)
+	}
+
+	config = oauth.NewGitLabConfig(base, "https://git.local/", "client-id", "client-secret", "http://localhost:8080")
+	if config.Endpoint.AuthURL != "https://git.local/oauth/authorize" || config.UserAPI != "https://git.local/api/v4/user" {
+		t.Fatalf("unexpected GitLab config: %v", config)
+	}
+
+	config = oauth.NewMicrosoftConfig(base, "tenant-id", "client-id", "client-secret", "http://localhost:8080")
+	if config.Issuer != "https://login.microsoftonline.com/tenant-id/v2.0" || !strings.Contains(config.Endpoint.AuthURL, "/tenant-id/") {
+		t.Fatalf("unexpected Microsoft config: %v", config)
+	}
+
+	if config.EmailPolicy.TrustProvider {
+		t.Fatalf("unexpected Microsoft email policy: %v", config.EmailPolicy)
+	}
+
+	config = oauth.NewMicrosoftConfig(base, "tenant-id", "client-id", "client-secret", "http://localhost:8080", "local")
+	if !config.EmailPolicy.TrustProvider || strings.Join(config.EmailPolicy.Domains, ",") != "local" {
+		t.Fatalf("unexpected Microsoft email policy: %v", config.EmailPolicy)
+	}
+
+	config = oauth.NewAppleConfig(base, "client-id", "client-secret", "http://localhost:8080")
+	if config.Issuer != oauth.AppleIssuer || config.JWKSURI != "https://appleid.apple.com/auth/keys" {
+		t.Fatalf("unexpected Apple config: %v", config)
+	}
+
+	driver := oauth.New(config, oauth.OIDCHandler, dependency.NewContainer(nil, nil, nil))
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	url, err := driver.LoginURLWithSession("session")
+	test.AssertOK(t, err, "valid login URL")
+
+	if !strings.Contains(url, "response_mode=form_post") || !strings.Contains(url, "nonce=") {
+		t.Fatalf("unexpected login URL: %s", url)
+	}
+}
+
+func TestOAuthAccounts(t *testing.T) {
+	var gitlab oauth.GitLabUser
+	err := json.Unmarshal([]byte(`{"id":1,"username":"foo","email":"foo@local","confirmed_at":null}`), &gitlab)
+	test.AssertOK(t, err, "valid GitLab user")
+
+	if gitlab.GetName() != "foo" || gitlab.IsEmailVerified() {
+		t.Fatalf("unexpected GitLab user: %v", gitlab)
+	}
+
+	gitlab.ConfirmedAt = "2018-01-01T00:00:00Z"
+	if gitlab.GetEmail() != "foo@local" || !gitlab.IsEmailVerified() {
+		t.Fatalf("unexpected GitLab user: %v", gitlab)
+	}
+
+	var apple oauth.AppleUser
+	err = json.Unmarshal([]byte(`{"sub":"subject","email":"foo@privaterelay.appleid.com","email_verified":"true","is_private_email":true}`), &apple)
+	test.AssertOK(t, err, "valid Apple user")
+
+	if apple.GetEmail() != "foo@privaterelay.appleid.com" || !apple.IsEmailVerified() || !apple.IsPrivateEmail || apple.Subject != "subject" {
+		t.Fatalf("unexpected Apple user: %v", apple)
+	}
+
+	err = json.Unmarshal([]byte(`{"sub":"subject","email":"foo@local","email_verified":false}`), &apple)
+	test.AssertOK(t, err, "valid Apple user")
+
+	if apple.IsEmailVerified() {
+		t.Fatalf("unexpected Apple user: %v", apple)
+	}
+
+	microsoft := oauth.MicrosoftUser{PreferredUsername: "foo@local", Email: "foo@local"}
+	if microsoft.GetName() != "foo@local" || microsoft.GetEmail() != "foo@local" {
+		t.Fatalf("unexpected Microsoft user: %v", microsoft)
+	}
+
+	err = json.Unmarshal([]byte(`{"sub":"subject","email":"foo@local","xms_edov":"true"}`), &microsoft)
+	test.AssertOK(t, err, "valid Microsoft user")
+
+	if !microsoft.IsEmailVerified() {
+		t.Fatalf("unexpected Microsoft user: %v", microsoft)
+	}
+
+	mapped := oauth.MappedUser{Mapping: oauth.FieldMapping{
+		Subject:       "id",
+		Name:          "profile.name",
+		Email:         "profile.emails.primary",
+		EmailVerified: "profile.verified",
+		Groups:        "profile.groups",
+	}}
+	err = json.Unmarshal([]byte(`{"id":12345678901,"profile":{"name":"foo","emails":{"primary":"foo@local"},"verified":true,"groups":["admins",7]}}`), &mapped)
+	test.AssertOK(t, err, "valid mapped user")
+
+	if mapped.Subject != "12345678901" || mapped.GetName() != "foo" || mapped.GetEmail() != "foo@local" || !mapped.IsEmailVerified() || strings.Join(mapped.GetGroups(), ",") != "admins,7" {
+		t.Fatalf("unexpected mapped user: %v", mapped)
+	}
+
+	err = json.Unmarshal([]byte(`{"id":"id","profile":"private"}`), &mapped)
+	test.AssertOK(t, err, "valid mapped user")
+
+	if mapped.GetName() != "" || mapped.GetEmail() != "" || mapped.IsEmailVerified() {
+		t.Fatalf("unexpected mapped user: %v", mapped)
+	}
+
+	err = json.Unmarshal([]byte(`[]`), &mapped)
+	test.AssertErr(t, err, "invalid user info")
+}
+
+func TestOAuthAppleClientSecret(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertOK(t, err, "valid key")
+
+	secret, err := oauth.AppleClientSecret("team-id", "client-id", "key-id", key, time.Hour)
+	test.AssertOK(t, err, "valid secret")
+
+	claims := &jwt.StandardClaims{}
+	token, err := jwt.ParseWithClaims(secret, claims, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	test.AssertOK(t, err, "valid signature")
+
+	if token.Header["kid"] != "key-id" || claims.Issuer != "team-id" || claims.Subject != "client-id" || claims.Audience != oauth.AppleIssuer {
+		t.Fatalf("unexpected secret: %v %v", token.Header, claims)
+	}
+
+	_, err = oauth.AppleClientSecret("team-id", "client-id", "key-id", nil, time.Hour)
+	test.AssertErr(t, err, "missing key")
+}
+
+func TestOAuthEmailPolicy(t *testing.T) {
+	verified := oauth.GoogleUser{Email: "foo@Local", EmailVerified: true}
+	unverified := oauth.GoogleUser{Email: "foo@local"}
+	unflagged := oauth.FacebookUser{Email: "foo@local"}
+
+	var policy oauth.EmailPolicy
+	email, err := policy.Verify(verified)
+	test.AssertOK(t, err, "verified email")
+
+	if email != "foo@Local" {
+		t.Fatalf("unexpected email: %s", email)
+	}
+
+	_, err = policy.Verify(unverified)
+	if err != oauth.ErrUnverifiedEmail {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = policy.Verify(unflagged)
+	test.AssertOK(t, err, "account without the verified flag")
+
+	policy.RequireVerifiable = true
+	_, err = policy.Verify(unflagged)
+	if err != oauth.ErrUnverifiedEmail {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = policy.Verify(verified)
+	test.AssertOK(t, err, "verified email")
+
+	_, err = policy.Verify(oauth.GoogleUser{EmailVerified: true})
+	test.AssertErr(t, err, "missing email")
+
+	_, err = policy.Verify(nil)
+	test.AssertErr(t, err, "missing account")
+
+	policy.TrustProvider = true
+	_, err = policy.Verify(unverified)
+	test.AssertOK(t, err, "trusted provider")
+
+	_, err = policy.Verify(unflagged)
+	test.AssertOK(t, err, "trusted provider")
+
+	policy.Domains = []string{"local"}
+	_, err = policy.Verify(verified)
+	test.AssertOK(t, err, "allowed domain")
+
+	_, err = policy.Verify(oauth.FacebookUser{Email: "foo@external"})
+	if err != oauth.ErrEmailDomainNotAllowed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = policy.Verify(oauth.FacebookUser{Email: "foo@evil.local"})
+	if err != oauth.ErrEmailDomainNotAllowed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	policy.TrustProvider = false
+	_, err = policy.Verify(unverified)
+	if err != oauth.ErrUnverifiedEmail {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
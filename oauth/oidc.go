@@ -0,0 +1,358 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
+)
+
+// Bounds of the requests to OpenID providers
+const (
+	// DefaultFetchTimeout is the timeout of the discovery and key set requests
+	DefaultFetchTimeout = time.Second * 10
+	// KeySetRefreshInterval is the minimum delay between two fetches of a key set, so tokens with unknown keys cannot flood the provider
+	KeySetRefreshInterval = time.Minute
+)
+
+// fetchClient is the HTTP client of the discovery and key set requests
+var fetchClient = &http.Client{Timeout: DefaultFetchTimeout}
+
+// Discovery is the OpenID provider metadata
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Discover fetches the metadata of an OpenID provider from its /.well-known/openid-configuration document
+func Discover(ctx context.Context, issuer string) (discovery Discovery, err error) {
+	err = fetchJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		err = errors.Wrap(err, "could not fetch the discovery document")
+		return
+	}
+
+	if discovery.Issuer != issuer {
+		err = errors.Errorf("unexpected issuer: %s", discovery.Issuer)
+		return
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		err = errors.New("incomplete discovery document")
+		return
+	}
+	return
+}
+
+// VerifyIDToken verifies the signature and the claims of an ID token and returns its claims.
+// The nonce must be the one of the state verified by Login and the access token is checked against at_hash when the claim is present
+func (auth Driver) VerifyIDToken(ctx context.Context, idToken, accessToken string) (claims map[string]interface{}, err error) {
+	if auth.config.Issuer == "" {
+		err = errors.New("the driver is not configured for OpenID Connect")
+		return
+	}
+
+	mapClaims := jwt.MapClaims{}
+	token, err := new(jwt.Parser).ParseWithClaims(idToken, mapClaims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, errors.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		return auth.keys.find(ctx, kid, auth.Now())
+	})
+	if err != nil {
+		err = errors.Wrap(err, "could not verify ID token")
+		return
+	}
+
+	if issuer, _ := mapClaims["iss"].(string); issuer != auth.config.Issuer {
+		err = errors.Errorf("unexpected issuer: %s", issuer)
+		return
+	}
+
+	audiences := audienceOf(mapClaims)
+	if !contains(audiences, auth.config.ClientID) {
+		err = errors.New("the ID token is not intended for the client")
+		return
+	}
+
+	if party, ok := mapClaims["azp"].(string); (len(audiences) > 1 || ok) && party != auth.config.ClientID {
+		err = errors.New("unexpected authorized party")
+		return
+	}
+
+	if !mapClaims.VerifyExpiresAt(auth.Now().Unix(), true) {
+		err = errors.New("the ID token is expired")
+		return
+	}
+
+	if sub, _ := mapClaims["sub"].(string); sub == "" {
+		err = errors.New("missing subject")
+		return
+	}
+
+	nonce, _ := mapClaims["nonce"].(string)
+	if auth.flow.Nonce == "" || subtle.ConstantTimeCompare([]byte(nonce), []byte(auth.flow.Nonce)) != 1 {
+		err = errors.New("invalid nonce")
+		return
+	}
+
+	if atHash, ok := mapClaims["at_hash"].(string); ok {
+		if !verifyAccessTokenHash(token.Method, accessToken, atHash) {
+			err = errors.New("invalid access token hash")
+			return
+		}
+	}
+
+	claims = mapClaims
+	return
+}
+
+func audienceOf(claims jwt.MapClaims) (audiences []string) {
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, value := range aud {
+			if str, ok := value.(string); ok {
+				audiences = append(audiences, str)
+			}
+		}
+	}
+	return
+}
+
+func verifyAccessTokenHash(method jwt.SigningMethod, accessToken, atHash string) bool {
+	var hash crypto.Hash
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA:
+		hash = m.Hash
+	case *jwt.SigningMethodRSAPSS:
+		hash = m.Hash
+	case *jwt.SigningMethodECDSA:
+		hash = m.Hash
+	default:
+		return false
+	}
+
+	if !hash.Available() {
+		return false
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(accessToken))
+	sum := hasher.Sum(nil)
+	expected := base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(atHash)) == 1
+}
+
+// keySet is the cached JSON Web Key Set of an OpenID provider
+type keySet struct {
+	uri       string
+	records   map[string]interface{}
+	fetchedAt *time.Time
+	*sync.Mutex
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// find returns the key with the given ID. The set is fetched again when the key is unknown, e.g. after a key rotation,
+// at most once per KeySetRefreshInterval
+func (set keySet) find(ctx context.Context, kid string, now time.Time) (key interface{}, err error) {
+	set.Lock()
+	defer set.Unlock()
+
+	key, ok := set.lookup(kid)
+	if ok {
+		return
+	}
+
+	if set.uri == "" {
+		err = errors.New("missing JWKS URI")
+		return
+	}
+
+	if !set.fetchedAt.IsZero() && now.Before(set.fetchedAt.Add(KeySetRefreshInterval)) {
+		err = errors.Errorf("unknown key: %s", kid)
+		return
+	}
+
+	*set.fetchedAt = now
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = fetchJSON(ctx, set.uri, &document)
+	if err != nil {
+		err = errors.Wrap(err, "could not fetch the key set")
+		return
+	}
+
+	for id := range set.records {
+		delete(set.records, id)
+	}
+
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		parsed, e := parseJSONWebKey(jwk)
+		if e != nil {
+			continue
+		}
+
+		set.records[jwk.Kid] = parsed
+	}
+
+	key, ok = set.lookup(kid)
+	if !ok {
+		err = errors.Errorf("unknown key: %s", kid)
+		return
+	}
+	return
+}
+
+func (set keySet) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(set.records) == 1 {
+		for _, key := range set.records {
+			return key, true
+		}
+	}
+
+	key, ok := set.records[kid]
+	return key, ok
+}
+
+func parseJSONWebKey(jwk jsonWebKey) (key interface{}, err error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, e := decodeBigInt(jwk.N), decodeBigInt(jwk.E)
+		if n == nil || e == nil || !e.IsInt64() {
+			err = errors.New("invalid RSA key")
+			return
+		}
+
+		key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			err = errors.New("unsupported curve")
+			return
+		}
+
+		x, y := decodeBigInt(jwk.X), decodeBigInt(jwk.Y)
+		if x == nil || y == nil || !curve.IsOnCurve(x, y) {
+			err = errors.New("invalid EC key")
+			return
+		}
+
+		key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	default:
+		err = errors.New("unsupported key type")
+	}
+	return
+}
+
+func decodeBigInt(str string) *big.Int {
+	data, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+
+	return new(big.Int).SetBytes(data)
+}
+
+// newAccount returns a copy of the configured account so concurrent logins do not share it
+func newAccount(user gate.Account) gate.Account {
+	if user == nil {
+		return &OIDCUser{}
+	}
+
+	value := reflect.ValueOf(user)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return user
+	}
+
+	account := reflect.New(value.Elem().Type())
+	account.Elem().Set(value.Elem())
+	person, ok := account.Interface().(gate.Account)
+	if !ok {
+		return user
+	}
+
+	return person
+}
+
+func decodeClaims(claims map[string]interface{}, account gate.Account) error {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, account)
+}
+
+func fetchJSON(ctx context.Context, url string, v interface{}) error {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := fetchClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status: %d", response.StatusCode)
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,117 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// DefaultVerifierExpiration is the lifetime of code verifiers kept by MemoryVerifierStore
+const DefaultVerifierExpiration = time.Minute * 10
+
+// ErrVerifierNotFound is thrown when a code verifier is unknown, expired or has already been used
+var ErrVerifierNotFound = errors.New("code verifier not found")
+
+// VerifierStore is the contract which keeps PKCE code verifiers by state. Take must remove the verifier so it can only be used once
+type VerifierStore interface {
+	Store(state, verifier string) error
+	Take(state string) (string, error)
+}
+
+type verifier struct {
+	value     string
+	expiredAt time.Time
+}
+
+// MemoryVerifierStore is the in-memory VerifierStore
+type MemoryVerifierStore struct {
+	records   map[string]verifier
+	threshold *int
+	*sync.Mutex
+}
+
+// NewMemoryVerifierStore is the constructor for MemoryVerifierStore
+func NewMemoryVerifierStore() MemoryVerifierStore {
+	threshold := minPruneThreshold
+	return MemoryVerifierStore{
+		records:   map[string]verifier{},
+		threshold: &threshold,
+		Mutex:     &sync.Mutex{},
+	}
+}
+
+// Store keeps the verifier of the given state until it is taken or expired
+func (store MemoryVerifierStore) Store(state, value string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	now := time.Now()
+	if len(store.records) >= *store.threshold {
+		store.prune(now)
+	}
+
+	store.records[state] = verifier{value, now.Add(DefaultVerifierExpiration)}
+	return nil
+}
+
+// prune drops the expired verifiers. The next sweep happens when the remaining ones double, so storing stays constant on average
+func (store MemoryVerifierStore) prune(now time.Time) {
+	for key, record := range store.records {
+		if now.After(record.expiredAt) {
+			delete(store.records, key)
+		}
+	}
+
+	*store.threshold = len(store.records) * 2
+	if *store.threshold < minPruneThreshold {
+		*store.threshold = minPruneThreshold
+	}
+}
+
+// Take removes and returns the verifier of the given state
+func (store MemoryVerifierStore) Take(state string) (string, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	record, ok := store.records[state]
+	if !ok {
+		return "", ErrVerifierNotFound
+	}
+	delete(store.records, state)
+
+	if time.Now().After(record.expiredAt) {
+		return "", ErrVerifierNotFound
+	}
+
+	return record.value, nil
+}
+
+// GenerateVerifier generates a random code verifier (RFC 7636 section 4.1)
+func GenerateVerifier() (string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate code verifier")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// S256Challenge derives the S256 code challenge from a code verifier
+func S256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// challengeOptions returns the auth URL options carrying the code challenge
+func challengeOptions(verifier string) []oauth2.AuthCodeOption {
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", S256Challenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+}
@@ -0,0 +1,494 @@
+package oauth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/internal"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+	"golang.org/x/oauth2/microsoft"
+)
+
+// AppleIssuer is the issuer of Sign in with Apple ID tokens
+const AppleIssuer = "https://appleid.apple.com"
+
+// AccountFetcher is the contract for accounts which need further requests once the user info is decoded, e.g. GitHub emails
+type AccountFetcher interface {
+	Fetch(client internal.HTTPClient, userAPI string) error
+}
+
+// NewGitHubConfig is the constructor for OAuth configuration using GitHub API
+func NewGitHubConfig(base gate.Config, id, secret, redirectURI string) Config {
+	return Config{
+		Config:       base,
+		Name:         "github",
+		ClientID:     id,
+		ClientSecret: secret,
+		Scopes:       []string{"read:user", "user:email"},
+		Endpoint:     github.Endpoint,
+		RedirectURI:  redirectURI,
+		UserAPI:      "https://api.github.com/user",
+		Account:      &GitHubUser{},
+	}
+}
+
+// NewGitLabConfig is the constructor for OAuth configuration using GitLab API. The base URL defaults to https://gitlab.com
+func NewGitLabConfig(base gate.Config, baseURL, id, secret, redirectURI string) Config {
+	if baseURL == "" {
+		baseURL = "https://gitlab.com"
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	return Config{
+		Config:       base,
+		Name:         "gitlab",
+		ClientID:     id,
+		ClientSecret: secret,
+		Scopes:       []string{"read_user"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  baseURL + "/oauth/authorize",
+			TokenURL: baseURL + "/oauth/token",
+		},
+		RedirectURI: redirectURI,
+		UserAPI:     baseURL + "/api/v4/user",
+		Account:     &GitLabUser{},
+	}
+}
+
+// NewMicrosoftConfig is the constructor for OpenID Connect configuration using Microsoft Entra ID.
+// The tenant is the directory ID since the multi-tenant endpoints do not issue tokens with a fixed issuer.
+// Entra ID does not verify emails, so they are only trusted within the given domains of the tenant.
+// Without domains, the emails must be flagged by the xms_edov optional claim
+func NewMicrosoftConfig(base gate.Config, tenant, id, secret, redirectURI string, domains ...string) Config {
+	config := Config{
+		Config:           base,
+		Name:             "microsoft",
+		ClientID:         id,
+		ClientSecret:     secret,
+		Scopes:           []string{"openid", "email", "profile"},
+		Endpoint:         microsoft.AzureADEndpoint(tenant),
+		RedirectURI:      redirectURI,
+		UserAPI:          "https://graph.microsoft.com/oidc/userinfo",
+		Account:          &MicrosoftUser{},
+		Issuer:           "https://login.microsoftonline.com/" + tenant + "/v2.0",
+		JWKSURI:          "https://login.microsoftonline.com/" + tenant + "/discovery/v2.0/keys",
+		UserInfoFallback: true,
+	}
+
+	if len(domains) > 0 {
+		config.EmailPolicy = EmailPolicy{TrustProvider: true, Domains: domains}
+	}
+
+	return config
+}
+
+// NewAppleConfig is the constructor for OpenID Connect configuration using Sign in with Apple, see AppleClientSecret
+func NewAppleConfig(base gate.Config, id, secret, redirectURI string) Config {
+	return Config{
+		Config:       base,
+		Name:         "apple",
+		ClientID:     id,
+		ClientSecret: secret,
+		Scopes:       []string{"openid", "email"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  AppleIssuer + "/auth/authorize",
+			TokenURL: AppleIssuer + "/auth/token",
+		},
+		RedirectURI: redirectURI,
+		Account:     &AppleUser{},
+		Issuer:      AppleIssuer,
+		JWKSURI:     AppleIssuer + "/auth/keys",
+		// Apple posts the code back when the email scope is requested
+		AuthParams: map[string]string{"response_mode": "form_post"},
+	}
+}
+
+// AppleClientSecret generates the client secret of Sign in with Apple, which is a JWT signed by the private key of the team
+func AppleClientSecret(teamID, clientID, keyID string, key *ecdsa.PrivateKey, expiration time.Duration) (string, error) {
+	if key == nil {
+		return "", errors.New("missing private key")
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.StandardClaims{
+		Issuer:    teamID,
+		Subject:   clientID,
+		Audience:  AppleIssuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(expiration).Unix(),
+	})
+	token.Header["kid"] = keyID
+
+	secret, err := token.SignedString(key)
+	if err != nil {
+		return "", errors.Wrap(err, "could not sign the client secret")
+	}
+
+	return secret, nil
+}
+
+// NewGenericConfig is the constructor for OAuth configuration using any provider. The user info is decoded with the field mapping.
+// Emails are not verified when the mapping does not have the EmailVerified field,
+// so providers which only expose verified emails must be trusted explicitly with EmailPolicy.TrustProvider
+func NewGenericConfig(base gate.Config, endpoint oauth2.Endpoint, userAPI string, scopes []string, mapping FieldMapping, id, secret, redirectURI string) Config {
+	return Config{
+		Config:       base,
+		ClientID:     id,
+		ClientSecret: secret,
+		Scopes:       scopes,
+		Endpoint:     endpoint,
+		RedirectURI:  redirectURI,
+		UserAPI:      userAPI,
+		Account:      &MappedUser{Mapping: mapping},
+	}
+}
+
+// GitHubUser is the user from GitHub API. Its email is the verified primary email fetched from the emails API
+type GitHubUser struct {
+	ID           int64  `json:"id"`
+	Login        string `json:"login"`
+	Name         string `json:"name"`
+	PrimaryEmail string `json:"-"`
+}
+
+// GetName returns user's name
+func (user GitHubUser) GetName() string {
+	if user.Name == "" {
+		return user.Login
+	}
+
+	return user.Name
+}
+
+// GetSubject returns user's identifier at the provider
+func (user GitHubUser) GetSubject() string {
+	if user.ID == 0 {
+		return ""
+	}
+
+	return strconv.FormatInt(user.ID, 10)
+}
+
+// GetEmail returns user's email
+func (user GitHubUser) GetEmail() string {
+	return user.PrimaryEmail
+}
+
+// IsEmailVerified returns whether the email is verified by the provider. Only verified primary emails are fetched
+func (user GitHubUser) IsEmailVerified() bool {
+	return user.PrimaryEmail != ""
+}
+
+// Fetch finds the verified primary email since the user API only exposes the public email
+func (user *GitHubUser) Fetch(client internal.HTTPClient, userAPI string) error {
+	user.PrimaryEmail = ""
+
+	response, err := client.Get(strings.TrimSuffix(userAPI, "/") + "/emails")
+	if err != nil {
+		return err
+	}
+	if response == nil {
+		return errors.New("invalid API response")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return errors.Errorf("unexpected status: %d", response.StatusCode)
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&emails)
+	if err != nil {
+		return err
+	}
+
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			user.PrimaryEmail = email.Email
+		}
+	}
+	return nil
+}
+
+// GitLabUser is the user from GitLab API
+type GitLabUser struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	ConfirmedAt string `json:"confirmed_at"`
+}
+
+// GetName returns user's name
+func (user GitLabUser) GetName() string {
+	if user.Name == "" {
+		return user.Username
+	}
+
+	return user.Name
+}
+
+// GetSubject returns user's identifier at the provider
+func (user GitLabUser) GetSubject() string {
+	if user.ID == 0 {
+		return ""
+	}
+
+	return strconv.FormatInt(user.ID, 10)
+}
+
+// GetEmail returns user's email
+func (user GitLabUser) GetEmail() string {
+	return user.Email
+}
+
+// IsEmailVerified returns whether the email is verified by the provider
+func (user GitLabUser) IsEmailVerified() bool {
+	return user.ConfirmedAt != ""
+}
+
+// MicrosoftUser is the user from Microsoft Entra ID claims. Entra ID does not flag verified emails,
+// but the xms_edov optional claim flags the emails of verified domains, see NewMicrosoftConfig
+type MicrosoftUser struct {
+	Subject             string
+	Name                string
+	Email               string
+	EmailDomainVerified bool
+	PreferredUsername   string
+	Groups              []string
+}
+
+// UnmarshalJSON decodes the claims. The xms_edov flag may be a boolean or a string
+func (user *MicrosoftUser) UnmarshalJSON(data []byte) error {
+	var claims struct {
+		Subject             string      `json:"sub"`
+		Name                string      `json:"name"`
+		Email               string      `json:"email"`
+		EmailDomainVerified interface{} `json:"xms_edov"`
+		PreferredUsername   string      `json:"preferred_username"`
+		Groups              []string    `json:"groups"`
+	}
+
+	err := json.Unmarshal(data, &claims)
+	if err != nil {
+		return err
+	}
+
+	user.Subject = claims.Subject
+	user.Name = claims.Name
+	user.Email = claims.Email
+	user.EmailDomainVerified = isTrue(claims.EmailDomainVerified)
+	user.PreferredUsername = claims.PreferredUsername
+	user.Groups = claims.Groups
+	return nil
+}
+
+// GetName returns user's name
+func (user MicrosoftUser) GetName() string {
+	if user.Name == "" {
+		return user.PreferredUsername
+	}
+
+	return user.Name
+}
+
+// GetSubject returns user's identifier at the provider
+func (user MicrosoftUser) GetSubject() string {
+	return user.Subject
+}
+
+// GetGroups returns the object IDs of user's groups, when the groups claim is configured
+func (user MicrosoftUser) GetGroups() []string {
+	return user.Groups
+}
+
+// GetEmail returns user's email
+func (user MicrosoftUser) GetEmail() string {
+	return user.Email
+}
+
+// IsEmailVerified returns whether the domain of the email is verified by the tenant
+func (user MicrosoftUser) IsEmailVerified() bool {
+	return user.EmailDomainVerified
+}
+
+// AppleUser is the user from Sign in with Apple claims
+type AppleUser struct {
+	Subject        string
+	Email          string
+	EmailVerified  bool
+	IsPrivateEmail bool
+}
+
+// UnmarshalJSON decodes the claims. Apple flags may be booleans or strings
+func (user *AppleUser) UnmarshalJSON(data []byte) error {
+	var claims struct {
+		Subject        string      `json:"sub"`
+		Email          string      `json:"email"`
+		EmailVerified  interface{} `json:"email_verified"`
+		IsPrivateEmail interface{} `json:"is_private_email"`
+	}
+
+	err := json.Unmarshal(data, &claims)
+	if err != nil {
+		return err
+	}
+
+	user.Subject = claims.Subject
+	user.Email = claims.Email
+	user.EmailVerified = isTrue(claims.EmailVerified)
+	user.IsPrivateEmail = isTrue(claims.IsPrivateEmail)
+	return nil
+}
+
+// GetName returns user's name. Apple only sends it once, outside of the claims
+func (user AppleUser) GetName() string {
+	return ""
+}
+
+// GetSubject returns user's identifier at the provider
+func (user AppleUser) GetSubject() string {
+	return user.Subject
+}
+
+// GetEmail returns user's email
+func (user AppleUser) GetEmail() string {
+	return user.Email
+}
+
+// IsEmailVerified returns whether the email is verified by the provider
+func (user AppleUser) IsEmailVerified() bool {
+	return user.EmailVerified
+}
+
+// FieldMapping maps the user info fields to the account. Nested fields are separated by dots, e.g. "data.email"
+type FieldMapping struct {
+	Subject string
+	Name    string
+	Email   string
+	// EmailVerified is the field flagging verified emails
+	EmailVerified string
+	// Groups is the field listing the groups of the user
+	Groups string
+}
+
+// MappedUser is the user decoded with a field mapping
+type MappedUser struct {
+	Mapping       FieldMapping
+	Subject       string
+	Name          string
+	Email         string
+	EmailVerified bool
+	Groups        []string
+}
+
+// UnmarshalJSON decodes the user info with the mapping
+func (user *MappedUser) UnmarshalJSON(data []byte) error {
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&fields)
+	if err != nil {
+		return err
+	}
+
+	user.Subject = stringField(fields, user.Mapping.Subject)
+	user.Name = stringField(fields, user.Mapping.Name)
+	user.Email = stringField(fields, user.Mapping.Email)
+	user.EmailVerified = isTrue(field(fields, user.Mapping.EmailVerified))
+	user.Groups = stringsField(fields, user.Mapping.Groups)
+	return nil
+}
+
+// GetName returns user's name
+func (user MappedUser) GetName() string {
+	return user.Name
+}
+
+// GetSubject returns user's identifier at the provider
+func (user MappedUser) GetSubject() string {
+	return user.Subject
+}
+
+// GetGroups returns user's groups at the provider
+func (user MappedUser) GetGroups() []string {
+	return user.Groups
+}
+
+// GetEmail returns user's email
+func (user MappedUser) GetEmail() string {
+	return user.Email
+}
+
+// IsEmailVerified returns whether the email is verified by the provider
+func (user MappedUser) IsEmailVerified() bool {
+	return user.EmailVerified
+}
+
+func field(fields map[string]interface{}, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+
+	var value interface{} = fields
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		value = object[key]
+	}
+
+	return value
+}
+
+func stringField(fields map[string]interface{}, path string) string {
+	switch value := field(fields, path).(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func stringsField(fields map[string]interface{}, path string) (values []string) {
+	switch value := field(fields, path).(type) {
+	case string:
+		values = []string{value}
+	case []interface{}:
+		for _, v := range value {
+			if v != nil {
+				values = append(values, fmt.Sprint(v))
+			}
+		}
+	}
+	return
+}
+
+func isTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+
+	return false
+}
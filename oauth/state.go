@@ -0,0 +1,232 @@
+package oauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultStateExpiration is the lifetime of states when the configuration omits it
+const DefaultStateExpiration = time.Minute * 10
+
+// DefaultStateCookieName is the name of the cookie used by CookieStateStore
+const DefaultStateCookieName = "gate_oauth_state"
+
+var (
+	// ErrStateNotFound is thrown when a state is unknown or has already been used
+	ErrStateNotFound = errors.New("state not found")
+
+	// ErrInvalidState is thrown when a state is expired or bound to another session
+	ErrInvalidState = errors.New("invalid state")
+)
+
+// minPruneThreshold is the number of records from which the in-memory stores drop the expired ones
+const minPruneThreshold = 1024
+
+// State is the anti-CSRF value sent along the authorization request. It is bound to the session which started the flow.
+// With OpenID Connect, it also keeps the nonce expected in the ID token
+type State struct {
+	Value     string
+	Session   string
+	Nonce     string
+	ExpiredAt time.Time
+}
+
+// StateStore is the contract which keeps pending states. Take must remove the state so it can only be used once
+type StateStore interface {
+	Save(State) error
+	Take(string) (State, error)
+}
+
+// MemoryStateStore is the in-memory StateStore
+type MemoryStateStore struct {
+	records   map[string]State
+	threshold *int
+	*sync.Mutex
+}
+
+// NewMemoryStateStore is the constructor for MemoryStateStore
+func NewMemoryStateStore() MemoryStateStore {
+	threshold := minPruneThreshold
+	return MemoryStateStore{
+		records:   map[string]State{},
+		threshold: &threshold,
+		Mutex:     &sync.Mutex{},
+	}
+}
+
+// Save keeps the state until it is taken. Expired states are dropped
+func (store MemoryStateStore) Save(state State) error {
+	store.Lock()
+	defer store.Unlock()
+
+	if len(store.records) >= *store.threshold {
+		store.prune(time.Now())
+	}
+
+	store.records[state.Value] = state
+	return nil
+}
+
+// prune drops the expired states. The next sweep happens when the remaining ones double, so saving stays constant on average
+func (store MemoryStateStore) prune(now time.Time) {
+	for key, record := range store.records {
+		if now.After(record.ExpiredAt) {
+			delete(store.records, key)
+		}
+	}
+
+	*store.threshold = len(store.records) * 2
+	if *store.threshold < minPruneThreshold {
+		*store.threshold = minPruneThreshold
+	}
+}
+
+// Take removes and returns the state with the given value
+func (store MemoryStateStore) Take(value string) (State, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	state, ok := store.records[value]
+	if !ok {
+		return State{}, ErrStateNotFound
+	}
+
+	delete(store.records, value)
+	return state, nil
+}
+
+// CookieStateStore is the StateStore keeping the state in a signed cookie of the browser which started the flow.
+// It is bound to a single request so a store should be constructed for each request
+type CookieStateStore struct {
+	Name    string
+	Path    string
+	Secure  bool
+	key     []byte
+	writer  http.ResponseWriter
+	request *http.Request
+}
+
+type cookieState struct {
+	Value     string `json:"v"`
+	Session   string `json:"s,omitempty"`
+	Nonce     string `json:"n,omitempty"`
+	ExpiredAt int64  `json:"e"`
+}
+
+// NewCookieStateStore is the constructor for CookieStateStore. The key signs the cookie
+func NewCookieStateStore(key []byte, w http.ResponseWriter, r *http.Request) CookieStateStore {
+	return CookieStateStore{
+		Name:    DefaultStateCookieName,
+		Path:    "/",
+		Secure:  true,
+		key:     key,
+		writer:  w,
+		request: r,
+	}
+}
+
+// Save writes the signed state cookie
+func (store CookieStateStore) Save(state State) error {
+	if store.writer == nil {
+		return errors.New("missing response writer")
+	}
+
+	if len(store.key) == 0 {
+		return errors.New("missing cookie signing key")
+	}
+
+	payload, err := json.Marshal(cookieState{state.Value, state.Session, state.Nonce, state.ExpiredAt.Unix()})
+	if err != nil {
+		return errors.Wrap(err, "could not encode state")
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(store.writer, &http.Cookie{
+		Name:     store.Name,
+		Value:    encoded + "." + store.sign(encoded),
+		Path:     store.Path,
+		Expires:  state.ExpiredAt,
+		Secure:   store.Secure,
+		HttpOnly: true,
+	})
+	return nil
+}
+
+// Take verifies and clears the state cookie. The state must match the cookie
+func (store CookieStateStore) Take(value string) (state State, err error) {
+	if store.request == nil {
+		err = errors.New("missing request")
+		return
+	}
+
+	cookie, err := store.request.Cookie(store.Name)
+	if err != nil {
+		err = ErrStateNotFound
+		return
+	}
+
+	if store.writer != nil {
+		http.SetCookie(store.writer, &http.Cookie{
+			Name:     store.Name,
+			Value:    "",
+			Path:     store.Path,
+			MaxAge:   -1,
+			Secure:   store.Secure,
+			HttpOnly: true,
+		})
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(store.sign(parts[0]))) {
+		err = ErrStateNotFound
+		return
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		err = ErrStateNotFound
+		return
+	}
+
+	var decoded cookieState
+	err = json.Unmarshal(payload, &decoded)
+	if err != nil {
+		err = ErrStateNotFound
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(decoded.Value), []byte(value)) != 1 {
+		err = ErrStateNotFound
+		return
+	}
+
+	state = State{decoded.Value, decoded.Session, decoded.Nonce, time.Unix(decoded.ExpiredAt, 0)}
+	return
+}
+
+func (store CookieStateStore) sign(data string) string {
+	mac := hmac.New(sha256.New, store.key)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateState generates a random state
+func GenerateState() (string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate state")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
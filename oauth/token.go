@@ -0,0 +1,155 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// ErrProviderTokenNotFound is thrown when no upstream token is kept for the user
+var ErrProviderTokenNotFound = errors.New("provider token not found")
+
+// ProviderToken is the upstream token of a user at a provider
+type ProviderToken struct {
+	UserID   string
+	Provider string
+	Token    *oauth2.Token
+}
+
+// ProviderTokenService is the contract which keeps upstream tokens by user and provider. Store must replace the existing token
+type ProviderTokenService interface {
+	FindOneByUserProvider(userID, provider string) (ProviderToken, error)
+	Store(ProviderToken) error
+	IsErrNotFound(error) bool
+}
+
+// TokenSourceProvider is the contract for providers able to refresh tokens
+type TokenSourceProvider interface {
+	TokenSource(ctx context.Context, t *oauth2.Token) oauth2.TokenSource
+}
+
+// TokenAccount is the account along with the upstream token it was fetched with, see TokenHandler
+type TokenAccount struct {
+	gate.Account
+	Token *oauth2.Token
+}
+
+// TokenSource returns a token source refreshing the provided token
+func (provider DefaultProvider) TokenSource(ctx context.Context, t *oauth2.Token) oauth2.TokenSource {
+	return provider.config.TokenSource(ctx, t)
+}
+
+// GetProviderTokenService is the getter for provider token service
+func (auth Driver) GetProviderTokenService() ProviderTokenService {
+	return auth.tokens
+}
+
+// SetProviderTokenService is the setter for provider token service
+func (auth *Driver) SetProviderTokenService(service ProviderTokenService) {
+	auth.tokens = service
+}
+
+// GetProviderToken returns the upstream token of a specific user
+func (auth Driver) GetProviderToken(user gate.User) (token ProviderToken, err error) {
+	if user == nil {
+		err = errors.New("missing user")
+		return
+	}
+
+	if auth.tokens == nil {
+		err = gate.MissingServiceError{Service: "provider token service"}
+		return
+	}
+
+	token, err = auth.tokens.FindOneByUserProvider(user.GetID(), auth.ProviderName())
+	if err != nil {
+		if auth.tokens.IsErrNotFound(err) {
+			err = ErrProviderTokenNotFound
+			return
+		}
+
+		err = errors.Wrap(err, "could not find the provider token")
+		return
+	}
+
+	if token.Token == nil {
+		err = ErrProviderTokenNotFound
+		return
+	}
+	return
+}
+
+// UserClient returns an HTTP client calling the provider APIs on behalf of a specific user.
+// The token is refreshed when it is expired and the refreshed token is stored
+func (auth Driver) UserClient(ctx context.Context, user gate.User) (client *http.Client, err error) {
+	provider, ok := auth.provider.(TokenSourceProvider)
+	if !ok {
+		err = errors.New("the provider does not support token refresh")
+		return
+	}
+
+	token, err := auth.GetProviderToken(user)
+	if err != nil {
+		return
+	}
+
+	source := &storingTokenSource{
+		source:  oauth2.ReuseTokenSource(token.Token, provider.TokenSource(ctx, token.Token)),
+		record:  token,
+		tokens:  auth.tokens,
+		Mutex:   &sync.Mutex{},
+		current: token.Token.AccessToken,
+	}
+
+	client = oauth2.NewClient(ctx, source)
+	return
+}
+
+func (auth Driver) storeProviderToken(user gate.User, token *oauth2.Token) error {
+	if auth.tokens == nil {
+		return gate.MissingServiceError{Service: "provider token service"}
+	}
+
+	err := auth.tokens.Store(ProviderToken{user.GetID(), auth.ProviderName(), token})
+	if err != nil {
+		return errors.Wrap(err, "could not store the provider token")
+	}
+
+	return nil
+}
+
+// storingTokenSource stores the tokens refreshed by its source
+type storingTokenSource struct {
+	source  oauth2.TokenSource
+	record  ProviderToken
+	tokens  ProviderTokenService
+	current string
+	*sync.Mutex
+}
+
+func (source *storingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := source.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	source.Lock()
+	defer source.Unlock()
+
+	if token.AccessToken == source.current {
+		return token, nil
+	}
+
+	source.record.Token = token
+	err = source.tokens.Store(source.record)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not store the refreshed token")
+	}
+
+	source.current = token.AccessToken
+	return token, nil
+}
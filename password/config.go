@@ -7,4 +7,6 @@ import (
 // Config is the configuration for password-based authentication
 type Config struct {
 	gate.Config
+	// Provisioning decides whether and how unknown users are created on login
+	Provisioning gate.ProvisioningPolicy
 }
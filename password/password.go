@@ -1,13 +1,18 @@
 package password
 
 import (
+	"time"
+
 	"github.com/hiendv/gate"
 	"github.com/hiendv/gate/dependency"
 	"github.com/hiendv/gate/internal"
 	"github.com/pkg/errors"
 )
 
-// LoginFunc is the handler of password-based authentication
+// Provider is the provider of users created by password-based authentication
+const Provider = "password"
+
+// LoginFunc is the handler of password-based authentication. Wrong credentials should be rejected with gate.ErrInvalidCredentials
 type LoginFunc func(driver Driver, email, password string) (gate.Account, error)
 
 // Driver is password-based authentication
@@ -51,15 +56,20 @@ func (auth Driver) LoginURL(state string) (string, error) {
 
 // Login resolves password-based authentication with the given handler and credentials
 func (auth Driver) Login(credentials map[string]string) (user gate.User, err error) {
+	defer func(start time.Time) {
+		gate.AuditLogin(auth, Provider, credentials["email"], user, err)
+		gate.MeasureLogin(auth, Provider, start, err)
+	}(time.Now())
+
 	email, ok := credentials["email"]
 	if !ok {
-		err = errors.New("missing email")
+		err = gate.MissingCredentialsError{Credential: "email"}
 		return
 	}
 
 	password, ok := credentials["password"]
 	if !ok {
-		err = errors.New("missing password")
+		err = gate.MissingCredentialsError{Credential: "password"}
 		return
 	}
 
@@ -69,29 +79,7 @@ func (auth Driver) Login(credentials map[string]string) (user gate.User, err err
 		return
 	}
 
-	service, err := auth.UserService()
-	if err != nil {
-		err = errors.Wrap(err, "invalid user service")
-		return
-	}
-
-	if person.GetEmail() == "" {
-		err = errors.New("missing account email")
-		return
-	}
-
-	user, err = service.FindOneByEmail(person.GetEmail())
-	if err == nil {
-		return
-	}
-
-	if !service.IsErrNotFound(err) {
-		err = errors.Wrap(err, "could not find the user")
-		return
-	}
-
-	user, err = service.CreateOneByAccount(person)
-	return
+	return gate.LoginAccount(auth, Provider, person, nil, auth.config.Provisioning)
 }
 
 // IssueJWT issues and stores a JWT for a specific user
@@ -2,6 +2,7 @@ package password_test
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,14 +11,16 @@ import (
 	"github.com/hiendv/gate/internal/test"
 	"github.com/hiendv/gate/internal/test/fixtures"
 	"github.com/hiendv/gate/password"
+	"github.com/hiendv/gate/session"
 	"github.com/pkg/errors"
 )
 
 var (
-	auth         gate.Auth
-	userService  *fixtures.MyUserService
-	tokenService *fixtures.MyTokenService
-	roleService  *fixtures.MyRoleService
+	auth          gate.Auth
+	userService   *fixtures.MyUserService
+	tokenService  *fixtures.MyTokenService
+	roleService   *fixtures.MyRoleService
+	apiKeyService *fixtures.MyAPIKeyService
 )
 
 func TestMain(m *testing.M) {
@@ -69,8 +72,9 @@ func TestMain(m *testing.M) {
 	roleService = fixtures.NewMyRoleService(roles)
 	userService = fixtures.NewMyUserService(users, []string{"local"})
 	tokenService = fixtures.NewMyTokenService(nil)
+	apiKeyService = fixtures.NewMyAPIKeyService(nil)
 
-	auth = password.New(
+	driver := password.New(
 		password.Config{Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false)},
 		func(driver password.Driver, email, password string) (gate.Account, error) {
 			for _, record := range accounts {
@@ -79,14 +83,17 @@ func TestMain(m *testing.M) {
 				}
 			}
 
-			return nil, errors.New("invalid credentials")
+			return nil, gate.ErrInvalidCredentials
 		},
 		dependency.NewContainer(userService, tokenService, roleService),
 	)
-	if auth == nil {
+	if driver == nil {
 		os.Exit(1)
 	}
 
+	driver.SetAPIKeyService(apiKeyService)
+	auth = driver
+
 	os.Exit(m.Run())
 }
 
@@ -253,3 +260,346 @@ func TestPasswordJWT(t *testing.T) {
 		})
 	})
 }
+
+func TestPasswordAPIKey(t *testing.T) {
+	user, err := userService.FindOneByEmail("foo@local")
+	test.AssertOK(t, err, "existing user")
+
+	t.Run("without API key service", func(t *testing.T) {
+		driver := password.New(
+			password.Config{Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false)},
+			password.LoginFuncStub,
+			dependency.NewContainer(userService, tokenService, roleService),
+		)
+		if driver == nil {
+			t.Fatal("unexpected nil driver")
+		}
+
+		_, _, err := gate.IssueAPIKey(driver, user, "ci", nil, 0)
+		test.AssertErr(t, err, "missing API key service")
+
+		_, err = driver.Authenticate(gate.APIKeyPrefix + "id_secret")
+		test.AssertErr(t, err, "missing API key service")
+	})
+
+	t.Run("unscoped", func(t *testing.T) {
+		key, plain, err := gate.IssueAPIKey(auth, user, "ci", nil, 0)
+		test.AssertOK(t, err, "valid user")
+
+		if !gate.IsAPIKey(plain) {
+			t.Fatalf("unexpected API key: %s", plain)
+		}
+
+		stored, err := apiKeyService.FindOneByID(key.ID)
+		test.AssertOK(t, err, "existing API key")
+
+		if stored.Hash == "" || strings.Contains(plain, stored.Hash) {
+			t.Fatal("the secret should be hashed")
+		}
+
+		if !stored.LastUsedAt.IsZero() {
+			t.Fatal("the API key should not be used yet")
+		}
+
+		keyUser, err := auth.Authenticate(plain)
+		test.AssertOK(t, err, "valid API key")
+
+		if keyUser.GetID() != user.GetID() {
+			t.Fatalf("ids should be equal: %v - %v", keyUser.GetID(), user.GetID())
+		}
+
+		stored, err = apiKeyService.FindOneByID(key.ID)
+		test.AssertOK(t, err, "existing API key")
+
+		if stored.LastUsedAt.IsZero() {
+			t.Fatal("the usage should be tracked")
+		}
+
+		err = auth.Authorize(keyUser, "GET", "/api/v1/posts")
+		test.AssertOK(t, err, "valid abilities")
+
+		err = auth.Authorize(keyUser, "POST", "/api/v1/users")
+		test.AssertOK(t, err, "valid abilities")
+	})
+
+	t.Run("scoped", func(t *testing.T) {
+		_, plain, err := gate.IssueAPIKey(auth, user, "reader", []gate.Ability{
+			{Action: "GET", Object: "/api/v1/posts*"},
+			{Action: "DELETE", Object: "*"},
+		}, time.Hour)
+		test.AssertOK(t, err, "valid user")
+
+		keyUser, err := auth.Authenticate(plain)
+		test.AssertOK(t, err, "valid API key")
+
+		err = auth.Authorize(keyUser, "GET", "/api/v1/posts")
+		test.AssertOK(t, err, "valid scope")
+
+		err = auth.Authorize(keyUser, "GET", "/api/v1/users")
+		test.AssertErr(t, err, "out of scope")
+
+		err = auth.Authorize(keyUser, "POST", "/api/v1/users")
+		test.AssertErr(t, err, "out of scope")
+
+		err = auth.Authorize(keyUser, "DELETE", "/api/v1/posts")
+		test.AssertErr(t, err, "scope beyond the owner's abilities")
+
+		abilities, err := auth.GetUserAbilities(keyUser)
+		test.AssertOK(t, err, "valid abilities")
+
+		if len(abilities) != 1 || abilities[0].GetAction() != "GET" || abilities[0].GetObject() != "/api/v1/posts*" {
+			t.Fatalf("the abilities should be narrowed to the scopes: %v", abilities)
+		}
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		key, plain, err := gate.IssueAPIKey(auth, user, "ci", nil, 0)
+		test.AssertOK(t, err, "valid user")
+
+		err = gate.RevokeAPIKey(auth, key.ID)
+		test.AssertOK(t, err, "existing API key")
+
+		_, err = auth.Authenticate(plain)
+		test.AssertErr(t, err, "revoked API key")
+
+		err = gate.RevokeAPIKey(auth, "unknown")
+		test.AssertErr(t, err, "unknown API key")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		key, plain, err := gate.IssueAPIKey(auth, user, "ci", nil, time.Hour)
+		test.AssertOK(t, err, "valid user")
+
+		err = apiKeyService.Expire(key.ID)
+		test.AssertOK(t, err, "existing API key")
+
+		_, err = auth.Authenticate(plain)
+		test.AssertErr(t, err, "expired API key")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		key, plain, err := gate.IssueAPIKey(auth, user, "ci", nil, 0)
+		test.AssertOK(t, err, "valid user")
+
+		_, err = auth.Authenticate(plain + "x")
+		test.AssertErr(t, err, "wrong secret")
+
+		_, err = auth.Authenticate(gate.APIKeyPrefix + key.ID)
+		test.AssertErr(t, err, "missing secret")
+
+		_, err = auth.Authenticate(gate.APIKeyPrefix + "unknown_secret")
+		test.AssertErr(t, err, "unknown API key")
+
+		_, err = gate.AuthenticateAPIKey(auth, "not an API key")
+		test.AssertErr(t, err, "not an API key")
+	})
+
+	t.Run("deleted owner", func(t *testing.T) {
+		_, plain, err := gate.IssueAPIKey(auth, fixtures.User{ID: "another-id"}, "ci", nil, 0)
+		test.AssertOK(t, err, "valid user")
+
+		_, err = auth.Authenticate(plain)
+		test.AssertErr(t, err, "non-existing user")
+	})
+}
+
+func TestPasswordProvisioning(t *testing.T) {
+	accounts := []fixtures.Account{
+		{Email: "foo@local", Password: "fooo"},
+		{Email: "qux@local", Password: "quxx"},
+		{Email: "qux@external", Password: "quxx"},
+		{Email: "vetoed@local", Password: "quxx"},
+	}
+	newDriver := func(policy gate.ProvisioningPolicy) *password.Driver {
+		return password.New(
+			password.Config{
+				Config:       gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+				Provisioning: policy,
+			},
+			func(driver password.Driver, email, password string) (gate.Account, error) {
+				for _, record := range accounts {
+					if record.Valid(email, password) {
+						return record, nil
+					}
+				}
+
+				return nil, gate.ErrInvalidCredentials
+			},
+			dependency.NewContainer(userService, tokenService, roleService),
+		)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		driver := newDriver(gate.ProvisioningPolicy{Disabled: true})
+
+		_, err := driver.Login(map[string]string{"email": "qux@local", "password": "quxx"})
+		if err != gate.ErrProvisioningDisabled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = driver.Login(map[string]string{"email": "foo@local", "password": "fooo"})
+		test.AssertOK(t, err, "existing user")
+	})
+
+	t.Run("domains and roles", func(t *testing.T) {
+		driver := newDriver(gate.ProvisioningPolicy{
+			Domains:      []string{"LOCAL"},
+			DefaultRoles: []string{"member"},
+			Hook: func(account gate.Account, provisioning gate.Provisioning) (gate.Provisioning, error) {
+				if account.GetEmail() == "vetoed@local" {
+					return provisioning, errors.New("vetoed")
+				}
+
+				provisioning.Roles = append(provisioning.Roles, provisioning.Provider)
+				return provisioning, nil
+			},
+		})
+
+		_, err := driver.Login(map[string]string{"email": "qux@external", "password": "quxx"})
+		if err != gate.ErrProvisioningDomainNotAllowed {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = driver.Login(map[string]string{"email": "vetoed@local", "password": "quxx"})
+		test.AssertErr(t, err, "vetoed account")
+
+		_, err = userService.FindOneByEmail("vetoed@local")
+		test.AssertErr(t, err, "non-existing user")
+
+		user, err := driver.Login(map[string]string{"email": "qux@local", "password": "quxx"})
+		test.AssertOK(t, err, "allowed domain")
+
+		roles := user.GetRoles()
+		if len(roles) != 2 || roles[0] != "member" || roles[1] != password.Provider {
+			t.Fatalf("unexpected roles: %v", roles)
+		}
+	})
+}
+
+func TestPasswordSession(t *testing.T) {
+	user, err := userService.FindOneByEmail("foo@local")
+	test.AssertOK(t, err, "existing user")
+
+	container := dependency.NewContainer(userService, tokenService, roleService)
+	driver := password.New(
+		password.Config{Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false)},
+		password.LoginFuncStub,
+		container,
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	t.Run("without session store", func(t *testing.T) {
+		_, err := gate.CreateSession(driver, user, gate.SessionConfig{})
+		test.AssertErr(t, err, "missing session store")
+
+		_, err = driver.Authenticate(gate.SessionPrefix + "id")
+		test.AssertErr(t, err, "missing session store")
+	})
+
+	store := session.NewMemoryStore()
+	container.SetSessionStore(store)
+
+	t.Run("valid session", func(t *testing.T) {
+		created, err := gate.CreateSession(driver, user, gate.SessionConfig{})
+		test.AssertOK(t, err, "valid user")
+
+		if !gate.IsSessionID(created.ID) || created.IdleTimeout != gate.DefaultSessionIdleTimeout {
+			t.Fatalf("unexpected session: %v", created)
+		}
+
+		sessionUser, err := driver.Authenticate(created.ID)
+		test.AssertOK(t, err, "valid session")
+
+		if sessionUser.GetID() != user.GetID() {
+			t.Fatalf("ids should be equal: %v - %v", sessionUser.GetID(), user.GetID())
+		}
+
+		err = driver.Authorize(sessionUser, "GET", "/api/v1/posts")
+		test.AssertOK(t, err, "valid abilities")
+
+		stored, err := store.FindOneByID(created.ID)
+		test.AssertOK(t, err, "existing session")
+
+		if !stored.LastSeenAt.After(created.LastSeenAt) {
+			t.Fatal("the expiration should slide")
+		}
+
+		rotated, err := gate.RotateSession(driver, created.ID)
+		test.AssertOK(t, err, "valid session")
+
+		if rotated.ID == created.ID || rotated.UserID != created.UserID || !rotated.ExpiredAt.Equal(created.ExpiredAt) {
+			t.Fatalf("unexpected rotated session: %v", rotated)
+		}
+
+		_, err = driver.Authenticate(created.ID)
+		test.AssertErr(t, err, "rotated session")
+
+		_, err = driver.Authenticate(rotated.ID)
+		test.AssertOK(t, err, "valid session")
+
+		err = gate.DestroySession(driver, rotated.ID)
+		test.AssertOK(t, err, "valid session")
+
+		_, err = driver.Authenticate(rotated.ID)
+		test.AssertErr(t, err, "destroyed session")
+
+		err = gate.DestroySession(driver, rotated.ID)
+		test.AssertOK(t, err, "destroyed session")
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		now := time.Now()
+		idle := gate.Session{ID: gate.SessionPrefix + "idle", UserID: user.GetID(), LastSeenAt: now.Add(-time.Hour), ExpiredAt: now.Add(time.Hour), IdleTimeout: time.Minute}
+		absolute := gate.Session{ID: gate.SessionPrefix + "absolute", UserID: user.GetID(), LastSeenAt: now, ExpiredAt: now.Add(-time.Second), IdleTimeout: time.Minute}
+
+		for _, record := range []gate.Session{idle, absolute} {
+			err := store.Store(record)
+			test.AssertOK(t, err, "valid session")
+
+			_, err = gate.AuthenticateSession(driver, record.ID)
+			if err != gate.ErrSessionExpired {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, err = store.FindOneByID(record.ID)
+			test.AssertErr(t, err, "expired session should be deleted")
+		}
+
+		defer container.SetClock(nil)
+		created, err := gate.CreateSession(driver, user, gate.SessionConfig{IdleTimeout: time.Minute})
+		test.AssertOK(t, err, "valid user")
+
+		container.SetClock(func() time.Time {
+			return created.LastSeenAt.Add(time.Minute * 2)
+		})
+		_, err = gate.AuthenticateSession(driver, created.ID)
+		if err != gate.ErrSessionExpired {
+			t.Fatalf("the session should expire on the clock of the container: %v", err)
+		}
+	})
+
+	t.Run("invalid session", func(t *testing.T) {
+		_, err := gate.AuthenticateSession(driver, gate.SessionPrefix)
+		if err != gate.ErrInvalidSession {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = gate.AuthenticateSession(driver, gate.SessionPrefix+"unknown")
+		if err != gate.ErrInvalidSession {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = gate.RotateSession(driver, "unknown")
+		if err != gate.ErrInvalidSession {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = store.Store(gate.Session{ID: gate.SessionPrefix + "orphan", UserID: "unknown", LastSeenAt: time.Now(), ExpiredAt: time.Now().Add(time.Hour)})
+		test.AssertOK(t, err, "valid session")
+
+		_, err = driver.Authenticate(gate.SessionPrefix + "orphan")
+		test.AssertErr(t, err, "missing user")
+	})
+}
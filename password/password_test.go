@@ -1,14 +1,26 @@
 package password_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/audit"
 	"github.com/hiendv/gate/dependency"
 	"github.com/hiendv/gate/internal/test"
 	"github.com/hiendv/gate/internal/test/fixtures"
+	"github.com/hiendv/gate/metrics"
 	"github.com/hiendv/gate/password"
+	"github.com/hiendv/gate/policy"
+	"github.com/hiendv/gate/relation"
+	"github.com/hiendv/gate/session"
 	"github.com/pkg/errors"
 )
 
@@ -191,6 +203,206 @@ func TestPasswordJWTService(t *testing.T) {
 	})
 }
 
+func TestPasswordAsymmetricJWT(t *testing.T) {
+	user := fixtures.User{ID: fixtures.RandomString(8), Email: "asymmetric@local", Roles: []string{"role"}}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertOK(t, err, "valid RSA key")
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertOK(t, err, "valid ECDSA key")
+
+	cases := []struct {
+		alg       string
+		signKey   interface{}
+		verifyKey interface{}
+	}{
+		{"RS256", rsaKey, &rsaKey.PublicKey},
+		{"RS256", rsaKey, rsaKey},
+		{"PS256", rsaKey, &rsaKey.PublicKey},
+		{"ES256", ecKey, &ecKey.PublicKey},
+		{"ES256", ecKey, ecKey},
+	}
+
+	for _, c := range cases {
+		service := gate.NewJWTService(gate.NewJWTConfig(jwt.GetSigningMethod(c.alg), c.signKey, c.verifyKey, time.Hour*1, false))
+		token, err := service.Issue(service.NewClaims(user))
+		test.AssertOK(t, err, "valid signing key")
+
+		parsed, err := service.Parse(token.Value)
+		test.AssertOK(t, err, "valid verifying key")
+		if parsed.UserID != user.ID {
+			t.Fatalf("unexpected token: %v", parsed)
+		}
+	}
+
+	service := gate.NewJWTService(gate.NewJWTConfig(jwt.SigningMethodRS256, rsaKey, &ecKey.PublicKey, time.Hour*1, false))
+	token, err := service.Issue(service.NewClaims(user))
+	test.AssertOK(t, err, "valid signing key")
+
+	_, err = service.Parse(token.Value)
+	test.AssertErr(t, err, "invalid verifying key")
+}
+
+func TestPasswordJWTClaimErrors(t *testing.T) {
+	user := fixtures.User{ID: fixtures.RandomString(8), Email: "claims@local", Roles: []string{"role"}}
+
+	jwtConfig, err := gate.NewHMACJWTConfig("HS256", "jwt-secret", time.Hour*1, false)
+	test.AssertOK(t, err, "valid JWT config")
+
+	service := gate.NewJWTService(jwtConfig)
+	claims := service.NewClaims(user)
+	if failures := service.ValidateClaims(claims); len(failures) != 0 {
+		t.Fatalf("unexpected failures: %v", failures)
+	}
+
+	claims.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+	claims.NotBefore = time.Now().Add(time.Hour).Unix()
+
+	failures := service.ValidateClaims(claims)
+	if len(failures) != 2 || failures[0].Claim != "exp" || failures[1].Claim != "nbf" {
+		t.Fatalf("unexpected failures: %v", failures)
+	}
+
+	if !errors.Is(failures[0], gate.ErrTokenExpired) || !errors.Is(failures[1], gate.ErrTokenNotValidYet) {
+		t.Fatalf("unexpected failures: %v", failures)
+	}
+}
+
+func TestPasswordJWE(t *testing.T) {
+	user := fixtures.User{
+		ID:    fixtures.RandomString(8),
+		Email: "confidential@local",
+		Roles: []string{"confidential-role"},
+	}
+
+	driver := password.New(
+		password.Config{Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false)},
+		password.LoginFuncStub,
+		dependency.NewContainer(fixtures.NewMyUserService([]fixtures.User{user}, nil), fixtures.NewMyTokenService(nil), fixtures.NewMyRoleService(nil)),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	jwtConfig, err := gate.NewHMACJWTConfig("HS256", "jwt-secret", time.Hour*1, false)
+	test.AssertOK(t, err, "valid JWT config")
+
+	plain := gate.NewJWTService(jwtConfig)
+	plainToken, err := plain.Issue(plain.NewClaims(user))
+	test.AssertOK(t, err, "valid signed token")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertOK(t, err, "valid RSA key")
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertOK(t, err, "valid ECDSA key")
+
+	otherECKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertOK(t, err, "valid ECDSA key")
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := gate.NewJWEConfig("A128KW", []byte("secret"), []byte("secret"))
+		test.AssertErr(t, err, "unsupported algorithm")
+
+		_, err = gate.NewJWEConfig(gate.JWEDirect, []byte("short"), nil)
+		test.AssertErr(t, err, "short key")
+
+		_, err = gate.NewJWEConfig(gate.JWERSAOAEP, rsaKey, nil)
+		test.AssertErr(t, err, "private key for encryption")
+
+		_, err = gate.NewJWEConfig(gate.JWEECDHES, nil, nil)
+		test.AssertErr(t, err, "missing keys")
+	})
+
+	cases := []struct {
+		alg        string
+		encryptKey interface{}
+		decryptKey interface{}
+		wrongKey   interface{}
+	}{
+		{gate.JWEDirect, []byte(strings.Repeat("k", 32)), []byte(strings.Repeat("k", 32)), []byte(strings.Repeat("w", 32))},
+		{gate.JWERSAOAEP, &rsaKey.PublicKey, rsaKey, nil},
+		{gate.JWERSAOAEP256, &rsaKey.PublicKey, rsaKey, nil},
+		{gate.JWEECDHES, &ecKey.PublicKey, ecKey, otherECKey},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.alg, func(t *testing.T) {
+			encryption, err := gate.NewJWEConfig(c.alg, c.encryptKey, c.decryptKey)
+			test.AssertOK(t, err, "valid JWE config")
+
+			driver.SetJWTService(gate.NewJWTService(jwtConfig.WithEncryption(encryption)))
+			token, err := driver.IssueJWT(user)
+			test.AssertOK(t, err, "valid encrypted token")
+
+			parts := strings.Split(token.Value, ".")
+			if len(parts) != 5 {
+				t.Fatalf("unexpected token segments: %d", len(parts))
+			}
+
+			for _, part := range parts {
+				data, err := base64.RawURLEncoding.DecodeString(part)
+				test.AssertOK(t, err, "valid segment")
+				if strings.Contains(string(data), user.Email) || strings.Contains(string(data), "confidential-role") {
+					t.Fatal("unexpected readable claims")
+				}
+			}
+
+			parsed, err := driver.ParseJWT(token.Value)
+			test.AssertOK(t, err, "valid encrypted token")
+			if parsed.UserID != user.ID || parsed.ID != token.ID || parsed.Value != token.Value {
+				t.Fatal("unexpected token")
+			}
+
+			authenticated, err := driver.Authenticate(token.Value)
+			test.AssertOK(t, err, "valid encrypted token")
+			if authenticated.GetID() != user.ID {
+				t.Fatal("unexpected user")
+			}
+
+			_, err = driver.ParseJWT(plainToken.Value)
+			test.AssertErr(t, err, "signed-only token")
+
+			parts[3] = strings.Repeat("A", len(parts[3]))
+			_, err = driver.ParseJWT(strings.Join(parts, "."))
+			test.AssertErr(t, err, "tampered ciphertext")
+
+			if c.wrongKey == nil {
+				return
+			}
+
+			wrong, err := gate.NewJWEConfig(c.alg, nil, c.wrongKey)
+			test.AssertOK(t, err, "valid JWE config")
+
+			driver.SetJWTService(gate.NewJWTService(jwtConfig.WithEncryption(wrong)))
+			_, err = driver.ParseJWT(token.Value)
+			test.AssertErr(t, err, "wrong decryption key")
+		})
+	}
+
+	t.Run("RSA-OAEP with wrong key", func(t *testing.T) {
+		otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		test.AssertOK(t, err, "valid RSA key")
+
+		encryption, err := gate.NewJWEConfig(gate.JWERSAOAEP256, &rsaKey.PublicKey, nil)
+		test.AssertOK(t, err, "valid JWE config")
+
+		token, err := gate.NewJWTService(jwtConfig.WithEncryption(encryption)).Issue(plain.NewClaims(user))
+		test.AssertOK(t, err, "valid encrypted token")
+
+		_, err = gate.NewJWTService(jwtConfig.WithEncryption(encryption)).Parse(token.Value)
+		test.AssertErr(t, err, "missing decryption key")
+
+		wrong, err := gate.NewJWEConfig(gate.JWERSAOAEP256, nil, otherRSAKey)
+		test.AssertOK(t, err, "valid JWE config")
+
+		_, err = gate.NewJWTService(jwtConfig.WithEncryption(wrong)).Parse(token.Value)
+		test.AssertErr(t, err, "wrong decryption key")
+	})
+}
+
 func TestPasswordRoleService(t *testing.T) {
 	user := fixtures.User{
 		ID:    fixtures.RandomString(8),
@@ -230,3 +442,779 @@ func TestPasswordRoleService(t *testing.T) {
 		})
 	})
 }
+
+func TestPasswordJWTLifetime(t *testing.T) {
+	admin := fixtures.User{
+		ID:    fixtures.RandomString(8),
+		Email: "admin@local",
+		Roles: []string{"admin"},
+	}
+
+	member := fixtures.User{
+		ID:    fixtures.RandomString(8),
+		Email: "member@local",
+		Roles: []string{"member"},
+	}
+
+	driver := password.New(
+		password.Config{Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false)},
+		password.LoginFuncStub,
+		dependency.NewContainer(fixtures.NewMyUserService([]fixtures.User{admin, member}, nil), fixtures.NewMyTokenService(nil), fixtures.NewMyRoleService(nil)),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	jwtConfig, err := gate.NewHMACJWTConfig("HS256", "jwt-secret", time.Hour*1, false)
+	test.AssertOK(t, err, "valid JWT config")
+
+	now := time.Date(2020, time.November, 10, 23, 0, 0, 0, time.UTC)
+	newService := func(policy gate.JWTLifetimePolicy) *gate.JWTService {
+		service := gate.NewJWTService(jwtConfig.WithLifetime(policy))
+		service.Now = func() time.Time {
+			return now
+		}
+
+		driver.SetJWTService(service)
+		return service
+	}
+
+	t.Run("leeway", func(t *testing.T) {
+		service := newService(gate.JWTLifetimePolicy{})
+		token, err := driver.IssueJWT(member)
+		test.AssertOK(t, err, "valid token")
+
+		service.Now = func() time.Time {
+			return now.Add(-time.Second * 5)
+		}
+		_, err = driver.ParseJWT(token.Value)
+		test.AssertErr(t, err, "issued in the future without leeway")
+		if errors.Cause(err) != gate.ErrTokenNotValidYet {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		service.Now = func() time.Time {
+			return now.Add(time.Hour + time.Second*5)
+		}
+		_, err = driver.ParseJWT(token.Value)
+		test.AssertErr(t, err, "expired without leeway")
+		if errors.Cause(err) != gate.ErrTokenExpired {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		service = newService(gate.JWTLifetimePolicy{Leeway: time.Second * 10})
+		service.Now = func() time.Time {
+			return now.Add(-time.Second * 5)
+		}
+		_, err = driver.ParseJWT(token.Value)
+		test.AssertOK(t, err, "issued in the future within leeway")
+
+		service.Now = func() time.Time {
+			return now.Add(time.Hour + time.Second*5)
+		}
+		_, err = driver.ParseJWT(token.Value)
+		test.AssertOK(t, err, "expired within leeway")
+
+		service.Now = func() time.Time {
+			return now.Add(time.Hour + time.Second*15)
+		}
+		_, err = driver.ParseJWT(token.Value)
+		test.AssertErr(t, err, "expired beyond leeway")
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		service := newService(gate.JWTLifetimePolicy{MaxAge: time.Minute * 10})
+		token, err := driver.IssueJWT(member)
+		test.AssertOK(t, err, "valid token")
+
+		service.Now = func() time.Time {
+			return now.Add(time.Minute * 5)
+		}
+		_, err = driver.ParseJWT(token.Value)
+		test.AssertOK(t, err, "young token")
+
+		service.Now = func() time.Time {
+			return now.Add(time.Minute * 15)
+		}
+		_, err = driver.ParseJWT(token.Value)
+		test.AssertErr(t, err, "old token")
+		if errors.Cause(err) != gate.ErrTokenTooOld {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("role expirations", func(t *testing.T) {
+		newService(gate.JWTLifetimePolicy{RoleExpirations: map[string]time.Duration{"admin": time.Minute * 5, "member": time.Hour * 2}})
+		token, err := driver.IssueJWT(admin)
+		test.AssertOK(t, err, "valid token")
+		if !token.ExpiredAt.Equal(now.Add(time.Minute * 5)) {
+			t.Fatalf("unexpected expiration: %v", token.ExpiredAt)
+		}
+
+		token, err = driver.IssueJWT(member)
+		test.AssertOK(t, err, "valid token")
+		if !token.ExpiredAt.Equal(now.Add(time.Hour)) {
+			t.Fatalf("unexpected expiration: %v", token.ExpiredAt)
+		}
+	})
+
+	t.Run("absolute lifetime", func(t *testing.T) {
+		service := newService(gate.JWTLifetimePolicy{AbsoluteLifetime: time.Minute * 90})
+		token, err := driver.IssueJWT(member)
+		test.AssertOK(t, err, "valid token")
+
+		_, err = gate.RefreshJWT(driver, "invalid")
+		test.AssertErr(t, err, "invalid token")
+
+		service.Now = func() time.Time {
+			return now.Add(time.Minute * 50)
+		}
+		refreshed, err := gate.RefreshJWT(driver, token.Value)
+		test.AssertOK(t, err, "refresh within the lifetime")
+		if !refreshed.AuthenticatedAt.Equal(now) || refreshed.ID == token.ID {
+			t.Fatal("unexpected refreshed token")
+		}
+
+		if !refreshed.ExpiredAt.Equal(now.Add(time.Minute * 90)) {
+			t.Fatalf("unexpected expiration: %v", refreshed.ExpiredAt)
+		}
+
+		_, err = driver.Authenticate(refreshed.Value)
+		test.AssertOK(t, err, "valid refreshed token")
+
+		service.Now = func() time.Time {
+			return now.Add(time.Minute * 89)
+		}
+		refreshed, err = gate.RefreshJWT(driver, refreshed.Value)
+		test.AssertOK(t, err, "refresh within the lifetime")
+		if !refreshed.AuthenticatedAt.Equal(now) {
+			t.Fatal("unexpected authentication time")
+		}
+
+		service.Now = func() time.Time {
+			return now.Add(time.Minute * 91)
+		}
+		_, err = driver.ParseJWT(refreshed.Value)
+		test.AssertErr(t, err, "lifetime exceeded")
+
+		_, err = service.RefreshClaims(member, refreshed)
+		test.AssertErr(t, err, "lifetime exceeded")
+		if err != gate.ErrTokenLifetimeExceeded {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = service.RefreshClaims(admin, refreshed)
+		test.AssertErr(t, err, "another user")
+	})
+
+	t.Run("skip claims validation", func(t *testing.T) {
+		service := newService(gate.JWTLifetimePolicy{MaxAge: time.Minute})
+		token, err := driver.IssueJWT(member)
+		test.AssertOK(t, err, "valid token")
+
+		skipConfig, err := gate.NewHMACJWTConfig("HS256", "jwt-secret", time.Hour*1, true)
+		test.AssertOK(t, err, "valid JWT config")
+
+		service = gate.NewJWTService(skipConfig.WithLifetime(gate.JWTLifetimePolicy{MaxAge: time.Minute}))
+		service.Now = func() time.Time {
+			return now.Add(time.Hour * 24)
+		}
+		driver.SetJWTService(service)
+		_, err = driver.ParseJWT(token.Value)
+		test.AssertOK(t, err, "skipped validation")
+	})
+}
+
+func TestPasswordErrors(t *testing.T) {
+	account := fixtures.Account{Email: "email@local", Password: "password"}
+	user := fixtures.User{
+		ID:    fixtures.RandomString(8),
+		Email: "email@local",
+		Roles: []string{},
+	}
+
+	driver := fixtures.NewPasswordDriver(
+		password.Config{Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false)},
+		account,
+		dependency.NewContainer(fixtures.NewMyUserService([]fixtures.User{user}, nil), fixtures.NewMyTokenService(nil), nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	t.Run("missing service", func(t *testing.T) {
+		_, err := driver.RoleService()
+		if !errors.Is(err, gate.ErrMissingService) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var missing gate.MissingServiceError
+		if !errors.As(err, &missing) || missing.Service != "role service" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = gate.CreateSession(driver, user, gate.SessionConfig{})
+		if !errors.Is(err, gate.ErrMissingService) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The optional services are missing from an Auth which does not provide them
+		container := dependency.NewContainer(fixtures.NewMyUserService([]fixtures.User{user}, nil), fixtures.NewMyTokenService(nil), nil)
+		container.SetSessionStore(session.NewMemoryStore())
+		container.SetAPIKeyService(fixtures.NewMyAPIKeyService(nil))
+		minimal := minimalAuth{*fixtures.NewPasswordDriver(password.Config{Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false)}, account, container)}
+
+		_, err = gate.CreateSession(minimal, user, gate.SessionConfig{})
+		if !errors.As(err, &missing) || missing.Service != "session store" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, _, err = gate.IssueAPIKey(minimal, user, "key", nil, 0)
+		if !errors.As(err, &missing) || missing.Service != "API key service" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("credentials", func(t *testing.T) {
+		_, err := driver.Login(map[string]string{"password": "password"})
+		var missing gate.MissingCredentialsError
+		if !errors.Is(err, gate.ErrMissingCredentials) || !errors.As(err, &missing) || missing.Credential != "email" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = driver.Login(map[string]string{"email": "email@local", "password": "wrong"})
+		if !errors.Is(err, gate.ErrInvalidCredentials) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("tokens", func(t *testing.T) {
+		_, err := driver.Authenticate("malformed")
+		if !errors.Is(err, gate.ErrTokenMalformed) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		otherConfig, err := gate.NewHMACJWTConfig("HS256", "another-secret", time.Hour*1, false)
+		test.AssertOK(t, err, "valid JWT config")
+
+		other := gate.NewJWTService(otherConfig)
+		forged, err := other.Issue(other.NewClaims(user))
+		test.AssertOK(t, err, "valid token")
+
+		_, err = driver.Authenticate(forged.Value)
+		if !errors.Is(err, gate.ErrTokenSignature) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		service, err := driver.JWTService()
+		test.AssertOK(t, err, "valid JWT service")
+
+		claims := service.NewClaims(user)
+		claims.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+		expired, err := service.Issue(claims)
+		test.AssertOK(t, err, "valid token")
+
+		_, err = driver.Authenticate(expired.Value)
+		if !errors.Is(err, gate.ErrTokenExpired) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		token, err := driver.IssueJWT(user)
+		test.AssertOK(t, err, "valid token")
+
+		_, err = driver.Authenticate(token.Value)
+		test.AssertOK(t, err, "valid token")
+	})
+
+	t.Run("kinds", func(t *testing.T) {
+		kinds := []struct {
+			err  error
+			kind error
+		}{
+			{gate.ErrInvalidAPIKey, gate.ErrInvalidCredentials},
+			{gate.ErrAPIKeyExpired, gate.ErrTokenExpired},
+			{gate.ErrAPIKeyRevoked, gate.ErrTokenRevoked},
+			{gate.ErrInvalidSession, gate.ErrInvalidCredentials},
+			{gate.ErrSessionExpired, gate.ErrTokenExpired},
+		}
+
+		for _, kind := range kinds {
+			err := errors.Wrap(kind.err, "wrapped")
+			if !errors.Is(err, kind.kind) || !errors.Is(err, kind.err) || errors.Cause(err) != kind.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		if gate.ErrorLabel(gate.ErrAPIKeyExpired) != "api_key_expired" || gate.ErrorLabel(gate.ErrInvalidSession) != "invalid_session" {
+			t.Fatalf("unexpected labels: %s, %s", gate.ErrorLabel(gate.ErrAPIKeyExpired), gate.ErrorLabel(gate.ErrInvalidSession))
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		config, err := gate.NewHMACJWTConfig("HS256", 0, time.Hour*1, false)
+		test.AssertOK(t, err, "valid JWT config")
+
+		service := gate.NewJWTService(config)
+		_, err = service.Issue(service.NewClaims(user))
+		if !errors.Is(err, gate.ErrInvalidKey) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestPasswordAudit(t *testing.T) {
+	account := fixtures.Account{Email: "new@local", Password: "password"}
+	sink := audit.NewMemorySink()
+
+	container := dependency.NewContainer(
+		fixtures.NewMyUserService(nil, []string{"local"}),
+		fixtures.NewMyTokenService(nil),
+		fixtures.NewMyRoleService([]fixtures.Role{{ID: "member", Abilities: []fixtures.Ability{{Action: "GET", Object: "/posts"}}}}),
+	)
+	container.SetAuditSink(sink)
+	container.SetSessionStore(session.NewMemoryStore())
+
+	driver := fixtures.NewPasswordDriver(
+		password.Config{
+			Config:       gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+			Provisioning: gate.ProvisioningPolicy{DefaultRoles: []string{"member"}},
+		},
+		account,
+		container,
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	_, err := driver.Login(map[string]string{"email": "new@local", "password": "wrong"})
+	test.AssertErr(t, err, "invalid credentials")
+
+	user, err := driver.Login(map[string]string{"email": "new@local", "password": "password"})
+	test.AssertOK(t, err, "valid credentials")
+
+	token, err := driver.IssueJWT(user)
+	test.AssertOK(t, err, "valid token")
+
+	created, err := gate.CreateSession(driver, user, gate.SessionConfig{})
+	test.AssertOK(t, err, "valid user")
+
+	err = gate.DestroySession(driver, created.ID)
+	test.AssertOK(t, err, "valid session")
+
+	err = gate.DestroySession(driver, created.ID)
+	test.AssertOK(t, err, "destroyed session")
+
+	err = driver.Authorize(user, "GET", "/posts")
+	test.AssertOK(t, err, "allowed")
+
+	err = driver.Authorize(user, "DELETE", "/posts")
+	test.AssertErr(t, err, "denied")
+
+	_, err = driver.Authenticate("invalid")
+	test.AssertErr(t, err, "malformed token")
+
+	expected := []gate.AuditEvent{
+		{Type: gate.EventLoginFailed, Provider: password.Provider, Login: "new@local", Reason: "invalid credentials"},
+		{Type: gate.EventUserProvisioned, Provider: password.Provider, UserID: user.GetID(), Email: "new@local"},
+		{Type: gate.EventLoginSucceeded, Provider: password.Provider, Login: "new@local", UserID: user.GetID(), Email: "new@local"},
+		{Type: gate.EventTokenIssued, UserID: user.GetID(), TokenID: token.ID, TokenKind: gate.TokenKindJWT},
+		{Type: gate.EventTokenIssued, UserID: user.GetID(), TokenKind: gate.TokenKindSession},
+		{Type: gate.EventTokenRevoked, UserID: user.GetID(), TokenKind: gate.TokenKindSession},
+		{Type: gate.EventAuthorizationAllowed, UserID: user.GetID(), Action: "GET", Object: "/posts"},
+		{Type: gate.EventAuthorizationDenied, UserID: user.GetID(), Action: "DELETE", Object: "/posts", Reason: "forbidden"},
+		{Type: gate.EventAuthenticationFailed, Reason: "malformed token"},
+	}
+
+	events := sink.Events()
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected events: %v", events)
+	}
+
+	for i, event := range events {
+		if event.Time.IsZero() {
+			t.Fatalf("unexpected zero time: %v", event)
+		}
+
+		event.Time = time.Time{}
+		if event != expected[i] {
+			t.Fatalf("unexpected event %d: %v", i, event)
+		}
+	}
+}
+
+func TestPasswordMetrics(t *testing.T) {
+	account := fixtures.Account{Email: "new@local", Password: "password"}
+	registry := metrics.NewRegistry(nil)
+
+	container := dependency.NewContainer(
+		fixtures.NewMyUserService(nil, []string{"local"}),
+		fixtures.NewMyTokenService(nil),
+		fixtures.NewMyRoleService([]fixtures.Role{{ID: "member", Abilities: []fixtures.Ability{{Action: "GET", Object: "/posts"}}}}),
+	)
+	container.SetInstrumentation(registry)
+
+	driver := fixtures.NewPasswordDriver(
+		password.Config{
+			Config:       gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+			Provisioning: gate.ProvisioningPolicy{DefaultRoles: []string{"member"}},
+		},
+		account,
+		container,
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	_, err := driver.Login(map[string]string{"email": "new@local", "password": "wrong"})
+	test.AssertErr(t, err, "invalid credentials")
+
+	user, err := driver.Login(map[string]string{"email": "new@local", "password": "password"})
+	test.AssertOK(t, err, "valid credentials")
+
+	token, err := driver.IssueJWT(user)
+	test.AssertOK(t, err, "valid token")
+
+	_, err = driver.Authenticate(token.Value)
+	test.AssertOK(t, err, "valid token")
+
+	_, err = driver.Authenticate("malformed")
+	test.AssertErr(t, err, "malformed token")
+
+	for i := 0; i < 2; i++ {
+		err = driver.Authorize(user, "GET", "/posts")
+		test.AssertOK(t, err, "allowed")
+	}
+
+	err = driver.Authorize(user, "DELETE", "/posts")
+	test.AssertErr(t, err, "denied")
+
+	expected := []struct {
+		name   string
+		labels map[string]string
+		value  float64
+	}{
+		{gate.MetricLogins, map[string]string{"provider": password.Provider, "result": "invalid_credentials"}, 1},
+		{gate.MetricLogins, map[string]string{"provider": password.Provider, "result": "success"}, 1},
+		{gate.MetricJWTOperations, map[string]string{"operation": "issue", "result": "success"}, 1},
+		{gate.MetricJWTOperations, map[string]string{"operation": "parse", "result": "success"}, 1},
+		{gate.MetricJWTOperations, map[string]string{"operation": "parse", "result": "token_malformed"}, 1},
+		{gate.MetricAuthentications, map[string]string{"result": "success"}, 1},
+		{gate.MetricAuthentications, map[string]string{"result": "token_malformed"}, 1},
+		{gate.MetricAuthorizations, map[string]string{"result": "success"}, 2},
+		{gate.MetricAuthorizations, map[string]string{"result": "forbidden"}, 1},
+		{gate.MetricMatcherCacheLookups, map[string]string{"result": "miss"}, 2},
+		{gate.MetricMatcherCacheLookups, map[string]string{"result": "hit"}, 3},
+	}
+
+	for _, metric := range expected {
+		if value := registry.Counter(metric.name, metric.labels); value != metric.value {
+			t.Fatalf("unexpected %s%v: %v", metric.name, metric.labels, value)
+		}
+	}
+}
+
+func TestPasswordTenants(t *testing.T) {
+	user := fixtures.User{
+		ID:          "id",
+		Email:       "member@local",
+		TenantRoles: map[string][]string{"acme": {"admin"}, "globex": {"viewer"}},
+	}
+	sink := audit.NewMemorySink()
+
+	container := dependency.NewContainer(
+		fixtures.NewMyUserService([]fixtures.User{user}, nil),
+		fixtures.NewMyTokenService(nil),
+		fixtures.NewMyRoleService([]fixtures.Role{
+			{ID: "admin", Abilities: []fixtures.Ability{{Action: "GET", Object: "/posts"}, {Action: "DELETE", Object: "/posts"}}},
+			{ID: "viewer", Abilities: []fixtures.Ability{{Action: "GET", Object: "/posts"}}},
+		}),
+	)
+	container.SetAuditSink(sink)
+
+	driver := password.New(
+		password.Config{
+			Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+		},
+		password.LoginFuncStub,
+		container,
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	t.Run("authorize", func(t *testing.T) {
+		err := gate.AuthorizeTenant(driver, user, "acme", "DELETE", "/posts")
+		test.AssertOK(t, err, "admin of the tenant")
+
+		err = gate.AuthorizeTenant(driver, user, "globex", "GET", "/posts")
+		test.AssertOK(t, err, "viewer of the tenant")
+
+		err = gate.AuthorizeTenant(driver, user, "globex", "DELETE", "/posts")
+		if !errors.Is(err, gate.ErrForbidden) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = gate.AuthorizeTenant(driver, user, "initech", "GET", "/posts")
+		if !errors.Is(err, gate.ErrNotTenantMember) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		err = driver.Authorize(user, "GET", "/posts")
+		if !errors.Is(err, gate.ErrNoAbilities) {
+			t.Fatalf("tenant roles should not leak to the flat roles: %v", err)
+		}
+
+		denied := sink.Events(gate.EventAuthorizationDenied)
+		if len(denied) != 3 || denied[0].Tenant != "globex" || denied[1].Tenant != "initech" || denied[2].Tenant != "" {
+			t.Fatalf("unexpected events: %v", denied)
+		}
+	})
+
+	t.Run("abilities", func(t *testing.T) {
+		abilities, err := gate.GetUserTenantAbilities(driver, user, "acme")
+		test.AssertOK(t, err, "member of the tenant")
+		if len(abilities) != 2 {
+			t.Fatalf("unexpected abilities: %v", abilities)
+		}
+
+		abilities, err = gate.GetUserTenantAbilities(driver, user, "globex")
+		test.AssertOK(t, err, "member of the tenant")
+		if len(abilities) != 1 || abilities[0].GetAction() != "GET" {
+			t.Fatalf("unexpected abilities: %v", abilities)
+		}
+
+		_, err = gate.GetUserTenantAbilities(driver, user, "initech")
+		test.AssertErr(t, err, "not a member of the tenant")
+	})
+
+	t.Run("jwt", func(t *testing.T) {
+		_, err := gate.IssueTenantJWT(driver, user, "initech")
+		test.AssertErr(t, err, "not a member of the tenant")
+
+		token, err := gate.IssueTenantJWT(driver, user, "globex")
+		test.AssertOK(t, err, "member of the tenant")
+		if token.Tenant != "globex" {
+			t.Fatalf("unexpected tenant: %s", token.Tenant)
+		}
+
+		parts := strings.Split(token.Value, ".")
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		test.AssertOK(t, err, "valid payload")
+		if !strings.Contains(string(payload), `"roles":["viewer"]`) || !strings.Contains(string(payload), `"tenant":"globex"`) {
+			t.Fatalf("unexpected claims: %s", payload)
+		}
+
+		authenticated, err := driver.Authenticate(token.Value)
+		test.AssertOK(t, err, "valid token")
+
+		member, ok := authenticated.(gate.TenantMember)
+		if !ok || member.Tenant != "globex" {
+			t.Fatalf("unexpected user: %v", authenticated)
+		}
+
+		err = driver.Authorize(authenticated, "GET", "/posts")
+		test.AssertOK(t, err, "viewer of the tenant")
+
+		err = driver.Authorize(authenticated, "DELETE", "/posts")
+		if !errors.Is(err, gate.ErrForbidden) {
+			t.Fatalf("the token should not grant the roles of another tenant: %v", err)
+		}
+
+		err = gate.AuthorizeTenant(driver, authenticated, "acme", "DELETE", "/posts")
+		if !errors.Is(err, gate.ErrTenantMismatch) || !errors.Is(err, gate.ErrNotTenantMember) {
+			t.Fatalf("the token should not be re-bound to another tenant: %v", err)
+		}
+
+		err = gate.AuthorizeTenant(driver, authenticated, "globex", "GET", "/posts")
+		test.AssertOK(t, err, "viewer of the bound tenant")
+
+		refreshed, err := gate.RefreshJWT(driver, token.Value)
+		test.AssertOK(t, err, "valid token")
+		if refreshed.Tenant != "globex" {
+			t.Fatalf("unexpected tenant: %s", refreshed.Tenant)
+		}
+	})
+}
+
+func TestPasswordRelations(t *testing.T) {
+	alice := fixtures.User{ID: "alice", Email: "alice@local", Roles: []string{"member"}, TenantRoles: map[string][]string{"acme": {"member"}}}
+	bob := fixtures.User{ID: "bob", Email: "bob@local", Roles: []string{"member"}}
+
+	store := relation.NewMemoryStore()
+	err := store.Write(
+		relation.Tuple{Object: "document:42", Relation: "owner", Subject: "alice"},
+		relation.Tuple{Object: "document:42", Relation: "viewer", Subject: "bob"},
+	)
+	test.AssertOK(t, err, "valid tuples")
+
+	container := dependency.NewContainer(
+		fixtures.NewMyUserService([]fixtures.User{alice, bob}, nil),
+		fixtures.NewMyTokenService(nil),
+		fixtures.NewMyRoleService([]fixtures.Role{{ID: "member", Abilities: []fixtures.Ability{{Action: "GET", Object: "/posts"}}}}),
+	)
+	relations, err := relation.New(store, relation.Schema{
+		"document": {
+			Relations: map[string]relation.Rewrite{
+				"owner":  {},
+				"editor": {Implied: []string{"owner"}},
+				"viewer": {Implied: []string{"editor"}},
+			},
+			Actions: map[string]string{"view": "viewer", "edit": "editor"},
+		},
+	})
+	test.AssertOK(t, err, "valid schema")
+	container.SetRelationService(relations)
+
+	driver := password.New(
+		password.Config{
+			Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+		},
+		password.LoginFuncStub,
+		container,
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	err = driver.Authorize(alice, "edit", "document:42")
+	test.AssertOK(t, err, "owner implies editor")
+
+	err = driver.Authorize(bob, "view", "document:42")
+	test.AssertOK(t, err, "viewer")
+
+	err = driver.Authorize(bob, "edit", "document:42")
+	if !errors.Is(err, gate.ErrForbidden) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = driver.Authorize(alice, "archive", "document:42")
+	if !errors.Is(err, gate.ErrForbidden) {
+		t.Fatalf("unmapped actions should be forbidden: %v", err)
+	}
+
+	err = driver.Authorize(bob, "GET", "/posts")
+	test.AssertOK(t, err, "objects out of the schema are authorized by roles")
+
+	err = driver.Authorize(gate.APIKeyUser{User: alice, Key: gate.APIKey{Scopes: []gate.Ability{{Action: "view", Object: "document:*"}}}}, "edit", "document:42")
+	if !errors.Is(err, gate.ErrForbidden) {
+		t.Fatalf("scopes should restrict the relations: %v", err)
+	}
+
+	denying := abilitiesDenial{*driver, []gate.UserAbility{gate.Ability{Action: "edit", Object: "document:*"}}}
+	err = gate.Authorize(denying, alice, "edit", "document:42")
+	if !errors.Is(err, gate.ErrForbidden) {
+		t.Fatalf("denied abilities should restrict the relations: %v", err)
+	}
+
+	member, err := gate.NewTenantMember(alice, "acme")
+	test.AssertOK(t, err, "member of the tenant")
+
+	err = driver.Authorize(member, "edit", "document:42")
+	test.AssertOK(t, err, "owner in the bound tenant")
+
+	err = driver.Authorize(gate.TenantMember{User: alice, Tenant: "globex"}, "edit", "document:42")
+	if !errors.Is(err, gate.ErrNotTenantMember) {
+		t.Fatalf("tenants should restrict the relations: %v", err)
+	}
+
+	err = driver.Authorize(nil, "view", "document:42")
+	test.AssertErr(t, err, "missing user")
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	roles, err := policy.Parse([]byte(`roles:
+  viewer:
+    allow:
+      - {action: GET, object: /posts/*}
+  editor:
+    inherits: [viewer]
+    allow:
+      - {action: POST, object: /posts/*}
+    deny:
+      - {action: GET, object: /posts/drafts/*}
+`))
+	test.AssertOK(t, err, "valid policy")
+
+	viewer := fixtures.User{ID: "viewer", Email: "viewer@local", Roles: []string{"viewer"}}
+	editor := fixtures.User{ID: "editor", Email: "editor@local", Roles: []string{"editor"}}
+	both := fixtures.User{ID: "both", Email: "both@local", Roles: []string{"viewer", "editor"}}
+
+	driver := password.New(
+		password.Config{
+			Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+		},
+		password.LoginFuncStub,
+		dependency.NewContainer(fixtures.NewMyUserService(nil, nil), fixtures.NewMyTokenService(nil), roles),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	cases := []struct {
+		user    gate.User
+		action  string
+		object  string
+		allowed bool
+	}{
+		{viewer, "GET", "/posts/1", true},
+		{viewer, "GET", "/posts/drafts/1", true},
+		{viewer, "POST", "/posts/1", false},
+		{editor, "GET", "/posts/1", true},
+		{editor, "POST", "/posts/1", true},
+		{editor, "GET", "/posts/drafts/1", false},
+		{both, "GET", "/posts/drafts/1", false},
+	}
+
+	for _, c := range cases {
+		err = driver.Authorize(c.user, c.action, c.object)
+		if (err == nil) != c.allowed {
+			t.Fatalf("unexpected authorization of %s to %s %s: %v", c.user.GetID(), c.action, c.object, err)
+		}
+	}
+
+	abilities, err := driver.GetUserAbilities(editor)
+	test.AssertOK(t, err, "valid abilities")
+
+	for _, ability := range abilities {
+		if ability.GetObject() == "/posts/drafts/*" {
+			t.Fatalf("unexpected denied ability: %v", ability)
+		}
+	}
+
+	denied, err := gate.GetUserDeniedAbilities(driver, editor)
+	test.AssertOK(t, err, "valid denied abilities")
+
+	if len(denied) != 1 || denied[0].GetAction() != "GET" || denied[0].GetObject() != "/posts/drafts/*" {
+		t.Fatalf("unexpected denied abilities: %v", denied)
+	}
+	overriding := abilitiesOverride{*driver, []gate.UserAbility{gate.Ability{Action: "*", Object: "*"}}}
+	err = gate.Authorize(overriding, viewer, "POST", "/posts/1")
+	test.AssertOK(t, err, "overridden abilities")
+
+	err = gate.Authorize(overriding, editor, "GET", "/posts/drafts/1")
+	if !errors.Is(err, gate.ErrForbidden) {
+		t.Fatalf("denied abilities should override the overridden abilities: %v", err)
+	}
+}
+
+type minimalAuth struct {
+	gate.Auth
+}
+
+type abilitiesOverride struct {
+	password.Driver
+	abilities []gate.UserAbility
+}
+
+func (auth abilitiesOverride) GetUserAbilities(user gate.User) ([]gate.UserAbility, error) {
+	return auth.abilities, nil
+}
+
+type abilitiesDenial struct {
+	password.Driver
+	denied []gate.UserAbility
+}
+
+func (auth abilitiesDenial) GetUserDeniedAbilities(user gate.User) ([]gate.UserAbility, error) {
+	return auth.denied, nil
+}
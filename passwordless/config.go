@@ -0,0 +1,25 @@
+package passwordless
+
+import (
+	"time"
+
+	"github.com/hiendv/gate"
+)
+
+// Config is the configuration for passwordless authentication
+type Config struct {
+	gate.Config
+	LinkURL        string
+	LinkExpiration time.Duration
+	// LinkResendInterval is the minimum interval between the links sent to an email
+	LinkResendInterval time.Duration
+	CodeExpiration     time.Duration
+	CodeAttempts       int
+	// CodeSecret keys the hashes of the one-time codes so a leaked store does not allow guessing them offline.
+	// It is required by the codes, a driver only sending links may omit it
+	CodeSecret string
+	// CodeResendInterval is the minimum interval between the codes sent to an email
+	CodeResendInterval time.Duration
+	// Provisioning decides whether and how unknown users are created on login
+	Provisioning gate.ProvisioningPolicy
+}
@@ -0,0 +1,2 @@
+// Package passwordless is the passwordless email authentication driver for github.com/hiendv/gate. It delivers single-use magic links and one-time codes through a Mailer.
+package passwordless
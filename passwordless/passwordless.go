@@ -0,0 +1,465 @@
+package passwordless
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal"
+	"github.com/pkg/errors"
+)
+
+// Provider is the provider of users created by passwordless authentication
+const Provider = "passwordless"
+
+var (
+	// ErrTooManyAttempts is thrown when a code is burnt after too many wrong attempts. It matches gate.ErrInvalidCredentials
+	ErrTooManyAttempts error = gate.KindError{Message: "too many attempts", Kind: gate.ErrInvalidCredentials}
+
+	// ErrCodeSentRecently is thrown when a code is requested before the resend interval of the previous one
+	ErrCodeSentRecently = errors.New("a code was sent recently")
+
+	// ErrLinkSentRecently is thrown when a link is requested before the resend interval of the previous one
+	ErrLinkSentRecently = errors.New("a link was sent recently")
+
+	errMissingCodeSecret = errors.New("the codes require a code secret")
+)
+
+// Default values used when the configuration omits them
+const (
+	DefaultLinkExpiration     = time.Minute * 15
+	DefaultLinkResendInterval = time.Minute
+	DefaultCodeExpiration     = time.Minute * 10
+	DefaultCodeAttempts       = 5
+	DefaultCodeResendInterval = time.Minute
+)
+
+// Driver is passwordless email authentication
+type Driver struct {
+	dependency.Container
+	config        Config
+	mailer        Mailer
+	tokens        LoginTokenService
+	Now           func() time.Time
+	GenerateToken func() (string, error)
+	GenerateCode  func() (string, error)
+}
+
+// Account is the account identified by its email
+type Account struct {
+	Email string
+}
+
+// GetName returns the account's name
+func (account Account) GetName() string {
+	return ""
+}
+
+// GetEmail returns the account's email
+func (account Account) GetEmail() string {
+	return account.Email
+}
+
+// New is the constructor for Driver
+func New(config Config, mailer Mailer, tokens LoginTokenService, container dependency.Container) *Driver {
+	var driver = &Driver{}
+
+	if config.LinkExpiration == 0 {
+		config.LinkExpiration = DefaultLinkExpiration
+	}
+
+	if config.LinkResendInterval == 0 {
+		config.LinkResendInterval = DefaultLinkResendInterval
+	}
+
+	if config.CodeExpiration == 0 {
+		config.CodeExpiration = DefaultCodeExpiration
+	}
+
+	if config.CodeAttempts == 0 {
+		config.CodeAttempts = DefaultCodeAttempts
+	}
+
+	if config.CodeResendInterval == 0 {
+		config.CodeResendInterval = DefaultCodeResendInterval
+	}
+	driver.config = config
+
+	if mailer == nil || tokens == nil {
+		return nil
+	}
+	driver.mailer = mailer
+	driver.tokens = tokens
+
+	driver.Now = func() time.Time {
+		return time.Now().Local()
+	}
+	driver.GenerateToken = func() (string, error) {
+		token := make([]byte, 32)
+		_, err := rand.Read(token)
+		if err != nil {
+			return "", err
+		}
+
+		return base64.RawURLEncoding.EncodeToString(token), nil
+	}
+	driver.GenerateCode = func() (string, error) {
+		code, err := rand.Int(rand.Reader, big.NewInt(1000000))
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("%06d", code.Int64()), nil
+	}
+
+	jwtConfig, err := gate.NewHMACJWTConfig("HS256", config.JWTSigningKey(), config.JWTExpiration(), config.JWTSkipClaimsValidation())
+	if err != nil {
+		return nil
+	}
+
+	container.SetJWTService(gate.NewJWTService(jwtConfig))
+	container.SetMatcher(internal.NewMatcher())
+	driver.Container = container
+
+	return driver
+}
+
+// LoginURL returns the URL to the consent page
+func (auth Driver) LoginURL(state string) (string, error) {
+	return "", errors.New("the driver does not support login URL")
+}
+
+// SendLink issues a magic link token for the given email and delivers it with the mailer.
+// Another link is not sent to the email before the resend interval
+func (auth Driver) SendLink(email string) (err error) {
+	email = normalize(email)
+	if email == "" {
+		err = gate.MissingCredentialsError{Credential: "email"}
+		return
+	}
+
+	now := auth.Now()
+	sent, err := auth.tokens.FindOneByID(linkSentID(email))
+	if err == nil && now.Before(sent.IssuedAt.Add(auth.config.LinkResendInterval)) {
+		err = ErrLinkSentRecently
+		return
+	}
+
+	if err != nil && !auth.tokens.IsErrNotFound(err) {
+		err = errors.Wrap(err, "could not find the last link")
+		return
+	}
+
+	secret, err := auth.GenerateToken()
+	if err != nil {
+		err = errors.Wrap(err, "could not generate the token")
+		return
+	}
+
+	token := LoginToken{
+		ID:        hash(secret),
+		Kind:      KindLink,
+		Email:     email,
+		Hash:      hash(secret),
+		IssuedAt:  now,
+		ExpiredAt: now.Add(auth.config.LinkExpiration),
+	}
+
+	err = auth.tokens.Store(token)
+	if err != nil {
+		err = errors.Wrap(err, "could not store the token")
+		return
+	}
+
+	err = auth.tokens.Store(LoginToken{
+		ID:        linkSentID(email),
+		Kind:      KindLinkSent,
+		Email:     email,
+		IssuedAt:  now,
+		ExpiredAt: now.Add(auth.config.LinkResendInterval),
+	})
+	if err != nil {
+		err = errors.Wrap(err, "could not store the last link")
+		return
+	}
+
+	message := Message{
+		Kind:      KindLink,
+		Email:     email,
+		Secret:    secret,
+		ExpiredAt: token.ExpiredAt,
+	}
+
+	if auth.config.LinkURL != "" {
+		message.URL, err = linkURL(auth.config.LinkURL, secret)
+		if err != nil {
+			return
+		}
+	}
+
+	err = auth.mailer.Send(message)
+	if err != nil {
+		err = errors.Wrap(err, "could not send the link")
+		return
+	}
+	return
+}
+
+// SendCode issues a one-time code for the given email and delivers it with the mailer.
+// A new code replaces the previous one once the resend interval is over, so resending does not reset the attempts more often
+func (auth Driver) SendCode(email string) (err error) {
+	if auth.config.CodeSecret == "" {
+		err = errMissingCodeSecret
+		return
+	}
+
+	email = normalize(email)
+	if email == "" {
+		err = gate.MissingCredentialsError{Credential: "email"}
+		return
+	}
+
+	now := auth.Now()
+	previous, err := auth.tokens.FindOneByID(codeID(email))
+	if err == nil && now.Before(previous.IssuedAt.Add(auth.config.CodeResendInterval)) {
+		err = ErrCodeSentRecently
+		return
+	}
+
+	if err != nil && !auth.tokens.IsErrNotFound(err) {
+		err = errors.Wrap(err, "could not find the code")
+		return
+	}
+
+	code, err := auth.GenerateCode()
+	if err != nil {
+		err = errors.Wrap(err, "could not generate the code")
+		return
+	}
+
+	token := LoginToken{
+		ID:        codeID(email),
+		Kind:      KindCode,
+		Email:     email,
+		Hash:      auth.codeHash(email, code),
+		IssuedAt:  now,
+		ExpiredAt: now.Add(auth.config.CodeExpiration),
+	}
+
+	err = auth.tokens.Store(token)
+	if err != nil {
+		err = errors.Wrap(err, "could not store the code")
+		return
+	}
+
+	err = auth.mailer.Send(Message{
+		Kind:      KindCode,
+		Email:     email,
+		Secret:    code,
+		ExpiredAt: token.ExpiredAt,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "could not send the code")
+		return
+	}
+	return
+}
+
+// Login resolves passwordless authentication with either a "token" from a magic link or an "email" and a "code"
+func (auth Driver) Login(credentials map[string]string) (user gate.User, err error) {
+	defer func(start time.Time) {
+		gate.AuditLogin(auth, Provider, credentials["email"], user, err)
+		gate.MeasureLogin(auth, Provider, start, err)
+	}(time.Now())
+
+	var email string
+	if secret, ok := credentials["token"]; ok {
+		email, err = auth.consumeLink(secret)
+	} else if code, ok := credentials["code"]; ok {
+		email, err = auth.consumeCode(credentials["email"], code)
+	} else {
+		err = gate.MissingCredentialsError{Credential: "token or code"}
+		return
+	}
+
+	if err != nil {
+		err = errors.Wrap(err, "could not login")
+		return
+	}
+
+	return gate.LoginAccount(auth, Provider, Account{Email: email}, nil, auth.config.Provisioning)
+}
+
+// IssueJWT issues and stores a JWT for a specific user
+func (auth Driver) IssueJWT(user gate.User) (gate.JWT, error) {
+	return gate.IssueJWT(auth, user)
+}
+
+// ParseJWT parses a JWT string to a JWT
+func (auth Driver) ParseJWT(tokenString string) (gate.JWT, error) {
+	return gate.ParseJWT(auth, tokenString)
+}
+
+// Authenticate performs the authentication using JWT
+func (auth Driver) Authenticate(tokenString string) (gate.User, error) {
+	return gate.Authenticate(auth, tokenString)
+}
+
+// GetUserFromJWT returns a user from a given JWT
+func (auth Driver) GetUserFromJWT(token gate.JWT) (user gate.User, err error) {
+	return gate.GetUserFromJWT(auth, token)
+}
+
+// Authorize performs the authorization when a given user takes an action on an object using RBAC
+func (auth Driver) Authorize(user gate.User, action, object string) (err error) {
+	return gate.Authorize(auth, user, action, object)
+}
+
+// GetUserAbilities returns a user's abilities
+func (auth Driver) GetUserAbilities(user gate.User) (abilities []gate.UserAbility, err error) {
+	return gate.GetUserAbilities(auth, user)
+}
+
+func (auth Driver) consumeLink(secret string) (email string, err error) {
+	if secret == "" {
+		err = errors.Wrap(gate.ErrInvalidCredentials, "invalid token")
+		return
+	}
+
+	token, err := auth.tokens.FindOneByID(hash(secret))
+	if err != nil {
+		if auth.tokens.IsErrNotFound(err) {
+			err = errors.Wrap(gate.ErrInvalidCredentials, "invalid token")
+			return
+		}
+
+		err = errors.Wrap(err, "could not find the token")
+		return
+	}
+
+	if token.Kind != KindLink {
+		err = errors.Wrap(gate.ErrInvalidCredentials, "invalid token")
+		return
+	}
+
+	return auth.consume(token)
+}
+
+func (auth Driver) consumeCode(email, code string) (result string, err error) {
+	if auth.config.CodeSecret == "" {
+		err = errMissingCodeSecret
+		return
+	}
+
+	email = normalize(email)
+	if email == "" {
+		err = gate.MissingCredentialsError{Credential: "email"}
+		return
+	}
+
+	token, err := auth.tokens.FindOneByID(codeID(email))
+	if err != nil {
+		if auth.tokens.IsErrNotFound(err) {
+			err = errors.Wrap(gate.ErrInvalidCredentials, "invalid code")
+			return
+		}
+
+		err = errors.Wrap(err, "could not find the code")
+		return
+	}
+
+	if token.Kind != KindCode {
+		err = errors.Wrap(gate.ErrInvalidCredentials, "invalid code")
+		return
+	}
+
+	// The attempt is counted before the comparison so parallel guesses cannot exceed the limit
+	attempts, err := auth.tokens.IncrementAttempts(token.ID)
+	if err != nil {
+		if auth.tokens.IsErrNotFound(err) {
+			err = errors.Wrap(gate.ErrInvalidCredentials, "invalid code")
+			return
+		}
+
+		err = errors.Wrap(err, "could not count the attempt")
+		return
+	}
+
+	if attempts <= auth.config.CodeAttempts && subtle.ConstantTimeCompare([]byte(token.Hash), []byte(auth.codeHash(email, code))) == 1 {
+		return auth.consume(token)
+	}
+
+	if attempts >= auth.config.CodeAttempts {
+		// The code is burnt after too many attempts. It is kept so another code is not sent before the resend interval
+		err = ErrTooManyAttempts
+		return
+	}
+
+	err = errors.Wrap(gate.ErrInvalidCredentials, "invalid code")
+	return
+}
+
+func (auth Driver) consume(token LoginToken) (email string, err error) {
+	// Deleting first guarantees the token is consumed only once
+	err = auth.tokens.Delete(token.ID)
+	if err != nil {
+		err = errors.Wrap(err, "could not consume the token")
+		return
+	}
+
+	if auth.Now().After(token.ExpiredAt) {
+		err = gate.ErrTokenExpired
+		return
+	}
+
+	email = token.Email
+	return
+}
+
+func linkURL(base, secret string) (result string, err error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		err = errors.Wrap(err, "invalid link URL")
+		return
+	}
+
+	query := link.Query()
+	query.Set("token", secret)
+	link.RawQuery = query.Encode()
+
+	result = link.String()
+	return
+}
+
+func (auth Driver) codeHash(email, code string) string {
+	mac := hmac.New(sha256.New, []byte(auth.config.CodeSecret))
+	mac.Write([]byte(email + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func codeID(email string) string {
+	return hash(KindCode + ":" + email)
+}
+
+func linkSentID(email string) string {
+	return hash(KindLinkSent + ":" + email)
+}
+
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
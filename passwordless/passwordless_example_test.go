@@ -0,0 +1,94 @@
+package passwordless_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal/test/fixtures"
+	"github.com/hiendv/gate/passwordless"
+)
+
+func Example() {
+	var auth gate.Auth
+
+	userService := fixtures.NewMyUserService(
+		[]fixtures.User{
+			{
+				ID:    "id",
+				Email: "email@local",
+				Roles: []string{"role-id"},
+			},
+		},
+		[]string{"local"},
+	)
+	tokenService := fixtures.NewMyTokenService(nil)
+	roleService := fixtures.NewMyRoleService([]fixtures.Role{
+		{
+			ID: "role-id",
+			Abilities: []fixtures.Ability{
+				{Action: "GET", Object: "/api/v1/*"},
+			},
+		},
+	})
+	mailer := &fixtures.MyMailer{}
+
+	driver := passwordless.New(
+		passwordless.Config{
+			Config:     gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+			LinkURL:    "http://localhost:8080/login",
+			CodeSecret: "code-secret",
+		},
+		mailer,
+		fixtures.NewMyLoginTokenService(),
+		dependency.NewContainer(userService, tokenService, roleService),
+	)
+	if driver == nil {
+		fmt.Println("driver should not be nil")
+		return
+	}
+
+	auth = driver
+	err := driver.SendLink("email@local")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// The user follows the link and the token comes back
+	user, err := auth.Login(map[string]string{"token": mailer.Last().Secret})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("%s:%s - %v\n", user.GetID(), user.GetEmail(), err)
+
+	err = driver.SendCode("email@local")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// The user types the code received by email
+	user, err = auth.Login(map[string]string{"email": "email@local", "code": mailer.Last().Secret})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("%s:%s - %v\n", user.GetID(), user.GetEmail(), err)
+
+	err = auth.Authorize(user, "GET", "/api/v1/users")
+	fmt.Printf("%v\n", err)
+
+	err = auth.Authorize(user, "POST", "/api/v1/users")
+	fmt.Printf("%v\n", err)
+
+	// Output:
+	// id:email@local - <nil>
+	// id:email@local - <nil>
+	// <nil>
+	// forbidden
+}
@@ -0,0 +1,377 @@
+package passwordless_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal/test"
+	"github.com/hiendv/gate/internal/test/fixtures"
+	"github.com/hiendv/gate/passwordless"
+	"github.com/pkg/errors"
+)
+
+var (
+	auth              gate.Auth
+	driver            *passwordless.Driver
+	mailer            *fixtures.MyMailer
+	userService       *fixtures.MyUserService
+	tokenService      *fixtures.MyTokenService
+	roleService       *fixtures.MyRoleService
+	loginTokenService *fixtures.MyLoginTokenService
+)
+
+func TestMain(m *testing.M) {
+	roles := []fixtures.Role{
+		{
+			ID: fixtures.RandomString(8),
+			Abilities: []fixtures.Ability{
+				{Action: "GET", Object: "/api/v1/*"},
+				{Action: "POST", Object: "/api/v1/users*"},
+			},
+		},
+	}
+
+	users := []fixtures.User{
+		{
+			ID:    fixtures.RandomString(8),
+			Email: "foo@local",
+			Roles: []string{roles[0].ID},
+		},
+	}
+
+	mailer = &fixtures.MyMailer{}
+	roleService = fixtures.NewMyRoleService(roles)
+	userService = fixtures.NewMyUserService(users, []string{"local"})
+	tokenService = fixtures.NewMyTokenService(nil)
+	loginTokenService = fixtures.NewMyLoginTokenService()
+
+	driver = passwordless.New(
+		newConfig(),
+		mailer,
+		loginTokenService,
+		dependency.NewContainer(userService, tokenService, roleService),
+	)
+
+	auth = driver
+	if auth == nil {
+		os.Exit(1)
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestPasswordlessLink(t *testing.T) {
+	t.Run("valid token", func(t *testing.T) {
+		err := driver.SendLink("Foo@Local")
+		test.AssertOK(t, err, "valid email")
+
+		message := mailer.Last()
+		if message.Kind != passwordless.KindLink || message.Email != "foo@local" {
+			t.Fatalf("unexpected message: %v", message)
+		}
+
+		link, err := url.Parse(message.URL)
+		test.AssertOK(t, err, "valid link")
+
+		if link.Query().Get("token") != message.Secret || link.Query().Get("next") != "/" {
+			t.Fatalf("unexpected link: %s", message.URL)
+		}
+
+		if _, err = loginTokenService.FindOneByID(message.Secret); err == nil {
+			t.Fatal("the secret should not be stored in plain text")
+		}
+
+		user, err := auth.Login(map[string]string{"token": message.Secret})
+		test.AssertOK(t, err, "valid token")
+
+		if user.GetEmail() != "foo@local" {
+			t.Fatalf("unexpected user: %s", user.GetEmail())
+		}
+
+		_, err = auth.Login(map[string]string{"token": message.Secret})
+		test.AssertErr(t, err, "consumed token")
+	})
+
+	t.Run("first time", func(t *testing.T) {
+		now := driver.Now
+		defer func() {
+			driver.Now = now
+		}()
+
+		_, err := userService.FindOneByEmail("bar@local")
+		test.AssertErr(t, err, "non-existing user")
+
+		err = driver.SendLink("bar@local")
+		test.AssertOK(t, err, "valid email")
+
+		firstUser, err := auth.Login(map[string]string{"token": mailer.Last().Secret})
+		test.AssertOK(t, err, "valid token")
+
+		err = driver.SendLink("bar@local")
+		if err != passwordless.ErrLinkSentRecently {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		driver.Now = func() time.Time {
+			return now().Add(passwordless.DefaultLinkResendInterval)
+		}
+		err = driver.SendLink("bar@local")
+		test.AssertOK(t, err, "valid email")
+
+		secondUser, err := auth.Login(map[string]string{"token": mailer.Last().Secret})
+		test.AssertOK(t, err, "valid token")
+
+		if firstUser.GetID() != secondUser.GetID() {
+			t.Errorf("ids should be equal: %v - %v", firstUser.GetID(), secondUser.GetID())
+		}
+	})
+
+	t.Run("forbidden email", func(t *testing.T) {
+		err := driver.SendLink("qux@external")
+		test.AssertOK(t, err, "valid email")
+
+		_, err = auth.Login(map[string]string{"token": mailer.Last().Secret})
+		test.AssertErr(t, err, "forbidden email domain")
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		err := driver.SendLink(fixtures.EmailTriggeringDatabaseError)
+		test.AssertOK(t, err, "valid email")
+
+		_, err = auth.Login(map[string]string{"token": mailer.Last().Secret})
+		test.AssertErr(t, err, "database error")
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		now := driver.Now
+		defer func() {
+			driver.Now = now
+		}()
+
+		err := driver.SendLink("expired@local")
+		test.AssertOK(t, err, "valid email")
+
+		driver.Now = func() time.Time {
+			return now().Add(passwordless.DefaultLinkExpiration * 2)
+		}
+
+		_, err = auth.Login(map[string]string{"token": mailer.Last().Secret})
+		test.AssertErr(t, err, "expired token")
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		_, err := auth.Login(map[string]string{})
+		test.AssertErr(t, err, "missing token")
+
+		_, err = auth.Login(map[string]string{"token": ""})
+		test.AssertErr(t, err, "empty token")
+
+		_, err = auth.Login(map[string]string{"token": "unknown"})
+		test.AssertErr(t, err, "unknown token")
+	})
+}
+
+func TestPasswordlessCode(t *testing.T) {
+	t.Run("valid code", func(t *testing.T) {
+		err := driver.SendCode("foo@local")
+		test.AssertOK(t, err, "valid email")
+
+		message := mailer.Last()
+		if message.Kind != passwordless.KindCode || len(message.Secret) != 6 || message.URL != "" {
+			t.Fatalf("unexpected message: %v", message)
+		}
+
+		_, err = auth.Login(map[string]string{"code": message.Secret})
+		test.AssertErr(t, err, "missing email")
+
+		_, err = auth.Login(map[string]string{"email": "bar@local", "code": message.Secret})
+		test.AssertErr(t, err, "code of another email")
+
+		user, err := auth.Login(map[string]string{"email": "FOO@local", "code": message.Secret})
+		test.AssertOK(t, err, "valid code")
+
+		if user.GetEmail() != "foo@local" {
+			t.Fatalf("unexpected user: %s", user.GetEmail())
+		}
+
+		_, err = auth.Login(map[string]string{"email": "foo@local", "code": message.Secret})
+		test.AssertErr(t, err, "consumed code")
+	})
+
+	t.Run("replaced code", func(t *testing.T) {
+		generate := driver.GenerateCode
+		now := driver.Now
+		defer func() {
+			driver.GenerateCode = generate
+			driver.Now = now
+		}()
+
+		driver.GenerateCode = func() (string, error) {
+			return "123456", nil
+		}
+		err := driver.SendCode("foo@local")
+		test.AssertOK(t, err, "valid email")
+
+		unkeyed := sha256.Sum256([]byte("foo@local:123456"))
+		codes := 0
+		for _, stored := range loginTokenService.FindByEmail("foo@local") {
+			if stored.Kind != passwordless.KindCode {
+				continue
+			}
+
+			codes++
+			if stored.Hash == hex.EncodeToString(unkeyed[:]) {
+				t.Fatalf("the code should be hashed with the secret: %s", stored.Hash)
+			}
+		}
+
+		if codes != 1 {
+			t.Fatalf("unexpected codes: %d", codes)
+		}
+
+		driver.GenerateCode = func() (string, error) {
+			return "654321", nil
+		}
+		err = driver.SendCode("foo@local")
+		if err != passwordless.ErrCodeSentRecently {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		driver.Now = func() time.Time {
+			return now().Add(passwordless.DefaultCodeResendInterval)
+		}
+		err = driver.SendCode("foo@local")
+		test.AssertOK(t, err, "valid email")
+
+		_, err = auth.Login(map[string]string{"email": "foo@local", "code": "123456"})
+		test.AssertErr(t, err, "replaced code")
+
+		_, err = auth.Login(map[string]string{"email": "foo@local", "code": "654321"})
+		test.AssertOK(t, err, "valid code")
+	})
+
+	t.Run("too many attempts", func(t *testing.T) {
+		err := driver.SendCode("foo@local")
+		test.AssertOK(t, err, "valid email")
+
+		code := mailer.Last().Secret
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+
+		for i := 0; i < passwordless.DefaultCodeAttempts; i++ {
+			_, err = auth.Login(map[string]string{"email": "foo@local", "code": wrong})
+			test.AssertErr(t, err, "wrong code")
+		}
+
+		_, err = auth.Login(map[string]string{"email": "foo@local", "code": code})
+		if !errors.Is(err, passwordless.ErrTooManyAttempts) || !errors.Is(err, gate.ErrInvalidCredentials) {
+			t.Fatalf("unexpected error for a burnt code: %v", err)
+		}
+
+		err = driver.SendCode("foo@local")
+		if err != passwordless.ErrCodeSentRecently {
+			t.Fatalf("a burnt code should not be replaced before the resend interval: %v", err)
+		}
+	})
+
+	t.Run("parallel attempts", func(t *testing.T) {
+		err := driver.SendCode("bar@local")
+		test.AssertOK(t, err, "valid email")
+
+		code := mailer.Last().Secret
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < passwordless.DefaultCodeAttempts*4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				auth.Login(map[string]string{"email": "bar@local", "code": wrong})
+			}()
+		}
+
+		wg.Wait()
+
+		_, err = auth.Login(map[string]string{"email": "bar@local", "code": code})
+		test.AssertErr(t, err, "every parallel attempt should be counted")
+	})
+
+	t.Run("expired code", func(t *testing.T) {
+		now := driver.Now
+		defer func() {
+			driver.Now = now
+		}()
+
+		err := driver.SendCode("baz@local")
+		test.AssertOK(t, err, "valid email")
+
+		driver.Now = func() time.Time {
+			return now().Add(passwordless.DefaultCodeExpiration * 2)
+		}
+
+		_, err = auth.Login(map[string]string{"email": "baz@local", "code": mailer.Last().Secret})
+		test.AssertErr(t, err, "expired code")
+	})
+
+	t.Run("link token as code", func(t *testing.T) {
+		err := driver.SendLink("link@local")
+		test.AssertOK(t, err, "valid email")
+
+		_, err = auth.Login(map[string]string{"email": "link@local", "code": mailer.Last().Secret})
+		test.AssertErr(t, err, "link token")
+	})
+}
+
+func TestPasswordlessJWT(t *testing.T) {
+	now := driver.Now
+	defer func() {
+		driver.Now = now
+	}()
+
+	// A link was sent to the email by the previous tests
+	driver.Now = func() time.Time {
+		return now().Add(passwordless.DefaultLinkResendInterval)
+	}
+
+	err := driver.SendLink("foo@local")
+	test.AssertOK(t, err, "valid email")
+
+	user, err := auth.Login(map[string]string{"token": mailer.Last().Secret})
+	test.AssertOK(t, err, "valid token")
+
+	token, err := auth.IssueJWT(user)
+	test.AssertOK(t, err, "valid user")
+
+	_, err = tokenService.FindOneByID(token.ID)
+	test.AssertOK(t, err, "existing token")
+
+	parsedToken, err := auth.ParseJWT(token.Value)
+	test.AssertOK(t, err, "valid token")
+
+	_, err = auth.GetUserFromJWT(parsedToken)
+	test.AssertOK(t, err, "existing user")
+
+	user, err = auth.Authenticate(token.Value)
+	test.AssertOK(t, err, "valid token")
+
+	err = auth.Authorize(user, "GET", "/api/v1/users")
+	test.AssertOK(t, err, "valid abilities")
+
+	err = auth.Authorize(user, "POST", "/api/v1/posts")
+	test.AssertErr(t, err, "invalid abilities")
+
+	_, err = auth.GetUserAbilities(user)
+	test.AssertOK(t, err, "valid abilities")
+}
@@ -0,0 +1,165 @@
+package passwordless_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal/test"
+	"github.com/hiendv/gate/internal/test/fixtures"
+	"github.com/hiendv/gate/passwordless"
+	"github.com/pkg/errors"
+)
+
+func newConfig() passwordless.Config {
+	return passwordless.Config{
+		Config:     gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+		LinkURL:    "http://localhost:8080/login?next=%2F",
+		CodeSecret: "code-secret",
+	}
+}
+
+func TestPasswordlessInvalidConfig(t *testing.T) {
+	instance := passwordless.New(
+		passwordless.Config{},
+		&fixtures.MyMailer{},
+		fixtures.NewMyLoginTokenService(),
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+
+	if instance != nil {
+		t.Fatal("unexpected non-nil driver")
+	}
+
+	config := newConfig()
+	config.CodeSecret = ""
+	mailer := &fixtures.MyMailer{}
+	instance = passwordless.New(
+		config,
+		mailer,
+		fixtures.NewMyLoginTokenService(),
+		dependency.NewContainer(nil, nil, nil),
+	)
+
+	if instance == nil {
+		t.Fatal("unexpected nil driver without the code secret")
+	}
+
+	err := instance.SendLink("email@local")
+	test.AssertOK(t, err, "links without the code secret")
+
+	err = instance.SendCode("email@local")
+	test.AssertErr(t, err, "codes without the code secret")
+
+	_, err = instance.Login(map[string]string{"email": "email@local", "code": "123456"})
+	test.AssertErr(t, err, "codes without the code secret")
+}
+
+func TestPasswordlessInvalidDependencies(t *testing.T) {
+	instance := passwordless.New(
+		newConfig(),
+		nil,
+		fixtures.NewMyLoginTokenService(),
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+
+	if instance != nil {
+		t.Fatal("unexpected non-nil driver")
+	}
+
+	instance = passwordless.New(
+		newConfig(),
+		&fixtures.MyMailer{},
+		nil,
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+
+	if instance != nil {
+		t.Fatal("unexpected non-nil driver")
+	}
+}
+
+func TestPasswordlessMailer(t *testing.T) {
+	mailer := &fixtures.MyMailer{Failing: true}
+	driver := passwordless.New(
+		newConfig(),
+		mailer,
+		fixtures.NewMyLoginTokenService(),
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	_, err := driver.LoginURL("state")
+	test.AssertErr(t, err, "unsupported login URL")
+
+	err = driver.SendLink("email@local")
+	test.AssertErr(t, err, "failing mailer")
+
+	err = driver.SendCode("email@local")
+	test.AssertErr(t, err, "failing mailer")
+
+	err = driver.SendLink(" ")
+	test.AssertErr(t, err, "missing email")
+
+	err = driver.SendCode("")
+	test.AssertErr(t, err, "missing email")
+}
+
+func TestPasswordlessGenerators(t *testing.T) {
+	driver := passwordless.New(
+		newConfig(),
+		&fixtures.MyMailer{},
+		fixtures.NewMyLoginTokenService(),
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	code, err := driver.GenerateCode()
+	test.AssertOK(t, err, "valid generator")
+
+	if len(code) != 6 {
+		t.Fatalf("unexpected code: %s", code)
+	}
+
+	driver.GenerateToken = func() (string, error) {
+		return "", errors.New("no entropy")
+	}
+	err = driver.SendLink("email@local")
+	test.AssertErr(t, err, "failing generator")
+
+	driver.GenerateCode = func() (string, error) {
+		return "", errors.New("no entropy")
+	}
+	err = driver.SendCode("email@local")
+	test.AssertErr(t, err, "failing generator")
+}
+
+func TestPasswordlessUserService(t *testing.T) {
+	mailer := &fixtures.MyMailer{}
+	driver := passwordless.New(
+		newConfig(),
+		mailer,
+		fixtures.NewMyLoginTokenService(),
+		// User, Token and Role services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	err := driver.SendLink("email@local")
+	test.AssertOK(t, err, "valid email")
+
+	_, err = driver.Login(map[string]string{"token": mailer.Last().Secret})
+	test.AssertErr(t, err, "missing user service")
+}
@@ -0,0 +1,49 @@
+package passwordless
+
+import (
+	"time"
+)
+
+// Kinds of login tokens
+const (
+	KindLink = "link"
+	KindCode = "code"
+	// KindLinkSent is the record of the last link sent to an email. It is not a login token, it throttles the links
+	KindLinkSent = "link-sent"
+)
+
+// LoginToken is the single-use login token. Only the hash of the secret is kept
+type LoginToken struct {
+	ID        string
+	Kind      string
+	Email     string
+	Hash      string
+	Attempts  int
+	IssuedAt  time.Time
+	ExpiredAt time.Time
+}
+
+// LoginTokenService is the contract which offers queries on the login token entity.
+// Store must replace the token with the same ID and Delete must fail if the token does not exist so a token is consumed only once.
+// IncrementAttempts must increment the attempts of an existing token atomically and return the new count so parallel guesses are all counted
+type LoginTokenService interface {
+	FindOneByID(string) (LoginToken, error)
+	Store(LoginToken) error
+	IncrementAttempts(string) (int, error)
+	Delete(string) error
+	IsErrNotFound(error) bool
+}
+
+// Message is the content delivered to the user
+type Message struct {
+	Kind      string
+	Email     string
+	Secret    string
+	URL       string
+	ExpiredAt time.Time
+}
+
+// Mailer is the contract which delivers login messages
+type Mailer interface {
+	Send(Message) error
+}
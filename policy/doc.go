@@ -0,0 +1,3 @@
+// Package policy contains the declarative policies of gate: roles, inherited roles and allowed or denied abilities
+// declared in a YAML or JSON file, and the role service backed by such a file, see gate.RoleService
+package policy
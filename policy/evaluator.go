@@ -0,0 +1,88 @@
+package policy
+
+import (
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal"
+	"github.com/pkg/errors"
+)
+
+var errUnsupported = errors.New("the evaluator only supports authorization")
+
+// User is a user having roles, e.g. to evaluate a policy
+type User struct {
+	ID    string
+	Roles []string
+}
+
+// GetID returns user ID
+func (user User) GetID() string {
+	return user.ID
+}
+
+// GetName returns user name, which is empty
+func (user User) GetName() string {
+	return ""
+}
+
+// GetEmail returns user email, which is empty
+func (user User) GetEmail() string {
+	return ""
+}
+
+// GetRoles returns user roles
+func (user User) GetRoles() []string {
+	return user.Roles
+}
+
+// Evaluator authorizes users against a policy with gate.Authorize, without any other service
+type Evaluator struct {
+	dependency.Container
+}
+
+// NewEvaluator is the constructor for Evaluator
+func NewEvaluator(policy Policy) Evaluator {
+	container := dependency.NewContainer(nil, nil, policy)
+	container.SetMatcher(internal.NewMatcher())
+	return Evaluator{container}
+}
+
+// LoginURL is not supported
+func (evaluator Evaluator) LoginURL(state string) (string, error) {
+	return "", errUnsupported
+}
+
+// Login is not supported
+func (evaluator Evaluator) Login(credentials map[string]string) (gate.User, error) {
+	return nil, errUnsupported
+}
+
+// IssueJWT is not supported
+func (evaluator Evaluator) IssueJWT(user gate.User) (gate.JWT, error) {
+	return gate.JWT{}, errUnsupported
+}
+
+// ParseJWT is not supported
+func (evaluator Evaluator) ParseJWT(tokenString string) (gate.JWT, error) {
+	return gate.JWT{}, errUnsupported
+}
+
+// Authenticate is not supported
+func (evaluator Evaluator) Authenticate(tokenString string) (gate.User, error) {
+	return nil, errUnsupported
+}
+
+// GetUserFromJWT is not supported
+func (evaluator Evaluator) GetUserFromJWT(token gate.JWT) (gate.User, error) {
+	return nil, errUnsupported
+}
+
+// Authorize performs the authorization when a given user takes an action on an object against the policy
+func (evaluator Evaluator) Authorize(user gate.User, action, object string) error {
+	return gate.Authorize(evaluator, user, action, object)
+}
+
+// GetUserAbilities returns a user's abilities in the policy
+func (evaluator Evaluator) GetUserAbilities(user gate.User) ([]gate.UserAbility, error) {
+	return gate.GetUserAbilities(evaluator, user)
+}
@@ -0,0 +1,259 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hiendv/gate/internal"
+	"github.com/pkg/errors"
+)
+
+// Severities of the findings. Policies with errors are rejected by RoleService
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Codes of the findings
+const (
+	// CodeInvalidPattern is an action or object pattern which does not compile, so the ability never matches
+	CodeInvalidPattern = "invalid-pattern"
+	// CodeBroadWildcard is an object pattern matching any object
+	CodeBroadWildcard = "broad-wildcard"
+	// CodeRedundantAbility is an ability declared twice by the role or already declared by an inherited role
+	CodeRedundantAbility = "redundant-ability"
+	// CodeShadowedAbility is an ability covered by a broader ability of the role or an inherited role
+	CodeShadowedAbility = "shadowed-ability"
+	// CodeEmptyRole is a role allowing nothing, even with its inherited roles
+	CodeEmptyRole = "empty-role"
+)
+
+// Finding is a problem of a policy
+type Finding struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Role     string `json:"role"`
+	Action   string `json:"action,omitempty"`
+	Object   string `json:"object,omitempty"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Message  string `json:"message"`
+}
+
+func (finding Finding) String() string {
+	return fmt.Sprintf("line %d, column %d: %s %s: %s", finding.Line, finding.Column, finding.Severity, finding.Code, finding.Message)
+}
+
+// Report is the result of the static analysis of a policy, ordered by position
+type Report struct {
+	Findings []Finding `json:"findings"`
+}
+
+// HasErrors determines whether the report has errors or only warnings
+func (report Report) HasErrors() bool {
+	return report.Err() != nil
+}
+
+// Err returns the errors of the report as an error, if any
+func (report Report) Err() error {
+	var messages []string
+	for _, finding := range report.Findings {
+		if finding.Severity == SeverityError {
+			messages = append(messages, finding.String())
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(messages, "\n"))
+}
+
+// probe is an object no reasonable pattern expects, so a pattern matching both it and nothing matches any object
+const probe = "\x00gate-lint-probe\x00"
+
+// Lint analyses the abilities of a policy. Every pattern is compiled the way gate matches it.
+// A pattern covers another when it matches the other pattern as a string, which is exact for literal patterns and approximate otherwise
+func Lint(policy Policy) (report Report) {
+	expressions := map[string]*regexp.Regexp{}
+	compile := func(pattern string) (*regexp.Regexp, error) {
+		if expression, ok := expressions[pattern]; ok {
+			return expression, nil
+		}
+
+		expression, err := internal.Compile(pattern)
+		if err == nil {
+			expressions[pattern] = expression
+		}
+
+		return expression, err
+	}
+
+	linter := linter{policy, compile, &report}
+	for _, role := range policy.Roles {
+		linter.lintRole(role)
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+
+		return a.Column < b.Column
+	})
+	return
+}
+
+type linter struct {
+	policy  Policy
+	compile func(string) (*regexp.Regexp, error)
+	report  *Report
+}
+
+func (linter linter) add(finding Finding) {
+	linter.report.Findings = append(linter.report.Findings, finding)
+}
+
+func (linter linter) lintRole(role Role) {
+	if len(role.GetAbilities()) == 0 {
+		linter.add(Finding{
+			Code:     CodeEmptyRole,
+			Severity: SeverityWarning,
+			Role:     role.ID,
+			Line:     role.Line,
+			Column:   role.Column,
+			Message:  fmt.Sprintf("the role %s allows nothing", role.ID),
+		})
+	}
+
+	var inheritedAllow, inheritedDeny []Ability
+	for _, ancestor := range linter.ancestors(role) {
+		inheritedAllow = append(inheritedAllow, ancestor.Allow...)
+		inheritedDeny = append(inheritedDeny, ancestor.Deny...)
+	}
+
+	linter.lintAbilities(role, role.Allow, inheritedAllow, "allows")
+	linter.lintAbilities(role, role.Deny, inheritedDeny, "denies")
+}
+
+// ancestors returns the roles the role inherits, directly or not
+func (linter linter) ancestors(role Role) (ancestors []Role) {
+	visited := map[string]bool{role.ID: true}
+	queue := append([]string{}, role.Inherits...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if visited[id] {
+			continue
+		}
+
+		visited[id] = true
+		ancestor, ok := linter.policy.Role(id)
+		if !ok {
+			continue
+		}
+
+		ancestors = append(ancestors, ancestor)
+		queue = append(queue, ancestor.Inherits...)
+	}
+
+	return
+}
+
+func (linter linter) lintAbilities(role Role, own, inherited []Ability, verb string) {
+	for i, ability := range own {
+		finding := Finding{Role: role.ID, Action: ability.Action, Object: ability.Object, Line: ability.Line, Column: ability.Column}
+		if !linter.valid(finding, ability) {
+			continue
+		}
+
+		if linter.broad(ability.Object) {
+			finding.Code = CodeBroadWildcard
+			finding.Severity = SeverityWarning
+			finding.Message = fmt.Sprintf("the role %s %s %s on any object", role.ID, verb, ability.Action)
+			if linter.broad(ability.Action) {
+				finding.Message = fmt.Sprintf("the role %s %s any action on any object", role.ID, verb)
+			}
+
+			linter.add(finding)
+		}
+
+		others := append(append([]Ability{}, own[:i]...), inherited...)
+		if duplicate, ok := linter.find(others, func(other Ability) bool {
+			return other.Action == ability.Action && other.Object == ability.Object
+		}); ok {
+			finding.Code = CodeRedundantAbility
+			finding.Severity = SeverityWarning
+			finding.Message = fmt.Sprintf("%s %s of the role %s is already declared by the role %s at line %d", ability.Action, ability.Object, role.ID, duplicate.Role, duplicate.Line)
+			linter.add(finding)
+			continue
+		}
+
+		others = append(append(append([]Ability{}, own[:i]...), own[i+1:]...), inherited...)
+		if broader, ok := linter.find(others, func(other Ability) bool {
+			return (other.Action != ability.Action || other.Object != ability.Object) && linter.covers(other, ability)
+		}); ok {
+			finding.Code = CodeShadowedAbility
+			finding.Severity = SeverityWarning
+			finding.Message = fmt.Sprintf("%s %s of the role %s is covered by %s %s of the role %s at line %d", ability.Action, ability.Object, role.ID, broader.Action, broader.Object, broader.Role, broader.Line)
+			linter.add(finding)
+		}
+	}
+}
+
+// valid reports the patterns of the ability which do not compile
+func (linter linter) valid(finding Finding, ability Ability) bool {
+	valid := true
+	for _, field := range []struct {
+		name    string
+		pattern string
+	}{{"action", ability.Action}, {"object", ability.Object}} {
+		_, err := linter.compile(field.pattern)
+		if err == nil {
+			continue
+		}
+
+		valid = false
+		finding.Code = CodeInvalidPattern
+		finding.Severity = SeverityError
+		finding.Message = fmt.Sprintf("the %s pattern %q of the role %s is invalid: %s", field.name, field.pattern, finding.Role, errors.Cause(err))
+		linter.add(finding)
+	}
+
+	return valid
+}
+
+func (linter linter) find(abilities []Ability, predicate func(Ability) bool) (Ability, bool) {
+	for _, ability := range abilities {
+		if predicate(ability) {
+			return ability, true
+		}
+	}
+
+	return Ability{}, false
+}
+
+// broad determines whether the pattern matches anything, i.e. both nothing and an unexpected string
+func (linter linter) broad(pattern string) bool {
+	expression, err := linter.compile(pattern)
+	return err == nil && expression.MatchString("") && expression.MatchString(probe)
+}
+
+// covers determines whether the ability matches whatever the other one matches
+func (linter linter) covers(ability, other Ability) bool {
+	action, err := linter.compile(ability.Action)
+	if err != nil {
+		return false
+	}
+
+	object, err := linter.compile(ability.Object)
+	if err != nil {
+		return false
+	}
+
+	return action.MatchString(other.Action) && object.MatchString(other.Object)
+}
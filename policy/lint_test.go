@@ -0,0 +1,126 @@
+package policy_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hiendv/gate/internal/test"
+	"github.com/hiendv/gate/policy"
+)
+
+const lintDocument = `roles:
+  viewer:
+    allow:
+      - {action: GET, object: /posts/*}
+      - {action: GET, object: /posts/1}
+  editor:
+    inherits: [viewer]
+    allow:
+      - {action: GET, object: /posts/*}
+      - {action: POST, object: /posts}
+      - {action: POST, object: /posts}
+  admin:
+    allow:
+      - {action: "*", object: "*"}
+  nobody:
+    deny:
+      - {action: GET, object: /posts/*}
+`
+
+func TestLint(t *testing.T) {
+	p, err := policy.Parse([]byte(lintDocument))
+	test.AssertOK(t, err, "valid policy")
+
+	report := policy.Lint(p)
+	expected := []struct {
+		code     string
+		severity string
+		role     string
+		line     int
+	}{
+		{policy.CodeShadowedAbility, policy.SeverityWarning, "viewer", 5},
+		{policy.CodeRedundantAbility, policy.SeverityWarning, "editor", 9},
+		{policy.CodeRedundantAbility, policy.SeverityWarning, "editor", 11},
+		{policy.CodeBroadWildcard, policy.SeverityWarning, "admin", 14},
+		{policy.CodeEmptyRole, policy.SeverityWarning, "nobody", 15},
+	}
+
+	if len(report.Findings) != len(expected) {
+		t.Fatalf("unexpected findings: %v", report.Findings)
+	}
+
+	for i, finding := range report.Findings {
+		e := expected[i]
+		if finding.Code != e.code || finding.Severity != e.severity || finding.Role != e.role || finding.Line != e.line {
+			t.Fatalf("unexpected finding %d: %v", i, finding)
+		}
+	}
+
+	if report.Findings[0].Message != "GET /posts/1 of the role viewer is covered by GET /posts/* of the role viewer at line 4" {
+		t.Fatalf("unexpected message: %s", report.Findings[0].Message)
+	}
+
+	if report.Findings[1].Message != "GET /posts/* of the role editor is already declared by the role viewer at line 4" {
+		t.Fatalf("unexpected message: %s", report.Findings[1].Message)
+	}
+
+	if report.HasErrors() {
+		t.Fatalf("unexpected errors: %v", report.Err())
+	}
+
+	data, err := json.Marshal(report)
+	test.AssertOK(t, err, "valid report")
+
+	var decoded policy.Report
+	err = json.Unmarshal(data, &decoded)
+	test.AssertOK(t, err, "valid report")
+	if len(decoded.Findings) != len(expected) || decoded.Findings[1] != report.Findings[1] {
+		t.Fatalf("unexpected report: %s", data)
+	}
+
+	// Parse rejects invalid patterns, but policies may be declared in code
+	report = policy.Lint(policy.Policy{Roles: []policy.Role{{
+		ID:    "viewer",
+		Allow: []policy.Ability{{Action: "GET", Object: "posts.(", Role: "viewer", Line: 6, Column: 9}},
+	}}})
+	if !report.HasErrors() || !strings.Contains(report.Err().Error(), `line 6, column 9: error invalid-pattern: the object pattern "posts.(" of the role viewer is invalid`) {
+		t.Fatalf("unexpected errors: %v", report.Err())
+	}
+}
+
+func TestLintClean(t *testing.T) {
+	p, err := policy.Parse([]byte(document))
+	test.AssertOK(t, err, "valid policy")
+
+	report := policy.Lint(p)
+	if report.HasErrors() {
+		t.Fatalf("unexpected errors: %v", report.Err())
+	}
+
+	// The admin role allows anything and the guest role nothing
+	if len(report.Findings) != 2 || report.Findings[0].Code != policy.CodeBroadWildcard || report.Findings[1].Code != policy.CodeEmptyRole {
+		t.Fatalf("unexpected findings: %v", report.Findings)
+	}
+}
+
+func TestRoleServiceLint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	test.AssertOK(t, err, "temporary directory")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.yaml")
+	writePolicy(t, path, "roles:\n  viewer:\n    allow: [{action: GET, object: \"posts.(\"}]\n")
+	_, err = policy.NewRoleService(path)
+	test.AssertErr(t, err, "invalid pattern")
+	if !strings.Contains(err.Error(), `the object pattern "posts.(" of the role viewer is invalid`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	writePolicy(t, path, "roles:\n  admin:\n    allow: [{action: \"*\", object: \"*\"}]\n")
+	_, err = policy.NewRoleService(path)
+	test.AssertOK(t, err, "warnings only")
+}
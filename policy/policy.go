@@ -0,0 +1,443 @@
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/internal"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Error is an error at a position of a policy file. The context is the offending line of the file
+type Error struct {
+	Line    int
+	Column  int
+	Message string
+	Context string
+}
+
+func (err Error) Error() string {
+	message := "line " + strconv.Itoa(err.Line)
+	if err.Column > 0 {
+		message += ", column " + strconv.Itoa(err.Column)
+	}
+
+	message += ": " + err.Message
+	if err.Context == "" {
+		return message
+	}
+
+	message += fmt.Sprintf("\n%4d | %s", err.Line, err.Context)
+	if err.Column > 0 {
+		message += "\n     | " + strings.Repeat(" ", err.Column-1) + "^"
+	}
+
+	return message
+}
+
+// Ability is an ability declared by a role of a policy with its position in the file
+type Ability struct {
+	Action string
+	Object string
+	Role   string
+	Line   int
+	Column int
+}
+
+// GetAction returns ability action
+func (ability Ability) GetAction() string {
+	return ability.Action
+}
+
+// GetObject returns ability object
+func (ability Ability) GetObject() string {
+	return ability.Object
+}
+
+// Role is a role declared in a policy with its position in the file. Its abilities include the ones of the inherited roles
+type Role struct {
+	ID       string
+	Inherits []string
+	Allow    []Ability
+	Deny     []Ability
+	Line     int
+	Column   int
+	// inherited are the positions of the inherited roles in the file
+	inherited []position
+	allowed   []gate.UserAbility
+	denied    []gate.UserAbility
+}
+
+type position struct {
+	line   int
+	column int
+}
+
+// GetAbilities returns the allowed abilities of the role and the inherited roles
+func (role Role) GetAbilities() []gate.UserAbility {
+	return role.allowed
+}
+
+// GetDeniedAbilities returns the denied abilities of the role and the inherited roles
+func (role Role) GetDeniedAbilities() []gate.UserAbility {
+	return role.denied
+}
+
+// Policy is the set of roles declared in a policy. It is a static role service
+type Policy struct {
+	Roles []Role
+	index map[string]int
+}
+
+// Role returns the role with the given ID
+func (policy Policy) Role(id string) (role Role, ok bool) {
+	i, ok := policy.index[id]
+	if !ok {
+		return
+	}
+
+	role = policy.Roles[i]
+	return
+}
+
+// FindByIDs fetches the roles with the given IDs. Unknown roles are ignored
+func (policy Policy) FindByIDs(ids []string) (roles []gate.Role, err error) {
+	for _, id := range ids {
+		if role, ok := policy.Role(id); ok {
+			roles = append(roles, role)
+		}
+	}
+	return
+}
+
+// Load reads and parses the policy file
+func Load(path string) (policy Policy, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.Wrap(err, "could not read the policy")
+		return
+	}
+
+	policy, err = Parse(data)
+	if err != nil {
+		err = errors.Wrap(err, path)
+		return
+	}
+	return
+}
+
+// Parse parses and validates a policy in YAML, or JSON as a subset of YAML, e.g.
+//
+//	roles:
+//	  viewer:
+//	    allow:
+//	      - {action: GET, object: /posts/*}
+//	  editor:
+//	    inherits: [viewer]
+//	    allow:
+//	      - {action: POST, object: /posts}
+//	    deny:
+//	      - {action: GET, object: /posts/drafts/*}
+//
+// Errors are Error values locating the problem in the policy
+func Parse(data []byte) (policy Policy, err error) {
+	parser := parser{lines: strings.Split(string(data), "\n")}
+
+	var document yaml.Node
+	err = yaml.Unmarshal(data, &document)
+	if err != nil {
+		err = parser.syntaxError(err)
+		return
+	}
+
+	policy, err = parser.parse(&document)
+	if err != nil {
+		policy = Policy{}
+		return
+	}
+
+	err = parser.resolve(&policy)
+	if err != nil {
+		policy = Policy{}
+		return
+	}
+	return
+}
+
+var syntaxErrorPattern = regexp.MustCompile(`^yaml: (?:line (\d+): )?(.*)$`)
+
+type parser struct {
+	lines []string
+}
+
+func (parser parser) error(line, column int, format string, args ...interface{}) error {
+	err := Error{Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+	if line > 0 && line <= len(parser.lines) {
+		err.Context = strings.TrimRight(parser.lines[line-1], "\r")
+	}
+
+	return err
+}
+
+func (parser parser) nodeError(node *yaml.Node, format string, args ...interface{}) error {
+	return parser.error(node.Line, node.Column, format, args...)
+}
+
+func (parser parser) syntaxError(err error) error {
+	match := syntaxErrorPattern.FindStringSubmatch(err.Error())
+	if match == nil || match[1] == "" {
+		return parser.error(1, 0, "%s", strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+
+	line, _ := strconv.Atoi(match[1])
+	return parser.error(line, 0, "%s", match[2])
+}
+
+func (parser parser) parse(document *yaml.Node) (policy Policy, err error) {
+	policy.index = map[string]int{}
+	if len(document.Content) == 0 {
+		err = parser.error(1, 0, "the policy is empty")
+		return
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		err = parser.nodeError(root, "the policy must be a mapping with roles")
+		return
+	}
+
+	var roles *yaml.Node
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "roles":
+			roles = value
+		default:
+			err = parser.nodeError(key, "unknown key %q, expected roles", key.Value)
+			return
+		}
+	}
+
+	if roles == nil {
+		err = parser.nodeError(root, "missing roles")
+		return
+	}
+
+	if roles.Kind != yaml.MappingNode {
+		err = parser.nodeError(roles, "roles must be a mapping of role IDs to roles")
+		return
+	}
+
+	for i := 0; i < len(roles.Content); i += 2 {
+		key, value := roles.Content[i], roles.Content[i+1]
+		if key.Value == "" {
+			err = parser.nodeError(key, "the role ID is empty")
+			return
+		}
+
+		if _, ok := policy.index[key.Value]; ok {
+			err = parser.nodeError(key, "the role %s is already declared", key.Value)
+			return
+		}
+
+		var role Role
+		role, err = parser.parseRole(key, value)
+		if err != nil {
+			return
+		}
+
+		policy.index[role.ID] = len(policy.Roles)
+		policy.Roles = append(policy.Roles, role)
+	}
+	return
+}
+
+func (parser parser) parseRole(key, value *yaml.Node) (role Role, err error) {
+	role = Role{ID: key.Value, Line: key.Line, Column: key.Column}
+	if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+		return
+	}
+
+	if value.Kind != yaml.MappingNode {
+		err = parser.nodeError(value, "the role %s must be a mapping", role.ID)
+		return
+	}
+
+	for i := 0; i < len(value.Content); i += 2 {
+		field, content := value.Content[i], value.Content[i+1]
+		switch field.Value {
+		case "inherits":
+			role.Inherits, role.inherited, err = parser.parseInherits(role.ID, content)
+		case "allow":
+			role.Allow, err = parser.parseAbilities(role.ID, content)
+		case "deny":
+			role.Deny, err = parser.parseAbilities(role.ID, content)
+		default:
+			err = parser.nodeError(field, "unknown key %q in the role %s, expected inherits, allow or deny", field.Value, role.ID)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (parser parser) parseInherits(id string, node *yaml.Node) (inherits []string, positions []position, err error) {
+	if node.Kind != yaml.SequenceNode {
+		err = parser.nodeError(node, "inherits of the role %s must be a list of role IDs", id)
+		return
+	}
+
+	for _, item := range node.Content {
+		if item.Kind != yaml.ScalarNode || item.Value == "" {
+			err = parser.nodeError(item, "inherits of the role %s must be a list of role IDs", id)
+			return
+		}
+
+		inherits = append(inherits, item.Value)
+		positions = append(positions, position{item.Line, item.Column})
+	}
+	return
+}
+
+func (parser parser) parseAbilities(id string, node *yaml.Node) (abilities []Ability, err error) {
+	if node.Kind != yaml.SequenceNode {
+		err = parser.nodeError(node, "abilities of the role %s must be a list", id)
+		return
+	}
+
+	for _, item := range node.Content {
+		if item.Kind != yaml.MappingNode {
+			err = parser.nodeError(item, "an ability of the role %s must be a mapping with action and object", id)
+			return
+		}
+
+		ability := Ability{Role: id, Line: item.Line, Column: item.Column}
+		for i := 0; i < len(item.Content); i += 2 {
+			field, value := item.Content[i], item.Content[i+1]
+			if value.Kind != yaml.ScalarNode {
+				err = parser.nodeError(value, "%s of an ability of the role %s must be a string", field.Value, id)
+				return
+			}
+
+			switch field.Value {
+			case "action":
+				ability.Action = value.Value
+			case "object":
+				ability.Object = value.Value
+			default:
+				err = parser.nodeError(field, "unknown key %q in an ability of the role %s, expected action or object", field.Value, id)
+				return
+			}
+
+			_, e := internal.Compile(value.Value)
+			if e != nil {
+				err = parser.nodeError(value, "the %s pattern %q of the role %s is invalid: %s", field.Value, value.Value, id, e)
+				return
+			}
+		}
+
+		if ability.Action == "" {
+			err = parser.nodeError(item, "an ability of the role %s is missing its action", id)
+			return
+		}
+
+		if ability.Object == "" {
+			err = parser.nodeError(item, "an ability of the role %s is missing its object", id)
+			return
+		}
+
+		abilities = append(abilities, ability)
+	}
+	return
+}
+
+// resolve checks the inherited roles and collects the inherited abilities
+func (parser parser) resolve(policy *Policy) error {
+	// Roles are unvisited (0), being resolved (1) or resolved (2)
+	states := make([]int, len(policy.Roles))
+
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		role := &policy.Roles[i]
+		switch states[i] {
+		case 1:
+			for len(path) > 0 && path[0] != role.ID {
+				path = path[1:]
+			}
+
+			return parser.error(role.Line, role.Column, "the role %s inherits itself through %s", role.ID, strings.Join(append(path, role.ID), " -> "))
+		case 2:
+			return nil
+		}
+
+		states[i] = 1
+		allowed := abilitySet{}
+		denied := abilitySet{}
+		allowed.add(role.Allow)
+		denied.add(role.Deny)
+
+		for k, id := range role.Inherits {
+			j, ok := policy.index[id]
+			if !ok {
+				at := role.inherited[k]
+				return parser.error(at.line, at.column, "the role %s inherits the undeclared role %s", role.ID, id)
+			}
+
+			err := visit(j, append(path, role.ID))
+			if err != nil {
+				return err
+			}
+
+			allowed.merge(policy.Roles[j].allowed)
+			denied.merge(policy.Roles[j].denied)
+		}
+
+		role.allowed = allowed.abilities
+		role.denied = denied.abilities
+		states[i] = 2
+		return nil
+	}
+
+	for i := range policy.Roles {
+		err := visit(i, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type abilitySet struct {
+	abilities []gate.UserAbility
+	keys      map[string]bool
+}
+
+func (set *abilitySet) add(abilities []Ability) {
+	for _, ability := range abilities {
+		set.merge([]gate.UserAbility{ability})
+	}
+}
+
+func (set *abilitySet) merge(abilities []gate.UserAbility) {
+	if set.keys == nil {
+		set.keys = map[string]bool{}
+	}
+
+	for _, ability := range abilities {
+		key := ability.GetAction() + " " + ability.GetObject()
+		if set.keys[key] {
+			continue
+		}
+
+		set.keys[key] = true
+		set.abilities = append(set.abilities, ability)
+	}
+}
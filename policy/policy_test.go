@@ -0,0 +1,227 @@
+package policy_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hiendv/gate/internal/test"
+	"github.com/hiendv/gate/policy"
+	"github.com/pkg/errors"
+)
+
+const document = `roles:
+  viewer:
+    allow:
+      - {action: GET, object: /posts/*}
+  editor:
+    inherits: [viewer]
+    allow:
+      - action: POST
+        object: /posts
+    deny:
+      - {action: GET, object: /posts/drafts/*}
+  admin:
+    inherits: [editor]
+    allow:
+      - {action: "*", object: "*"}
+  guest:
+`
+
+func abilities(role policy.Role) (allowed, denied []string) {
+	for _, ability := range role.GetAbilities() {
+		allowed = append(allowed, ability.GetAction()+" "+ability.GetObject())
+	}
+
+	for _, ability := range role.GetDeniedAbilities() {
+		denied = append(denied, ability.GetAction()+" "+ability.GetObject())
+	}
+
+	return
+}
+
+func TestParse(t *testing.T) {
+	p, err := policy.Parse([]byte(document))
+	test.AssertOK(t, err, "valid policy")
+
+	if len(p.Roles) != 4 || p.Roles[0].ID != "viewer" || p.Roles[3].ID != "guest" {
+		t.Fatalf("unexpected roles: %v", p.Roles)
+	}
+
+	admin, ok := p.Role("admin")
+	if !ok || admin.Line != 12 || admin.Column != 3 {
+		t.Fatalf("unexpected role: %v", admin)
+	}
+
+	allowed, denied := abilities(admin)
+	if strings.Join(allowed, ",") != "* *,POST /posts,GET /posts/*" || strings.Join(denied, ",") != "GET /posts/drafts/*" {
+		t.Fatalf("unexpected abilities: %v %v", allowed, denied)
+	}
+
+	editor, _ := p.Role("editor")
+	if editor.Allow[0].Line != 8 || editor.Allow[0].Column != 9 {
+		t.Fatalf("unexpected position: %v", editor.Allow[0])
+	}
+
+	roles, err := p.FindByIDs([]string{"guest", "unknown", "viewer"})
+	test.AssertOK(t, err, "valid roles")
+	if len(roles) != 2 || len(roles[0].GetAbilities()) != 0 || len(roles[1].GetAbilities()) != 1 {
+		t.Fatalf("unexpected roles: %v", roles)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	p, err := policy.Parse([]byte(`{
+  "roles": {
+    "viewer": {"allow": [{"action": "GET", "object": "/posts/*"}]},
+    "editor": {"inherits": ["viewer"], "deny": [{"action": "GET", "object": "/posts/drafts/*"}]}
+  }
+}`))
+	test.AssertOK(t, err, "valid policy")
+
+	editor, _ := p.Role("editor")
+	allowed, denied := abilities(editor)
+	if len(allowed) != 1 || len(denied) != 1 {
+		t.Fatalf("unexpected abilities: %v %v", allowed, denied)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		document string
+		line     int
+		column   int
+		message  string
+	}{
+		{"", 1, 0, "the policy is empty"},
+		{"- roles", 1, 1, "the policy must be a mapping with roles"},
+		{"rules: {}", 1, 1, `unknown key "rules", expected roles`},
+		{"roles: [viewer]", 1, 8, "roles must be a mapping of role IDs to roles"},
+		{"roles:\n  viewer: [GET]", 2, 11, "the role viewer must be a mapping"},
+		{"roles:\n  viewer:\n    allows: []", 3, 5, `unknown key "allows" in the role viewer, expected inherits, allow or deny`},
+		{"roles:\n  viewer:\n    allow:\n      - {action: GET}", 4, 9, "an ability of the role viewer is missing its object"},
+		{"roles:\n  viewer:\n    allow:\n      - {verb: GET, object: /posts}", 4, 10, `unknown key "verb" in an ability of the role viewer, expected action or object`},
+		{"roles:\n  viewer:\n    allow: {action: GET}", 3, 12, "abilities of the role viewer must be a list"},
+		{"roles:\n  viewer:\n    deny:\n      - {action: GET, object: \"posts.(\"}", 4, 31, `the object pattern "posts.(" of the role viewer is invalid`},
+		{"roles:\n  editor:\n    inherits: [viewr]", 3, 16, "the role editor inherits the undeclared role viewr"},
+		{"roles:\n  a:\n    inherits: [b]\n  b:\n    inherits: [a]", 2, 3, "the role a inherits itself through a -> b -> a"},
+		{"roles:\n  viewer:\n    allow: []\n  viewer: {}", 4, 3, "the role viewer is already declared"},
+		{"roles:\n  viewer:\n\tallow: []", 3, 0, "found character that cannot start any token"},
+	}
+
+	for _, c := range cases {
+		_, err := policy.Parse([]byte(c.document))
+		var perr policy.Error
+		if !errors.As(err, &perr) {
+			t.Fatalf("unexpected error for %q: %v", c.document, err)
+		}
+
+		if perr.Line != c.line || perr.Column != c.column || !strings.Contains(perr.Message, c.message) {
+			t.Fatalf("unexpected error for %q: %#v", c.document, perr)
+		}
+	}
+
+	_, err := policy.Parse([]byte("roles:\n  editor:\n    inherits: [viewr]"))
+	expected := "line 3, column 16: the role editor inherits the undeclared role viewr\n   3 |     inherits: [viewr]\n     |                ^"
+	if err.Error() != expected {
+		t.Fatalf("unexpected message:\n%s", err)
+	}
+}
+
+func writePolicy(t *testing.T, path, content string) {
+	// The policy is replaced atomically
+	err := ioutil.WriteFile(path+".tmp", []byte(content), 0600)
+	test.AssertOK(t, err, "writable file")
+
+	err = os.Rename(path+".tmp", path)
+	test.AssertOK(t, err, "writable file")
+}
+
+func TestRoleService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	test.AssertOK(t, err, "temporary directory")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.yaml")
+	_, err = policy.NewRoleService(path)
+	test.AssertErr(t, err, "missing file")
+
+	writePolicy(t, path, "roles:\n  viewer:\n    allow: [{action: GET, object: /posts}]\n")
+	service, err := policy.NewRoleService(path)
+	test.AssertOK(t, err, "valid policy")
+
+	roles, err := service.FindByIDs([]string{"viewer"})
+	test.AssertOK(t, err, "valid roles")
+	if len(roles) != 1 {
+		t.Fatalf("unexpected roles: %v", roles)
+	}
+
+	writePolicy(t, path, "roles:\n  viewer:\n    allow: [{action: GET}]\n")
+	err = service.Reload()
+	test.AssertErr(t, err, "invalid policy")
+	if !strings.HasPrefix(err.Error(), path+": line 3") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := service.Policy().Role("viewer"); !ok {
+		t.Fatal("the previous policy should be kept")
+	}
+
+	writePolicy(t, path, "roles:\n  editor:\n    allow: [{action: POST, object: /posts}]\n")
+	err = service.Reload()
+	test.AssertOK(t, err, "valid policy")
+	if _, ok := service.Policy().Role("editor"); !ok {
+		t.Fatal("the policy should be replaced")
+	}
+}
+
+func TestRoleServiceWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	test.AssertOK(t, err, "temporary directory")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policy.yaml")
+	writePolicy(t, path, "roles:\n  viewer: {}\n")
+	service, err := policy.NewRoleService(path)
+	test.AssertOK(t, err, "valid policy")
+
+	errs := make(chan error, 10)
+	stop := service.Watch(time.Millisecond*5, func(err error) {
+		errs <- err
+	})
+	defer stop()
+
+	writePolicy(t, path, "roles:\n  viewer: []\n")
+	select {
+	case err = <-errs:
+		test.AssertErr(t, err, "invalid policy")
+	case <-time.After(time.Second * 2):
+		t.Fatal("the invalid policy should be reported")
+	}
+
+	writePolicy(t, path, "roles:\n  editor: {}\n")
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		if _, ok := service.Policy().Role("editor"); ok {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the policy should be reloaded")
+		}
+
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	stop()
+	stop()
+	if len(errs) != 0 {
+		t.Fatalf("the invalid policy should be reported once: %v", <-errs)
+	}
+
+	stop = service.Watch(0, nil)
+	stop()
+}
@@ -0,0 +1,153 @@
+package policy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
+)
+
+// DefaultWatchInterval is the interval at which Watch checks the policy file when the given one is not positive
+const DefaultWatchInterval = time.Second * 5
+
+// RoleService is the role service backed by a policy file. Reloads replace the whole policy at once,
+// so concurrent authorizations see either the previous policy or the new one
+type RoleService struct {
+	path  string
+	state *state
+}
+
+type state struct {
+	policy Policy
+	data   []byte
+	*sync.RWMutex
+}
+
+// NewRoleService is the constructor for RoleService. The policy file must be valid and free of lint errors, see Lint
+func NewRoleService(path string) (service RoleService, err error) {
+	if path == "" {
+		err = errors.New("missing path")
+		return
+	}
+
+	service = RoleService{path, &state{RWMutex: &sync.RWMutex{}}}
+	err = service.Reload()
+	if err != nil {
+		service = RoleService{}
+		return
+	}
+	return
+}
+
+// Policy returns the current policy
+func (service RoleService) Policy() Policy {
+	service.state.RLock()
+	defer service.state.RUnlock()
+
+	return service.state.policy
+}
+
+// FindByIDs fetches the roles with the given IDs from the current policy
+func (service RoleService) FindByIDs(ids []string) ([]gate.Role, error) {
+	return service.Policy().FindByIDs(ids)
+}
+
+// Reload reads the policy file again. An invalid policy or one with lint errors is rejected and the current one is kept
+func (service RoleService) Reload() error {
+	data, err := ioutil.ReadFile(service.path)
+	if err != nil {
+		return errors.Wrap(err, "could not read the policy")
+	}
+
+	return service.load(data)
+}
+
+// load replaces the policy if the data changed
+func (service RoleService) load(data []byte) error {
+	service.state.RLock()
+	unchanged := service.state.data != nil && bytes.Equal(data, service.state.data)
+	service.state.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	policy, err := Parse(data)
+	if err != nil {
+		return errors.Wrap(err, service.path)
+	}
+
+	err = Lint(policy).Err()
+	if err != nil {
+		return errors.Wrap(err, service.path)
+	}
+
+	service.state.Lock()
+	service.state.policy = policy
+	service.state.data = data
+	service.state.Unlock()
+	return nil
+}
+
+// Watch reloads the policy file when it changes, checking it at the given interval, DefaultWatchInterval if it is not positive, until stopped.
+// Invalid changes are reported once to the error handler, if any, and the current policy is kept.
+// The file is best replaced atomically, e.g. by a rename, so a partially written policy is never seen
+func (service RoleService) Watch(interval time.Duration, onError func(error)) (stop func()) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+
+		var rejected []byte
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			data, err := ioutil.ReadFile(service.path)
+			if err != nil {
+				rejected = nil
+				report(onError, errors.Wrap(err, "could not read the policy"))
+				continue
+			}
+
+			if rejected != nil && bytes.Equal(data, rejected) {
+				continue
+			}
+
+			err = service.load(data)
+			if err != nil {
+				rejected = data
+				report(onError, err)
+				continue
+			}
+
+			rejected = nil
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+func report(onError func(error), err error) {
+	if onError != nil {
+		onError(err)
+	}
+}
@@ -0,0 +1,129 @@
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Decisions of the simulated requests
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// Request is an authorization request of a user having roles, e.g. sampled from the production traffic
+type Request struct {
+	User   string   `json:"user,omitempty"`
+	Roles  []string `json:"roles"`
+	Action string   `json:"action"`
+	Object string   `json:"object"`
+	Line   int      `json:"line,omitempty"`
+}
+
+func (request Request) String() string {
+	user := request.User
+	if user == "" {
+		user = "-"
+	}
+
+	return fmt.Sprintf("%s [%s] %s %s", user, strings.Join(request.Roles, ","), request.Action, request.Object)
+}
+
+// Change is a request whose decision flips between the policies. The reason is the cause of the denial
+type Change struct {
+	Request Request `json:"request"`
+	Before  string  `json:"before"`
+	After   string  `json:"after"`
+	Reason  string  `json:"reason"`
+}
+
+func (change Change) String() string {
+	message := fmt.Sprintf("%s -> %s: %s (%s)", change.Before, change.After, change.Request, change.Reason)
+	if change.Request.Line > 0 {
+		message = fmt.Sprintf("line %d: %s", change.Request.Line, message)
+	}
+
+	return message
+}
+
+// Simulation is the result of the requests against two policies. The changes are in the order of the requests
+type Simulation struct {
+	Requests int      `json:"requests"`
+	Revoked  []Change `json:"revoked"`
+	Granted  []Change `json:"granted"`
+}
+
+// Changed determines whether any decision flips
+func (simulation Simulation) Changed() bool {
+	return len(simulation.Revoked) > 0 || len(simulation.Granted) > 0
+}
+
+// Simulate authorizes the requests against both policies with gate.Authorize, reporting the decisions flipping
+// from allow to deny as revoked and from deny to allow as granted
+func Simulate(before, after Policy, requests []Request) (simulation Simulation) {
+	previous, next := NewEvaluator(before), NewEvaluator(after)
+	simulation.Requests = len(requests)
+	simulation.Revoked = []Change{}
+	simulation.Granted = []Change{}
+
+	for _, request := range requests {
+		user := User{ID: request.User, Roles: request.Roles}
+		e := previous.Authorize(user, request.Action, request.Object)
+		err := next.Authorize(user, request.Action, request.Object)
+
+		switch {
+		case e == nil && err != nil:
+			simulation.Revoked = append(simulation.Revoked, Change{request, DecisionAllow, DecisionDeny, errors.Cause(err).Error()})
+		case e != nil && err == nil:
+			simulation.Granted = append(simulation.Granted, Change{request, DecisionDeny, DecisionAllow, errors.Cause(e).Error()})
+		}
+	}
+
+	return
+}
+
+// ReadRequests reads a corpus of requests as JSON lines, e.g. {"user": "42", "roles": ["editor"], "action": "GET", "object": "/posts/1"}.
+// Blank lines are skipped and the requests record their line
+func ReadRequests(r io.Reader) (requests []Request, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+
+		var request Request
+		e := decoder.Decode(&request)
+		if e != nil {
+			err = Error{Line: line, Message: e.Error(), Context: string(data)}
+			return
+		}
+
+		if request.Action == "" || request.Object == "" {
+			err = Error{Line: line, Message: "the request requires an action and an object", Context: string(data)}
+			return
+		}
+
+		request.Line = line
+		requests = append(requests, request)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		err = errors.Wrap(err, "could not read the requests")
+	}
+	return
+}
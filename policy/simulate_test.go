@@ -0,0 +1,107 @@
+package policy_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hiendv/gate/policy"
+	"github.com/pkg/errors"
+)
+
+const changed = `roles:
+  viewer:
+    allow:
+      - {action: GET, object: /posts/*}
+      - {action: GET, object: /comments/*}
+  editor:
+    inherits: [viewer]
+    allow:
+      - action: POST
+        object: /posts
+    deny:
+      - {action: GET, object: /posts/*}
+  admin:
+    inherits: [editor]
+    allow:
+      - {action: "*", object: "*"}
+`
+
+func TestSimulate(t *testing.T) {
+	before, err := policy.Parse([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := policy.Parse([]byte(changed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []policy.Request{
+		{User: "1", Roles: []string{"viewer"}, Action: "GET", Object: "/posts/1"},
+		{User: "1", Roles: []string{"viewer"}, Action: "GET", Object: "/comments/1"},
+		{User: "2", Roles: []string{"editor"}, Action: "GET", Object: "/posts/1"},
+		{User: "2", Roles: []string{"editor"}, Action: "POST", Object: "/posts"},
+		{User: "3", Roles: []string{"guest"}, Action: "GET", Object: "/posts/1"},
+		{User: "4", Roles: []string{"admin"}, Action: "DELETE", Object: "/posts/1"},
+	}
+
+	simulation := policy.Simulate(before, after, requests)
+	if simulation.Requests != 6 || !simulation.Changed() {
+		t.Fatalf("unexpected simulation: %+v", simulation)
+	}
+
+	if len(simulation.Revoked) != 1 || simulation.Revoked[0].Request.User != "2" || simulation.Revoked[0].Reason != "forbidden" {
+		t.Fatalf("unexpected revoked decisions: %+v", simulation.Revoked)
+	}
+
+	if simulation.Revoked[0].Before != policy.DecisionAllow || simulation.Revoked[0].After != policy.DecisionDeny {
+		t.Fatalf("unexpected revoked decisions: %+v", simulation.Revoked)
+	}
+
+	if len(simulation.Granted) != 1 || simulation.Granted[0].Request.Object != "/comments/1" || simulation.Granted[0].Reason != "forbidden" {
+		t.Fatalf("unexpected granted decisions: %+v", simulation.Granted)
+	}
+
+	if simulation.Granted[0].String() != "deny -> allow: 1 [viewer] GET /comments/1 (forbidden)" {
+		t.Fatalf("unexpected change: %s", simulation.Granted[0])
+	}
+
+	simulation = policy.Simulate(before, before, requests)
+	if simulation.Changed() || simulation.Revoked == nil || simulation.Granted == nil {
+		t.Fatalf("unexpected simulation: %+v", simulation)
+	}
+}
+
+func TestReadRequests(t *testing.T) {
+	requests, err := policy.ReadRequests(strings.NewReader(`{"user": "1", "roles": ["viewer"], "action": "GET", "object": "/posts/1"}
+
+{"roles": [], "action": "GET", "object": "/posts/2"}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 2 || requests[0].Line != 1 || requests[1].Line != 3 || requests[1].String() != "- [] GET /posts/2" {
+		t.Fatalf("unexpected requests: %+v", requests)
+	}
+
+	cases := []struct {
+		input   string
+		line    int
+		message string
+	}{
+		{"{\"action\": \"GET\", \"object\": \"/\"}\n{\"action\": \"GET\"}", 2, "requires an action and an object"},
+		{`{"action": "GET", "object": "/", "group": "a"}`, 1, "unknown field"},
+		{`{"action": "GET",`, 1, "unexpected EOF"},
+	}
+
+	for _, c := range cases {
+		_, err := policy.ReadRequests(strings.NewReader(c.input))
+
+		var e policy.Error
+		if !errors.As(err, &e) || e.Line != c.line || !strings.Contains(e.Message, c.message) {
+			t.Fatalf("unexpected error of %q: %v", c.input, err)
+		}
+	}
+}
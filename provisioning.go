@@ -0,0 +1,149 @@
+package gate
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrProvisioningDisabled is thrown when an unknown user logs in while provisioning is disabled
+	ErrProvisioningDisabled = errors.New("user provisioning is disabled")
+
+	// ErrProvisioningDomainNotAllowed is thrown when the email domain of an unknown user is not allowed to sign up
+	ErrProvisioningDomainNotAllowed = errors.New("the email domain is not allowed to sign up")
+)
+
+// GroupedAccount is the contract for accounts carrying their groups at the provider
+type GroupedAccount interface {
+	Account
+	GetGroups() []string
+}
+
+// Provisioning is what the policy decided for a user created on its first login, apart from the account of the provider.
+// Email is the verified email of the login, which may differ from the email of the account
+type Provisioning struct {
+	Provider string
+	Email    string
+	Roles    []string
+}
+
+// ProvisioningUserService is the contract for user services creating users with their provisioning, e.g. their roles.
+// User services without it may only create users without roles
+type ProvisioningUserService interface {
+	UserService
+	CreateOneByProvisioning(Account, Provisioning) (User, error)
+}
+
+// ProvisioningPolicy decides whether and how users are created on their first login. The zero value creates users without roles
+type ProvisioningPolicy struct {
+	// Disabled denies the logins of unknown users
+	Disabled bool
+	// Domains is the allow-list of email domains of new users. Any domain is allowed when it is empty
+	Domains []string
+	// DefaultRoles are the roles of every new user
+	DefaultRoles []string
+	// GroupRoles maps the groups of the account at the provider to roles, see GroupedAccount
+	GroupRoles map[string][]string
+	// Hook vetoes, by returning an error, or enriches the provisioning before the user is created
+	Hook func(Account, Provisioning) (Provisioning, error)
+}
+
+// Provision decides the provisioning of a new user, or rejects its account.
+// The domains are checked against the given email, which is the verified one and may differ from the email of the account
+func (policy ProvisioningPolicy) Provision(provider, email string, account Account) (provisioning Provisioning, err error) {
+	if policy.Disabled {
+		err = ErrProvisioningDisabled
+		return
+	}
+
+	if account == nil {
+		err = errors.New("missing account")
+		return
+	}
+
+	if !EmailDomainAllowed(email, policy.Domains) {
+		err = ErrProvisioningDomainNotAllowed
+		return
+	}
+
+	provisioning = Provisioning{Provider: provider, Email: email}
+	provisioning.Roles = appendRoles(provisioning.Roles, policy.DefaultRoles...)
+
+	if grouped, ok := account.(GroupedAccount); ok {
+		for _, group := range grouped.GetGroups() {
+			provisioning.Roles = appendRoles(provisioning.Roles, policy.GroupRoles[group]...)
+		}
+	}
+
+	if policy.Hook == nil {
+		return
+	}
+
+	provisioning, err = policy.Hook(account, provisioning)
+	if err != nil {
+		provisioning = Provisioning{}
+		err = errors.Wrap(err, "the account is rejected")
+		return
+	}
+	return
+}
+
+// EmailDomainAllowed determines whether the domain of the email is in the allow-list, case-insensitively.
+// Any domain is allowed when the list is empty
+func EmailDomainAllowed(email string, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return false
+	}
+
+	domain := email[at+1:]
+	for _, allowed := range domains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func createUser(service UserService, account Account, provisioning Provisioning) (user User, err error) {
+	provisioner, ok := service.(ProvisioningUserService)
+	if ok {
+		return provisioner.CreateOneByProvisioning(account, provisioning)
+	}
+
+	if len(provisioning.Roles) != 0 {
+		err = errors.New("the user service does not assign the roles of new users")
+		return
+	}
+
+	if provisioning.Email != account.GetEmail() {
+		err = errors.New("the user service does not create users with the verified email")
+		return
+	}
+
+	return service.CreateOneByAccount(account)
+}
+
+func appendRoles(roles []string, others ...string) []string {
+	for _, role := range others {
+		exists := false
+		for _, r := range roles {
+			if r == role {
+				exists = true
+				break
+			}
+		}
+
+		if !exists {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
@@ -0,0 +1,3 @@
+// Package relation contains the relationship-based authorization of gate, checking Zanzibar-style tuples, e.g. document:42#owner@alice,
+// with rewrite rules, see gate.RelationService
+package relation
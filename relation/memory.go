@@ -0,0 +1,83 @@
+package relation
+
+import (
+	"sort"
+	"sync"
+)
+
+// TupleStore is the contract which keeps tuples. Writing an existing tuple or deleting a missing one is not an error
+type TupleStore interface {
+	Write(...Tuple) error
+	Delete(...Tuple) error
+	Read(object, relation string) ([]Tuple, error)
+}
+
+// MemoryStore is the in-memory tuple store
+type MemoryStore struct {
+	records map[string]map[string]bool
+	*sync.Mutex
+}
+
+// NewMemoryStore is the constructor for MemoryStore
+func NewMemoryStore() MemoryStore {
+	return MemoryStore{
+		records: map[string]map[string]bool{},
+		Mutex:   &sync.Mutex{},
+	}
+}
+
+// Write keeps the tuples. Either all of them are kept or none
+func (store MemoryStore) Write(tuples ...Tuple) error {
+	for _, tuple := range tuples {
+		if err := tuple.Validate(); err != nil {
+			return err
+		}
+	}
+
+	store.Lock()
+	defer store.Unlock()
+
+	for _, tuple := range tuples {
+		key := tuple.Object + "#" + tuple.Relation
+		subjects, ok := store.records[key]
+		if !ok {
+			subjects = map[string]bool{}
+			store.records[key] = subjects
+		}
+
+		subjects[tuple.Subject] = true
+	}
+
+	return nil
+}
+
+// Delete removes the tuples
+func (store MemoryStore) Delete(tuples ...Tuple) error {
+	store.Lock()
+	defer store.Unlock()
+
+	for _, tuple := range tuples {
+		key := tuple.Object + "#" + tuple.Relation
+		delete(store.records[key], tuple.Subject)
+		if len(store.records[key]) == 0 {
+			delete(store.records, key)
+		}
+	}
+
+	return nil
+}
+
+// Read fetches the tuples of the relation of the object, ordered by subject
+func (store MemoryStore) Read(object, relation string) (tuples []Tuple, err error) {
+	store.Lock()
+	defer store.Unlock()
+
+	for subject := range store.records[object+"#"+relation] {
+		tuples = append(tuples, Tuple{object, relation, subject})
+	}
+
+	sort.Slice(tuples, func(i, j int) bool {
+		return tuples[i].Subject < tuples[j].Subject
+	})
+	return
+}
@@ -0,0 +1,202 @@
+package relation
+
+import (
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxDepth is the default bound of the nested usersets, implied relations and parents a check walks through
+const DefaultMaxDepth = 16
+
+// ErrMaxDepth is thrown when a check exceeds the maximum depth, e.g. because of cyclic usersets
+var ErrMaxDepth = errors.New("the maximum depth of the relation check is exceeded")
+
+// Rewrite is the rewrite rule of a relation. Besides its direct subjects, the relation holds for
+// the subjects of the implying relations of the same object and for the subjects of the inherited relations
+type Rewrite struct {
+	// Implied are the relations of the same object implying this one, e.g. editor implies viewer
+	Implied []string
+	// Inherited are the relations of the objects related by a tupleset, e.g. the viewers of the parent folder
+	Inherited []Inheritance
+}
+
+// Inheritance is the relation of the objects related to an object by a tupleset, e.g. viewer of document:42#parent@folder:1
+type Inheritance struct {
+	Tupleset string
+	Relation string
+}
+
+// Namespace is the configuration of an object type
+type Namespace struct {
+	// Relations are the relations of the objects with their rewrite rules
+	Relations map[string]Rewrite
+	// Actions map the actions on the objects to the relation they require, e.g. edit requires editor.
+	// Unmapped actions are forbidden
+	Actions map[string]string
+}
+
+// Schema maps object types, e.g. document for document:42, to their namespace.
+// Authorization of the objects of these types is delegated to the relations
+type Schema map[string]Namespace
+
+// Validate checks the rewrite rules and the actions refer to defined relations and the implied relations are acyclic
+func (schema Schema) Validate() error {
+	for name, namespace := range schema {
+		for relation, rewrite := range namespace.Relations {
+			for _, implied := range rewrite.Implied {
+				if _, ok := namespace.Relations[implied]; !ok {
+					return errors.Errorf("%s#%s is implied by the undefined relation %s", name, relation, implied)
+				}
+			}
+
+			for _, inheritance := range rewrite.Inherited {
+				if inheritance.Tupleset == "" || inheritance.Relation == "" {
+					return errors.Errorf("%s#%s has an invalid inheritance", name, relation)
+				}
+			}
+		}
+
+		for action, relation := range namespace.Actions {
+			if _, ok := namespace.Relations[relation]; !ok {
+				return errors.Errorf("%s action %s requires the undefined relation %s", name, action, relation)
+			}
+		}
+
+		visited := map[string]int{}
+		for relation := range namespace.Relations {
+			if cyclic(namespace, relation, visited) {
+				return errors.Errorf("%s#%s implies itself", name, relation)
+			}
+		}
+	}
+
+	return nil
+}
+
+// cyclic walks the implied relations depth-first. Relations are unvisited (0), being visited (1) or done (2)
+func cyclic(namespace Namespace, relation string, visited map[string]int) bool {
+	switch visited[relation] {
+	case 1:
+		return true
+	case 2:
+		return false
+	}
+
+	visited[relation] = 1
+	for _, implied := range namespace.Relations[relation].Implied {
+		if cyclic(namespace, implied, visited) {
+			return true
+		}
+	}
+
+	visited[relation] = 2
+	return false
+}
+
+// Service is the relationship-based authorization service, see gate.RelationService
+type Service struct {
+	store    TupleStore
+	schema   Schema
+	MaxDepth int
+}
+
+// New is the constructor for Service. The store is required and the schema must be valid
+func New(store TupleStore, schema Schema) (service *Service, err error) {
+	if store == nil {
+		err = errors.New("missing tuple store")
+		return
+	}
+
+	err = schema.Validate()
+	if err != nil {
+		err = errors.Wrap(err, "invalid schema")
+		return
+	}
+
+	service = &Service{store, schema, DefaultMaxDepth}
+	return
+}
+
+// RelationFor returns the relation an action on the object requires, if the object type is in the schema
+func (service Service) RelationFor(action, object string) (relation string, ok bool) {
+	namespace, ok := service.schema[ObjectType(object)]
+	if !ok {
+		return
+	}
+
+	relation = namespace.Actions[action]
+	return
+}
+
+// Check determines whether the subject, e.g. a user ID, has the relation to the object
+func (service Service) Check(object, relation, subject string) (bool, error) {
+	return service.check(object, relation, subject, 0)
+}
+
+func (service Service) check(object, relation, subject string, depth int) (related bool, err error) {
+	if depth > service.MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+
+	tuples, err := service.store.Read(object, relation)
+	if err != nil {
+		err = errors.Wrap(err, "could not read the tuples")
+		return
+	}
+
+	for _, tuple := range tuples {
+		if tuple.Subject == subject {
+			related = true
+			return
+		}
+	}
+
+	for _, tuple := range tuples {
+		setObject, setRelation, ok := userset(tuple.Subject)
+		if !ok {
+			continue
+		}
+
+		related, err = service.check(setObject, setRelation, subject, depth+1)
+		if related || err != nil {
+			return
+		}
+	}
+
+	rewrite := service.schema[ObjectType(object)].Relations[relation]
+	for _, implied := range rewrite.Implied {
+		related, err = service.check(object, implied, subject, depth+1)
+		if related || err != nil {
+			return
+		}
+	}
+
+	for _, inheritance := range rewrite.Inherited {
+		related, err = service.inherits(object, inheritance, subject, depth)
+		if related || err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (service Service) inherits(object string, inheritance Inheritance, subject string, depth int) (related bool, err error) {
+	parents, err := service.store.Read(object, inheritance.Tupleset)
+	if err != nil {
+		err = errors.Wrap(err, "could not read the tuples")
+		return
+	}
+
+	for _, parent := range parents {
+		parentObject := parent.Subject
+		if setObject, _, ok := userset(parentObject); ok {
+			parentObject = setObject
+		}
+
+		related, err = service.check(parentObject, inheritance.Relation, subject, depth+1)
+		if related || err != nil {
+			return
+		}
+	}
+	return
+}
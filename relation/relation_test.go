@@ -0,0 +1,182 @@
+package relation_test
+
+import (
+	"testing"
+
+	"github.com/hiendv/gate/internal/test"
+	"github.com/hiendv/gate/relation"
+	"github.com/pkg/errors"
+)
+
+var schema = relation.Schema{
+	"document": {
+		Relations: map[string]relation.Rewrite{
+			"owner":  {},
+			"parent": {},
+			"editor": {Implied: []string{"owner"}},
+			"viewer": {Implied: []string{"editor"}, Inherited: []relation.Inheritance{{Tupleset: "parent", Relation: "viewer"}}},
+		},
+		Actions: map[string]string{"view": "viewer", "edit": "editor", "delete": "owner"},
+	},
+	"folder": {
+		Relations: map[string]relation.Rewrite{"viewer": {}},
+	},
+	"group": {
+		Relations: map[string]relation.Rewrite{"member": {}},
+	},
+}
+
+func mustParse(t *testing.T, strs ...string) (tuples []relation.Tuple) {
+	for _, str := range strs {
+		tuple, err := relation.ParseTuple(str)
+		test.AssertOK(t, err, "valid tuple")
+		tuples = append(tuples, tuple)
+	}
+
+	return
+}
+
+func TestParseTuple(t *testing.T) {
+	tuple, err := relation.ParseTuple("document:42#viewer@group:eng#member")
+	test.AssertOK(t, err, "valid tuple")
+	if tuple != (relation.Tuple{Object: "document:42", Relation: "viewer", Subject: "group:eng#member"}) {
+		t.Fatalf("unexpected tuple: %v", tuple)
+	}
+
+	if tuple.String() != "document:42#viewer@group:eng#member" {
+		t.Fatalf("unexpected string: %s", tuple)
+	}
+
+	tuple, err = relation.ParseTuple("document:42#owner@alice@local")
+	test.AssertOK(t, err, "valid tuple")
+	if tuple.Subject != "alice@local" {
+		t.Fatalf("unexpected subject: %s", tuple.Subject)
+	}
+
+	for _, str := range []string{"", "document:42", "document:42#owner", "#owner@alice", "document:42#@alice", "document:42#owner@"} {
+		_, err = relation.ParseTuple(str)
+		if err != relation.ErrInvalidTuple {
+			t.Fatalf("unexpected error for %q: %v", str, err)
+		}
+	}
+
+	if relation.ObjectType("document:42") != "document" || relation.ObjectType("document") != "" {
+		t.Fatal("unexpected object type")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := relation.NewMemoryStore()
+	err := store.Write(mustParse(t, "document:42#viewer@bob", "document:42#viewer@alice")...)
+	test.AssertOK(t, err, "valid tuples")
+
+	err = store.Write(relation.Tuple{Object: "document:42"})
+	test.AssertErr(t, err, "invalid tuple")
+
+	tuples, err := store.Read("document:42", "viewer")
+	test.AssertOK(t, err, "valid read")
+	if len(tuples) != 2 || tuples[0].Subject != "alice" || tuples[1].Subject != "bob" {
+		t.Fatalf("unexpected tuples: %v", tuples)
+	}
+
+	err = store.Delete(mustParse(t, "document:42#viewer@alice", "document:42#viewer@carol")...)
+	test.AssertOK(t, err, "valid tuples")
+
+	tuples, err = store.Read("document:42", "viewer")
+	test.AssertOK(t, err, "valid read")
+	if len(tuples) != 1 || tuples[0].Subject != "bob" {
+		t.Fatalf("unexpected tuples: %v", tuples)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	store := relation.NewMemoryStore()
+	err := store.Write(mustParse(t,
+		"document:42#owner@alice",
+		"document:42#editor@group:eng#member",
+		"group:eng#member@bob",
+		"document:42#parent@folder:1",
+		"folder:1#viewer@carol",
+	)...)
+	test.AssertOK(t, err, "valid tuples")
+
+	service, err := relation.New(store, schema)
+	test.AssertOK(t, err, "valid schema")
+
+	cases := []struct {
+		relation string
+		subject  string
+		related  bool
+	}{
+		{"owner", "alice", true},
+		{"editor", "alice", true},
+		{"viewer", "alice", true},
+		{"owner", "bob", false},
+		{"editor", "bob", true},
+		{"viewer", "bob", true},
+		{"editor", "carol", false},
+		{"viewer", "carol", true},
+		{"viewer", "dave", false},
+	}
+
+	for _, c := range cases {
+		related, err := service.Check("document:42", c.relation, c.subject)
+		test.AssertOK(t, err, "valid check")
+		if related != c.related {
+			t.Fatalf("unexpected check of %s for %s: %v", c.relation, c.subject, related)
+		}
+	}
+
+	required, ok := service.RelationFor("edit", "document:42")
+	if !ok || required != "editor" {
+		t.Fatalf("unexpected relation: %s", required)
+	}
+
+	required, ok = service.RelationFor("archive", "document:42")
+	if !ok || required != "" {
+		t.Fatalf("unexpected relation: %s", required)
+	}
+
+	_, ok = service.RelationFor("edit", "/posts")
+	if ok {
+		t.Fatal("unexpected handled object")
+	}
+}
+
+func TestCheckMaxDepth(t *testing.T) {
+	store := relation.NewMemoryStore()
+	err := store.Write(mustParse(t, "group:a#member@group:b#member", "group:b#member@group:a#member")...)
+	test.AssertOK(t, err, "valid tuples")
+
+	service, err := relation.New(store, schema)
+	test.AssertOK(t, err, "valid schema")
+
+	_, err = service.Check("group:a", "member", "alice")
+	if !errors.Is(err, relation.ErrMaxDepth) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestInvalidSchema(t *testing.T) {
+	service, err := relation.New(nil, schema)
+	test.AssertErr(t, err, "missing store")
+	if service != nil {
+		t.Fatal("unexpected service without a store")
+	}
+
+	invalid := []relation.Schema{
+		{"document": {Relations: map[string]relation.Rewrite{"viewer": {Implied: []string{"editor"}}}}},
+		{"document": {Relations: map[string]relation.Rewrite{"viewer": {Inherited: []relation.Inheritance{{Tupleset: "parent"}}}}}},
+		{"document": {Relations: map[string]relation.Rewrite{"viewer": {}}, Actions: map[string]string{"edit": "editor"}}},
+		{"document": {Relations: map[string]relation.Rewrite{"viewer": {Implied: []string{"editor"}}, "editor": {Implied: []string{"viewer"}}}}},
+	}
+
+	for _, s := range invalid {
+		test.AssertErr(t, s.Validate(), "invalid schema")
+		service, err := relation.New(relation.NewMemoryStore(), s)
+		test.AssertErr(t, err, "invalid schema")
+		if service != nil {
+			t.Fatalf("unexpected service for %v", s)
+		}
+	}
+}
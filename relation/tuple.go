@@ -0,0 +1,74 @@
+package relation
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidTuple is thrown when a tuple lacks its object, relation or subject
+var ErrInvalidTuple = errors.New("invalid tuple")
+
+// Tuple is the relation of a subject to an object, e.g. document:42#owner@alice.
+// The subject is either a user ID or a userset, e.g. group:eng#member for the members of a group, or an object, e.g. folder:1 for a parent
+type Tuple struct {
+	Object   string
+	Relation string
+	Subject  string
+}
+
+// ParseTuple parses a tuple in the object#relation@subject notation
+func ParseTuple(str string) (tuple Tuple, err error) {
+	hash := strings.Index(str, "#")
+	if hash < 0 {
+		err = ErrInvalidTuple
+		return
+	}
+
+	at := strings.Index(str[hash:], "@")
+	if at < 0 {
+		err = ErrInvalidTuple
+		return
+	}
+
+	tuple = Tuple{str[:hash], str[hash+1 : hash+at], str[hash+at+1:]}
+	err = tuple.Validate()
+	if err != nil {
+		tuple = Tuple{}
+		return
+	}
+	return
+}
+
+// Validate checks the tuple has an object, a relation and a subject
+func (tuple Tuple) Validate() error {
+	if tuple.Object == "" || tuple.Relation == "" || tuple.Subject == "" {
+		return ErrInvalidTuple
+	}
+
+	return nil
+}
+
+func (tuple Tuple) String() string {
+	return tuple.Object + "#" + tuple.Relation + "@" + tuple.Subject
+}
+
+// ObjectType returns the type of an object, e.g. document for document:42
+func ObjectType(object string) string {
+	colon := strings.Index(object, ":")
+	if colon < 0 {
+		return ""
+	}
+
+	return object[:colon]
+}
+
+// userset splits a userset subject, e.g. group:eng#member, to its object and relation
+func userset(subject string) (object, relation string, ok bool) {
+	hash := strings.Index(subject, "#")
+	if hash < 0 {
+		return
+	}
+
+	return subject[:hash], subject[hash+1:], true
+}
@@ -0,0 +1,281 @@
+package gate
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SessionPrefix is the visible prefix which distinguishes session IDs from JWTs and API keys
+const SessionPrefix = "sess_"
+
+// Default timeouts used when the session configuration omits them
+const (
+	DefaultSessionIdleTimeout     = time.Minute * 30
+	DefaultSessionAbsoluteTimeout = time.Hour * 12
+)
+
+var (
+	// ErrInvalidSession is thrown when a session ID is malformed or unknown
+	ErrInvalidSession error = KindError{"invalid session", ErrInvalidCredentials}
+
+	// ErrSessionExpired is thrown when a session is idle for too long or reaches its absolute timeout
+	ErrSessionExpired error = KindError{"the session is expired", ErrTokenExpired}
+)
+
+// SessionConfig is the configuration for sessions
+type SessionConfig struct {
+	// IdleTimeout expires sessions which are not used for a while. Each use slides the expiration
+	IdleTimeout time.Duration
+	// AbsoluteTimeout expires sessions regardless of their usage
+	AbsoluteTimeout time.Duration
+}
+
+// Session is the server-side state of a logged in user. Its ID is the opaque value given to the client
+type Session struct {
+	ID          string
+	UserID      string
+	CreatedAt   time.Time
+	LastSeenAt  time.Time
+	ExpiredAt   time.Time
+	IdleTimeout time.Duration
+}
+
+// Expired checks if the session is expired at the given time
+func (session Session) Expired(now time.Time) bool {
+	if now.After(session.ExpiredAt) {
+		return true
+	}
+
+	return session.IdleTimeout > 0 && now.After(session.LastSeenAt.Add(session.IdleTimeout))
+}
+
+// SessionUser is the user authenticated by a session
+type SessionUser struct {
+	User
+	Session Session
+}
+
+// IsSessionID determines whether the given string is a session ID or not
+func IsSessionID(str string) bool {
+	return strings.HasPrefix(str, SessionPrefix)
+}
+
+// CreateSession creates and stores a session for a specific user, e.g. after login
+func CreateSession(auth Auth, user User, config SessionConfig) (session Session, err error) {
+	if user == nil {
+		err = errors.New("missing user")
+		return
+	}
+
+	store, err := getSessionStore(auth)
+	if err != nil {
+		return
+	}
+
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DefaultSessionIdleTimeout
+	}
+
+	if config.AbsoluteTimeout == 0 {
+		config.AbsoluteTimeout = DefaultSessionAbsoluteTimeout
+	}
+
+	id, err := generateSessionID()
+	if err != nil {
+		return
+	}
+
+	now := currentTime(auth)
+	session = Session{
+		ID:          id,
+		UserID:      user.GetID(),
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		ExpiredAt:   now.Add(config.AbsoluteTimeout),
+		IdleTimeout: config.IdleTimeout,
+	}
+
+	err = store.Store(session)
+	if err != nil {
+		session = Session{}
+		err = errors.Wrap(err, "could not store the session")
+		return
+	}
+
+	// The session ID is a bearer secret, it is left out of the event
+	Audit(auth, AuditEvent{Type: EventTokenIssued, Time: now, UserID: session.UserID, TokenKind: TokenKindSession})
+	return
+}
+
+// AuthenticateSession performs the authentication using a session ID. Each use slides the idle expiration
+func AuthenticateSession(auth Auth, id string) (user User, err error) {
+	session, err := findSession(auth, id)
+	if err != nil {
+		return
+	}
+
+	store, err := getSessionStore(auth)
+	if err != nil {
+		return
+	}
+
+	session.LastSeenAt = currentTime(auth)
+	err = store.Touch(session.ID, session.LastSeenAt)
+	if err != nil {
+		if store.IsErrNotFound(err) {
+			err = ErrInvalidSession
+			return
+		}
+
+		err = errors.Wrap(err, "could not track session usage")
+		return
+	}
+
+	service, err := auth.UserService()
+	if err != nil {
+		return
+	}
+
+	owner, err := service.FindOneByID(session.UserID)
+	if err != nil {
+		err = errors.Wrap(err, "could not find the user with the given id")
+		return
+	}
+
+	user = SessionUser{owner, session}
+	return
+}
+
+// RotateSession replaces the ID of a session, e.g. when the privileges of its user change, to prevent session fixation.
+// The old ID is invalidated
+func RotateSession(auth Auth, id string) (session Session, err error) {
+	session, err = findSession(auth, id)
+	if err != nil {
+		return
+	}
+
+	store, err := getSessionStore(auth)
+	if err != nil {
+		return
+	}
+
+	session.ID, err = generateSessionID()
+	if err != nil {
+		session = Session{}
+		return
+	}
+
+	session.LastSeenAt = currentTime(auth)
+	err = store.Store(session)
+	if err != nil {
+		session = Session{}
+		err = errors.Wrap(err, "could not store the session")
+		return
+	}
+
+	err = store.Delete(id)
+	if err != nil {
+		session = Session{}
+		err = errors.Wrap(err, "could not delete the session")
+		return
+	}
+	return
+}
+
+// DestroySession deletes the session with the given ID, e.g. on logout
+func DestroySession(auth Auth, id string) (err error) {
+	store, err := getSessionStore(auth)
+	if err != nil {
+		return
+	}
+
+	session, err := store.FindOneByID(id)
+	if err != nil {
+		if store.IsErrNotFound(err) {
+			err = nil
+			return
+		}
+
+		err = errors.Wrap(err, "could not find the session")
+		return
+	}
+
+	err = store.Delete(id)
+	if err != nil && !store.IsErrNotFound(err) {
+		err = errors.Wrap(err, "could not delete the session")
+		return
+	}
+
+	Audit(auth, AuditEvent{Type: EventTokenRevoked, Time: currentTime(auth), UserID: session.UserID, TokenKind: TokenKindSession})
+	err = nil
+	return
+}
+
+func findSession(auth Auth, id string) (session Session, err error) {
+	if !IsSessionID(id) || len(id) == len(SessionPrefix) {
+		err = ErrInvalidSession
+		return
+	}
+
+	store, err := getSessionStore(auth)
+	if err != nil {
+		return
+	}
+
+	session, err = store.FindOneByID(id)
+	if err != nil {
+		if store.IsErrNotFound(err) {
+			err = ErrInvalidSession
+			return
+		}
+
+		err = errors.Wrap(err, "could not find the session")
+		return
+	}
+
+	if session.Expired(currentTime(auth)) {
+		session = Session{}
+		err = store.Delete(id)
+		if err != nil && !store.IsErrNotFound(err) {
+			err = errors.Wrap(err, "could not delete the expired session")
+			return
+		}
+
+		err = ErrSessionExpired
+		return
+	}
+	return
+}
+
+// getSessionStore returns the session store of the Auth if it is a SessionAuth
+func getSessionStore(auth Auth) (SessionStore, error) {
+	sessioned, ok := auth.(SessionAuth)
+	if !ok {
+		return nil, MissingServiceError{Service: "session store"}
+	}
+
+	return sessioned.SessionStore()
+}
+
+// currentTime returns the time of the Auth if it is a ClockAuth
+func currentTime(auth Auth) time.Time {
+	if clock, ok := auth.(ClockAuth); ok {
+		return clock.Clock()
+	}
+
+	return time.Now()
+}
+
+func generateSessionID() (string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate session ID")
+	}
+
+	return SessionPrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
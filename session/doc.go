@@ -0,0 +1,2 @@
+// Package session contains the session stores used by the session subsystem of gate, see gate.CreateSession
+package session
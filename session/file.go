@@ -0,0 +1,189 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
+)
+
+const fileExtension = ".json"
+
+// FileStore is the session store keeping each session in a file of a directory.
+// Only the hash of the session ID is kept, naming the file and in its content, so the directory does not leak valid IDs.
+// Touch and Delete are serialized within the process, stores sharing the directory across processes may race
+type FileStore struct {
+	dir string
+	*sync.Mutex
+}
+
+// NewFileStore is the constructor for FileStore. The directory is created if it does not exist
+func NewFileStore(dir string) (store FileStore, err error) {
+	if dir == "" {
+		err = errors.New("missing directory")
+		return
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		err = errors.Wrap(err, "could not create the directory")
+		return
+	}
+
+	store = FileStore{dir, &sync.Mutex{}}
+	return
+}
+
+// FindOneByID fetches the session with the given ID
+func (store FileStore) FindOneByID(id string) (session gate.Session, err error) {
+	data, err := ioutil.ReadFile(store.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrNotFound
+			return
+		}
+
+		err = errors.Wrap(err, "could not read the session")
+		return
+	}
+
+	err = json.Unmarshal(data, &session)
+	if err != nil {
+		err = errors.Wrap(err, "could not decode the session")
+		return
+	}
+
+	if session.ID != hash(id) {
+		session = gate.Session{}
+		err = ErrNotFound
+		return
+	}
+
+	session.ID = id
+	return
+}
+
+// Store writes the session. The file is replaced atomically
+func (store FileStore) Store(session gate.Session) error {
+	store.Lock()
+	defer store.Unlock()
+
+	return store.write(session)
+}
+
+// Touch updates the last use of the session with the given ID
+func (store FileStore) Touch(id string, lastSeenAt time.Time) error {
+	store.Lock()
+	defer store.Unlock()
+
+	session, err := store.FindOneByID(id)
+	if err != nil {
+		return err
+	}
+
+	session.LastSeenAt = lastSeenAt
+	return store.write(session)
+}
+
+func (store FileStore) write(session gate.Session) error {
+	path := store.path(session.ID)
+	session.ID = hash(session.ID)
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return errors.Wrap(err, "could not encode the session")
+	}
+
+	file, err := ioutil.TempFile(store.dir, ".session")
+	if err != nil {
+		return errors.Wrap(err, "could not create the session file")
+	}
+
+	_, err = file.Write(data)
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return errors.Wrap(err, "could not write the session file")
+	}
+
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		os.Remove(file.Name())
+		return errors.Wrap(err, "could not write the session file")
+	}
+
+	return nil
+}
+
+// Delete removes the session with the given ID
+func (store FileStore) Delete(id string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	err := os.Remove(store.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+
+		return errors.Wrap(err, "could not delete the session")
+	}
+
+	return nil
+}
+
+// IsErrNotFound determines whether the error is not found error or not
+func (store FileStore) IsErrNotFound(err error) bool {
+	return err == ErrNotFound
+}
+
+// Prune removes the sessions expired at the given time. It should be run periodically
+func (store FileStore) Prune(now time.Time) error {
+	files, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		return errors.Wrap(err, "could not read the directory")
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), fileExtension) {
+			continue
+		}
+
+		path := filepath.Join(store.dir, file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		var session gate.Session
+		if json.Unmarshal(data, &session) == nil && !session.Expired(now) {
+			continue
+		}
+
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "could not delete the session")
+		}
+	}
+
+	return nil
+}
+
+func (store FileStore) path(id string) string {
+	return filepath.Join(store.dir, hash(id)+fileExtension)
+}
+
+func hash(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
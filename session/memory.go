@@ -0,0 +1,105 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is thrown when a session is unknown
+var ErrNotFound = errors.New("session not found")
+
+// minPruneThreshold is the number of sessions from which MemoryStore drops the expired ones
+const minPruneThreshold = 1024
+
+// MemoryStore is the in-memory session store
+type MemoryStore struct {
+	records   map[string]gate.Session
+	threshold *int
+	*sync.Mutex
+}
+
+// NewMemoryStore is the constructor for MemoryStore
+func NewMemoryStore() MemoryStore {
+	threshold := minPruneThreshold
+	return MemoryStore{
+		records:   map[string]gate.Session{},
+		threshold: &threshold,
+		Mutex:     &sync.Mutex{},
+	}
+}
+
+// FindOneByID fetches the session with the given ID
+func (store MemoryStore) FindOneByID(id string) (gate.Session, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	session, ok := store.records[id]
+	if !ok {
+		return gate.Session{}, ErrNotFound
+	}
+
+	return session, nil
+}
+
+// Store keeps the session. Expired sessions are dropped
+func (store MemoryStore) Store(session gate.Session) error {
+	store.Lock()
+	defer store.Unlock()
+
+	if len(store.records) >= *store.threshold {
+		store.prune(time.Now())
+	}
+
+	store.records[session.ID] = session
+	return nil
+}
+
+// Touch updates the last use of the session with the given ID
+func (store MemoryStore) Touch(id string, lastSeenAt time.Time) error {
+	store.Lock()
+	defer store.Unlock()
+
+	session, ok := store.records[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	session.LastSeenAt = lastSeenAt
+	store.records[id] = session
+	return nil
+}
+
+// prune drops the expired sessions. The next sweep happens when the remaining ones double, so storing stays constant on average
+func (store MemoryStore) prune(now time.Time) {
+	for id, session := range store.records {
+		if session.Expired(now) {
+			delete(store.records, id)
+		}
+	}
+
+	*store.threshold = len(store.records) * 2
+	if *store.threshold < minPruneThreshold {
+		*store.threshold = minPruneThreshold
+	}
+}
+
+// Delete removes the session with the given ID
+func (store MemoryStore) Delete(id string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	if _, ok := store.records[id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(store.records, id)
+	return nil
+}
+
+// IsErrNotFound determines whether the error is not found error or not
+func (store MemoryStore) IsErrNotFound(err error) bool {
+	return err == ErrNotFound
+}
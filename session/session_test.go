@@ -0,0 +1,162 @@
+package session_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/internal/test"
+	"github.com/hiendv/gate/session"
+)
+
+func testStore(t *testing.T, store gate.SessionStore) {
+	now := time.Now()
+	record := gate.Session{
+		ID:          "sess_id",
+		UserID:      "user-id",
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		ExpiredAt:   now.Add(time.Hour),
+		IdleTimeout: time.Minute,
+	}
+
+	_, err := store.FindOneByID(record.ID)
+	if !store.IsErrNotFound(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = store.Store(record)
+	test.AssertOK(t, err, "valid session")
+
+	found, err := store.FindOneByID(record.ID)
+	test.AssertOK(t, err, "existing session")
+
+	if found.UserID != record.UserID || !found.ExpiredAt.Equal(record.ExpiredAt) || found.IdleTimeout != record.IdleTimeout {
+		t.Fatalf("unexpected session: %v", found)
+	}
+
+	record.LastSeenAt = now.Add(time.Second)
+	err = store.Store(record)
+	test.AssertOK(t, err, "replaced session")
+
+	found, err = store.FindOneByID(record.ID)
+	test.AssertOK(t, err, "existing session")
+
+	if !found.LastSeenAt.Equal(record.LastSeenAt) {
+		t.Fatalf("unexpected session: %v", found)
+	}
+
+	err = store.Touch(record.ID, now.Add(time.Minute))
+	test.AssertOK(t, err, "existing session")
+
+	found, err = store.FindOneByID(record.ID)
+	test.AssertOK(t, err, "existing session")
+
+	if !found.LastSeenAt.Equal(now.Add(time.Minute)) || found.UserID != record.UserID {
+		t.Fatalf("unexpected session: %v", found)
+	}
+
+	err = store.Delete(record.ID)
+	test.AssertOK(t, err, "existing session")
+
+	err = store.Touch(record.ID, now)
+	if !store.IsErrNotFound(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = store.Delete(record.ID)
+	if !store.IsErrNotFound(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = store.FindOneByID(record.ID)
+	if !store.IsErrNotFound(err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, session.NewMemoryStore())
+
+	t.Run("expiration", func(t *testing.T) {
+		store := session.NewMemoryStore()
+		err := store.Store(gate.Session{ID: "sess_active", ExpiredAt: time.Now().Add(time.Hour)})
+		test.AssertOK(t, err, "valid session")
+
+		for i := 0; i < 2048; i++ {
+			err = store.Store(gate.Session{ID: "sess_" + strconv.Itoa(i), ExpiredAt: time.Now().Add(-time.Second)})
+			test.AssertOK(t, err, "valid session")
+		}
+
+		_, err = store.FindOneByID("sess_0")
+		test.AssertErr(t, err, "expired sessions should be dropped")
+
+		_, err = store.FindOneByID("sess_active")
+		test.AssertOK(t, err, "active sessions should be kept")
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gate-sessions")
+	test.AssertOK(t, err, "valid directory")
+	defer os.RemoveAll(dir)
+
+	_, err = session.NewFileStore("")
+	test.AssertErr(t, err, "missing directory")
+
+	store, err := session.NewFileStore(filepath.Join(dir, "sessions"))
+	test.AssertOK(t, err, "valid directory")
+
+	testStore(t, store)
+
+	t.Run("file names", func(t *testing.T) {
+		err := store.Store(gate.Session{ID: "sess_../../escape", ExpiredAt: time.Now().Add(time.Hour)})
+		test.AssertOK(t, err, "valid session")
+
+		files, err := ioutil.ReadDir(filepath.Join(dir, "sessions"))
+		test.AssertOK(t, err, "valid directory")
+
+		if len(files) != 1 || files[0].Name() == "sess_../../escape" {
+			t.Fatalf("unexpected files: %v", files)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "sessions", files[0].Name()))
+		test.AssertOK(t, err, "valid file")
+
+		if strings.Contains(string(data), "escape") {
+			t.Fatalf("the session ID should not be written: %s", data)
+		}
+
+		stored, err := store.FindOneByID("sess_../../escape")
+		test.AssertOK(t, err, "existing session")
+
+		if stored.ID != "sess_../../escape" {
+			t.Fatalf("unexpected session: %v", stored)
+		}
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		now := time.Now()
+		err := store.Store(gate.Session{ID: "sess_active", LastSeenAt: now, ExpiredAt: now.Add(time.Hour), IdleTimeout: time.Minute})
+		test.AssertOK(t, err, "valid session")
+
+		err = store.Store(gate.Session{ID: "sess_idle", LastSeenAt: now.Add(-time.Hour), ExpiredAt: now.Add(time.Hour), IdleTimeout: time.Minute})
+		test.AssertOK(t, err, "valid session")
+
+		err = store.Prune(now)
+		test.AssertOK(t, err, "valid directory")
+
+		_, err = store.FindOneByID("sess_active")
+		test.AssertOK(t, err, "active session")
+
+		_, err = store.FindOneByID("sess_idle")
+		if !store.IsErrNotFound(err) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
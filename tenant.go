@@ -0,0 +1,111 @@
+package gate
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotTenantMember is thrown when a user has no roles in a tenant
+	ErrNotTenantMember = errors.New("the user is not a member of the tenant")
+
+	// ErrTenantMismatch is thrown when a user bound to a tenant, e.g. by a tenant JWT, acts in another tenant. It matches ErrNotTenantMember
+	ErrTenantMismatch error = KindError{"the user is bound to another tenant", ErrNotTenantMember}
+)
+
+// TenantUser is the contract for users whose roles are assigned per tenant, e.g. admin of an organization and viewer of another
+type TenantUser interface {
+	User
+	GetTenantRoles(tenant string) []string
+}
+
+// TenantMember is the user acting in a tenant. Its roles are only the ones bound to the tenant
+type TenantMember struct {
+	User
+	Tenant string
+}
+
+// GetRoles returns the roles of the user in the tenant
+func (member TenantMember) GetRoles() []string {
+	user, ok := member.User.(TenantUser)
+	if !ok {
+		return nil
+	}
+
+	return user.GetTenantRoles(member.Tenant)
+}
+
+// GetScopes returns the scopes of the user, if any, so binding a scoped user to a tenant keeps its restrictions
+func (member TenantMember) GetScopes() []UserAbility {
+	user, ok := member.User.(ScopedUser)
+	if !ok {
+		return nil
+	}
+
+	return user.GetScopes()
+}
+
+// NewTenantMember binds a user to a tenant. The user must have roles in the tenant and must not be bound to another one
+func NewTenantMember(user User, tenant string) (member TenantMember, err error) {
+	if tenant == "" {
+		err = errors.New("invalid tenant")
+		return
+	}
+
+	if current, ok := user.(TenantMember); ok {
+		if current.Tenant != tenant {
+			err = ErrTenantMismatch
+			return
+		}
+
+		user = current.User
+	}
+
+	member = TenantMember{user, tenant}
+	if len(member.GetRoles()) == 0 {
+		member = TenantMember{}
+		err = ErrNotTenantMember
+		return
+	}
+	return
+}
+
+// IssueTenantJWT issues and stores a JWT for a specific user acting in a tenant. The token carries the tenant and the roles bound to it
+func IssueTenantJWT(auth Auth, user User, tenant string) (token JWT, err error) {
+	member, err := NewTenantMember(user, tenant)
+	if err != nil {
+		return
+	}
+
+	return IssueJWT(auth, member)
+}
+
+// AuthorizeTenant performs the authorization when a given user takes an action on an object in a tenant using only the roles bound to the tenant
+func AuthorizeTenant(auth Auth, user User, tenant, action, object string) (err error) {
+	start := time.Now()
+	member, err := NewTenantMember(user, tenant)
+	if err == nil {
+		return Authorize(auth, member, action, object)
+	}
+
+	measure(auth, MetricAuthorizations, MetricAuthorizationDuration, start, err)
+
+	event := AuditEvent{Type: EventAuthorizationDenied, Tenant: tenant, Action: action, Object: object, Reason: auditReason(err)}
+	if user != nil {
+		event.UserID = user.GetID()
+	}
+
+	Audit(auth, event)
+	return
+}
+
+// GetUserTenantAbilities returns a user's abilities in a tenant
+func GetUserTenantAbilities(auth Auth, user User, tenant string) (abilities []UserAbility, err error) {
+	member, err := NewTenantMember(user, tenant)
+	if err != nil {
+		return
+	}
+
+	return GetUserAbilities(auth, member)
+}
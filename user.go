@@ -13,8 +13,36 @@ type Role interface {
 	GetAbilities() []UserAbility
 }
 
+// DenyingRole is the contract for roles which deny abilities. Denied abilities override the abilities of every role of the user
+type DenyingRole interface {
+	Role
+	GetDeniedAbilities() []UserAbility
+}
+
 // UserAbility is the contract for the ability entity
 type UserAbility interface {
 	GetAction() string
 	GetObject() string
 }
+
+// ScopedUser is the contract for users whose abilities are restricted to a set of scopes, e.g. users authenticated by API keys
+type ScopedUser interface {
+	User
+	GetScopes() []UserAbility
+}
+
+// Ability is the plain implementation of UserAbility
+type Ability struct {
+	Action string
+	Object string
+}
+
+// GetAction returns ability action
+func (ability Ability) GetAction() string {
+	return ability.Action
+}
+
+// GetObject returns ability object
+func (ability Ability) GetObject() string {
+	return ability.Object
+}
@@ -0,0 +1,202 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/hiendv/gate/internal"
+	"github.com/pkg/errors"
+)
+
+// Authenticator data flags
+const (
+	FlagUserPresent            byte = 0x01
+	FlagUserVerified           byte = 0x04
+	FlagAttestedCredentialData byte = 0x40
+	FlagExtensionData          byte = 0x80
+)
+
+// COSE algorithm identifiers supported by the driver
+const (
+	AlgorithmES256 int64 = -7
+	AlgorithmEdDSA int64 = -8
+	AlgorithmRS256 int64 = -257
+)
+
+// AuthenticatorData is the parsed authenticator data of a ceremony
+type AuthenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// ParseAuthenticatorData parses the raw authenticator data
+func ParseAuthenticatorData(data []byte) (result AuthenticatorData, err error) {
+	if len(data) < 37 {
+		err = errors.New("authenticator data is too short")
+		return
+	}
+
+	result.RPIDHash = data[:32]
+	result.Flags = data[32]
+	result.SignCount = binary.BigEndian.Uint32(data[33:37])
+
+	if result.Flags&FlagAttestedCredentialData == 0 {
+		return
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		err = errors.New("attested credential data is too short")
+		return
+	}
+
+	result.AAGUID = rest[:16]
+	length := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < length {
+		err = errors.New("credential ID is too short")
+		return
+	}
+
+	result.CredentialID = rest[:length]
+	rest = rest[length:]
+
+	_, remaining, err := internal.CBORDecode(rest)
+	if err != nil {
+		err = errors.Wrap(err, "invalid credential public key")
+		return
+	}
+
+	result.PublicKey = rest[:len(rest)-len(remaining)]
+	return
+}
+
+// HasFlag checks if the given flag is set
+func (data AuthenticatorData) HasFlag(flag byte) bool {
+	return data.Flags&flag == flag
+}
+
+// VerifyRPID checks if the authenticator data is scoped to the given relying party ID
+func (data AuthenticatorData) VerifyRPID(rpID string) bool {
+	hash := sha256.Sum256([]byte(rpID))
+	return bytes.Equal(data.RPIDHash, hash[:])
+}
+
+// PublicKey is the credential public key decoded from its COSE representation
+type PublicKey struct {
+	Algorithm int64
+	Key       crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key (RFC 8152) into a public key
+func ParsePublicKey(data []byte) (key PublicKey, err error) {
+	value, _, err := internal.CBORDecode(data)
+	if err != nil {
+		return
+	}
+
+	params, ok := value.(map[interface{}]interface{})
+	if !ok {
+		err = errors.New("invalid COSE key")
+		return
+	}
+
+	kty, _ := params[int64(1)].(int64)
+	key.Algorithm, _ = params[int64(3)].(int64)
+
+	switch key.Algorithm {
+	default:
+		err = errors.Errorf("unsupported algorithm: %d", key.Algorithm)
+	case AlgorithmES256:
+		if kty != 2 {
+			err = errors.New("invalid key type")
+			return
+		}
+
+		crv, _ := params[int64(-1)].(int64)
+		x, _ := params[int64(-2)].([]byte)
+		y, _ := params[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			err = errors.New("invalid EC2 key")
+			return
+		}
+
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			err = errors.New("invalid EC2 key")
+			return
+		}
+
+		key.Key = publicKey
+	case AlgorithmRS256:
+		if kty != 3 {
+			err = errors.New("invalid key type")
+			return
+		}
+
+		n, _ := params[int64(-1)].([]byte)
+		e, _ := params[int64(-2)].([]byte)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			err = errors.New("invalid RSA key")
+			return
+		}
+
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		key.Key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+	case AlgorithmEdDSA:
+		if kty != 1 {
+			err = errors.New("invalid key type")
+			return
+		}
+
+		crv, _ := params[int64(-1)].(int64)
+		x, _ := params[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			err = errors.New("invalid OKP key")
+			return
+		}
+
+		key.Key = ed25519.PublicKey(x)
+	}
+
+	return
+}
+
+// ecdsaSignature is the ASN.1 structure of an ECDSA signature
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// Verify checks the signature of the given message
+func (key PublicKey) Verify(message, signature []byte) bool {
+	switch publicKey := key.Key.(type) {
+	case *ecdsa.PublicKey:
+		var parsed ecdsaSignature
+		rest, err := asn1.Unmarshal(signature, &parsed)
+		if err != nil || len(rest) != 0 || parsed.R == nil || parsed.S == nil {
+			return false
+		}
+
+		hash := sha256.Sum256(message)
+		return ecdsa.Verify(publicKey, hash[:], parsed.R, parsed.S)
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(publicKey, message, signature)
+	}
+
+	return false
+}
@@ -0,0 +1,96 @@
+package webauthn
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrChallengeNotFound is thrown when a challenge is unknown or has already been used
+	ErrChallengeNotFound = errors.New("challenge not found")
+
+	// ErrChallengeUserMismatch is thrown when a challenge was issued for another user. It matches gate.ErrInvalidCredentials
+	ErrChallengeUserMismatch error = gate.KindError{Message: "the challenge was issued for another user", Kind: gate.ErrInvalidCredentials}
+
+	// ErrChallengeCeremonyMismatch is thrown when a challenge was issued for another ceremony. It matches gate.ErrInvalidCredentials
+	ErrChallengeCeremonyMismatch error = gate.KindError{Message: "the challenge was issued for another ceremony", Kind: gate.ErrInvalidCredentials}
+)
+
+// Challenge is the random value an authenticator signs during a ceremony
+type Challenge struct {
+	Value     string
+	UserID    string
+	Ceremony  string
+	ExpiredAt time.Time
+}
+
+// ChallengeStore is the contract which keeps pending challenges. Take must remove the challenge so it can only be used once
+type ChallengeStore interface {
+	Store(Challenge) error
+	Take(string) (Challenge, error)
+}
+
+// minPruneThreshold is the number of challenges from which MemoryChallengeStore drops the expired ones
+const minPruneThreshold = 1024
+
+// MemoryChallengeStore is the in-memory ChallengeStore
+type MemoryChallengeStore struct {
+	records   map[string]Challenge
+	threshold *int
+	*sync.Mutex
+}
+
+// NewMemoryChallengeStore is the constructor for MemoryChallengeStore
+func NewMemoryChallengeStore() MemoryChallengeStore {
+	threshold := minPruneThreshold
+	return MemoryChallengeStore{
+		records:   map[string]Challenge{},
+		threshold: &threshold,
+		Mutex:     &sync.Mutex{},
+	}
+}
+
+// Store keeps the challenge until it is taken. Challenges which are never taken are dropped once they expire
+func (store MemoryChallengeStore) Store(challenge Challenge) error {
+	store.Lock()
+	defer store.Unlock()
+
+	if len(store.records) >= *store.threshold {
+		store.prune(time.Now())
+	}
+
+	store.records[challenge.Value] = challenge
+	return nil
+}
+
+// prune drops the expired challenges. The next sweep happens when the remaining ones double, so storing stays constant on average
+func (store MemoryChallengeStore) prune(now time.Time) {
+	for value, challenge := range store.records {
+		if now.After(challenge.ExpiredAt) {
+			delete(store.records, value)
+		}
+	}
+
+	*store.threshold = len(store.records) * 2
+	if *store.threshold < minPruneThreshold {
+		*store.threshold = minPruneThreshold
+	}
+}
+
+// Take removes and returns the challenge with the given value
+func (store MemoryChallengeStore) Take(value string) (challenge Challenge, err error) {
+	store.Lock()
+	defer store.Unlock()
+
+	challenge, ok := store.records[value]
+	if !ok {
+		err = ErrChallengeNotFound
+		return
+	}
+
+	delete(store.records, value)
+	return
+}
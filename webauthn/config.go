@@ -0,0 +1,17 @@
+package webauthn
+
+import (
+	"time"
+
+	"github.com/hiendv/gate"
+)
+
+// Config is the configuration for WebAuthn authentication
+type Config struct {
+	gate.Config
+	RPID                    string
+	RPName                  string
+	Origin                  string
+	Timeout                 time.Duration
+	RequireUserVerification bool
+}
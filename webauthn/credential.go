@@ -0,0 +1,21 @@
+package webauthn
+
+// Credential is the public key credential registered by an authenticator
+type Credential struct {
+	ID        string
+	UserID    string
+	PublicKey []byte
+	SignCount uint32
+	Format    string
+}
+
+// CredentialService is the contract which offers queries on the credential entity.
+// UpdateSignCount must replace the signature counter atomically, only if it is still the previous one, and report whether it did
+// so parallel assertions of a cloned authenticator cannot all succeed
+type CredentialService interface {
+	FindOneByID(string) (Credential, error)
+	FindByUserID(string) ([]Credential, error)
+	Store(Credential) error
+	UpdateSignCount(id string, previous, count uint32) (bool, error)
+	IsErrNotFound(error) bool
+}
@@ -0,0 +1,2 @@
+// Package webauthn is the WebAuthn (passkey) authentication driver for github.com/hiendv/gate. It verifies registration and assertion ceremonies performed by authenticators through the browser.
+package webauthn
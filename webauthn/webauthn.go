@@ -0,0 +1,609 @@
+package webauthn
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal"
+	"github.com/pkg/errors"
+)
+
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+)
+
+// Provider is the provider of audit events of WebAuthn authentication
+const Provider = "webauthn"
+
+// DefaultTimeout is the challenge lifetime used when the configuration omits it
+const DefaultTimeout = time.Minute * 5
+
+// ErrSignCountInvalid is thrown when the signature counter does not increase, the authenticator may be cloned. It matches gate.ErrInvalidCredentials
+var ErrSignCountInvalid error = gate.KindError{Message: "invalid signature counter, the authenticator may be cloned", Kind: gate.ErrInvalidCredentials}
+
+var encoding = base64.RawURLEncoding
+
+// Driver is WebAuthn authentication
+type Driver struct {
+	dependency.Container
+	config            Config
+	credentials       CredentialService
+	challenges        ChallengeStore
+	Now               func() time.Time
+	GenerateChallenge func() (string, error)
+}
+
+// RelyingParty is the relying party entity of the ceremony options
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity is the user entity of the registration options
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter is the acceptable credential type of the registration options
+type CredentialParameter struct {
+	Type      string `json:"type"`
+	Algorithm int64  `json:"alg"`
+}
+
+// CredentialDescriptor refers to a registered credential
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// CreationOptions are the options for navigator.credentials.create()
+type CreationOptions struct {
+	Challenge          string                 `json:"challenge"`
+	RelyingParty       RelyingParty           `json:"rp"`
+	User               UserEntity             `json:"user"`
+	Parameters         []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout            int64                  `json:"timeout"`
+	ExcludeCredentials []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	Attestation        string                 `json:"attestation"`
+}
+
+// RequestOptions are the options for navigator.credentials.get()
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// New is the constructor for Driver
+func New(config Config, credentials CredentialService, container dependency.Container) *Driver {
+	var driver = &Driver{}
+
+	if config.RPID == "" || config.Origin == "" {
+		return nil
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	driver.config = config
+
+	if credentials == nil {
+		return nil
+	}
+	driver.credentials = credentials
+	driver.challenges = NewMemoryChallengeStore()
+	driver.Now = func() time.Time {
+		return time.Now().Local()
+	}
+	driver.GenerateChallenge = func() (string, error) {
+		challenge := make([]byte, 32)
+		_, err := rand.Read(challenge)
+		if err != nil {
+			return "", err
+		}
+
+		return encoding.EncodeToString(challenge), nil
+	}
+
+	jwtConfig, err := gate.NewHMACJWTConfig("HS256", config.JWTSigningKey(), config.JWTExpiration(), config.JWTSkipClaimsValidation())
+	if err != nil {
+		return nil
+	}
+
+	container.SetJWTService(gate.NewJWTService(jwtConfig))
+	container.SetMatcher(internal.NewMatcher())
+	driver.Container = container
+
+	return driver
+}
+
+// GetChallengeStore is the getter for challenge store
+func (auth Driver) GetChallengeStore() ChallengeStore {
+	return auth.challenges
+}
+
+// SetChallengeStore is the setter for challenge store
+func (auth *Driver) SetChallengeStore(store ChallengeStore) {
+	auth.challenges = store
+}
+
+// LoginURL returns the URL to the consent page
+func (auth Driver) LoginURL(state string) (string, error) {
+	return "", errors.New("the driver does not support login URL")
+}
+
+// BeginRegistration starts the registration ceremony for a specific user
+func (auth Driver) BeginRegistration(user gate.User) (options CreationOptions, err error) {
+	if user == nil {
+		err = errors.New("invalid user")
+		return
+	}
+
+	credentials, err := auth.credentials.FindByUserID(user.GetID())
+	if err != nil && !auth.credentials.IsErrNotFound(err) {
+		err = errors.Wrap(err, "could not find the credentials")
+		return
+	}
+
+	challenge, err := auth.newChallenge(ceremonyCreate, user.GetID())
+	if err != nil {
+		return
+	}
+
+	options = CreationOptions{
+		Challenge:    challenge.Value,
+		RelyingParty: RelyingParty{ID: auth.config.RPID, Name: auth.config.RPName},
+		User: UserEntity{
+			ID:          encoding.EncodeToString([]byte(user.GetID())),
+			Name:        user.GetEmail(),
+			DisplayName: user.GetName(),
+		},
+		Parameters: []CredentialParameter{
+			{Type: "public-key", Algorithm: AlgorithmES256},
+			{Type: "public-key", Algorithm: AlgorithmEdDSA},
+			{Type: "public-key", Algorithm: AlgorithmRS256},
+		},
+		Timeout:            int64(auth.config.Timeout / time.Millisecond),
+		ExcludeCredentials: descriptors(credentials),
+		Attestation:        "none",
+	}
+	return
+}
+
+// FinishRegistration verifies the authenticator response and stores the credential for a specific user.
+// The response must contain base64url-encoded "client_data" and "attestation_object".
+func (auth Driver) FinishRegistration(user gate.User, response map[string]string) (credential Credential, err error) {
+	if user == nil {
+		err = errors.New("invalid user")
+		return
+	}
+
+	rawClientData, err := decodeField(response, "client_data")
+	if err != nil {
+		return
+	}
+
+	rawAttestation, err := decodeField(response, "attestation_object")
+	if err != nil {
+		return
+	}
+
+	challenge, err := auth.verifyClientData(rawClientData, ceremonyCreate)
+	if err != nil {
+		return
+	}
+
+	if challenge.UserID != user.GetID() {
+		err = ErrChallengeUserMismatch
+		return
+	}
+
+	attestation, err := parseAttestation(rawAttestation)
+	if err != nil {
+		return
+	}
+
+	data, err := auth.verifyAuthenticatorData(attestation.authData)
+	if err != nil {
+		return
+	}
+
+	if !data.HasFlag(FlagAttestedCredentialData) || len(data.CredentialID) == 0 {
+		err = errors.New("missing attested credential data")
+		return
+	}
+
+	publicKey, err := ParsePublicKey(data.PublicKey)
+	if err != nil {
+		err = errors.Wrap(err, "invalid credential public key")
+		return
+	}
+
+	clientDataHash := sha256.Sum256(rawClientData)
+	err = verifyAttestationStatement(attestation, publicKey, clientDataHash[:])
+	if err != nil {
+		return
+	}
+
+	credential = Credential{
+		ID:        encoding.EncodeToString(data.CredentialID),
+		UserID:    user.GetID(),
+		PublicKey: data.PublicKey,
+		SignCount: data.SignCount,
+		Format:    attestation.format,
+	}
+
+	_, err = auth.credentials.FindOneByID(credential.ID)
+	if err == nil {
+		err = errors.New("the credential is already registered")
+		return
+	}
+
+	if !auth.credentials.IsErrNotFound(err) {
+		err = errors.Wrap(err, "could not find the credential")
+		return
+	}
+
+	err = auth.credentials.Store(credential)
+	if err != nil {
+		err = errors.Wrap(err, "could not store the credential")
+		return
+	}
+	return
+}
+
+// BeginLogin starts the assertion ceremony. The user is optional for discoverable credentials
+func (auth Driver) BeginLogin(user gate.User) (options RequestOptions, err error) {
+	var userID string
+	var credentials []Credential
+	if user != nil {
+		userID = user.GetID()
+		credentials, err = auth.credentials.FindByUserID(userID)
+		if err != nil {
+			err = errors.Wrap(err, "could not find the credentials")
+			return
+		}
+
+		if len(credentials) == 0 {
+			err = errors.New("the user has no credentials")
+			return
+		}
+	}
+
+	challenge, err := auth.newChallenge(ceremonyGet, userID)
+	if err != nil {
+		return
+	}
+
+	options = RequestOptions{
+		Challenge:        challenge.Value,
+		RPID:             auth.config.RPID,
+		Timeout:          int64(auth.config.Timeout / time.Millisecond),
+		AllowCredentials: descriptors(credentials),
+		UserVerification: "preferred",
+	}
+	if auth.config.RequireUserVerification {
+		options.UserVerification = "required"
+	}
+	return
+}
+
+// Login resolves WebAuthn authentication with the given assertion.
+// The credentials must contain "credential_id" and base64url-encoded "client_data", "authenticator_data" and "signature".
+func (auth Driver) Login(credentials map[string]string) (user gate.User, err error) {
+	defer func(start time.Time) {
+		gate.AuditLogin(auth, Provider, credentials["credential_id"], user, err)
+		gate.MeasureLogin(auth, Provider, start, err)
+	}(time.Now())
+
+	credentialID, ok := credentials["credential_id"]
+	if !ok {
+		err = gate.MissingCredentialsError{Credential: "credential ID"}
+		return
+	}
+
+	rawClientData, err := decodeField(credentials, "client_data")
+	if err != nil {
+		return
+	}
+
+	rawAuthenticatorData, err := decodeField(credentials, "authenticator_data")
+	if err != nil {
+		return
+	}
+
+	signature, err := decodeField(credentials, "signature")
+	if err != nil {
+		return
+	}
+
+	credential, err := auth.credentials.FindOneByID(credentialID)
+	if err != nil {
+		if auth.credentials.IsErrNotFound(err) {
+			err = errors.Wrap(gate.ErrInvalidCredentials, "unknown credential")
+			return
+		}
+
+		err = errors.Wrap(err, "could not find the credential")
+		return
+	}
+
+	challenge, err := auth.verifyClientData(rawClientData, ceremonyGet)
+	if err != nil {
+		return
+	}
+
+	if challenge.UserID != "" && challenge.UserID != credential.UserID {
+		err = ErrChallengeUserMismatch
+		return
+	}
+
+	data, err := auth.verifyAuthenticatorData(rawAuthenticatorData)
+	if err != nil {
+		return
+	}
+
+	publicKey, err := ParsePublicKey(credential.PublicKey)
+	if err != nil {
+		err = errors.Wrap(err, "invalid credential public key")
+		return
+	}
+
+	clientDataHash := sha256.Sum256(rawClientData)
+	if !publicKey.Verify(append(append([]byte{}, rawAuthenticatorData...), clientDataHash[:]...), signature) {
+		err = errors.Wrap(gate.ErrInvalidCredentials, "invalid signature")
+		return
+	}
+
+	// Authenticators without counters always report zero
+	if data.SignCount != 0 || credential.SignCount != 0 {
+		if data.SignCount <= credential.SignCount {
+			err = ErrSignCountInvalid
+			return
+		}
+
+		var updated bool
+		updated, err = auth.credentials.UpdateSignCount(credential.ID, credential.SignCount, data.SignCount)
+		if err != nil {
+			err = errors.Wrap(err, "could not update the signature counter")
+			return
+		}
+
+		// Another assertion updated the counter in between
+		if !updated {
+			err = ErrSignCountInvalid
+			return
+		}
+	}
+
+	service, err := auth.UserService()
+	if err != nil {
+		err = errors.Wrap(err, "invalid user service")
+		return
+	}
+
+	user, err = service.FindOneByID(credential.UserID)
+	if err != nil {
+		err = errors.Wrap(err, "could not find the user")
+		return
+	}
+	return
+}
+
+// IssueJWT issues and stores a JWT for a specific user
+func (auth Driver) IssueJWT(user gate.User) (gate.JWT, error) {
+	return gate.IssueJWT(auth, user)
+}
+
+// ParseJWT parses a JWT string to a JWT
+func (auth Driver) ParseJWT(tokenString string) (gate.JWT, error) {
+	return gate.ParseJWT(auth, tokenString)
+}
+
+// Authenticate performs the authentication using JWT
+func (auth Driver) Authenticate(tokenString string) (gate.User, error) {
+	return gate.Authenticate(auth, tokenString)
+}
+
+// GetUserFromJWT returns a user from a given JWT
+func (auth Driver) GetUserFromJWT(token gate.JWT) (user gate.User, err error) {
+	return gate.GetUserFromJWT(auth, token)
+}
+
+// Authorize performs the authorization when a given user takes an action on an object using RBAC
+func (auth Driver) Authorize(user gate.User, action, object string) (err error) {
+	return gate.Authorize(auth, user, action, object)
+}
+
+// GetUserAbilities returns a user's abilities
+func (auth Driver) GetUserAbilities(user gate.User) (abilities []gate.UserAbility, err error) {
+	return gate.GetUserAbilities(auth, user)
+}
+
+func (auth Driver) newChallenge(ceremony, userID string) (challenge Challenge, err error) {
+	if auth.challenges == nil {
+		err = gate.MissingServiceError{Service: "challenge store"}
+		return
+	}
+
+	value, err := auth.GenerateChallenge()
+	if err != nil {
+		err = errors.Wrap(err, "could not generate the challenge")
+		return
+	}
+
+	challenge = Challenge{
+		Value:     value,
+		UserID:    userID,
+		Ceremony:  ceremony,
+		ExpiredAt: auth.Now().Add(auth.config.Timeout),
+	}
+
+	err = auth.challenges.Store(challenge)
+	if err != nil {
+		err = errors.Wrap(err, "could not store the challenge")
+		return
+	}
+	return
+}
+
+func (auth Driver) verifyClientData(raw []byte, ceremony string) (challenge Challenge, err error) {
+	var data clientData
+	err = json.Unmarshal(raw, &data)
+	if err != nil {
+		err = errors.Wrap(err, "invalid client data")
+		return
+	}
+
+	if data.Type != ceremony {
+		err = errors.Errorf("unexpected ceremony: %s", data.Type)
+		return
+	}
+
+	if data.Origin != auth.config.Origin {
+		err = errors.Errorf("unexpected origin: %s", data.Origin)
+		return
+	}
+
+	if auth.challenges == nil {
+		err = gate.MissingServiceError{Service: "challenge store"}
+		return
+	}
+
+	challenge, err = auth.challenges.Take(data.Challenge)
+	if err != nil {
+		err = errors.Wrap(err, "invalid challenge")
+		return
+	}
+
+	if challenge.Ceremony != ceremony {
+		err = ErrChallengeCeremonyMismatch
+		return
+	}
+
+	if auth.Now().After(challenge.ExpiredAt) {
+		err = errors.New("the challenge is expired")
+		return
+	}
+	return
+}
+
+func (auth Driver) verifyAuthenticatorData(raw []byte) (data AuthenticatorData, err error) {
+	data, err = ParseAuthenticatorData(raw)
+	if err != nil {
+		return
+	}
+
+	if !data.VerifyRPID(auth.config.RPID) {
+		err = errors.New("unexpected relying party ID")
+		return
+	}
+
+	if !data.HasFlag(FlagUserPresent) {
+		err = errors.New("the user is not present")
+		return
+	}
+
+	if auth.config.RequireUserVerification && !data.HasFlag(FlagUserVerified) {
+		err = errors.New("the user is not verified")
+		return
+	}
+	return
+}
+
+type attestation struct {
+	format    string
+	statement map[interface{}]interface{}
+	authData  []byte
+}
+
+func parseAttestation(raw []byte) (result attestation, err error) {
+	value, _, err := internal.CBORDecode(raw)
+	if err != nil {
+		err = errors.Wrap(err, "invalid attestation object")
+		return
+	}
+
+	object, ok := value.(map[interface{}]interface{})
+	if !ok {
+		err = errors.New("invalid attestation object")
+		return
+	}
+
+	result.format, _ = object["fmt"].(string)
+	result.statement, _ = object["attStmt"].(map[interface{}]interface{})
+	result.authData, _ = object["authData"].([]byte)
+	if result.format == "" || result.authData == nil {
+		err = errors.New("invalid attestation object")
+		return
+	}
+	return
+}
+
+func verifyAttestationStatement(object attestation, publicKey PublicKey, clientDataHash []byte) error {
+	switch object.format {
+	case "none":
+		if len(object.statement) != 0 {
+			return errors.New("invalid attestation statement")
+		}
+
+		return nil
+	case "packed":
+		if _, ok := object.statement["x5c"]; ok {
+			return errors.New("unsupported attestation: packed with certificates")
+		}
+
+		alg, _ := object.statement["alg"].(int64)
+		signature, _ := object.statement["sig"].([]byte)
+		if alg != publicKey.Algorithm {
+			return errors.New("invalid attestation algorithm")
+		}
+
+		message := append(append([]byte{}, object.authData...), clientDataHash...)
+		if !publicKey.Verify(message, signature) {
+			return errors.New("invalid attestation signature")
+		}
+
+		return nil
+	}
+
+	return errors.Errorf("unsupported attestation format: %s", object.format)
+}
+
+func decodeField(fields map[string]string, key string) (value []byte, err error) {
+	encoded, ok := fields[key]
+	if !ok {
+		err = gate.MissingCredentialsError{Credential: key}
+		return
+	}
+
+	value, err = encoding.DecodeString(encoded)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid %s", key)
+		return
+	}
+	return
+}
+
+func descriptors(credentials []Credential) (result []CredentialDescriptor) {
+	for _, credential := range credentials {
+		result = append(result, CredentialDescriptor{Type: "public-key", ID: credential.ID})
+	}
+	return
+}
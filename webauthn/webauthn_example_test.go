@@ -0,0 +1,123 @@
+package webauthn_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal/test/fixtures"
+	"github.com/hiendv/gate/webauthn"
+)
+
+func Example() {
+	var auth gate.Auth
+
+	userService := fixtures.NewMyUserService(
+		[]fixtures.User{
+			{
+				ID:    "id",
+				Email: "email@local",
+				Roles: []string{"role-id"},
+			},
+		},
+		[]string{"local"},
+	)
+	tokenService := fixtures.NewMyTokenService(nil)
+	roleService := fixtures.NewMyRoleService([]fixtures.Role{
+		{
+			ID: "role-id",
+			Abilities: []fixtures.Ability{
+				{Action: "GET", Object: "/api/v1/*"},
+			},
+		},
+	})
+
+	driver := webauthn.New(
+		webauthn.Config{
+			Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+			RPID:   "localhost",
+			RPName: "Gate",
+			Origin: "http://localhost:8080",
+		},
+		fixtures.NewMyCredentialService(nil),
+		dependency.NewContainer(userService, tokenService, roleService),
+	)
+	if driver == nil {
+		fmt.Println("driver should not be nil")
+		return
+	}
+
+	// The authenticator is usually driven by the browser
+	authenticator, err := fixtures.NewAuthenticator()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	user, err := userService.FindOneByID("id")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	creationOptions, err := driver.BeginRegistration(user)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	attestation, err := authenticator.Create("localhost", "http://localhost:8080", creationOptions.Challenge)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	_, err = driver.FinishRegistration(user, attestation)
+	fmt.Printf("registration: %v\n", err)
+
+	requestOptions, err := driver.BeginLogin(nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	assertion, err := authenticator.Get("localhost", "http://localhost:8080", requestOptions.Challenge)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	auth = driver
+	user, err = auth.Login(assertion)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	jwt, err := auth.IssueJWT(user)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	parsedUser, err := auth.Authenticate(jwt.Value)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("%s:%s - %v\n", parsedUser.GetID(), parsedUser.GetEmail(), err)
+
+	err = auth.Authorize(parsedUser, "GET", "/api/v1/users")
+	fmt.Printf("%v\n", err)
+
+	err = auth.Authorize(parsedUser, "POST", "/api/v1/users")
+	fmt.Printf("%v\n", err)
+
+	// Output:
+	// registration: <nil>
+	// id:email@local - <nil>
+	// <nil>
+	// forbidden
+}
@@ -0,0 +1,504 @@
+package webauthn_test
+
+import (
+	"encoding/base64"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal/test"
+	"github.com/hiendv/gate/internal/test/fixtures"
+	"github.com/hiendv/gate/webauthn"
+	"github.com/pkg/errors"
+)
+
+const (
+	rpID   = "localhost"
+	origin = "http://localhost:8080"
+)
+
+var (
+	auth              gate.Auth
+	driver            *webauthn.Driver
+	userService       *fixtures.MyUserService
+	tokenService      *fixtures.MyTokenService
+	roleService       *fixtures.MyRoleService
+	credentialService *fixtures.MyCredentialService
+)
+
+func TestMain(m *testing.M) {
+	roles := []fixtures.Role{
+		{
+			ID: fixtures.RandomString(8),
+			Abilities: []fixtures.Ability{
+				{Action: "GET", Object: "/api/v1/*"},
+				{Action: "POST", Object: "/api/v1/users*"},
+			},
+		},
+	}
+
+	users := []fixtures.User{
+		{
+			ID:    fixtures.RandomString(8),
+			Email: "foo@local",
+			Roles: []string{roles[0].ID},
+		},
+		{
+			ID:    fixtures.RandomString(8),
+			Email: "bar@local",
+			Roles: []string{},
+		},
+	}
+
+	roleService = fixtures.NewMyRoleService(roles)
+	userService = fixtures.NewMyUserService(users, []string{"local"})
+	tokenService = fixtures.NewMyTokenService(nil)
+	credentialService = fixtures.NewMyCredentialService(nil)
+
+	driver = webauthn.New(
+		newConfig(),
+		credentialService,
+		dependency.NewContainer(userService, tokenService, roleService),
+	)
+
+	auth = driver
+	if auth == nil {
+		os.Exit(1)
+	}
+
+	os.Exit(m.Run())
+}
+
+func register(t *testing.T, user gate.User, authenticator *fixtures.Authenticator) webauthn.Credential {
+	options, err := driver.BeginRegistration(user)
+	test.AssertOK(t, err, "valid user")
+
+	response, err := authenticator.Create(rpID, origin, options.Challenge)
+	test.AssertOK(t, err, "valid authenticator")
+
+	credential, err := driver.FinishRegistration(user, response)
+	test.AssertOK(t, err, "valid registration")
+	return credential
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	user, err := userService.FindOneByEmail("foo@local")
+	test.AssertOK(t, err, "existing user")
+
+	t.Run("options", func(t *testing.T) {
+		options, err := driver.BeginRegistration(user)
+		test.AssertOK(t, err, "valid user")
+
+		if options.RelyingParty.ID != rpID {
+			t.Fatalf("unexpected relying party: %s", options.RelyingParty.ID)
+		}
+
+		id, err := base64.RawURLEncoding.DecodeString(options.User.ID)
+		test.AssertOK(t, err, "valid user handle")
+
+		if string(id) != user.GetID() {
+			t.Fatalf("unexpected user handle: %s", id)
+		}
+
+		_, err = driver.BeginRegistration(nil)
+		test.AssertErr(t, err, "invalid user")
+	})
+
+	t.Run("none attestation", func(t *testing.T) {
+		authenticator, err := fixtures.NewAuthenticator()
+		test.AssertOK(t, err, "valid authenticator")
+
+		credential := register(t, user, authenticator)
+		if credential.ID != authenticator.ID() || credential.UserID != user.GetID() {
+			t.Fatalf("unexpected credential: %v", credential)
+		}
+
+		options, err := driver.BeginRegistration(user)
+		test.AssertOK(t, err, "valid user")
+
+		if len(options.ExcludeCredentials) == 0 {
+			t.Fatal("registered credentials should be excluded")
+		}
+
+		response, err := authenticator.Create(rpID, origin, options.Challenge)
+		test.AssertOK(t, err, "valid authenticator")
+
+		_, err = driver.FinishRegistration(user, response)
+		test.AssertErr(t, err, "registered credential")
+	})
+
+	t.Run("packed self attestation", func(t *testing.T) {
+		authenticator, err := fixtures.NewAuthenticator()
+		test.AssertOK(t, err, "valid authenticator")
+
+		authenticator.Format = "packed"
+		register(t, user, authenticator)
+	})
+
+	t.Run("invalid responses", func(t *testing.T) {
+		authenticator, err := fixtures.NewAuthenticator()
+		test.AssertOK(t, err, "valid authenticator")
+
+		t.Run("missing fields", func(t *testing.T) {
+			_, err := driver.FinishRegistration(user, map[string]string{})
+			test.AssertErr(t, err, "missing client data")
+
+			_, err = driver.FinishRegistration(user, map[string]string{"client_data": "e30"})
+			test.AssertErr(t, err, "missing attestation object")
+
+			_, err = driver.FinishRegistration(user, map[string]string{"client_data": "!", "attestation_object": "e30"})
+			test.AssertErr(t, err, "malformed client data")
+		})
+
+		t.Run("unknown challenge", func(t *testing.T) {
+			response, err := authenticator.Create(rpID, origin, "unknown")
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = driver.FinishRegistration(user, response)
+			test.AssertErr(t, err, "unknown challenge")
+		})
+
+		t.Run("reused challenge", func(t *testing.T) {
+			options, err := driver.BeginRegistration(user)
+			test.AssertOK(t, err, "valid user")
+
+			response, err := authenticator.Create(rpID, origin, options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = driver.FinishRegistration(user, response)
+			test.AssertOK(t, err, "valid registration")
+
+			_, err = driver.FinishRegistration(user, response)
+			test.AssertErr(t, err, "reused challenge")
+		})
+
+		t.Run("another user", func(t *testing.T) {
+			another, err := userService.FindOneByEmail("bar@local")
+			test.AssertOK(t, err, "existing user")
+
+			options, err := driver.BeginRegistration(another)
+			test.AssertOK(t, err, "valid user")
+
+			response, err := authenticator.Create(rpID, origin, options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = driver.FinishRegistration(user, response)
+			if !errors.Is(err, webauthn.ErrChallengeUserMismatch) || !errors.Is(err, gate.ErrInvalidCredentials) {
+				t.Fatalf("unexpected error for the challenge of another user: %v", err)
+			}
+		})
+
+		t.Run("wrong origin", func(t *testing.T) {
+			options, err := driver.BeginRegistration(user)
+			test.AssertOK(t, err, "valid user")
+
+			response, err := authenticator.Create(rpID, "http://evil.local", options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = driver.FinishRegistration(user, response)
+			test.AssertErr(t, err, "wrong origin")
+		})
+
+		t.Run("wrong relying party", func(t *testing.T) {
+			options, err := driver.BeginRegistration(user)
+			test.AssertOK(t, err, "valid user")
+
+			response, err := authenticator.Create("evil.local", origin, options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = driver.FinishRegistration(user, response)
+			test.AssertErr(t, err, "wrong relying party")
+		})
+
+		t.Run("user not present", func(t *testing.T) {
+			absent, err := fixtures.NewAuthenticator()
+			test.AssertOK(t, err, "valid authenticator")
+
+			absent.Flags = 0
+			options, err := driver.BeginRegistration(user)
+			test.AssertOK(t, err, "valid user")
+
+			response, err := absent.Create(rpID, origin, options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = driver.FinishRegistration(user, response)
+			test.AssertErr(t, err, "user not present")
+		})
+
+		t.Run("unsupported format", func(t *testing.T) {
+			unsupported, err := fixtures.NewAuthenticator()
+			test.AssertOK(t, err, "valid authenticator")
+
+			unsupported.Format = "tpm"
+			options, err := driver.BeginRegistration(user)
+			test.AssertOK(t, err, "valid user")
+
+			response, err := unsupported.Create(rpID, origin, options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = driver.FinishRegistration(user, response)
+			test.AssertErr(t, err, "unsupported format")
+		})
+
+		t.Run("expired challenge", func(t *testing.T) {
+			now := driver.Now
+			defer func() {
+				driver.Now = now
+			}()
+
+			options, err := driver.BeginRegistration(user)
+			test.AssertOK(t, err, "valid user")
+
+			driver.Now = func() time.Time {
+				return now().Add(webauthn.DefaultTimeout * 2)
+			}
+
+			response, err := authenticator.Create(rpID, origin, options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = driver.FinishRegistration(user, response)
+			test.AssertErr(t, err, "expired challenge")
+		})
+
+		t.Run("assertion challenge", func(t *testing.T) {
+			options, err := driver.BeginLogin(nil)
+			test.AssertOK(t, err, "discoverable credentials")
+
+			response, err := authenticator.Create(rpID, origin, options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = driver.FinishRegistration(user, response)
+			if !errors.Is(err, webauthn.ErrChallengeCeremonyMismatch) {
+				t.Fatalf("unexpected error for the challenge of another ceremony: %v", err)
+			}
+		})
+	})
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	user, err := userService.FindOneByEmail("bar@local")
+	test.AssertOK(t, err, "existing user")
+
+	authenticator, err := fixtures.NewAuthenticator()
+	test.AssertOK(t, err, "valid authenticator")
+
+	register(t, user, authenticator)
+
+	t.Run("valid assertion", func(t *testing.T) {
+		options, err := driver.BeginLogin(user)
+		test.AssertOK(t, err, "registered user")
+
+		if len(options.AllowCredentials) != 1 || options.AllowCredentials[0].ID != authenticator.ID() {
+			t.Fatalf("unexpected allowed credentials: %v", options.AllowCredentials)
+		}
+
+		assertion, err := authenticator.Get(rpID, origin, options.Challenge)
+		test.AssertOK(t, err, "valid authenticator")
+
+		loggedInUser, err := auth.Login(assertion)
+		test.AssertOK(t, err, "valid assertion")
+
+		if loggedInUser.GetID() != user.GetID() {
+			t.Fatalf("ids should be equal: %v - %v", loggedInUser.GetID(), user.GetID())
+		}
+
+		credential, err := credentialService.FindOneByID(authenticator.ID())
+		test.AssertOK(t, err, "existing credential")
+
+		if credential.SignCount != authenticator.SignCount {
+			t.Fatalf("unexpected signature counter: %d", credential.SignCount)
+		}
+
+		_, err = auth.Login(assertion)
+		test.AssertErr(t, err, "replayed assertion")
+	})
+
+	t.Run("discoverable credential", func(t *testing.T) {
+		options, err := driver.BeginLogin(nil)
+		test.AssertOK(t, err, "discoverable credentials")
+
+		assertion, err := authenticator.Get(rpID, origin, options.Challenge)
+		test.AssertOK(t, err, "valid authenticator")
+
+		_, err = auth.Login(assertion)
+		test.AssertOK(t, err, "valid assertion")
+	})
+
+	t.Run("user without credentials", func(t *testing.T) {
+		_, err := driver.BeginLogin(fixtures.User{ID: "nobody", Email: "nobody@local"})
+		test.AssertErr(t, err, "no credentials")
+	})
+
+	t.Run("signature counter", func(t *testing.T) {
+		options, err := driver.BeginLogin(user)
+		test.AssertOK(t, err, "registered user")
+
+		cloned := *authenticator
+		cloned.SignCount = 0
+		assertion, err := cloned.Get(rpID, origin, options.Challenge)
+		test.AssertOK(t, err, "valid authenticator")
+
+		_, err = auth.Login(assertion)
+		if !errors.Is(err, webauthn.ErrSignCountInvalid) || !errors.Is(err, gate.ErrInvalidCredentials) {
+			t.Fatalf("unexpected error for a cloned authenticator: %v", err)
+		}
+
+		// The counter read by the driver is updated by another assertion before the driver updates it
+		staleDriver := webauthn.New(newConfig(), staleCredentials{credentialService}, dependency.NewContainer(userService, tokenService, roleService))
+		options, err = staleDriver.BeginLogin(user)
+		test.AssertOK(t, err, "registered user")
+
+		assertion, err = authenticator.Get(rpID, origin, options.Challenge)
+		test.AssertOK(t, err, "valid authenticator")
+
+		_, err = staleDriver.Login(assertion)
+		if !errors.Is(err, webauthn.ErrSignCountInvalid) {
+			t.Fatalf("unexpected error for a concurrent assertion: %v", err)
+		}
+	})
+
+	t.Run("invalid assertions", func(t *testing.T) {
+		t.Run("missing fields", func(t *testing.T) {
+			_, err := auth.Login(map[string]string{})
+			test.AssertErr(t, err, "missing credential ID")
+
+			_, err = auth.Login(map[string]string{"credential_id": authenticator.ID()})
+			test.AssertErr(t, err, "missing client data")
+		})
+
+		t.Run("unknown credential", func(t *testing.T) {
+			unknown, err := fixtures.NewAuthenticator()
+			test.AssertOK(t, err, "valid authenticator")
+
+			options, err := driver.BeginLogin(nil)
+			test.AssertOK(t, err, "discoverable credentials")
+
+			assertion, err := unknown.Get(rpID, origin, options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = auth.Login(assertion)
+			test.AssertErr(t, err, "unknown credential")
+		})
+
+		t.Run("bad signature", func(t *testing.T) {
+			options, err := driver.BeginLogin(user)
+			test.AssertOK(t, err, "registered user")
+
+			assertion, err := authenticator.Get(rpID, origin, options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			assertion["signature"] = base64.RawURLEncoding.EncodeToString([]byte("signature"))
+			_, err = auth.Login(assertion)
+			test.AssertErr(t, err, "bad signature")
+		})
+
+		t.Run("challenge of another user", func(t *testing.T) {
+			another, err := userService.FindOneByEmail("foo@local")
+			test.AssertOK(t, err, "existing user")
+
+			options, err := driver.BeginLogin(another)
+			test.AssertOK(t, err, "registered user")
+
+			assertion, err := authenticator.Get(rpID, origin, options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = auth.Login(assertion)
+			if !errors.Is(err, webauthn.ErrChallengeUserMismatch) || !errors.Is(err, gate.ErrInvalidCredentials) {
+				t.Fatalf("unexpected error for the challenge of another user: %v", err)
+			}
+		})
+
+		t.Run("registration challenge", func(t *testing.T) {
+			options, err := driver.BeginRegistration(user)
+			test.AssertOK(t, err, "valid user")
+
+			assertion, err := authenticator.Get(rpID, origin, options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = auth.Login(assertion)
+			if !errors.Is(err, webauthn.ErrChallengeCeremonyMismatch) {
+				t.Fatalf("unexpected error for the challenge of another ceremony: %v", err)
+			}
+		})
+
+		t.Run("user verification", func(t *testing.T) {
+			config := newConfig()
+			config.RequireUserVerification = true
+			strictDriver := webauthn.New(config, credentialService, dependency.NewContainer(userService, tokenService, roleService))
+			if strictDriver == nil {
+				t.Fatal("unexpected nil driver")
+			}
+
+			unverified := *authenticator
+			unverified.Flags = webauthn.FlagUserPresent
+
+			options, err := strictDriver.BeginLogin(user)
+			test.AssertOK(t, err, "registered user")
+
+			if options.UserVerification != "required" {
+				t.Fatalf("unexpected user verification: %s", options.UserVerification)
+			}
+
+			assertion, err := unverified.Get(rpID, origin, options.Challenge)
+			test.AssertOK(t, err, "valid authenticator")
+
+			_, err = strictDriver.Login(assertion)
+			test.AssertErr(t, err, "user not verified")
+		})
+	})
+}
+
+func TestWebAuthnJWT(t *testing.T) {
+	user, err := userService.FindOneByEmail("foo@local")
+	test.AssertOK(t, err, "existing user")
+
+	authenticator, err := fixtures.NewAuthenticator()
+	test.AssertOK(t, err, "valid authenticator")
+
+	register(t, user, authenticator)
+
+	options, err := driver.BeginLogin(user)
+	test.AssertOK(t, err, "registered user")
+
+	assertion, err := authenticator.Get(rpID, origin, options.Challenge)
+	test.AssertOK(t, err, "valid authenticator")
+
+	user, err = auth.Login(assertion)
+	test.AssertOK(t, err, "valid assertion")
+
+	token, err := auth.IssueJWT(user)
+	test.AssertOK(t, err, "valid user")
+
+	_, err = tokenService.FindOneByID(token.ID)
+	test.AssertOK(t, err, "existing token")
+
+	parsedToken, err := auth.ParseJWT(token.Value)
+	test.AssertOK(t, err, "valid token")
+
+	_, err = auth.GetUserFromJWT(parsedToken)
+	test.AssertOK(t, err, "existing user")
+
+	user, err = auth.Authenticate(token.Value)
+	test.AssertOK(t, err, "valid token")
+
+	err = auth.Authorize(user, "GET", "/api/v1/users")
+	test.AssertOK(t, err, "valid abilities")
+
+	err = auth.Authorize(user, "POST", "/api/v1/posts")
+	test.AssertErr(t, err, "invalid abilities")
+
+	_, err = auth.GetUserAbilities(user)
+	test.AssertOK(t, err, "valid abilities")
+}
+
+type staleCredentials struct {
+	*fixtures.MyCredentialService
+}
+
+func (service staleCredentials) FindOneByID(id string) (webauthn.Credential, error) {
+	credential, err := service.MyCredentialService.FindOneByID(id)
+	credential.SignCount--
+	return credential, err
+}
@@ -0,0 +1,196 @@
+package webauthn_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hiendv/gate"
+	"github.com/hiendv/gate/dependency"
+	"github.com/hiendv/gate/internal/test"
+	"github.com/hiendv/gate/internal/test/fixtures"
+	"github.com/hiendv/gate/webauthn"
+)
+
+func newConfig() webauthn.Config {
+	return webauthn.Config{
+		Config: gate.NewConfig("jwt-secret", "jwt-secret", time.Hour*1, false),
+		RPID:   "localhost",
+		RPName: "Gate",
+		Origin: "http://localhost:8080",
+	}
+}
+
+func TestWebAuthnInvalidConfig(t *testing.T) {
+	instance := webauthn.New(
+		webauthn.Config{},
+		fixtures.NewMyCredentialService(nil),
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+
+	if instance != nil {
+		t.Fatal("unexpected non-nil driver")
+	}
+
+	config := newConfig()
+	config.Config = gate.Config{}
+	instance = webauthn.New(
+		config,
+		fixtures.NewMyCredentialService(nil),
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+
+	if instance != nil {
+		t.Fatal("unexpected non-nil driver")
+	}
+}
+
+func TestWebAuthnInvalidCredentialService(t *testing.T) {
+	instance := webauthn.New(
+		newConfig(),
+		nil,
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+
+	if instance != nil {
+		t.Fatal("unexpected non-nil driver")
+	}
+}
+
+func TestWebAuthnLoginURL(t *testing.T) {
+	driver := webauthn.New(
+		newConfig(),
+		fixtures.NewMyCredentialService(nil),
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	_, err := driver.LoginURL("state")
+	test.AssertErr(t, err, "unsupported login URL")
+}
+
+func TestWebAuthnChallengeStore(t *testing.T) {
+	user := fixtures.User{ID: "id", Email: "email@local"}
+
+	driver := webauthn.New(
+		newConfig(),
+		fixtures.NewMyCredentialService(nil),
+		// Services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	t.Run("with invalid challenge store", func(t *testing.T) {
+		store := driver.GetChallengeStore()
+		defer driver.SetChallengeStore(store)
+
+		driver.SetChallengeStore(nil)
+		_, err := driver.BeginRegistration(user)
+		test.AssertErr(t, err, "missing challenge store")
+
+		_, err = driver.BeginLogin(nil)
+		test.AssertErr(t, err, "missing challenge store")
+	})
+
+	t.Run("single use", func(t *testing.T) {
+		store := webauthn.NewMemoryChallengeStore()
+		err := store.Store(webauthn.Challenge{Value: "challenge"})
+		test.AssertOK(t, err, "valid challenge")
+
+		_, err = store.Take("challenge")
+		test.AssertOK(t, err, "existing challenge")
+
+		_, err = store.Take("challenge")
+		test.AssertErr(t, err, "used challenge")
+	})
+
+	t.Run("expiration", func(t *testing.T) {
+		store := webauthn.NewMemoryChallengeStore()
+		err := store.Store(webauthn.Challenge{Value: "pending", ExpiredAt: time.Now().Add(time.Hour)})
+		test.AssertOK(t, err, "valid challenge")
+
+		for i := 0; i < 2048; i++ {
+			err = store.Store(webauthn.Challenge{Value: strconv.Itoa(i), ExpiredAt: time.Now().Add(-time.Second)})
+			test.AssertOK(t, err, "valid challenge")
+		}
+
+		_, err = store.Take("0")
+		test.AssertErr(t, err, "expired challenges should be dropped")
+
+		_, err = store.Take("pending")
+		test.AssertOK(t, err, "pending challenges should be kept")
+	})
+}
+
+func TestWebAuthnUserService(t *testing.T) {
+	authenticator, err := fixtures.NewAuthenticator()
+	test.AssertOK(t, err, "valid authenticator")
+
+	credentials := fixtures.NewMyCredentialService(nil)
+	driver := webauthn.New(
+		newConfig(),
+		credentials,
+		// User, Token and Role services are omitted
+		dependency.NewContainer(nil, nil, nil),
+	)
+	if driver == nil {
+		t.Fatal("unexpected nil driver")
+	}
+
+	user := fixtures.User{ID: "id", Email: "email@local"}
+	options, err := driver.BeginRegistration(user)
+	test.AssertOK(t, err, "valid user")
+
+	response, err := authenticator.Create("localhost", "http://localhost:8080", options.Challenge)
+	test.AssertOK(t, err, "valid authenticator")
+
+	_, err = driver.FinishRegistration(user, response)
+	test.AssertOK(t, err, "valid registration")
+
+	requestOptions, err := driver.BeginLogin(user)
+	test.AssertOK(t, err, "registered user")
+
+	assertion, err := authenticator.Get("localhost", "http://localhost:8080", requestOptions.Challenge)
+	test.AssertOK(t, err, "valid authenticator")
+
+	_, err = driver.Login(assertion)
+	test.AssertErr(t, err, "missing user service")
+}
+
+func TestWebAuthnAuthenticatorData(t *testing.T) {
+	t.Run("too short", func(t *testing.T) {
+		_, err := webauthn.ParseAuthenticatorData(make([]byte, 36))
+		test.AssertErr(t, err, "short authenticator data")
+	})
+
+	t.Run("truncated credential", func(t *testing.T) {
+		data := make([]byte, 37+18)
+		data[32] = webauthn.FlagAttestedCredentialData
+		data[37+17] = 8
+		_, err := webauthn.ParseAuthenticatorData(data)
+		test.AssertErr(t, err, "truncated credential ID")
+	})
+
+	t.Run("public key", func(t *testing.T) {
+		authenticator, err := fixtures.NewAuthenticator()
+		test.AssertOK(t, err, "valid authenticator")
+
+		key, err := webauthn.ParsePublicKey(authenticator.PublicKey())
+		test.AssertOK(t, err, "valid public key")
+
+		if key.Algorithm != webauthn.AlgorithmES256 {
+			t.Fatalf("unexpected algorithm: %d", key.Algorithm)
+		}
+
+		_, err = webauthn.ParsePublicKey([]byte{0x01})
+		test.AssertErr(t, err, "invalid public key")
+	})
+}